@@ -0,0 +1,294 @@
+// Package attributes decodes the attributes of stablehlo.Statement from their StableHLO text (see
+// stablehlo.Statement.AttributeToStableHLO), so it works the same way for programs built with the stablehlo API
+// or parsed from text.
+//
+// It is used by the stablehlo verifier, the interpreter and the autodiff packages.
+package attributes
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Statement is implemented by stablehlo.Statement. It is an interface so the stablehlo package can use this
+// package without an import cycle.
+type Statement interface {
+	// AttributeToStableHLO returns the StableHLO text of the attribute, and whether it is set.
+	AttributeToStableHLO(key string) (string, bool)
+}
+
+// Text returns the StableHLO text of a required attribute.
+func Text(stmt Statement, key string) (string, error) {
+	text, found := stmt.AttributeToStableHLO(key)
+	if !found {
+		return "", errors.Errorf("missing attribute %q", key)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// Ints decodes an attribute with a list of integers, like "array<i64: 1, 2>", "[1, 2]" or
+// "dense<[[0, 1], [1, 0]]> : tensor<2x2xi64>". Booleans are converted to 0 or 1.
+func Ints(stmt Statement, key string) ([]int, error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return nil, err
+	}
+	values, err := ParseInts(text)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "attribute %q", key)
+	}
+	return values, nil
+}
+
+// OptionalInts is like Ints, but it returns defaultValue if the attribute is not set.
+func OptionalInts(stmt Statement, key string, defaultValue []int) ([]int, error) {
+	if _, found := stmt.AttributeToStableHLO(key); !found {
+		return defaultValue, nil
+	}
+	return Ints(stmt, key)
+}
+
+// Int decodes an integer attribute, like "3 : i64".
+func Int(stmt Statement, key string) (int, error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return 0, err
+	}
+	text, _, _ = strings.Cut(text, ":")
+	value, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return 0, errors.Wrapf(err, "attribute %q is not an integer", key)
+	}
+	return value, nil
+}
+
+// OptionalBool decodes a boolean attribute, or returns defaultValue if the attribute is not set.
+func OptionalBool(stmt Statement, key string, defaultValue bool) (bool, error) {
+	if _, found := stmt.AttributeToStableHLO(key); !found {
+		return defaultValue, nil
+	}
+	text, err := Text(stmt, key)
+	if err != nil {
+		return false, err
+	}
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, errors.Errorf("attribute %q is not a boolean: %q", key, text)
+	}
+}
+
+// Enum decodes an enum attribute like "#stablehlo<comparison_direction LT>", returning its value ("LT").
+func Enum(stmt Statement, key string) (string, error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(text, "#") || !strings.HasSuffix(text, ">") {
+		return "", errors.Errorf("attribute %q is not an enum: %q", key, text)
+	}
+	fields := strings.Fields(text[:len(text)-1])
+	return fields[len(fields)-1], nil
+}
+
+// Struct decodes a structured attribute, like "#stablehlo.gather<offset_dims = [1], index_vector_dim = 1>",
+// into a map of its fields to their text values. Missing fields are simply not set.
+func Struct(stmt Statement, key string) (map[string]string, error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return nil, err
+	}
+	start := strings.Index(text, "<")
+	if start == -1 || !strings.HasSuffix(text, ">") {
+		return nil, errors.Errorf("attribute %q is not a structured attribute: %q", key, text)
+	}
+	fields := make(map[string]string)
+	for _, field := range splitTopLevel(text[start+1 : len(text)-1]) {
+		if field == "" {
+			continue
+		}
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			return nil, errors.Errorf("attribute %q has an invalid field %q", key, field)
+		}
+		fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return fields, nil
+}
+
+// StructInts returns the list of integers of a field of a structured attribute, or nil if it is not set.
+func StructInts(fields map[string]string, name string) ([]int, error) {
+	text, found := fields[name]
+	if !found {
+		return nil, nil
+	}
+	values, err := ParseInts(text)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "field %q", name)
+	}
+	return values, nil
+}
+
+// StructInt returns the integer value of a field of a structured attribute.
+func StructInt(fields map[string]string, name string) (int, error) {
+	text, found := fields[name]
+	if !found {
+		return 0, errors.Errorf("missing field %q", name)
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, errors.Wrapf(err, "field %q is not an integer", name)
+	}
+	return value, nil
+}
+
+// splitTopLevel splits the text by the commas that are not nested in brackets.
+func splitTopLevel(text string) []string {
+	var parts []string
+	var depth, start int
+	for i, c := range text {
+		switch c {
+		case '[', '<', '(', '{':
+			depth++
+		case ']', '>', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(text[start:]))
+}
+
+// ParseInts parses a list of integers, in any of the forms: "array<i64: 1, 2>", "[1, 2]" (possibly nested) or
+// "dense<...> : tensor<...>" (including splat values). Booleans are converted to 0 or 1.
+func ParseInts(text string) ([]int, error) {
+	text = strings.TrimSpace(text)
+	var content string
+	splatSize := 0
+	switch {
+	case strings.HasPrefix(text, "array<"):
+		content = strings.TrimSuffix(text[len("array<"):], ">")
+		_, content, _ = strings.Cut(content, ":")
+	case strings.HasPrefix(text, "dense<"):
+		end := strings.Index(text, ">")
+		if end == -1 {
+			return nil, errors.Errorf("invalid dense literal %q", text)
+		}
+		content = text[len("dense<"):end]
+		if !strings.Contains(content, "[") {
+			// Splat value: it is repeated for the whole shape.
+			_, typeText, found := strings.Cut(text[end+1:], ":")
+			if !found {
+				return nil, errors.Errorf("invalid dense literal %q", text)
+			}
+			shape, err := shapes.FromStableHLO(strings.TrimSpace(typeText))
+			if err != nil {
+				return nil, err
+			}
+			splatSize = shape.Size()
+		}
+	default:
+		content = text
+	}
+	content = strings.NewReplacer("[", " ", "]", " ", ",", " ").Replace(content)
+	var values []int
+	for _, field := range strings.Fields(content) {
+		switch field {
+		case "true":
+			values = append(values, 1)
+		case "false":
+			values = append(values, 0)
+		default:
+			value, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, errors.Errorf("invalid integer %q in %q", field, text)
+			}
+			values = append(values, value)
+		}
+	}
+	if splatSize > 0 && len(values) == 1 {
+		for len(values) < splatSize {
+			values = append(values, values[0])
+		}
+	}
+	return values, nil
+}
+
+// Float decodes a float attribute, like "1.000000e-05 : f32".
+func Float(stmt Statement, key string) (float64, error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return 0, err
+	}
+	text, _, _ = strings.Cut(text, ":")
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "attribute %q is not a float", key)
+	}
+	return value, nil
+}
+
+// ConvAxes holds the axes configuration of one of the operands (or the output) of a convolution.
+type ConvAxes struct {
+	// BatchOrInput is the batch axis for the input and output, and the input features axis for the kernel.
+	// FeatureOrOutput is the features axis for the input and output, and the output features axis for the kernel.
+	BatchOrInput, FeatureOrOutput int
+	Spatial                       []int
+}
+
+// ConvDimensionNumbers decodes the dimension numbers of a convolution, in the form
+// "#stablehlo.conv<[b, 0, 1, f]x[0, 1, i, o]->[b, 0, 1, f]>".
+func ConvDimensionNumbers(stmt Statement, key string) (input, kernel, output ConvAxes, err error) {
+	text, err := Text(stmt, key)
+	if err != nil {
+		return
+	}
+	start, end := strings.Index(text, "<"), strings.LastIndex(text, ">")
+	if !strings.HasPrefix(text, "#stablehlo.conv") || start == -1 || end < start {
+		err = errors.Errorf("unsupported convolution dimension numbers %q", text)
+		return
+	}
+	operandsText, outputText, found := strings.Cut(text[start+1:end], "->")
+	inputText, kernelText, found2 := strings.Cut(operandsText, "]x[")
+	if !found || !found2 {
+		err = errors.Errorf("unsupported convolution dimension numbers %q", text)
+		return
+	}
+	parse := func(text, first, second string) (axes ConvAxes, err error) {
+		text = strings.Trim(strings.TrimSpace(text), "[]")
+		labels := strings.Split(text, ",")
+		axes.Spatial = make([]int, len(labels)-2)
+		for axis, label := range labels {
+			switch label = strings.TrimSpace(label); label {
+			case first:
+				axes.BatchOrInput = axis
+			case second:
+				axes.FeatureOrOutput = axis
+			default:
+				spatialIdx, parseErr := strconv.Atoi(label)
+				if parseErr != nil || spatialIdx < 0 || spatialIdx >= len(axes.Spatial) {
+					return axes, errors.Errorf("invalid axis label %q in convolution dimension numbers", label)
+				}
+				axes.Spatial[spatialIdx] = axis
+			}
+		}
+		return axes, nil
+	}
+	if input, err = parse(inputText, "b", "f"); err != nil {
+		return
+	}
+	if kernel, err = parse(kernelText, "i", "o"); err != nil {
+		return
+	}
+	output, err = parse(outputText, "b", "f")
+	return
+}
@@ -7,11 +7,11 @@ import (
 	"strings"
 )
 
-const _OpTypeName = "InvalidFuncReturnConstantIdentityAbsAddAllGatherAllReduceAllToAllAndAtan2BatchNormInferenceBatchNormTrainingBatchNormGradBitcastConvertBroadcastInDimCallCbrtCeilClampCollectiveBroadcastCollectivePermuteCompareComplexConcatenateConvertConvolutionCosineCountLeadingZerosDivideDotGeneralDynamicBroadcastInDimDynamicConvDynamicGatherDynamicIotaDynamicPadDynamicSliceDynamicUpdateSliceErfExponentialExponentialMinusOneFftFloorGatherIfImagIsFiniteIotaLogLogPlusOneLogisticMaximumMinimumMultiplyNegateNotOptimizationBarrierOrPadPopcntPowerRealRemainderReduceReduceWindowReshapeReverseRNGBitGeneratorRoundNearestAfzRoundNearestEvenRsqrtScatterSelectSelectAndScatterShiftLeftShiftRightArithmeticShiftRightLogicalSignSineSliceSortSqrtSubtractTanTanhTransposeUniformDequantizeUniformQuantizeWhileXorGetDimensionSizeCaseCholeskyCompositeCustomCallDynamicReshapeGetTupleElementInfeedOutfeedPartitionIdRecvReducePrecisionReduceScatterSendTriangularSolveTupleLast"
+const _OpTypeName = "InvalidFuncReturnConstantIdentityAbsAddAllGatherAllReduceAllToAllAndAtan2BatchNormInferenceBatchNormTrainingBatchNormGradBitcastConvertBroadcastInDimCallCbrtCeilClampCollectiveBroadcastCollectivePermuteCompareComplexConcatenateConvertConvolutionCosineCountLeadingZerosDivideDotGeneralDynamicBroadcastInDimDynamicConvDynamicGatherDynamicIotaDynamicPadDynamicSliceDynamicUpdateSliceErfExponentialExponentialMinusOneFftFloorGatherIfImagIsFiniteIotaLogLogPlusOneLogisticMaximumMinimumMultiplyNegateNotOptimizationBarrierOrPadPopcntPowerRealRemainderReduceReduceWindowReshapeReverseRNGBitGeneratorRoundNearestAfzRoundNearestEvenRsqrtScatterSelectSelectAndScatterShiftLeftShiftRightArithmeticShiftRightLogicalSignSineSliceSortSqrtSubtractTanTanhTransposeUniformDequantizeUniformQuantizeWhileXorGetDimensionSizeCustomCallCaseCholeskyTriangularSolveReduceScatterReplicaIdPartitionIdTupleGetTupleElementAfterAllInfeedOutfeedSendRecvCompositeReducePrecisionMapDynamicReshapeLast"
 
-var _OpTypeIndex = [...]uint16{0, 7, 17, 25, 33, 36, 39, 48, 57, 65, 68, 73, 91, 108, 121, 135, 149, 153, 157, 161, 166, 185, 202, 209, 216, 227, 234, 245, 251, 268, 274, 284, 305, 316, 329, 340, 350, 362, 380, 383, 394, 413, 416, 421, 427, 429, 433, 441, 445, 448, 458, 466, 473, 480, 488, 494, 497, 516, 518, 521, 527, 532, 536, 545, 551, 563, 570, 577, 592, 607, 623, 628, 635, 641, 657, 666, 686, 703, 707, 711, 716, 720, 724, 732, 735, 739, 748, 765, 780, 785, 788, 804, 808, 816, 825, 835, 849, 864, 870, 877, 888, 892, 907, 920, 924, 939, 944, 948}
+var _OpTypeIndex = [...]uint16{0, 7, 17, 25, 33, 36, 39, 48, 57, 65, 68, 73, 91, 108, 121, 135, 149, 153, 157, 161, 166, 185, 202, 209, 216, 227, 234, 245, 251, 268, 274, 284, 305, 316, 329, 340, 350, 362, 380, 383, 394, 413, 416, 421, 427, 429, 433, 441, 445, 448, 458, 466, 473, 480, 488, 494, 497, 516, 518, 521, 527, 532, 536, 545, 551, 563, 570, 577, 592, 607, 623, 628, 635, 641, 657, 666, 686, 703, 707, 711, 716, 720, 724, 732, 735, 739, 748, 765, 780, 785, 788, 804, 814, 818, 826, 841, 854, 863, 874, 879, 894, 902, 908, 915, 919, 923, 932, 947, 950, 964, 968}
 
-const _OpTypeLowerName = "invalidfuncreturnconstantidentityabsaddallgatherallreducealltoallandatan2batchnorminferencebatchnormtrainingbatchnormgradbitcastconvertbroadcastindimcallcbrtceilclampcollectivebroadcastcollectivepermutecomparecomplexconcatenateconvertconvolutioncosinecountleadingzerosdividedotgeneraldynamicbroadcastindimdynamicconvdynamicgatherdynamiciotadynamicpaddynamicslicedynamicupdatesliceerfexponentialexponentialminusonefftfloorgatherifimagisfiniteiotaloglogplusonelogisticmaximumminimummultiplynegatenotoptimizationbarrierorpadpopcntpowerrealremainderreducereducewindowreshapereverserngbitgeneratorroundnearestafzroundnearestevenrsqrtscatterselectselectandscattershiftleftshiftrightarithmeticshiftrightlogicalsignsineslicesortsqrtsubtracttantanhtransposeuniformdequantizeuniformquantizewhilexorgetdimensionsizecasecholeskycompositecustomcalldynamicreshapegettupleelementinfeedoutfeedpartitionidrecvreduceprecisionreducescattersendtriangularsolvetuplelast"
+const _OpTypeLowerName = "invalidfuncreturnconstantidentityabsaddallgatherallreducealltoallandatan2batchnorminferencebatchnormtrainingbatchnormgradbitcastconvertbroadcastindimcallcbrtceilclampcollectivebroadcastcollectivepermutecomparecomplexconcatenateconvertconvolutioncosinecountleadingzerosdividedotgeneraldynamicbroadcastindimdynamicconvdynamicgatherdynamiciotadynamicpaddynamicslicedynamicupdatesliceerfexponentialexponentialminusonefftfloorgatherifimagisfiniteiotaloglogplusonelogisticmaximumminimummultiplynegatenotoptimizationbarrierorpadpopcntpowerrealremainderreducereducewindowreshapereverserngbitgeneratorroundnearestafzroundnearestevenrsqrtscatterselectselectandscattershiftleftshiftrightarithmeticshiftrightlogicalsignsineslicesortsqrtsubtracttantanhtransposeuniformdequantizeuniformquantizewhilexorgetdimensionsizecustomcallcasecholeskytriangularsolvereducescatterreplicaidpartitionidtuplegettupleelementafterallinfeedoutfeedsendrecvcompositereduceprecisionmapdynamicreshapelast"
 
 func (i OpType) String() string {
 	if i < 0 || i >= OpType(len(_OpTypeIndex)-1) {
@@ -115,25 +115,28 @@ func _OpTypeNoOp() {
 	_ = x[While-(88)]
 	_ = x[Xor-(89)]
 	_ = x[GetDimensionSize-(90)]
-	_ = x[Case-(91)]
-	_ = x[Cholesky-(92)]
-	_ = x[Composite-(93)]
-	_ = x[CustomCall-(94)]
-	_ = x[DynamicReshape-(95)]
-	_ = x[GetTupleElement-(96)]
-	_ = x[Infeed-(97)]
-	_ = x[Outfeed-(98)]
-	_ = x[PartitionId-(99)]
-	_ = x[Recv-(100)]
-	_ = x[ReducePrecision-(101)]
-	_ = x[ReduceScatter-(102)]
+	_ = x[CustomCall-(91)]
+	_ = x[Case-(92)]
+	_ = x[Cholesky-(93)]
+	_ = x[TriangularSolve-(94)]
+	_ = x[ReduceScatter-(95)]
+	_ = x[ReplicaId-(96)]
+	_ = x[PartitionId-(97)]
+	_ = x[Tuple-(98)]
+	_ = x[GetTupleElement-(99)]
+	_ = x[AfterAll-(100)]
+	_ = x[Infeed-(101)]
+	_ = x[Outfeed-(102)]
 	_ = x[Send-(103)]
-	_ = x[TriangularSolve-(104)]
-	_ = x[Tuple-(105)]
-	_ = x[Last-(106)]
+	_ = x[Recv-(104)]
+	_ = x[Composite-(105)]
+	_ = x[ReducePrecision-(106)]
+	_ = x[Map-(107)]
+	_ = x[DynamicReshape-(108)]
+	_ = x[Last-(109)]
 }
 
-var _OpTypeValues = []OpType{Invalid, FuncReturn, Constant, Identity, Abs, Add, AllGather, AllReduce, AllToAll, And, Atan2, BatchNormInference, BatchNormTraining, BatchNormGrad, BitcastConvert, BroadcastInDim, Call, Cbrt, Ceil, Clamp, CollectiveBroadcast, CollectivePermute, Compare, Complex, Concatenate, Convert, Convolution, Cosine, CountLeadingZeros, Divide, DotGeneral, DynamicBroadcastInDim, DynamicConv, DynamicGather, DynamicIota, DynamicPad, DynamicSlice, DynamicUpdateSlice, Erf, Exponential, ExponentialMinusOne, Fft, Floor, Gather, If, Imag, IsFinite, Iota, Log, LogPlusOne, Logistic, Maximum, Minimum, Multiply, Negate, Not, OptimizationBarrier, Or, Pad, Popcnt, Power, Real, Remainder, Reduce, ReduceWindow, Reshape, Reverse, RNGBitGenerator, RoundNearestAfz, RoundNearestEven, Rsqrt, Scatter, Select, SelectAndScatter, ShiftLeft, ShiftRightArithmetic, ShiftRightLogical, Sign, Sine, Slice, Sort, Sqrt, Subtract, Tan, Tanh, Transpose, UniformDequantize, UniformQuantize, While, Xor, GetDimensionSize, Case, Cholesky, Composite, CustomCall, DynamicReshape, GetTupleElement, Infeed, Outfeed, PartitionId, Recv, ReducePrecision, ReduceScatter, Send, TriangularSolve, Tuple, Last}
+var _OpTypeValues = []OpType{Invalid, FuncReturn, Constant, Identity, Abs, Add, AllGather, AllReduce, AllToAll, And, Atan2, BatchNormInference, BatchNormTraining, BatchNormGrad, BitcastConvert, BroadcastInDim, Call, Cbrt, Ceil, Clamp, CollectiveBroadcast, CollectivePermute, Compare, Complex, Concatenate, Convert, Convolution, Cosine, CountLeadingZeros, Divide, DotGeneral, DynamicBroadcastInDim, DynamicConv, DynamicGather, DynamicIota, DynamicPad, DynamicSlice, DynamicUpdateSlice, Erf, Exponential, ExponentialMinusOne, Fft, Floor, Gather, If, Imag, IsFinite, Iota, Log, LogPlusOne, Logistic, Maximum, Minimum, Multiply, Negate, Not, OptimizationBarrier, Or, Pad, Popcnt, Power, Real, Remainder, Reduce, ReduceWindow, Reshape, Reverse, RNGBitGenerator, RoundNearestAfz, RoundNearestEven, Rsqrt, Scatter, Select, SelectAndScatter, ShiftLeft, ShiftRightArithmetic, ShiftRightLogical, Sign, Sine, Slice, Sort, Sqrt, Subtract, Tan, Tanh, Transpose, UniformDequantize, UniformQuantize, While, Xor, GetDimensionSize, CustomCall, Case, Cholesky, TriangularSolve, ReduceScatter, ReplicaId, PartitionId, Tuple, GetTupleElement, AfterAll, Infeed, Outfeed, Send, Recv, Composite, ReducePrecision, Map, DynamicReshape, Last}
 
 var _OpTypeNameToValueMap = map[string]OpType{
 	_OpTypeName[0:7]:          Invalid,
@@ -318,38 +321,44 @@ var _OpTypeNameToValueMap = map[string]OpType{
 	_OpTypeLowerName[785:788]: Xor,
 	_OpTypeName[788:804]:      GetDimensionSize,
 	_OpTypeLowerName[788:804]: GetDimensionSize,
-	_OpTypeName[804:808]:      Case,
-	_OpTypeLowerName[804:808]: Case,
-	_OpTypeName[808:816]:      Cholesky,
-	_OpTypeLowerName[808:816]: Cholesky,
-	_OpTypeName[816:825]:      Composite,
-	_OpTypeLowerName[816:825]: Composite,
-	_OpTypeName[825:835]:      CustomCall,
-	_OpTypeLowerName[825:835]: CustomCall,
-	_OpTypeName[835:849]:      DynamicReshape,
-	_OpTypeLowerName[835:849]: DynamicReshape,
-	_OpTypeName[849:864]:      GetTupleElement,
-	_OpTypeLowerName[849:864]: GetTupleElement,
-	_OpTypeName[864:870]:      Infeed,
-	_OpTypeLowerName[864:870]: Infeed,
-	_OpTypeName[870:877]:      Outfeed,
-	_OpTypeLowerName[870:877]: Outfeed,
-	_OpTypeName[877:888]:      PartitionId,
-	_OpTypeLowerName[877:888]: PartitionId,
-	_OpTypeName[888:892]:      Recv,
-	_OpTypeLowerName[888:892]: Recv,
-	_OpTypeName[892:907]:      ReducePrecision,
-	_OpTypeLowerName[892:907]: ReducePrecision,
-	_OpTypeName[907:920]:      ReduceScatter,
-	_OpTypeLowerName[907:920]: ReduceScatter,
-	_OpTypeName[920:924]:      Send,
-	_OpTypeLowerName[920:924]: Send,
-	_OpTypeName[924:939]:      TriangularSolve,
-	_OpTypeLowerName[924:939]: TriangularSolve,
-	_OpTypeName[939:944]:      Tuple,
-	_OpTypeLowerName[939:944]: Tuple,
-	_OpTypeName[944:948]:      Last,
-	_OpTypeLowerName[944:948]: Last,
+	_OpTypeName[804:814]:      CustomCall,
+	_OpTypeLowerName[804:814]: CustomCall,
+	_OpTypeName[814:818]:      Case,
+	_OpTypeLowerName[814:818]: Case,
+	_OpTypeName[818:826]:      Cholesky,
+	_OpTypeLowerName[818:826]: Cholesky,
+	_OpTypeName[826:841]:      TriangularSolve,
+	_OpTypeLowerName[826:841]: TriangularSolve,
+	_OpTypeName[841:854]:      ReduceScatter,
+	_OpTypeLowerName[841:854]: ReduceScatter,
+	_OpTypeName[854:863]:      ReplicaId,
+	_OpTypeLowerName[854:863]: ReplicaId,
+	_OpTypeName[863:874]:      PartitionId,
+	_OpTypeLowerName[863:874]: PartitionId,
+	_OpTypeName[874:879]:      Tuple,
+	_OpTypeLowerName[874:879]: Tuple,
+	_OpTypeName[879:894]:      GetTupleElement,
+	_OpTypeLowerName[879:894]: GetTupleElement,
+	_OpTypeName[894:902]:      AfterAll,
+	_OpTypeLowerName[894:902]: AfterAll,
+	_OpTypeName[902:908]:      Infeed,
+	_OpTypeLowerName[902:908]: Infeed,
+	_OpTypeName[908:915]:      Outfeed,
+	_OpTypeLowerName[908:915]: Outfeed,
+	_OpTypeName[915:919]:      Send,
+	_OpTypeLowerName[915:919]: Send,
+	_OpTypeName[919:923]:      Recv,
+	_OpTypeLowerName[919:923]: Recv,
+	_OpTypeName[923:932]:      Composite,
+	_OpTypeLowerName[923:932]: Composite,
+	_OpTypeName[932:947]:      ReducePrecision,
+	_OpTypeLowerName[932:947]: ReducePrecision,
+	_OpTypeName[947:950]:      Map,
+	_OpTypeLowerName[947:950]: Map,
+	_OpTypeName[950:964]:      DynamicReshape,
+	_OpTypeLowerName[950:964]: DynamicReshape,
+	_OpTypeName[964:968]:      Last,
+	_OpTypeLowerName[964:968]: Last,
 }
 
 var _OpTypeNames = []string{
@@ -444,22 +453,25 @@ var _OpTypeNames = []string{
 	_OpTypeName[780:785],
 	_OpTypeName[785:788],
 	_OpTypeName[788:804],
-	_OpTypeName[804:808],
-	_OpTypeName[808:816],
-	_OpTypeName[816:825],
-	_OpTypeName[825:835],
-	_OpTypeName[835:849],
-	_OpTypeName[849:864],
-	_OpTypeName[864:870],
-	_OpTypeName[870:877],
-	_OpTypeName[877:888],
-	_OpTypeName[888:892],
-	_OpTypeName[892:907],
-	_OpTypeName[907:920],
-	_OpTypeName[920:924],
-	_OpTypeName[924:939],
-	_OpTypeName[939:944],
-	_OpTypeName[944:948],
+	_OpTypeName[804:814],
+	_OpTypeName[814:818],
+	_OpTypeName[818:826],
+	_OpTypeName[826:841],
+	_OpTypeName[841:854],
+	_OpTypeName[854:863],
+	_OpTypeName[863:874],
+	_OpTypeName[874:879],
+	_OpTypeName[879:894],
+	_OpTypeName[894:902],
+	_OpTypeName[902:908],
+	_OpTypeName[908:915],
+	_OpTypeName[915:919],
+	_OpTypeName[919:923],
+	_OpTypeName[923:932],
+	_OpTypeName[932:947],
+	_OpTypeName[947:950],
+	_OpTypeName[950:964],
+	_OpTypeName[964:968],
 }
 
 // OpTypeString retrieves an enum value from the enum constants string name.
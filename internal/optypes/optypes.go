@@ -106,24 +106,27 @@ const (
 	Xor
 
 	GetDimensionSize
-
-	// Here the ones not implemented yet, please add an issue in the repo if you need them.
-
+	CustomCall
 	Case
 	Cholesky
-	Composite
-	CustomCall
-	DynamicReshape
+	TriangularSolve
+	ReduceScatter
+	ReplicaId
+	PartitionId
+	Tuple
 	GetTupleElement
+	AfterAll
 	Infeed
 	Outfeed
-	PartitionId
+	Send
 	Recv
+	Composite
 	ReducePrecision
-	ReduceScatter
-	Send
-	TriangularSolve
-	Tuple
+	Map
+
+	// Here the ones not implemented yet, please add an issue in the repo if you need them.
+
+	DynamicReshape
 
 	// Last should always be kept the last, it is used as a counter/marker for .
 	Last
@@ -147,3 +150,23 @@ func (op OpType) ToStableHLO() string {
 	}
 	return name
 }
+
+// stableHLONames maps the StableHLO names of the implemented operations to their OpType, see FromStableHLO.
+var stableHLONames = func() map[string]OpType {
+	names := make(map[string]OpType, int(Last))
+	for op := Invalid + 1; op < Last; op++ {
+		names[op.ToStableHLO()] = op
+	}
+	// MLIR's func dialect return, used by exported modules.
+	names["func.return"] = FuncReturn
+	return names
+}()
+
+// FromStableHLO returns the OpType for the given StableHLO operation name (e.g.: "stablehlo.add"), the inverse of
+// OpType.ToStableHLO.
+//
+// It returns false if the name is unknown.
+func FromStableHLO(name string) (OpType, bool) {
+	op, found := stableHLONames[name]
+	return op, found
+}
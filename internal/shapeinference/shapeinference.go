@@ -354,7 +354,20 @@ func UnaryOp(opType optypes.OpType, operand shapes.Shape) (output shapes.Shape,
 //   - Both are dynamic (shapes.DimUnknown indicates dynamic dimensions)
 //   - One or both is dynamic (allows static to match dynamic at runtime)
 //   - Both are static and equal
+//
+// Tuples are compatible if they have the same number of elements, and each element is compatible.
 func areEqualShapesCompatible(a, b shapes.Shape) bool {
+	if a.IsTuple() || b.IsTuple() {
+		if a.TupleSize() != b.TupleSize() {
+			return false
+		}
+		for i, element := range a.TupleShapes {
+			if !areEqualShapesCompatible(element, b.TupleShapes[i]) {
+				return false
+			}
+		}
+		return true
+	}
 	if a.DType != b.DType {
 		return false
 	}
@@ -1063,6 +1076,57 @@ func Sort(inputs []shapes.Shape, axis int) (outputs []shapes.Shape, err error) {
 	return outputs, nil
 }
 
+// Map returns the output shape of the Map operation, which applies a scalar computation element-wise to the inputs.
+//
+// The inputs must all have the same dimensions, and the computation must take one scalar of each input dtype,
+// and return one scalar. The dimensions must be all the axes of the inputs, in order.
+func Map(inputs, computationInputs, computationOutputs []shapes.Shape, dimensions []int) (output shapes.Shape, err error) {
+	if len(inputs) == 0 {
+		return shapes.Invalid(), errors.New("Map requires at least one input")
+	}
+	for i, input := range inputs {
+		if !input.Ok() || input.IsTuple() {
+			return shapes.Invalid(), errors.Errorf("Map: invalid shape %s for input #%d", input, i)
+		}
+		if !areEqualDimensionsCompatible(input, inputs[0]) {
+			return shapes.Invalid(), errors.Errorf("Map requires the same dimensions for all inputs, got %s and %s for inputs #0 and #%d",
+				inputs[0], input, i)
+		}
+	}
+
+	// Check computation signature.
+	if len(computationInputs) != len(inputs) {
+		return shapes.Invalid(), errors.Errorf("Map computation must have one input per Map input, but it has %d inputs for %d Map inputs",
+			len(computationInputs), len(inputs))
+	}
+	for i, input := range computationInputs {
+		if !input.IsScalar() || input.DType != inputs[i].DType {
+			return shapes.Invalid(), errors.Errorf("Map computation input #%d must be a scalar of dtype %s, got %s",
+				i, inputs[i].DType, input)
+		}
+	}
+	if len(computationOutputs) != 1 || !computationOutputs[0].IsScalar() || computationOutputs[0].IsTuple() {
+		return shapes.Invalid(), errors.Errorf("Map computation must return one scalar, got %v", computationOutputs)
+	}
+
+	// Check dimensions.
+	rank := inputs[0].Rank()
+	if len(dimensions) != rank {
+		return shapes.Invalid(), errors.Errorf("Map dimensions must include all the %d axes of the inputs, got %v",
+			rank, dimensions)
+	}
+	for i, axis := range dimensions {
+		if axis != i {
+			return shapes.Invalid(), errors.Errorf("Map dimensions must be the axes of the inputs in order, got %v", dimensions)
+		}
+	}
+
+	output = inputs[0].Clone()
+	output.DType = computationOutputs[0].DType
+	output.Quantization = nil
+	return output, nil
+}
+
 // ArgMinMax calculates the output shape for an ArgMinMax operation.
 // It will be the shape of the operand minus the "reduce" axis.
 func ArgMinMax(operand shapes.Shape, axis int, outputDType dtypes.DType) (output shapes.Shape, err error) {
@@ -1838,6 +1902,85 @@ func FFT(x shapes.Shape, fftType types.FFTType, fftLength []int) (output shapes.
 	return
 }
 
+// isDimCompatible returns whether two axis dimensions are compatible, that is, if they are equal or
+// if either one is dynamic (shapes.DimUnknown).
+func isDimCompatible(a, b int) bool {
+	return a == b || a == shapes.DimUnknown || b == shapes.DimUnknown
+}
+
+// Cholesky returns the output shape of a Cholesky decomposition.
+// The operand a must be of rank >= 2 with float or complex dtype, and its last two axes must form square matrices
+// (the leading axes are batch axes).
+// The output shape is the same as the operand.
+func Cholesky(a shapes.Shape) (output shapes.Shape, err error) {
+	if !a.Ok() {
+		return shapes.Invalid(), errors.Errorf("Cholesky: invalid operand shape %s", a)
+	}
+	if !a.DType.IsFloat() && !a.DType.IsComplex() {
+		return shapes.Invalid(), errors.Errorf("Cholesky: operand must be float or complex, got %s", a.DType)
+	}
+	if a.Rank() < 2 {
+		return shapes.Invalid(), errors.Errorf("Cholesky: operand must have rank >= 2, got shape %s", a)
+	}
+	if !isDimCompatible(a.Dim(-1), a.Dim(-2)) {
+		return shapes.Invalid(), errors.Errorf("Cholesky: the last two axes of the operand must form square matrices, got shape %s", a)
+	}
+	return a.Clone(), nil
+}
+
+// TriangularSolve returns the output shape of a triangular solve: solving for x in op(a) * x = b (leftSide is true)
+// or x * op(a) = b (leftSide is false).
+//
+// The operand a must be of rank >= 2 with float or complex dtype, and its last two axes must form square
+// matrices [..., M, M]. The operand b must have the same dtype and batch axes (the leading axes), and its
+// last two axes are [M, N] if leftSide, or [N, M] otherwise.
+//
+// The output shape is the same as b.
+func TriangularSolve(a, b shapes.Shape, leftSide bool) (output shapes.Shape, err error) {
+	if !a.Ok() || !b.Ok() {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: invalid operand shapes a=%s, b=%s", a, b)
+	}
+	if a.DType != b.DType {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: operands must have the same dtype, got a=%s and b=%s", a.DType, b.DType)
+	}
+	if !a.DType.IsFloat() && !a.DType.IsComplex() {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: operands must be float or complex, got %s", a.DType)
+	}
+	if a.Rank() < 2 || a.Rank() != b.Rank() {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: operands must have the same rank >= 2, got a=%s and b=%s", a, b)
+	}
+	if !isDimCompatible(a.Dim(-1), a.Dim(-2)) {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: the last two axes of a must form square matrices, got shape %s", a)
+	}
+	rank := a.Rank()
+	for axis := range rank - 2 {
+		if !isDimCompatible(a.Dimensions[axis], b.Dimensions[axis]) {
+			return shapes.Invalid(), errors.Errorf("TriangularSolve: batch axis %d of a=%s and b=%s don't match", axis, a, b)
+		}
+	}
+	solvedAxis := rank - 2
+	if !leftSide {
+		solvedAxis = rank - 1
+	}
+	if !isDimCompatible(a.Dim(-1), b.Dimensions[solvedAxis]) {
+		return shapes.Invalid(), errors.Errorf("TriangularSolve: axis %d of b=%s must match the dimension of the matrices of a=%s (leftSide=%v)",
+			solvedAxis, b, a, leftSide)
+	}
+	return b.Clone(), nil
+}
+
+// ReducePrecision returns the output shape of the ReducePrecision op: the same as the operand, which must be a float.
+func ReducePrecision(operand shapes.Shape, exponentBits, mantissaBits int) (output shapes.Shape, err error) {
+	if !operand.DType.IsFloat() {
+		return shapes.Invalid(), errors.Errorf("ReducePrecision requires a float operand, got %s", operand)
+	}
+	if exponentBits < 1 || mantissaBits < 0 {
+		return shapes.Invalid(), errors.Errorf("ReducePrecision requires exponentBits >= 1 and mantissaBits >= 0, got %d and %d",
+			exponentBits, mantissaBits)
+	}
+	return operand.Clone(), nil
+}
+
 // CollectiveBroadcast returns the output shape for a collective_broadcast operation.
 // The output shape is identical to the operand shape.
 func CollectiveBroadcast(operand shapes.Shape, replicaGroups [][]int) (output shapes.Shape, err error) {
@@ -1969,6 +2112,58 @@ func AllReduce(operands []shapes.Shape, reductionInputs, reductionOutputs []shap
 	return outputs, nil
 }
 
+// ReduceScatter returns the output shape for a reduce_scatter operation: the operand is reduced across the
+// replicas of each group, and the result is split along scatterDimension, each replica getting one part.
+//
+// The size of the operand along scatterDimension must be divisible by the size of the replica groups,
+// and all groups must have the same size.
+func ReduceScatter(operand shapes.Shape, reductionInputs, reductionOutputs []shapes.Shape, replicaGroups [][]int,
+	scatterDimension int) (output shapes.Shape, err error) {
+	if !operand.Ok() {
+		return shapes.Invalid(), errors.Errorf("ReduceScatter: invalid operand shape %s", operand)
+	}
+	if len(replicaGroups) == 0 || len(replicaGroups[0]) == 0 {
+		return shapes.Invalid(), errors.New("ReduceScatter: replica_groups cannot be empty")
+	}
+	groupSize := len(replicaGroups[0])
+	for i, group := range replicaGroups {
+		if len(group) != groupSize {
+			return shapes.Invalid(), errors.Errorf("ReduceScatter: all replica groups must have the same size, "+
+				"group #0 has %d replicas, group #%d has %d", groupSize, i, len(group))
+		}
+	}
+	if scatterDimension < 0 || scatterDimension >= operand.Rank() {
+		return shapes.Invalid(), errors.Errorf("ReduceScatter: scatter_dimension %d is out of bounds for operand rank %d",
+			scatterDimension, operand.Rank())
+	}
+
+	// Check the computation function signature.
+	if len(reductionInputs) != 2 || len(reductionOutputs) != 1 {
+		return shapes.Invalid(), errors.Errorf("ReduceScatter: computation function must have 2 inputs and 1 output, "+
+			"but got %d inputs and %d outputs", len(reductionInputs), len(reductionOutputs))
+	}
+	for _, s := range []shapes.Shape{reductionInputs[0], reductionInputs[1], reductionOutputs[0]} {
+		if !s.IsScalar() || s.DType != operand.DType {
+			return shapes.Invalid(), errors.Errorf(
+				"ReduceScatter: computation function inputs and output must be scalar with the same dtype as the operand, "+
+					"got (%s, %s) -> %s -- operand dtype is %s",
+				reductionInputs[0], reductionInputs[1], reductionOutputs[0], operand.DType)
+		}
+	}
+
+	output = operand.Clone()
+	scatterDimSize := operand.Dimensions[scatterDimension]
+	// Skip divisibility check for dynamic dimensions
+	if scatterDimSize != shapes.DimUnknown {
+		if scatterDimSize%groupSize != 0 {
+			return shapes.Invalid(), errors.Errorf("ReduceScatter: scatter_dimension size %d is not divisible by the replica group size %d",
+				scatterDimSize, groupSize)
+		}
+		output.Dimensions[scatterDimension] = scatterDimSize / groupSize
+	}
+	return output, nil
+}
+
 // While returns the operation's output shapes and validates the condition and body functions.
 //
 // The While operation implements a loop that continues executing the body function
@@ -2105,6 +2300,67 @@ func If(pred shapes.Shape, trueBranchInputs, trueBranchOutputs, falseBranchInput
 	return outputs, nil
 }
 
+// Case performs shape inference for the stablehlo.case operation.
+//
+// The Case operation executes exactly one of the branches, selected by a scalar int32 index.
+// All branches must have no inputs and must produce outputs with compatible shapes.
+//
+// Parameters:
+//   - index: Shape of the index (must be scalar int32)
+//   - branchesInputs: Input shapes of each branch (must be empty)
+//   - branchesOutputs: Output shapes of each branch (must be compatible with each other)
+//
+// Returns:
+//   - outputs: The output shapes (same as branch outputs, merging dynamic dimensions)
+//   - err: Error if validation fails
+func Case(index shapes.Shape, branchesInputs, branchesOutputs [][]shapes.Shape) (outputs []shapes.Shape, err error) {
+	// Validate index is scalar int32
+	if !index.IsScalar() || index.DType != dtypes.Int32 {
+		return nil, errors.Errorf("Case index must be a scalar int32, got %s", index)
+	}
+	if len(branchesOutputs) == 0 {
+		return nil, errors.Errorf("Case requires at least one branch")
+	}
+	if len(branchesInputs) != len(branchesOutputs) {
+		return nil, errors.Errorf("Case got inputs for %d branches, but outputs for %d branches",
+			len(branchesInputs), len(branchesOutputs))
+	}
+
+	// Validate branches have no inputs (per StableHLO spec)
+	for i, inputs := range branchesInputs {
+		if len(inputs) != 0 {
+			return nil, errors.Errorf("Case branch #%d must have no inputs, got %d", i, len(inputs))
+		}
+	}
+
+	// Validate branches have compatible outputs, and merge dynamic dimensions.
+	first := branchesOutputs[0]
+	outputs = make([]shapes.Shape, len(first))
+	for i, s := range first {
+		outputs[i] = s.Clone()
+	}
+	for branchIdx, branchOutputs := range branchesOutputs[1:] {
+		branchIdx++
+		if len(branchOutputs) != len(first) {
+			return nil, errors.Errorf("Case branches must have same number of outputs, branch #0 has %d, branch #%d has %d",
+				len(first), branchIdx, len(branchOutputs))
+		}
+		for i := range branchOutputs {
+			if !areEqualShapesCompatible(outputs[i], branchOutputs[i]) {
+				return nil, errors.Errorf("Case branch outputs[%d] must be compatible, branch #0 has %s, branch #%d has %s",
+					i, first[i], branchIdx, branchOutputs[i])
+			}
+			// Merge dynamic dimensions: use concrete dimension if one branch has it
+			for axis := range outputs[i].Dimensions {
+				if outputs[i].Dimensions[axis] == shapes.DimUnknown && branchOutputs[i].Dimensions[axis] != shapes.DimUnknown {
+					outputs[i].Dimensions[axis] = branchOutputs[i].Dimensions[axis]
+				}
+			}
+		}
+	}
+	return outputs, nil
+}
+
 // Call performs shape inference for the stablehlo.call operation.
 // It validates that the operand shapes match the callee's input shapes
 // and returns the callee's output shapes.
@@ -2131,3 +2387,15 @@ func Call(operands, calleeInputs, calleeOutputs []shapes.Shape) (outputs []shape
 
 	return outputs, nil
 }
+
+// GetTupleElement returns the shape of the element at the given index of a tuple.
+func GetTupleElement(tuple shapes.Shape, index int) (output shapes.Shape, err error) {
+	if !tuple.IsTuple() {
+		return shapes.Invalid(), errors.Errorf("GetTupleElement requires a tuple operand, got %s", tuple)
+	}
+	if index < 0 || index >= tuple.TupleSize() {
+		return shapes.Invalid(), errors.Errorf("GetTupleElement index %d out of range for tuple %s with %d elements",
+			index, tuple, tuple.TupleSize())
+	}
+	return tuple.TupleShapes[index].Clone(), nil
+}
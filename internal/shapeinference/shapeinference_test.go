@@ -1004,4 +1004,202 @@ func TestCollectiveOps(t *testing.T) {
 			t.Errorf("Expected %s, got %s", operand, output)
 		}
 	})
+
+	t.Run("ReduceScatter", func(t *testing.T) {
+		scalar := S(F32)
+		reductionInputs, reductionOutputs := []shapes.Shape{scalar, scalar}, []shapes.Shape{scalar}
+		output, err := ReduceScatter(operand, reductionInputs, reductionOutputs, replicaGroups, 1)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected := S(F32, 2, 2)
+		if !expected.Equal(output) {
+			t.Errorf("Expected %s, got %s", expected, output)
+		}
+
+		_, err = ReduceScatter(S(F32, 3, 4), reductionInputs, reductionOutputs, replicaGroups, 0)
+		if err == nil {
+			t.Error("expected error for ReduceScatter with non-divisible scatter dimension, got nil")
+		}
+		_, err = ReduceScatter(operand, reductionInputs, reductionOutputs, replicaGroups, 2)
+		if err == nil {
+			t.Error("expected error for ReduceScatter with invalid scatter dimension, got nil")
+		}
+		_, err = ReduceScatter(operand, reductionInputs, reductionOutputs, [][]int{{0, 1}, {2}}, 1)
+		if err == nil {
+			t.Error("expected error for ReduceScatter with replica groups of different sizes, got nil")
+		}
+		_, err = ReduceScatter(operand, []shapes.Shape{S(I32), S(I32)}, []shapes.Shape{S(I32)}, replicaGroups, 1)
+		if err == nil {
+			t.Error("expected error for ReduceScatter with computation of the wrong dtype, got nil")
+		}
+	})
+}
+
+func TestCase(t *testing.T) {
+	index := S(I32)
+	noInputs := [][]shapes.Shape{nil, nil, nil}
+	outputs, err := Case(index, noInputs, [][]shapes.Shape{
+		{S(F32, 2, shapes.DimUnknown), S(Bool)},
+		{S(F32, 2, 3), S(Bool)},
+		{S(F32, shapes.DimUnknown, 3), S(Bool)},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(outputs) != 2 || !outputs[0].Equal(S(F32, 2, 3)) || !outputs[1].Equal(S(Bool)) {
+		t.Errorf("expected [(Float32)[2 3] (Bool)], got %v", outputs)
+	}
+
+	for name, tc := range map[string]struct {
+		index   shapes.Shape
+		inputs  [][]shapes.Shape
+		outputs [][]shapes.Shape
+	}{
+		"non-scalar index":      {S(I32, 1), [][]shapes.Shape{nil}, [][]shapes.Shape{{S(F32)}}},
+		"non-int32 index":       {S(I8), [][]shapes.Shape{nil}, [][]shapes.Shape{{S(F32)}}},
+		"no branches":           {index, nil, nil},
+		"branch with inputs":    {index, [][]shapes.Shape{{S(F32)}}, [][]shapes.Shape{{S(F32)}}},
+		"different num outputs": {index, [][]shapes.Shape{nil, nil}, [][]shapes.Shape{{S(F32)}, {S(F32), S(F32)}}},
+		"incompatible outputs":  {index, [][]shapes.Shape{nil, nil}, [][]shapes.Shape{{S(F32, 2)}, {S(F32, 3)}}},
+	} {
+		if _, err := Case(tc.index, tc.inputs, tc.outputs); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLinearAlgebra(t *testing.T) {
+	t.Run("Cholesky", func(t *testing.T) {
+		output, err := Cholesky(S(F32, 5, 3, 3))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !output.Equal(S(F32, 5, 3, 3)) {
+			t.Errorf("Expected %s, got %s", S(F32, 5, 3, 3), output)
+		}
+		for _, invalid := range []shapes.Shape{S(F32, 3), S(F32, 3, 4), S(I32, 3, 3)} {
+			if _, err := Cholesky(invalid); err == nil {
+				t.Errorf("expected error for Cholesky(%s), got nil", invalid)
+			}
+		}
+	})
+
+	t.Run("TriangularSolve", func(t *testing.T) {
+		a := S(F32, 5, 3, 3)
+		for _, tc := range []struct {
+			b        shapes.Shape
+			leftSide bool
+		}{
+			{S(F32, 5, 3, 7), true},
+			{S(F32, 5, 7, 3), false},
+		} {
+			output, err := TriangularSolve(a, tc.b, tc.leftSide)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !output.Equal(tc.b) {
+				t.Errorf("Expected %s, got %s", tc.b, output)
+			}
+		}
+		for _, tc := range []struct {
+			a, b     shapes.Shape
+			leftSide bool
+		}{
+			{a, S(F32, 5, 7, 3), true},               // Wrong solved axis for left side.
+			{a, S(F32, 5, 3, 7), false},              // Wrong solved axis for right side.
+			{a, S(F32, 4, 3, 7), true},               // Batch mismatch.
+			{a, S(F32, 3, 7), true},                  // Rank mismatch.
+			{S(F32, 5, 3, 4), S(F32, 5, 3, 7), true}, // a not square.
+			{a, S(dtypes.Float64, 5, 3, 7), true},    // DType mismatch.
+		} {
+			if _, err := TriangularSolve(tc.a, tc.b, tc.leftSide); err == nil {
+				t.Errorf("expected error for TriangularSolve(%s, %s, leftSide=%v), got nil", tc.a, tc.b, tc.leftSide)
+			}
+		}
+	})
+
+	t.Run("ReducePrecision", func(t *testing.T) {
+		output, err := ReducePrecision(S(F32, 2, 3), 4, 3)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !output.Equal(S(F32, 2, 3)) {
+			t.Errorf("Expected %s, got %s", S(F32, 2, 3), output)
+		}
+		if _, err := ReducePrecision(S(I32, 2, 3), 4, 3); err == nil {
+			t.Error("expected error for ReducePrecision of an integer, got nil")
+		}
+		if _, err := ReducePrecision(S(F32, 2, 3), 4, -1); err == nil {
+			t.Error("expected error for ReducePrecision with negative mantissa bits, got nil")
+		}
+	})
+}
+
+func TestTuple(t *testing.T) {
+	tuple := shapes.MakeTuple([]shapes.Shape{S(F32, 2, 3), S(I32)})
+	output, err := GetTupleElement(tuple, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !output.Equal(S(I32)) {
+		t.Errorf("Expected %s, got %s", S(I32), output)
+	}
+	for _, index := range []int{-1, 2} {
+		if _, err := GetTupleElement(tuple, index); err == nil {
+			t.Errorf("expected error for GetTupleElement(%s, %d), got nil", tuple, index)
+		}
+	}
+	if _, err := GetTupleElement(S(F32, 2), 0); err == nil {
+		t.Error("expected error for GetTupleElement of a non-tuple, got nil")
+	}
+
+	// Tuples passed to a Call must match element by element.
+	if _, err := Call([]shapes.Shape{tuple}, []shapes.Shape{tuple}, nil); err != nil {
+		t.Errorf("expected no error calling with matching tuples, got %v", err)
+	}
+	for _, calleeInput := range []shapes.Shape{
+		shapes.MakeTuple([]shapes.Shape{S(F32, 2, 3), S(F32)}),
+		shapes.MakeTuple([]shapes.Shape{S(F32, 2, 3)}),
+		S(F32, 2, 3),
+	} {
+		if _, err := Call([]shapes.Shape{tuple}, []shapes.Shape{calleeInput}, nil); err == nil {
+			t.Errorf("expected error calling with %s for parameter %s, got nil", tuple, calleeInput)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	output, err := Map([]shapes.Shape{S(F32, 2, 3), S(F32, 2, 3)}, []shapes.Shape{S(F32), S(F32)}, []shapes.Shape{S(Bool)},
+		[]int{0, 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !output.Equal(S(Bool, 2, 3)) {
+		t.Errorf("Expected %s, got %s", S(Bool, 2, 3), output)
+	}
+
+	// Invalid cases.
+	for _, tc := range []struct {
+		name                                          string
+		inputs, computationInputs, computationOutputs []shapes.Shape
+		dimensions                                    []int
+	}{
+		{"different dimensions", []shapes.Shape{S(F32, 2, 3), S(F32, 3, 2)}, []shapes.Shape{S(F32), S(F32)},
+			[]shapes.Shape{S(F32)}, []int{0, 1}},
+		{"wrong number of computation inputs", []shapes.Shape{S(F32, 2)}, []shapes.Shape{S(F32), S(F32)},
+			[]shapes.Shape{S(F32)}, []int{0}},
+		{"non-scalar computation input", []shapes.Shape{S(F32, 2)}, []shapes.Shape{S(F32, 2)},
+			[]shapes.Shape{S(F32)}, []int{0}},
+		{"mismatched computation input dtype", []shapes.Shape{S(F32, 2)}, []shapes.Shape{S(I32)},
+			[]shapes.Shape{S(F32)}, []int{0}},
+		{"multiple computation outputs", []shapes.Shape{S(F32, 2)}, []shapes.Shape{S(F32)},
+			[]shapes.Shape{S(F32), S(F32)}, []int{0}},
+		{"bad dimensions", []shapes.Shape{S(F32, 2, 3)}, []shapes.Shape{S(F32)},
+			[]shapes.Shape{S(F32)}, []int{1, 0}},
+	} {
+		if _, err := Map(tc.inputs, tc.computationInputs, tc.computationOutputs, tc.dimensions); err == nil {
+			t.Errorf("expected error for Map with %s, got nil", tc.name)
+		}
+	}
 }
@@ -233,6 +233,83 @@ func testCollectiveOps(t *testing.T, client *pjrt.Client) {
 		requireBuffersEqual(t, want, outputBuffers)
 	})
 
+	t.Run("ReduceScatter", func(t *testing.T) {
+		b := New(t.Name()).WithNumReplicas(numReplicas)
+		fn := b.Main()
+		sumComputation := fn.Closure()
+		{
+			lhs := must1(sumComputation.NamedInput("lhs", shapes.Make(dtypes.F32)))
+			rhs := must1(sumComputation.NamedInput("rhs", shapes.Make(dtypes.F32)))
+			must(sumComputation.Return(must1(Add(lhs, rhs))))
+		}
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2, 2)))
+		scattered := must1(ReduceScatter(x, replicaGroups, 1, sumComputation))
+		must(fn.Return(scattered))
+		program := must1(b.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		input0 := must1(client.BufferFromHost().FromFlatDataWithDimensions(
+			[]float32{1.0, 2.0, 3.0, 4.0}, []int{2, 2}).ToDeviceNum(replicaGroups[0][0]).Done())
+		input1 := must1(client.BufferFromHost().FromFlatDataWithDimensions(
+			[]float32{10.0, 20.0, 30.0, 40.0}, []int{2, 2}).ToDeviceNum(replicaGroups[0][1]).Done())
+
+		e, err := client.Compile().WithStableHLO(program).WithSPMD(numReplicas).Done()
+		if err != nil {
+			t.Errorf("failed to compile program: \n%s\nError: %v", program, err)
+			return
+		}
+		outputBuffers, err := e.Execute(input0, input1).DonateAll().Done()
+		if err != nil {
+			t.Errorf("failed to execute program: \n%s\nError: %v", program, err)
+			return
+		}
+
+		// The sum is [[11, 22], [33, 44]]: replica 0 gets the first column, replica 1 the second.
+		want := []FlatAndDims{
+			{[]float32{11.0, 33.0}, []int{2, 1}},
+			{[]float32{22.0, 44.0}, []int{2, 1}},
+		}
+		requireBuffersEqual(t, want, outputBuffers)
+	})
+
+	t.Run("ReplicaId", func(t *testing.T) {
+		b := New(t.Name()).WithNumReplicas(numReplicas)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2)))
+		replicaId := must1(fn.ReplicaId())
+		// Scale x by (replica_id + 1).
+		factor := must1(Add(must1(Convert(replicaId, dtypes.F32)), must1(fn.ConstantFromScalar(float32(1)))))
+		scaled := must1(Multiply(x, must1(BroadcastInDim(factor, x.Shape(), nil))))
+		must(fn.Return(replicaId, scaled))
+		program := must1(b.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		input0 := must1(client.BufferFromHost().FromFlatDataWithDimensions(
+			[]float32{1.0, 10.0}, []int{2}).ToDeviceNum(replicaGroups[0][0]).Done())
+		input1 := must1(client.BufferFromHost().FromFlatDataWithDimensions(
+			[]float32{1.0, 10.0}, []int{2}).ToDeviceNum(replicaGroups[0][1]).Done())
+
+		e, err := client.Compile().WithStableHLO(program).WithSPMD(numReplicas).Done()
+		if err != nil {
+			t.Errorf("failed to compile program: \n%s\nError: %v", program, err)
+			return
+		}
+		outputBuffers, err := e.Execute(input0, input1).DonateAll().Done()
+		if err != nil {
+			t.Errorf("failed to execute program: \n%s\nError: %v", program, err)
+			return
+		}
+
+		// Outputs are given in order of replica, for each output.
+		want := []FlatAndDims{
+			{[]uint32{0}, nil},
+			{[]float32{1.0, 10.0}, []int{2}},
+			{[]uint32{1}, nil},
+			{[]float32{2.0, 20.0}, []int{2}},
+		}
+		requireBuffersEqual(t, want, outputBuffers)
+	})
+
 	t.Run("CollectivePermute", func(t *testing.T) {
 		if strings.ToUpper(client.Plugin().Name()) == "CPU" {
 			t.Skip("Skipping CollectivePermute test: it is not implemented in PJRT CPU. ")
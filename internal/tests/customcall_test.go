@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/pjrt"
+	. "github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestCustomCall(t *testing.T) {
+	iterateClientsAndTest(t, testCustomCall)
+}
+
+// registeredGoScale records whether the Go FFI handler used by testCustomCall was registered: XLA registrations are
+// process-wide.
+var registeredGoScale bool
+
+func testCustomCall(t *testing.T, client *pjrt.Client) {
+	if client.Platform() != "cpu" {
+		t.Skipf("Go FFI handlers only tested with the CPU plugin, got platform %q", client.Platform())
+	}
+	const targetName = "go_xla_test_scale"
+	if !registeredGoScale {
+		err := client.Plugin().RegisterFFIHandler(targetName, "Host", func(call *pjrt.FFICall) error {
+			x, err := pjrt.FFIBufferData[float32](call.Args[0])
+			if err != nil {
+				return err
+			}
+			y, err := pjrt.FFIBufferData[float32](call.Results[0])
+			if err != nil {
+				return err
+			}
+			scale := call.Attributes["scale"].(float32)
+			offsets := call.Attributes["offsets"].([]float32)
+			for i := range x {
+				y[i] = x[i]*scale + offsets[i]
+			}
+			return nil
+		})
+		if err != nil && strings.Contains(err.Error(), "not supported") {
+			t.Skipf("Plugin doesn't support Go FFI handlers: %v", err)
+		}
+		requireNoError(t, err)
+		registeredGoScale = true
+	}
+
+	builder := New(t.Name())
+	fn := builder.Main()
+	x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+	outputs := must1(CustomCall(fn, targetName, []*Value{x}, []shapes.Shape{shapes.Make(dtypes.F32, 3)},
+		&CustomCallOptions{
+			TypedBackendConfig: map[string]any{
+				"scale":   float32(2),
+				"offsets": []float32{0.5, 0.25, 0},
+			},
+		}))
+	must(fn.Return(outputs[0]))
+	program := must1(builder.Build())
+	fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+	results := compileAndExecute(t, client, program)
+	requireBuffersEqual(t, []FlatAndDims{{[]float32{2.5, 4.25, 6}, []int{3}}}, results)
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/pjrt"
+	. "github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+func TestHostTransfers(t *testing.T) {
+	iterateClientsAndTest(t, testHostTransfers)
+}
+
+func testHostTransfers(t *testing.T, client *pjrt.Client) {
+	// The program receives a value from the host, sends its double back to the host, and returns it plus 1.
+	sendChannel, recvChannel := 1, 2
+	builder := New(t.Name())
+	fn := builder.Main()
+	token := must1(fn.CreateToken())
+	received, token := must2(Recv(token, []shapes.Shape{shapes.Make(dtypes.F32, 3)}, true,
+		&types.CollectiveConfig{ChannelID: &recvChannel}))
+	x := received[0]
+	doubled := must1(Add(x, x))
+	token = must1(Send(token, []*Value{doubled}, true, &types.CollectiveConfig{ChannelID: &sendChannel}))
+	one := must1(BroadcastInDim(must1(fn.ConstantFromScalar(float32(1))), x.Shape(), nil))
+	result := must1(Add(x, one))
+	// Return also the token, so the Send is not dropped.
+	must(fn.Return(result, token))
+	program := must1(builder.Build())
+	fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+	loadedExec, err := client.Compile().WithStableHLO(program).Done()
+	if err != nil {
+		t.Fatalf("failed to compile program: \n%s\nError: %v", program, err)
+	}
+	defer func() { requireNoError(t, loadedExec.Destroy()) }()
+
+	var mu sync.Mutex
+	var sent []float32
+	outputs, err := loadedExec.Execute().
+		WithHostRecvCallback(recvChannel, func(deviceNum int, numBytes int) ([]byte, error) {
+			if numBytes != 3*4 {
+				return nil, errors.Errorf("expected to send 12 bytes, got request for %d", numBytes)
+			}
+			data := make([]byte, 0, numBytes)
+			for _, v := range []float32{1, 2, 3} {
+				data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+			}
+			return data, nil
+		}).
+		WithHostSendCallback(sendChannel, func(deviceNum int, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for i := 0; i+4 <= len(data); i += 4 {
+				sent = append(sent, math.Float32frombits(binary.LittleEndian.Uint32(data[i:])))
+			}
+			return nil
+		}).
+		Done()
+	if err != nil && (strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "UNIMPLEMENTED")) {
+		t.Skipf("Plugin doesn't support host send/recv callbacks: %v", err)
+	}
+	requireNoError(t, err)
+	requireNoError(t, outputs[1].Destroy()) // Token.
+	requireBuffersEqual(t, []FlatAndDims{{[]float32{2, 3, 4}, []int{3}}}, outputs[:1])
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(sent) != fmt.Sprint([]float32{2, 4, 6}) {
+		t.Errorf("expected host to receive [2 4 6], got %v", sent)
+	}
+}
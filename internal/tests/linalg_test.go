@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/pjrt"
+	. "github.com/gomlx/go-xla/pkg/stablehlo"
+)
+
+func TestLinearAlgebra(t *testing.T) {
+	iterateClientsAndTest(t, testLinearAlgebra)
+}
+
+// spdMatrices returns a batch of numMatrices symmetric positive definite [n, n] matrices, flattened.
+func spdMatrices(numMatrices, n int) []float64 {
+	flat := make([]float64, 0, numMatrices*n*n)
+	for batch := range numMatrices {
+		// a = m * m^T + n * I, with m deterministic "random" values.
+		m := make([]float64, n*n)
+		for i := range m {
+			m[i] = math.Sin(float64(i+1) * float64(batch+2))
+		}
+		for row := range n {
+			for col := range n {
+				var sum float64
+				for k := range n {
+					sum += m[row*n+k] * m[col*n+k]
+				}
+				if row == col {
+					sum += float64(n)
+				}
+				flat = append(flat, sum)
+			}
+		}
+	}
+	return flat
+}
+
+// referenceCholesky returns the lower triangular l such that a = l * l^T, for a [n, n] matrix a.
+func referenceCholesky(a []float64, n int) []float64 {
+	l := make([]float64, n*n)
+	for row := range n {
+		for col := 0; col <= row; col++ {
+			sum := a[row*n+col]
+			for k := range col {
+				sum -= l[row*n+k] * l[col*n+k]
+			}
+			if row == col {
+				l[row*n+col] = math.Sqrt(sum)
+			} else {
+				l[row*n+col] = sum / l[col*n+col]
+			}
+		}
+	}
+	return l
+}
+
+// referenceSolve solves t * x = b, for a dense [m, m] matrix t and a [m, n] matrix b, using Gaussian elimination
+// with partial pivoting.
+func referenceSolve(t, b []float64, m, n int) []float64 {
+	t = append([]float64(nil), t...)
+	x := append([]float64(nil), b...)
+	for col := range m {
+		pivot := col
+		for row := col + 1; row < m; row++ {
+			if math.Abs(t[row*m+col]) > math.Abs(t[pivot*m+col]) {
+				pivot = row
+			}
+		}
+		for k := range m {
+			t[col*m+k], t[pivot*m+k] = t[pivot*m+k], t[col*m+k]
+		}
+		for k := range n {
+			x[col*n+k], x[pivot*n+k] = x[pivot*n+k], x[col*n+k]
+		}
+		for row := range m {
+			if row == col {
+				continue
+			}
+			factor := t[row*m+col] / t[col*m+col]
+			for k := range m {
+				t[row*m+k] -= factor * t[col*m+k]
+			}
+			for k := range n {
+				x[row*n+k] -= factor * x[col*n+k]
+			}
+		}
+	}
+	for row := range m {
+		for k := range n {
+			x[row*n+k] /= t[row*m+row]
+		}
+	}
+	return x
+}
+
+// transposeMatrix transposes the [rows, cols] matrix.
+func transposeMatrix(a []float64, rows, cols int) []float64 {
+	result := make([]float64, len(a))
+	for row := range rows {
+		for col := range cols {
+			result[col*rows+row] = a[row*cols+col]
+		}
+	}
+	return result
+}
+
+// referenceTriangularSolve is the pure-Go version of TriangularSolve for a [m, m] matrix a, and b shaped [m, n]
+// if leftSide or [n, m] otherwise.
+func referenceTriangularSolve(a, b []float64, m, n int, leftSide, lower, unitDiagonal, transposeA bool) []float64 {
+	// Dense version of op(a), using only the selected triangle.
+	opA := make([]float64, m*m)
+	for row := range m {
+		for col := range m {
+			switch {
+			case row == col && unitDiagonal:
+				opA[row*m+col] = 1
+			case row == col || (lower && col < row) || (!lower && col > row):
+				opA[row*m+col] = a[row*m+col]
+			}
+		}
+	}
+	if transposeA {
+		opA = transposeMatrix(opA, m, m)
+	}
+	if leftSide {
+		return referenceSolve(opA, b, m, n)
+	}
+	// x * opA = b  <=>  opA^T * x^T = b^T
+	xT := referenceSolve(transposeMatrix(opA, m, m), transposeMatrix(b, n, m), m, n)
+	return transposeMatrix(xT, m, n)
+}
+
+// TestLinearAlgebraReference checks the pure-Go reference implementations used by testLinearAlgebra.
+func TestLinearAlgebraReference(t *testing.T) {
+	const m, n = 4, 3
+	a := spdMatrices(1, m)
+	l := referenceCholesky(a, m)
+	for row := range m {
+		for col := range m {
+			var sum float64
+			for k := range m {
+				sum += l[row*m+k] * l[col*m+k]
+			}
+			assertInDelta(t, a[row*m+col], sum, 1e-9)
+		}
+	}
+
+	// Solving with the lower triangle of a: l * x = b.
+	b := make([]float64, m*n)
+	for i := range b {
+		b[i] = float64(i)
+	}
+	x := referenceTriangularSolve(l, b, m, n, true, true, false, false)
+	for row := range m {
+		for col := range n {
+			var sum float64
+			for k := range m {
+				sum += l[row*m+k] * x[k*n+col]
+			}
+			assertInDelta(t, b[row*n+col], sum, 1e-9)
+		}
+	}
+
+	// Right side, transposed: x * l^T = bT.
+	bT := transposeMatrix(b, m, n)
+	x = referenceTriangularSolve(l, bT, m, n, false, true, false, true)
+	for row := range n {
+		for col := range m {
+			var sum float64
+			for k := range m {
+				sum += x[row*m+k] * l[col*m+k]
+			}
+			assertInDelta(t, bT[row*m+col], sum, 1e-9)
+		}
+	}
+}
+
+func testLinearAlgebra(t *testing.T, client *pjrt.Client) {
+	const numMatrices, m, n = 2, 4, 3
+
+	t.Run("Cholesky", func(t *testing.T) {
+		for _, lower := range []bool{true, false} {
+			builder := New(fmt.Sprintf("%s_lower_%v", t.Name(), lower))
+			fn := builder.Main()
+			aFlat := spdMatrices(numMatrices, m)
+			a := must1(fn.ConstantFromFlatAndDimensions(aFlat, numMatrices, m, m))
+			must(fn.Return(must1(Cholesky(a, lower))))
+			program := must1(builder.Build())
+			fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+			results := compileAndExecute(t, client, program)
+			got, _ := must2(pjrt.BufferToArray[float64](results[0]))
+			requireNoError(t, results[0].Destroy())
+
+			for batch := range numMatrices {
+				offset := batch * m * m
+				want := referenceCholesky(aFlat[offset:offset+m*m], m)
+				if !lower {
+					want = transposeMatrix(want, m, m)
+				}
+				for row := range m {
+					for col := range m {
+						if (lower && col > row) || (!lower && col < row) {
+							// Values outside the triangle are implementation-defined.
+							continue
+						}
+						assertInDelta(t, want[row*m+col], got[offset+row*m+col], 1e-6)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("TriangularSolve", func(t *testing.T) {
+		aFlat := spdMatrices(numMatrices, m)
+		bFlat := make([]float64, numMatrices*m*n)
+		for i := range bFlat {
+			bFlat[i] = math.Cos(float64(i))
+		}
+		for _, leftSide := range []bool{true, false} {
+			for _, lower := range []bool{true, false} {
+				for _, unitDiagonal := range []bool{true, false} {
+					for _, transposeA := range []bool{true, false} {
+						name := fmt.Sprintf("left_%v_lower_%v_unit_%v_transpose_%v", leftSide, lower, unitDiagonal, transposeA)
+						builder := New(name)
+						fn := builder.Main()
+						a := must1(fn.ConstantFromFlatAndDimensions(aFlat, numMatrices, m, m))
+						bDims := []int{numMatrices, m, n}
+						if !leftSide {
+							bDims = []int{numMatrices, n, m}
+						}
+						b := must1(fn.ConstantFromFlatAndDimensions(bFlat, bDims...))
+						must(fn.Return(must1(TriangularSolve(a, b, leftSide, lower, unitDiagonal, transposeA))))
+						program := must1(builder.Build())
+						results := compileAndExecute(t, client, program)
+						got, dims := must2(pjrt.BufferToArray[float64](results[0]))
+						requireNoError(t, results[0].Destroy())
+						if fmt.Sprint(dims) != fmt.Sprint(bDims) {
+							t.Fatalf("%s: expected dimensions %v, got %v", name, bDims, dims)
+						}
+						for batch := range numMatrices {
+							aOffset, bOffset := batch*m*m, batch*m*n
+							want := referenceTriangularSolve(aFlat[aOffset:aOffset+m*m], bFlat[bOffset:bOffset+m*n],
+								m, n, leftSide, lower, unitDiagonal, transposeA)
+							for i := range want {
+								assertInDelta(t, want[i], got[bOffset+i], 1e-6)
+							}
+						}
+					}
+				}
+			}
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"iter"
 	"math"
 	"math/bits"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -901,6 +902,102 @@ func testOps(t *testing.T, client *pjrt.Client) {
 			{[]float32{7, 7, 7}, []int{3}},              // The offset impacts each feature equally.
 		}, outputs)
 	})
+
+	t.Run("Tuple", func(t *testing.T) {
+		builder := New(t.Name())
+
+		// scaleBy takes a tuple (x, scale) and returns x*scale.
+		scaleBy := builder.NewFunction("scale_by")
+		pair := must1(scaleBy.Input(shapes.MakeTuple([]shapes.Shape{
+			shapes.Make(dtypes.F32, 3), shapes.Make(dtypes.F32)})))
+		x := must1(GetTupleElement(pair, 0))
+		scale := must1(BroadcastInDim(must1(GetTupleElement(pair, 1)), x.Shape(), nil))
+		must(scaleBy.Return(must1(Multiply(x, scale))))
+
+		fn := builder.Main()
+		x = must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		scale = must1(fn.ConstantFromScalar(float32(10)))
+		results := must1(Call(scaleBy, must1(Tuple(x, scale))))
+		must(fn.Return(results[0]))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{10, 20, 30}, []int{3}}}, outputs)
+	})
+
+	t.Run("TupleOutputs", func(t *testing.T) {
+		// The main function can't return a tuple, but its elements can be returned instead.
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		n := must1(fn.ConstantFromScalar(int32(7)))
+		pair := must1(Tuple(x, n))
+		if err := fn.Return(pair); err == nil {
+			t.Fatal("expected error returning a tuple from main, got nil")
+		}
+		must(fn.Return(must1(GetTupleElement(pair, 1)), must1(GetTupleElement(pair, 0))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		requireBuffersEqual(t, []FlatAndDims{{[]int32{7}, nil}, {[]float32{1, 2, 3}, []int{3}}}, outputs)
+
+		// HLO programs (e.g.: exported from JAX) returning a tuple have it untupled by PJRT into one buffer per element.
+		hloBin := must1(os.ReadFile("../../pkg/pjrt/test_tuple_hlo.pb"))
+		loadedExec := must1(client.Compile().WithHLO(hloBin).Done())
+		defer func() { must(loadedExec.Destroy()) }()
+		input := must1(client.BufferFromHost().FromRawData(pjrt.ScalarToRaw(float32(9))).Done())
+		outputs = must1(loadedExec.Execute(input).DonateAll().Done())
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{81}, nil}, {[]float32{3}, nil}}, outputs)
+	})
+
+	t.Run("ReducePrecision", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 1.1, 3.3, 0.3, -17}, 5))
+		exponentBits, mantissaBits, _ := dtypes.F8E4M3FN.ExponentAndMantissaBits()
+		must(fn.Return(must1(ReducePrecision(x, exponentBits, mantissaBits))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		// With 3 mantissa bits, values in [2^e, 2^(e+1)) are rounded (to even) to multiples of 2^(e-3).
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{1, 1.125, 3.25, 0.3125, -16}, []int{5}}}, outputs)
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		builder := New(t.Name())
+
+		// The decomposition is used by backends that don't recognize the composite name.
+		decomposition := builder.NewFunction("my.scale_and_shift")
+		x := must1(decomposition.Input(shapes.Make(dtypes.F32, 3)))
+		two := must1(BroadcastInDim(must1(decomposition.ConstantFromScalar(float32(2))), x.Shape(), nil))
+		one := must1(BroadcastInDim(must1(decomposition.ConstantFromScalar(float32(1))), x.Shape(), nil))
+		must(decomposition.Return(must1(Add(must1(Multiply(x, two)), one))))
+
+		fn := builder.Main()
+		x = must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		results := must1(Composite("my.scale_and_shift", map[string]any{"scale": float32(2)}, decomposition, x))
+		must(fn.Return(results[0]))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{3, 5, 7}, []int{3}}}, outputs)
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		y := must1(fn.ConstantFromFlatAndDimensions([]float32{10, 20, 30}, 3))
+		mapper := fn.Closure()
+		a := must1(mapper.NamedInput("a", shapes.Make(dtypes.F32)))
+		b := must1(mapper.NamedInput("b", shapes.Make(dtypes.F32)))
+		must(mapper.Return(must1(Add(must1(Multiply(a, b)), a))))
+		must(fn.Return(must1(Map(mapper, nil, x, y))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{11, 42, 93}, []int{3}}}, outputs)
+	})
 }
 
 func TestBinaryOps(t *testing.T) {
@@ -216,6 +216,29 @@ func (b *Buffer) Device() (device *Device, err error) {
 	return
 }
 
+// ReadyEvent returns an Event that is ready once the buffer contents are available -- e.g., once the execution
+// that generates the buffer or the transfer to the buffer completes.
+//
+// If the computation of the buffer failed, the event holds the corresponding error.
+func (b *Buffer) ReadyEvent() (*Event, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_Buffer_ReadyEvent_Args](arena)
+	args.struct_size = C.PJRT_Buffer_ReadyEvent_Args_STRUCT_SIZE
+	args.buffer = b.wrapper.c
+	err = toError(plugin, C.call_PJRT_Buffer_ReadyEvent(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newEvent(plugin, args.event), nil
+}
+
 // Client returns the client that created this Buffer.
 func (b *Buffer) Client() *Client {
 	return b.wrapper.client
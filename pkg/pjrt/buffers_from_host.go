@@ -52,6 +52,7 @@ import (
 // - FromFlatDataWithDimensions: it takes as inputs a flat slice and shape (dtype and dimensions).
 //
 // The device defaults to 0, but it can be configured with BufferFromHostConfig.ToDevice or BufferFromHostConfig.ToDeviceNum.
+// Alternatively, a specific memory space can be given with BufferFromHostConfig.ToMemory.
 //
 // At the end call BufferFromHostConfig.Done to actually initiate the transfer.
 //
@@ -62,6 +63,7 @@ type BufferFromHostConfig struct {
 	dtype      dtypes.DType
 	dimensions []int
 	device     *Device
+	memory     *Memory
 
 	hostBufferSemantics PJRT_HostBufferSemantics
 
@@ -105,6 +107,7 @@ func (b *BufferFromHostConfig) ToDevice(device *Device) *BufferFromHostConfig {
 		return b
 	}
 	b.device = device
+	b.memory = nil
 	return b
 }
 
@@ -125,6 +128,27 @@ func (b *BufferFromHostConfig) ToDeviceNum(deviceNum int) *BufferFromHostConfig
 	return b.ToDevice(b.client.addressableDevices[deviceNum])
 }
 
+// ToMemory configures the memory space to copy the host data to -- e.g., a memory of kind MemoryKindPinnedHost
+// to keep the buffer in host memory. See Device.AddressableMemories and Client.AddressableMemories.
+//
+// The last one of ToDevice, ToDeviceNum or ToMemory called is used. The memory must belong to the same client.
+func (b *BufferFromHostConfig) ToMemory(memory *Memory) *BufferFromHostConfig {
+	if b.err != nil {
+		return b
+	}
+	if err := memory.check(); err != nil {
+		b.err = errors.WithMessage(err, "BufferFromHost().ToMemory() given an invalid memory")
+		return b
+	}
+	if memory.client != b.client {
+		b.err = errors.New("BufferFromHost().ToMemory() given a memory of a different client")
+		return b
+	}
+	b.memory = memory
+	b.device = nil
+	return b
+}
+
 // FromFlatDataWithDimensions configures the data to come from a flat slice of the desired data type, and the underlying
 // dimensions.
 // The flat slice size must match the product of the dimension.
@@ -186,7 +210,7 @@ func (b *BufferFromHostConfig) Done() (*Buffer, error) {
 	pinner.Pin(dataPtr)
 
 	// Set default device.
-	if b.device == nil {
+	if b.device == nil && b.memory == nil {
 		devices := b.client.AddressableDevices()
 		if len(devices) == 0 {
 			return nil, errors.New("BufferFromHost can't find addressable device to transfer to")
@@ -214,7 +238,11 @@ func (b *BufferFromHostConfig) Done() (*Buffer, error) {
 		args.dims = unsafe.SliceData(dims)
 	}
 	args.host_buffer_semantics = C.PJRT_HostBufferSemantics(b.hostBufferSemantics)
-	args.device = b.device.cDevice
+	if b.memory != nil {
+		args.memory = b.memory.cMemory
+	} else {
+		args.device = b.device.cDevice
+	}
 	err := toError(b.client.plugin, C.BufferFromHostAndWait(b.client.plugin.api, args))
 	if err != nil {
 		return nil, err
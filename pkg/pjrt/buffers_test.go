@@ -1,6 +1,7 @@
 package pjrt
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"runtime"
@@ -448,3 +449,63 @@ func TestBufferBitcast(t *testing.T) {
 	fmt.Printf("\t- data=[0x%X]\n", data)
 	assertEqual(t, val, data[0])
 }
+
+func TestBufferToHostAsync(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	data := []float32{1, 2, 3, 4, 5, 6}
+	buffer, err := ArrayToBuffer(client, data, 2, 3)
+	requireNoError(t, err)
+	defer func() { requireNoError(t, buffer.Destroy()) }()
+
+	{ // ToHostAsync
+		dst := make([]float32, len(data))
+		event, err := buffer.ToHostAsync(unsafe.Slice((*byte)(unsafe.Pointer(&dst[0])), len(dst)*4))
+		requireNoError(t, err)
+		requireNoError(t, <-event.Done())
+		assertEqualSlice(t, data, dst)
+		requireNoError(t, event.Destroy())
+	}
+
+	{ // ToHostAsync with a destination too small.
+		_, err := buffer.ToHostAsync(make([]byte, 4))
+		requireError(t, err)
+	}
+
+	{ // CopyRawToHost of the second row: on CPU the raw layout is row-major.
+		dst := make([]float32, 3)
+		err := buffer.CopyRawToHost(unsafe.Slice((*byte)(unsafe.Pointer(&dst[0])), len(dst)*4), 3*4)
+		if isNotSupportedError(err) {
+			fmt.Printf("CopyRawToHost not supported by %s: %v\n", client.plugin, err)
+			return
+		}
+		requireNoError(t, err)
+		assertEqualSlice(t, []float32{4, 5, 6}, dst)
+
+		// Out of range.
+		err = buffer.CopyRawToHost(make([]byte, 8), 5*4)
+		requireError(t, err)
+	}
+
+	{ // CopyRawToHostFuture: the destination is only given later.
+		future, err := buffer.CopyRawToHostFuture(4, 8)
+		if isNotSupportedError(err) {
+			fmt.Printf("CopyRawToHostFuture not supported by %s: %v\n", client.plugin, err)
+			return
+		}
+		requireNoError(t, err)
+		dst := make([]float32, 2)
+		event, err := future.SetDestination(unsafe.Slice((*byte)(unsafe.Pointer(&dst[0])), len(dst)*4))
+		requireNoError(t, err)
+		requireNoError(t, event.Await())
+		assertEqualSlice(t, []float32{2, 3}, dst)
+		requireError(t, future.Cancel(errors.New("too late")))
+	}
+}
+
+// isNotSupportedError returns whether err reports a PJRT function not supported or not implemented by the plugin.
+func isNotSupportedError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "not supported") ||
+		strings.Contains(err.Error(), fmt.Sprintf("code=%d", PJRT_Error_Code_UNIMPLEMENTED)))
+}
@@ -1,7 +1,9 @@
 package pjrt
 
 import (
+	"fmt"
 	"runtime"
+	"sync"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -12,7 +14,8 @@ import (
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
 
-PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, int64_t dst_size, int rank) {
+// BufferToHostStart starts the transfer of the buffer to host, and returns the event that signals its completion.
+PJRT_Error* BufferToHostStart(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, int64_t dst_size, int rank, PJRT_Event **event) {
 	PJRT_Buffer_ToHostBuffer_Args args = {0};
 
 	args.struct_size = PJRT_Buffer_ToHostBuffer_Args_STRUCT_SIZE;
@@ -32,22 +35,50 @@ PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, in
 		layout_args.tiled.minor_to_major = &minor_to_major[0];
 	}
 	PJRT_Error* err = api->PJRT_Buffer_ToHostBuffer(&args);
-	if (err) {
-		return err;
-	}
+	*event = args.event;
+	return err;
+}
 
+// AwaitAndDestroyEvent waits for the event and destroys it, returning the event error, if any.
+PJRT_Error* AwaitAndDestroyEvent(const PJRT_Api *api, PJRT_Event *event) {
 	PJRT_Event_Await_Args event_args = {0};
 	event_args.struct_size = PJRT_Event_Await_Args_STRUCT_SIZE;
-	event_args.event = args.event;
-	err = api->PJRT_Event_Await(&event_args);
-	PJRT_Event_Destroy_Args efree_args;
+	event_args.event = event;
+	PJRT_Error* err = api->PJRT_Event_Await(&event_args);
+	PJRT_Event_Destroy_Args efree_args = {0};
 	efree_args.struct_size = PJRT_Event_Destroy_Args_STRUCT_SIZE;
-	efree_args.event = args.event;
+	efree_args.event = event;
 	api->PJRT_Event_Destroy(&efree_args);
-
 	return err;
 }
 
+PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, int64_t dst_size, int rank) {
+	PJRT_Event *event = NULL;
+	PJRT_Error* err = BufferToHostStart(api, buffer, dst, dst_size, rank, &event);
+	if (err) {
+		return err;
+	}
+	return AwaitAndDestroyEvent(api, event);
+}
+
+// CallCopyRawToHostFutureReady calls the future_ready_callback returned by PJRT_Buffer_CopyRawToHostFuture.
+// If dst is NULL, the future is fulfilled with the given error instead.
+void CallCopyRawToHostFutureReady(void (*future_ready_callback)(PJRT_Buffer_CopyRawToHostFuture_Callback_Args* args),
+		void *callback_data, void *dst, const char *error_message, size_t error_message_size) {
+	PJRT_Buffer_CopyRawToHostFuture_Callback_Args args = {0};
+	args.struct_size = PJRT_Buffer_CopyRawToHostFuture_Callback_Args_STRUCT_SIZE;
+	args.callback_data = callback_data;
+	if (dst != NULL) {
+		args.error_code = PJRT_Error_Code_OK;
+		args.dst = dst;
+	} else {
+		args.error_code = PJRT_Error_Code_CANCELLED;
+		args.error_message = error_message;
+		args.error_message_size = error_message_size;
+	}
+	future_ready_callback(&args);
+}
+
 */
 import "C"
 
@@ -82,3 +113,227 @@ func (b *Buffer) ToHost(dst []byte) error {
 	}
 	return nil
 }
+
+// ToHostAsync starts the transfer of the contents of buffer stored on device to the host, and returns immediately
+// an Event that becomes ready when the transfer is complete.
+//
+// The space in dst has to hold enough space (see Buffer.Size) to hold the required data, or an error is returned.
+// The contents of dst must not be read or modified until the returned event is ready (see Event.Await,
+// Event.OnReady and Event.Done). It allows overlapping the download with other computations.
+//
+// Like ToHost, it always requests a major-to-minor layout.
+func (b *Buffer) ToHostAsync(dst []byte) (*Event, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+
+	// We'll need the buffer rank to set up the layout.
+	dims, err := b.Dimensions()
+	if err != nil {
+		return nil, err
+	}
+	rank := len(dims)
+
+	// dst is pinned until the transfer is complete.
+	pinner := &runtime.Pinner{}
+	dstBytes := unsafe.Pointer(unsafe.SliceData(dst))
+	pinner.Pin(dstBytes)
+	var cEvent *C.PJRT_Event
+	pErr := C.BufferToHostStart(plugin.api, b.wrapper.c, dstBytes, C.int64_t(len(dst)), C.int(rank), &cEvent)
+	err = toError(plugin, pErr)
+	if err != nil {
+		pinner.Unpin()
+		return nil, errors.WithMessage(err, "Failed to call PJRT_Buffer_ToHostBuffer to transfer the buffer to host")
+	}
+	return newPinnedEvent(plugin, cEvent, pinner)
+}
+
+// newPinnedEvent creates an Event and unpins the pinner once it is ready.
+func newPinnedEvent(plugin *Plugin, cEvent *C.PJRT_Event, pinner *runtime.Pinner) (*Event, error) {
+	event := newEvent(plugin, cEvent)
+	err := event.OnReady(func(error) { pinner.Unpin() })
+	if err != nil {
+		// We can't know when the transfer is done, so we wait for it before unpinning.
+		_ = event.Await()
+		pinner.Unpin()
+		return nil, errors.WithMessage(err, "failed to register callback for the transfer to host")
+	}
+	return event, nil
+}
+
+// checkRawCopyRange checks that the range [offset, offset+size) is within the buffer.
+func (b *Buffer) checkRawCopyRange(offset, size int) error {
+	if offset < 0 || size < 0 {
+		return errors.Errorf("invalid offset=%d and size=%d for raw copy from buffer", offset, size)
+	}
+	bufferSize, err := b.Size()
+	if err != nil {
+		return err
+	}
+	if offset+size > bufferSize {
+		return errors.Errorf("raw copy of %d bytes starting at offset %d goes beyond the buffer size of %d bytes",
+			size, offset, bufferSize)
+	}
+	return nil
+}
+
+// CopyRawToHost copies len(dst) bytes from the on-device buffer, starting at the given offset (in bytes), to dst.
+// It blocks until the copy is done.
+//
+// This allows reading a sub-range of a large buffer, like one row of a table.
+// The raw contents of the buffer are copied using the on-device layout, which for most platforms is the same
+// row-major layout used by ToHost -- TPUs are known to reorganize the layout.
+//
+// This may not be implemented for all PJRT plugins.
+func (b *Buffer) CopyRawToHost(dst []byte, offset int) error {
+	event, err := b.CopyRawToHostAsync(dst, offset)
+	if err != nil {
+		return err
+	}
+	return event.AwaitAndFree()
+}
+
+// CopyRawToHostAsync starts the copy of len(dst) bytes from the on-device buffer, starting at the given
+// offset (in bytes), to dst, and returns immediately an Event that becomes ready when the copy is complete.
+//
+// The contents of dst must not be read or modified until the returned event is ready.
+// See CopyRawToHost for details.
+func (b *Buffer) CopyRawToHostAsync(dst []byte, offset int) (*Event, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if plugin.api.PJRT_Buffer_CopyRawToHost == nil {
+		return nil, errors.Errorf("PJRT_Buffer_CopyRawToHost is not supported by the current plugin version %v", plugin)
+	}
+	if err = b.checkRawCopyRange(offset, len(dst)); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+
+	// dst is pinned until the transfer is complete.
+	pinner := &runtime.Pinner{}
+	dstBytes := unsafe.Pointer(unsafe.SliceData(dst))
+	pinner.Pin(dstBytes)
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_Buffer_CopyRawToHost_Args](arena)
+	args.struct_size = C.PJRT_Buffer_CopyRawToHost_Args_STRUCT_SIZE
+	args.buffer = b.wrapper.c
+	args.dst = dstBytes
+	args.offset = C.int64_t(offset)
+	args.transfer_size = C.int64_t(len(dst))
+	err = toError(plugin, C.call_PJRT_Buffer_CopyRawToHost(plugin.api, args))
+	if err != nil {
+		pinner.Unpin()
+		return nil, errors.WithMessage(err, "Failed to call PJRT_Buffer_CopyRawToHost")
+	}
+	return newPinnedEvent(plugin, args.event, pinner)
+}
+
+// RawHostCopyFuture is a pending copy of a range of an on-device buffer to host, whose destination is provided
+// later with SetDestination. It is created with Buffer.CopyRawToHostFuture.
+//
+// Either SetDestination or Cancel must be called exactly once.
+type RawHostCopyFuture struct {
+	plugin *Plugin
+	event  *Event
+	size   int
+
+	mu            sync.Mutex
+	done          bool
+	callbackData  unsafe.Pointer
+	readyCallback *[0]byte
+}
+
+// CopyRawToHostFuture prepares the copy of size bytes from the on-device buffer, starting at the given offset
+// (in bytes), to a host destination that is only given later, with RawHostCopyFuture.SetDestination.
+//
+// This allows the device to start preparing the transfer before the destination is allocated.
+//
+// This may not be implemented for all PJRT plugins.
+func (b *Buffer) CopyRawToHostFuture(offset, size int) (*RawHostCopyFuture, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if plugin.api.PJRT_Buffer_CopyRawToHostFuture == nil {
+		return nil, errors.Errorf("PJRT_Buffer_CopyRawToHostFuture is not supported by the current plugin version %v", plugin)
+	}
+	if err = b.checkRawCopyRange(offset, size); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_Buffer_CopyRawToHostFuture_Args](arena)
+	args.struct_size = C.PJRT_Buffer_CopyRawToHostFuture_Args_STRUCT_SIZE
+	args.buffer = b.wrapper.c
+	args.offset = C.int64_t(offset)
+	args.transfer_size = C.int64_t(size)
+	err = toError(plugin, C.call_PJRT_Buffer_CopyRawToHostFuture(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to call PJRT_Buffer_CopyRawToHostFuture")
+	}
+	return &RawHostCopyFuture{
+		plugin:        plugin,
+		event:         newEvent(plugin, args.event),
+		size:          size,
+		callbackData:  args.callback_data,
+		readyCallback: (*[0]byte)(unsafe.Pointer(args.future_ready_callback)),
+	}, nil
+}
+
+// Event returns the Event that becomes ready when the copy to the destination is complete.
+func (f *RawHostCopyFuture) Event() *Event {
+	return f.event
+}
+
+// SetDestination provides the destination of the copy, which must have the size given to
+// Buffer.CopyRawToHostFuture, and starts the copy.
+//
+// The contents of dst must not be read or modified until the event (see Event) is ready.
+// It returns the same Event returned by RawHostCopyFuture.Event.
+func (f *RawHostCopyFuture) SetDestination(dst []byte) (*Event, error) {
+	if len(dst) != f.size {
+		return nil, errors.Errorf("RawHostCopyFuture.SetDestination requires a destination with %d bytes, got %d",
+			f.size, len(dst))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return nil, errors.New("RawHostCopyFuture destination already set or cancelled")
+	}
+	f.done = true
+
+	// dst is pinned until the transfer is complete.
+	pinner := &runtime.Pinner{}
+	dstBytes := unsafe.Pointer(unsafe.SliceData(dst))
+	pinner.Pin(dstBytes)
+	err := f.event.OnReady(func(error) { pinner.Unpin() })
+	if err != nil {
+		pinner.Unpin()
+		return nil, errors.WithMessage(err, "failed to register callback for the transfer to host")
+	}
+	C.CallCopyRawToHostFutureReady(f.readyCallback, f.callbackData, dstBytes, nil, 0)
+	return f.event, nil
+}
+
+// Cancel the copy: the Event completes with the given error.
+func (f *RawHostCopyFuture) Cancel(reason error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return errors.New("RawHostCopyFuture destination already set or cancelled")
+	}
+	f.done = true
+	msg := fmt.Sprintf("%v", reason)
+	cMsg := C.CString(msg)
+	defer cFree(cMsg)
+	C.CallCopyRawToHostFutureReady(f.readyCallback, f.callbackData, nil, cMsg, C.size_t(len(msg)))
+	return nil
+}
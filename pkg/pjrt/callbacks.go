@@ -0,0 +1,141 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "ffi.h"
+
+// Implemented in distributed.go.
+extern PJRT_Error* CallCallbackError(PJRT_CallbackError* callback_error, PJRT_Error_Code code, const char* message, size_t message_size);
+extern void SetKeyValueGetResult(PJRT_KeyValueGetCallback_Args* args, const void* value, size_t value_size);
+extern void SetKeyValueTryGetResult(PJRT_KeyValueTryGetCallback_Args* args, const void* value, size_t value_size);
+*/
+import "C"
+import (
+	"context"
+	"math"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// This file holds the Go functions exported to C (callbacks): because of cgo restrictions, its preamble can only have
+// declarations.
+
+// goEventOnReady is the callback passed to PJRT_Event_OnReady, see Event.OnReady.
+// It takes ownership of the error, and the userArg is a cgo.Handle to an eventOnReadyContext.
+//
+//export goEventOnReady
+func goEventOnReady(pErr *C.PJRT_Error, userArg unsafe.Pointer) {
+	handle := cgo.Handle(userArg)
+	ctx := handle.Value().(*eventOnReadyContext)
+	handle.Delete()
+	err := toError(ctx.plugin, pErr)
+	go ctx.callback(err)
+}
+
+// keyValueCallbackError converts a KeyValueStore error to a PJRT_Error created with the plugin provided callbackError.
+func keyValueCallbackError(callbackError *C.PJRT_CallbackError, err error) *C.PJRT_Error {
+	code := PJRT_Error_Code_UNKNOWN
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		code = PJRT_Error_Code_NOT_FOUND
+	case errors.Is(err, context.DeadlineExceeded):
+		code = PJRT_Error_Code_DEADLINE_EXCEEDED
+	}
+	msg := err.Error()
+	cMsg := C.CString(msg)
+	defer cFree(cMsg)
+	return C.CallCallbackError(callbackError, C.PJRT_Error_Code(code), cMsg, C.size_t(len(msg)))
+}
+
+// goKeyValueGet is the PJRT_KeyValueGetCallback, and user_arg is a cgo.Handle to a KeyValueStore.
+//
+//export goKeyValueGet
+func goKeyValueGet(args *C.PJRT_KeyValueGetCallback_Args) *C.PJRT_Error {
+	store := cgo.Handle(args.user_arg).Value().(KeyValueStore)
+	key := cCharArray(args.key, args.key_size)
+	timeout := time.Duration(math.MaxInt64)
+	if args.timeout_in_ms >= 0 {
+		timeout = time.Duration(args.timeout_in_ms) * time.Millisecond
+	}
+	value, err := store.Get(key, timeout)
+	if err != nil {
+		return keyValueCallbackError(args.callback_error, err)
+	}
+	C.SetKeyValueGetResult(args, unsafe.Pointer(unsafe.SliceData(value)), C.size_t(len(value)))
+	return nil
+}
+
+// goKeyValueTryGet is the PJRT_KeyValueTryGetCallback, and user_arg is a cgo.Handle to a KeyValueStore.
+//
+//export goKeyValueTryGet
+func goKeyValueTryGet(args *C.PJRT_KeyValueTryGetCallback_Args) *C.PJRT_Error {
+	store := cgo.Handle(args.user_arg).Value().(KeyValueStore)
+	value, err := store.TryGet(cCharArray(args.key, args.key_size))
+	if err != nil {
+		return keyValueCallbackError(args.callback_error, err)
+	}
+	C.SetKeyValueTryGetResult(args, unsafe.Pointer(unsafe.SliceData(value)), C.size_t(len(value)))
+	return nil
+}
+
+// goKeyValuePut is the PJRT_KeyValuePutCallback, and user_arg is a cgo.Handle to a KeyValueStore.
+//
+//export goKeyValuePut
+func goKeyValuePut(args *C.PJRT_KeyValuePutCallback_Args) *C.PJRT_Error {
+	store := cgo.Handle(args.user_arg).Value().(KeyValueStore)
+	value := C.GoBytes(unsafe.Pointer(args.value), C.int(args.value_size))
+	err := store.Put(cCharArray(args.key, args.key_size), value)
+	if err != nil {
+		return keyValueCallbackError(args.callback_error, err)
+	}
+	return nil
+}
+
+// goFFIHandler is called by the C FFI handler of the given slot (see ffi.go) in the execution stage,
+// and calls the corresponding Go FFIHandler.
+//
+//export goFFIHandler
+func goFFIHandler(slot C.int, callFrame *C.XLA_FFI_CallFrame) (cErr *C.XLA_FFI_Error) {
+	handler := ffiHandlerForSlot(int(slot))
+	if handler == nil {
+		return ffiError(callFrame, errors.Errorf("no Go FFI handler registered for slot %d", slot))
+	}
+	call, err := newFFICall(callFrame)
+	if err != nil {
+		return ffiError(callFrame, err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			cErr = ffiError(callFrame, errors.Errorf("Go FFI handler panicked: %v", r))
+		}
+	}()
+	if err = handler(call); err != nil {
+		return ffiError(callFrame, err)
+	}
+	return nil
+}
+
+// goHostSendCallback is the PJRT_SendCallback for Send ops with host transfer, and userArg is a cgo.Handle to a
+// hostSendContext. See ExecutionConfig.WithHostSendCallback.
+//
+//export goHostSendCallback
+func goHostSendCallback(chunk *C.PJRT_Chunk, callbackError *C.PJRT_CallbackError, totalSize C.size_t, done C.bool,
+	userArg unsafe.Pointer) *C.PJRT_Error {
+	ctx := cgo.Handle(userArg).Value().(*hostSendContext)
+	if err := ctx.send(chunk, int(totalSize), bool(done)); err != nil {
+		return hostSendCallbackError(callbackError, err)
+	}
+	return nil
+}
+
+// goHostRecvCallback is the PJRT_RecvCallback for Recv ops with host transfer, and userArg is a cgo.Handle to a
+// hostRecvContext. See ExecutionConfig.WithHostRecvCallback.
+//
+//export goHostRecvCallback
+func goHostRecvCallback(stream *C.PJRT_CopyToDeviceStream, userArg unsafe.Pointer) {
+	ctx := cgo.Handle(userArg).Value().(*hostRecvContext)
+	ctx.receive(stream)
+}
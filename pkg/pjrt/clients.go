@@ -4,11 +4,15 @@ package pjrt
 #include "pjrt_c_api.h"
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
+
+// Implemented in distributed.go.
+extern void SetKeyValueCallbacks(PJRT_Client_Create_Args* args, uintptr_t handle);
 */
 import "C"
 import (
 	"fmt"
 	"runtime"
+	"runtime/cgo"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -147,15 +151,23 @@ type Client struct {
 	processIndex              int
 	addressableDevices        []*Device
 	allowBufferViews          bool
+
+	// compilationCache is the default cache used by Compile, it may be nil.
+	compilationCache *CompilationCache
 }
 
 type clientC struct {
 	// c holds the pointer to the C/C++ structure.
 	c *C.PJRT_Client
+
+	// kvStoreHandle is a handle to the KeyValueStore used by distributed clients, or 0 if not set.
+	// It must outlive the C client.
+	kvStoreHandle cgo.Handle
 }
 
-// newClient is called by Plugin.NewClient to create a new PJRT_Client wrapper.
-func newClient(plugin *Plugin, options NamedValuesMap) (*Client, error) {
+// newClient is called by Plugin.NewClient and Plugin.NewDistributedClient to create a new PJRT_Client wrapper.
+// kvStore is optional, and if set it is used for the PJRT key-value callbacks.
+func newClient(plugin *Plugin, options NamedValuesMap, kvStore KeyValueStore) (*Client, error) {
 	// Create C.PJRT_Client object.
 	args := C.new_PJRT_Client_Create_Args()
 	defer cFree(args)
@@ -164,16 +176,23 @@ func newClient(plugin *Plugin, options NamedValuesMap) (*Client, error) {
 	if err != nil {
 		return nil, errors.WithMessagef(err, "invalid options when creating a new pjrt.Client")
 	}
-	// No callback support yet, so we leave the various PJRT_KeyValue... fields empty.
+	var kvStoreHandle cgo.Handle
+	if kvStore != nil {
+		kvStoreHandle = cgo.NewHandle(kvStore)
+		C.SetKeyValueCallbacks(args, C.uintptr_t(kvStoreHandle))
+	}
 	err = toError(plugin, C.call_PJRT_Client_Create(plugin.api, args))
 	if err != nil {
+		if kvStore != nil {
+			kvStoreHandle.Delete()
+		}
 		return nil, err
 	}
 
 	// Prepare the Client object: not all initializations are fatal to the construction of the client.
 	c := &Client{
 		plugin: plugin,
-		client: &clientC{c: args.client},
+		client: &clientC{c: args.client, kvStoreHandle: kvStoreHandle},
 	}
 	c.platform, err = pjrtClientPlatformName(plugin, c)
 	if err != nil {
@@ -224,6 +243,10 @@ func (client *clientC) Destroy(plugin *Plugin) error {
 	args.client = client.c
 	err := toError(plugin, C.call_PJRT_Client_Destroy(plugin.api, args))
 	client.c = nil
+	if client.kvStoreHandle != 0 {
+		client.kvStoreHandle.Delete()
+		client.kvStoreHandle = 0
+	}
 	return err
 }
 
@@ -339,6 +362,19 @@ func (c *Client) Compile() *CompileConfig {
 	return newCompileConfig(c)
 }
 
+// SetCompilationCache sets the persistent cache of compiled programs used by default by Compile.
+// See CompilationCache and CompileConfig.WithCompilationCache.
+//
+// If cache is nil, the default is to not use any cache.
+func (c *Client) SetCompilationCache(cache *CompilationCache) {
+	c.compilationCache = cache
+}
+
+// CompilationCache returns the cache set with SetCompilationCache, or nil if none was set.
+func (c *Client) CompilationCache() *CompilationCache {
+	return c.compilationCache
+}
+
 // BufferFromHost creates an on-device buffer with the contents copied (optionally reused, if device is CPU) from
 // the given host buffer.
 //
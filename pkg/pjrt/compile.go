@@ -52,6 +52,9 @@ type CompileConfig struct {
 	// Device assignment:
 	deviceAssignment []int
 
+	// cache is an optional persistent cache of compiled programs.
+	cache *CompilationCache
+
 	// err is the first error that occurred during setup.
 	err error
 }
@@ -60,19 +63,29 @@ func newCompileConfig(client *Client) (cc *CompileConfig) {
 	cc = &CompileConfig{
 		plugin: client.plugin,
 		client: client,
-		options: &compile_options.CompileOptionsProto{
-			ArgumentLayouts:            nil,
-			ParameterIsTupledArguments: false,
-			ExecutableBuildOptions:     nil,
-			CompilePortableExecutable:  true, // Default is portable. It is set to false if a device assignment is set.
-			ProfileVersion:             0,
-			SerializedMultiSliceConfig: nil,
-			EnvOptionOverrides:         nil,
-			TargetConfig:               nil,
-		},
+		cache:  client.compilationCache,
+	}
+	cc.options, cc.err = newCompileOptionsProto()
+	return cc
+}
+
+// newCompileOptionsProto returns the default CompileOptionsProto, with the xla.DebugOptions set from
+// $XLA_DEBUG_OPTIONS (see EnvXlaDebugOptions), if defined.
+//
+// It is used by CompileConfig and Plugin.CompileForTopology.
+func newCompileOptionsProto() (*compile_options.CompileOptionsProto, error) {
+	options := &compile_options.CompileOptionsProto{
+		ArgumentLayouts:            nil,
+		ParameterIsTupledArguments: false,
+		ExecutableBuildOptions:     nil,
+		CompilePortableExecutable:  true, // Default is portable. It is set to false if a device assignment is set.
+		ProfileVersion:             0,
+		SerializedMultiSliceConfig: nil,
+		EnvOptionOverrides:         nil,
+		TargetConfig:               nil,
 	}
 	// Default values specified in the comments of the proto (but not as proper proto defaults).
-	cc.options.ExecutableBuildOptions = &compile_options.ExecutableBuildOptionsProto{
+	options.ExecutableBuildOptions = &compile_options.ExecutableBuildOptionsProto{
 		DeviceOrdinal: -1, // -1 means not set.
 		NumReplicas:   1,
 		NumPartitions: 1,
@@ -83,8 +96,7 @@ func newCompileConfig(client *Client) (cc *CompileConfig) {
 		debugOptions := &xla.DebugOptions{}
 		err := prototext.Unmarshal([]byte(debugOptionsStr), debugOptions)
 		if err != nil {
-			cc.err = errors.Wrapf(err, "Failed to parse xla.DebugOptions protobuf from $%s=%q", EnvXlaDebugOptions, debugOptionsStr)
-			return cc
+			return options, errors.Wrapf(err, "Failed to parse xla.DebugOptions protobuf from $%s=%q", EnvXlaDebugOptions, debugOptionsStr)
 		}
 		// Print configuration.
 		textBytes, err := prototext.MarshalOptions{Multiline: true}.Marshal(debugOptions)
@@ -96,9 +108,9 @@ func newCompileConfig(client *Client) (cc *CompileConfig) {
 		}
 
 		// Set parsed configuration.
-		cc.options.ExecutableBuildOptions.DebugOptions = debugOptions
+		options.ExecutableBuildOptions.DebugOptions = debugOptions
 	}
-	return cc
+	return options, nil
 }
 
 var compileConfigOnlyOnce = errors.Errorf("the CompileConfig can only be used once")
@@ -159,8 +171,8 @@ func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
 	defer pinner.Unpin()
 	pinner.Pin(cc)
 
-	// Get options and pin it.
-	binOptions, err := proto.Marshal(cc.options)
+	// Get options and pin it: the marshaling is deterministic, so it can be used as part of the cache key.
+	binOptions, err := proto.MarshalOptions{Deterministic: true}.Marshal(cc.options)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "failed to marshal the CompileOptionsProto to be passed to the PJRT plugin")
 	}
@@ -169,6 +181,17 @@ func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
 	}
 	pinner.Pin(unsafe.SliceData(binOptions))
 
+	// Check whether the program has already been compiled.
+	var cacheKey string
+	if cc.cache != nil {
+		cacheKey = compilationCacheKey(cc, binOptions)
+		exec := cc.loadFromCache(cacheKey)
+		if exec != nil {
+			cc.options = nil
+			return exec, nil
+		}
+	}
+
 	klog.V(2).Infof("calling pjrtClientCompile()")
 	exec, err := pjrtClientCompile(cc.plugin, cc.client, cc.program, cc.programFormat, binOptions)
 	if err != nil {
@@ -183,9 +206,51 @@ func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
 	exec.isPortable = cc.options.CompilePortableExecutable
 	klog.V(2).Infof("pjrtClientCompile() succeeded")
 	cc.options = nil // We can make sure this is freed.
+	if cc.cache != nil {
+		cc.storeInCache(cacheKey, exec)
+	}
 	return exec, nil
 }
 
+// loadFromCache returns the executable stored in the cache for the given key, or nil if it is not there.
+//
+// Errors are only logged: the compilation proceeds without the cache.
+func (cc *CompileConfig) loadFromCache(key string) *LoadedExecutable {
+	data, err := cc.cache.Get(key)
+	if err != nil {
+		klog.Warningf("Failed to read compilation cache in %q, compiling program instead: %+v", cc.cache.Dir(), err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	exec, err := cc.client.DeserializeAndLoad(data)
+	if err != nil {
+		klog.Warningf("Failed to load executable from compilation cache in %q, removing entry and compiling "+
+			"program instead: %+v", cc.cache.Dir(), err)
+		if err = cc.cache.Delete(key); err != nil {
+			klog.Warningf("Failed to remove compilation cache entry: %+v", err)
+		}
+		return nil
+	}
+	klog.V(1).Infof("Loaded executable %q from compilation cache in %q", exec.Name, cc.cache.Dir())
+	return exec
+}
+
+// storeInCache serializes the executable and stores it in the cache.
+//
+// Errors are only logged: the compiled executable is still valid.
+func (cc *CompileConfig) storeInCache(key string, exec *LoadedExecutable) {
+	data, err := exec.Serialize()
+	if err != nil {
+		klog.Warningf("Failed to serialize executable %q for the compilation cache: %+v", exec.Name, err)
+		return
+	}
+	if err = cc.cache.Put(key, data); err != nil {
+		klog.Warningf("Failed to store executable %q in the compilation cache: %+v", exec.Name, err)
+	}
+}
+
 // WithHLO configures the program to the serialized HLO (HloModule proto).
 // The serialized proto blob can allocated in Go or in C/C++, and must be kept alive (and unchanged) until the
 // call to Done is returned.
@@ -399,13 +464,17 @@ func (cc *CompileConfig) WithDeviceAssignment(assignment []int) *CompileConfig {
 	}
 	klog.V(1).Infof("Device assignment for %d replicas and %d partitions: %v", numReplicas, numPartitions, assignment)
 	cc.deviceAssignment = assignment
-	assignmentProto := cc.options.ExecutableBuildOptions.DeviceAssignment
-	if assignmentProto == nil {
-		assignmentProto = &xla_data.DeviceAssignmentProto{}
-		cc.options.ExecutableBuildOptions.DeviceAssignment = assignmentProto
+	cc.options.ExecutableBuildOptions.DeviceAssignment = newDeviceAssignmentProto(assignment, numReplicas, numPartitions)
+	return cc
+}
+
+// newDeviceAssignmentProto converts the device assignment, in replica-major order, to the proto used in the
+// compilation options.
+func newDeviceAssignmentProto(assignment []int, numReplicas, numPartitions int) *xla_data.DeviceAssignmentProto {
+	assignmentProto := &xla_data.DeviceAssignmentProto{
+		ReplicaCount:     int32(numReplicas),
+		ComputationCount: int32(numPartitions),
 	}
-	assignmentProto.ReplicaCount = int32(numReplicas)
-	assignmentProto.ComputationCount = int32(numPartitions)
 	assignmentProto.ComputationDevices = make([]*xla_data.DeviceAssignmentProto_ComputationDevice, numPartitions)
 	for partitionIdx := range numPartitions {
 		assignmentProto.ComputationDevices[partitionIdx] = &xla_data.DeviceAssignmentProto_ComputationDevice{}
@@ -415,6 +484,22 @@ func (cc *CompileConfig) WithDeviceAssignment(assignment []int) *CompileConfig {
 				assignment[replicaIdx*numPartitions+partitionIdx]) // replica-major order.
 		}
 	}
+	return assignmentProto
+}
+
+// WithCompilationCache configures a persistent cache of compiled programs: if the same program was compiled
+// before (by this or another process) with the same options, plugin version and device assignment, the
+// compilation is skipped and the executable is loaded from the cache instead.
+//
+// If cache is nil, the cache is disabled for this compilation.
+// The default is the cache set with Client.SetCompilationCache, if any.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithCompilationCache(cache *CompilationCache) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	cc.cache = cache
 	return cc
 }
 
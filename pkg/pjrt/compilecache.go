@@ -0,0 +1,299 @@
+package pjrt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// CompilationCacheLockTimeout is the maximum time to wait for the lock of a CompilationCache directory.
+// If it waits for longer than that, the cache operation fails with a timeout error, and the compilation proceeds
+// without the cache.
+var CompilationCacheLockTimeout = time.Minute
+
+const (
+	// compilationCacheExt is the extension of the files holding the cached serialized executables.
+	compilationCacheExt = ".pjrtexec"
+
+	// compilationCacheLockFile is the name of the file used to lock the cache directory across processes.
+	compilationCacheLockFile = ".lock"
+
+	// compilationCacheRetryLockPeriod is the period to wait between attempts to acquire the cache lock.
+	compilationCacheRetryLockPeriod = 10 * time.Millisecond
+)
+
+// CompilationCache is a persistent (on-disk) cache of compiled programs.
+//
+// It is used by CompileConfig.Done, when configured with CompileConfig.WithCompilationCache (or with
+// Client.SetCompilationCache), to skip the compilation of programs that were already compiled before,
+// possibly by another process.
+//
+// Entries are keyed by a hash of the program, the compilation options, the plugin and platform versions and the
+// device assignment. The stored values are the executables serialized with LoadedExecutable.Serialize.
+//
+// The total size of the cache is bounded: the least recently used entries are evicted when it is exceeded.
+// Access to the directory is protected with a file lock, so the same directory can be shared by several processes.
+//
+// It is safe for concurrent use.
+type CompilationCache struct {
+	dir     string
+	maxSize int64
+
+	hits, misses atomic.Int64
+}
+
+// NewCompilationCache creates a CompilationCache stored in the given directory, which is created if it doesn't
+// exist yet.
+//
+// maxSize is the maximum total size in bytes of the entries in the cache: once exceeded, the least recently used
+// entries are evicted. If maxSize <= 0 the cache size is not bounded.
+func NewCompilationCache(dir string, maxSize int64) (*CompilationCache, error) {
+	if dir == "" {
+		return nil, errors.New("NewCompilationCache requires a directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create compilation cache directory %q", dir)
+	}
+	return &CompilationCache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Dir returns the directory where the cache is stored.
+func (c *CompilationCache) Dir() string {
+	return c.dir
+}
+
+// MaxSize returns the maximum size of the cache in bytes. If <= 0 the cache size is not bounded.
+func (c *CompilationCache) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Stats returns the number of cache hits and misses since the CompilationCache was created.
+func (c *CompilationCache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// lock acquires the cross-process lock of the cache directory. If shared is true, it acquires a read lock.
+//
+// The returned lock must be released with Unlock.
+func (c *CompilationCache) lock(shared bool) (*flock.Flock, error) {
+	lockPath := filepath.Join(c.dir, compilationCacheLockFile)
+	fLock := flock.New(lockPath)
+	ctx, cancel := context.WithTimeout(context.Background(), CompilationCacheLockTimeout)
+	defer cancel()
+	var ok bool
+	var err error
+	if shared {
+		ok, err = fLock.TryRLockContext(ctx, compilationCacheRetryLockPeriod)
+	} else {
+		ok, err = fLock.TryLockContext(ctx, compilationCacheRetryLockPeriod)
+	}
+	if err != nil || !ok {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "failed to acquire lock %q for compilation cache: if it is stale, "+
+			"please manually remove the lock file", lockPath)
+	}
+	return fLock, nil
+}
+
+// compilationCacheMaxKeyLen is the maximum length of the keys, enough for hex encoded SHA-512 digests.
+const compilationCacheMaxKeyLen = 128
+
+// checkKey returns an error if the key is not a lowercase hex digest (like the ones used by CompileConfig), so
+// keys can't refer to files outside the cache directory.
+func checkKey(key string) error {
+	if key == "" || len(key) > compilationCacheMaxKeyLen {
+		return errors.Errorf("invalid compilation cache key %q: it must be a hex digest with 1 to %d digits",
+			key, compilationCacheMaxKeyLen)
+	}
+	for _, r := range key {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return errors.Errorf("invalid compilation cache key %q: it must be a lowercase hex digest", key)
+		}
+	}
+	return nil
+}
+
+// entryPath returns the path of the file for the given key, which must have been checked with checkKey.
+func (c *CompilationCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+compilationCacheExt)
+}
+
+// Get returns the cached value for the given key, or nil if it is not in the cache.
+// It marks the entry as recently used.
+//
+// Keys must be lowercase hex digests (e.g.: a hex encoded SHA-256 hash).
+func (c *CompilationCache) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	fLock, err := c.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fLock.Unlock() }()
+	path := c.entryPath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.misses.Add(1)
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read compilation cache entry %q", path)
+	}
+	c.hits.Add(1)
+
+	// The modification time is used to track the least recently used entries.
+	// Only the shared lock is held: evictions (under the exclusive lock) can't happen meanwhile, but concurrent
+	// Gets of the same entry may update its time concurrently, which is harmless -- the last one wins. An entry
+	// removed by other means (outside the lock) is not an error, since its data was already read.
+	now := time.Now()
+	if err = os.Chtimes(path, now, now); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("Failed to update the access time of compilation cache entry %q: %v", path, err)
+	}
+	return data, nil
+}
+
+// Put stores the value for the given key, and evicts the least recently used entries if the cache grows
+// beyond its maximum size.
+//
+// Keys must be lowercase hex digests, see Get.
+func (c *CompilationCache) Put(key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	fLock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fLock.Unlock() }()
+
+	// Write to a temporary file and rename it, so readers never see partially written entries.
+	tmpFile, err := os.CreateTemp(c.dir, key+"-*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file in compilation cache %q", c.dir)
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(value)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, c.entryPath(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "failed to write compilation cache entry for key %q", key)
+	}
+	return c.evictLocked()
+}
+
+// Delete removes the entry for the given key, if it exists.
+func (c *CompilationCache) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	fLock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fLock.Unlock() }()
+	err = os.Remove(c.entryPath(key))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to delete compilation cache entry for key %q", key)
+	}
+	return nil
+}
+
+// evictLocked removes the least recently used entries until the cache fits its maximum size.
+// It must be called with the exclusive lock held.
+func (c *CompilationCache) evictLocked() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list compilation cache directory %q", c.dir)
+	}
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var totalSize int64
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), compilationCacheExt) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			// Entry removed concurrently: it is no longer part of the cache anyway.
+			continue
+		}
+		entries = append(entries, entry{
+			path:    filepath.Join(c.dir, dirEntry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		totalSize += info.Size()
+	}
+	if totalSize <= c.maxSize {
+		return nil
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, e := range entries {
+		if totalSize <= c.maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to evict compilation cache entry %q", e.path)
+		}
+		klog.V(1).Infof("Evicted compilation cache entry %q (%d bytes)", e.path, e.size)
+		totalSize -= e.size
+	}
+	return nil
+}
+
+// compilationCacheKey returns the key used to store the program compiled with the given configuration.
+// binOptions is the deterministically marshaled CompileOptionsProto.
+func compilationCacheKey(cc *CompileConfig, binOptions []byte) string {
+	hasher := sha256.New()
+	writeBytes := func(data []byte) {
+		var size [binary.MaxVarintLen64]byte
+		hasher.Write(size[:binary.PutUvarint(size[:], uint64(len(data)))])
+		hasher.Write(data)
+	}
+	writeInt := func(v int) {
+		var buf [binary.MaxVarintLen64]byte
+		hasher.Write(buf[:binary.PutVarint(buf[:], int64(v))])
+	}
+	writeBytes([]byte(cc.programFormat))
+	writeBytes(cc.program)
+	writeBytes(binOptions)
+	major, minor := cc.plugin.Version()
+	writeInt(major)
+	writeInt(minor)
+	writeBytes([]byte(cc.plugin.Name()))
+	writeBytes([]byte(cc.client.Platform()))
+	writeBytes([]byte(cc.client.PlatformVersion()))
+	writeInt(len(cc.deviceAssignment))
+	for _, deviceIdx := range cc.deviceAssignment {
+		writeInt(deviceIdx)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,96 @@
+package pjrt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestCompilationCacheEviction(t *testing.T) {
+	cache, err := NewCompilationCache(t.TempDir(), 25)
+	requireNoError(t, err)
+
+	value, err := cache.Get("a")
+	requireNoError(t, err)
+	assertNil(t, value)
+
+	// Entries with 10 bytes each: only 2 fit in the cache.
+	requireNoError(t, cache.Put("a", bytes.Repeat([]byte{'a'}, 10)))
+	requireNoError(t, cache.Put("b", bytes.Repeat([]byte{'b'}, 10)))
+
+	// Make "a" the most recently used, by setting "b" back in time.
+	past := time.Now().Add(-time.Hour)
+	requireNoError(t, os.Chtimes(cache.entryPath("b"), past, past))
+	value, err = cache.Get("a")
+	requireNoError(t, err)
+	assertEqualSlice(t, bytes.Repeat([]byte{'a'}, 10), value)
+
+	// Adding "c" should evict "b", the least recently used.
+	requireNoError(t, os.Chtimes(cache.entryPath("a"), past.Add(time.Minute), past.Add(time.Minute)))
+	requireNoError(t, cache.Put("c", bytes.Repeat([]byte{'c'}, 10)))
+	value, err = cache.Get("b")
+	requireNoError(t, err)
+	assertNil(t, value)
+	for _, key := range []string{"a", "c"} {
+		value, err = cache.Get(key)
+		requireNoError(t, err)
+		assertLen(t, value, 10, "entry %q", key)
+	}
+	hits, misses := cache.Stats()
+	assertEqual(t, int64(3), hits)
+	assertEqual(t, int64(2), misses)
+
+	// No temporary files left behind.
+	tmpFiles, err := filepath.Glob(filepath.Join(cache.Dir(), "*.tmp"))
+	requireNoError(t, err)
+	assertEmpty(t, tmpFiles)
+
+	requireNoError(t, cache.Delete("a"))
+	value, err = cache.Get("a")
+	requireNoError(t, err)
+	assertNil(t, value)
+}
+
+func TestCompilationCacheKeys(t *testing.T) {
+	cache, err := NewCompilationCache(t.TempDir(), 0)
+	requireNoError(t, err)
+	for _, key := range []string{"", "../x", "a/b", "ABCD", "0g"} {
+		_, err = cache.Get(key)
+		requireError(t, err)
+		requireError(t, cache.Put(key, []byte{1}))
+		requireError(t, cache.Delete(key))
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(cache.Dir()), "x"+compilationCacheExt))
+	assertTrue(t, os.IsNotExist(err), "expected no entry written outside the cache directory, got %v", err)
+	requireNoError(t, cache.Put("0123456789abcdef", []byte{1}))
+}
+
+func TestCompilationCache(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+	cache, err := NewCompilationCache(t.TempDir(), 0)
+	requireNoError(t, err)
+
+	// f(x) = x*x
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32)))
+	must(mainFn.Return(must1(stablehlo.Multiply(x, x))))
+	program := must1(builder.Build())
+
+	for ii := range 2 {
+		exec, err := client.Compile().WithStableHLO(program).WithCompilationCache(cache).Done()
+		requireNoError(t, err, "Failed to compile program (#%d)", ii)
+		assertEqual(t, float32(9), execWithScalars(t, client, exec, float32(3)))
+		requireNoError(t, exec.Destroy())
+		hits, misses := cache.Stats()
+		assertEqual(t, int64(ii), hits)
+		assertEqual(t, int64(1), misses)
+	}
+}
@@ -8,7 +8,9 @@ package pjrt
 import "C"
 import (
 	"fmt"
+	"unsafe"
 
+	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 )
 
@@ -53,13 +55,14 @@ func pjrtDeviceDescriptionProcessIndex(dDesc *DeviceDescription) (int, error) {
 // are device-specific and operate on individual PjrtDevice objects (obtained from the PjrtClient_Devices list).
 type Device struct {
 	plugin          *Plugin
+	client          *Client
 	cDevice         *C.PJRT_Device // (PJRT) `device` has the same lifetime as (PJRT) `client`. It is owned by (PJRT) `client`.
 	localHardwareId int
 }
 
 // newDevice create a new Device reference.
 func newDevice(client *Client, device *C.PJRT_Device) *Device {
-	d := &Device{plugin: client.plugin, cDevice: device}
+	d := &Device{plugin: client.plugin, client: client, cDevice: device}
 	var err error
 	d.localHardwareId, err = pjrtDeviceLocalHardwareId(d)
 	if err != nil {
@@ -68,6 +71,17 @@ func newDevice(client *Client, device *C.PJRT_Device) *Device {
 	return d
 }
 
+// deviceFromC returns the client's addressable Device for the given C device, so pointers can be compared
+// with the ones returned by Client.AddressableDevices. Non-addressable devices are returned as new Device objects.
+func (c *Client) deviceFromC(cDevice *C.PJRT_Device) *Device {
+	for _, d := range c.addressableDevices {
+		if d.cDevice == cDevice {
+			return d
+		}
+	}
+	return newDevice(c, cDevice)
+}
+
 // IsAddressable returns whether the device is addressable by this client.
 func (d *Device) IsAddressable() (bool, error) {
 	args := C.new_PJRT_Device_IsAddressable_Args()
@@ -130,8 +144,66 @@ func (dDesc *DeviceDescription) ProcessIndex() int {
 	return dDesc.processIndex
 }
 
-// A vendor-dependent string that uniquely identifies the kind of device,
+// Id returns the ID of the device. It is unique among all devices of the same platform (across processes), and
+// it matches the device ids used in device assignments.
+func (dDesc *DeviceDescription) Id() (int, error) {
+	args := C.new_PJRT_DeviceDescription_Id_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Id(dDesc.plugin.api, args))
+	if err != nil {
+		return -1, err
+	}
+	return int(args.id), nil
+}
+
+// Kind returns a vendor-dependent string that uniquely identifies the kind of device,
 // e.g., "Tesla V100-SXM2-16GB".
+func (dDesc *DeviceDescription) Kind() (string, error) {
+	args := C.new_PJRT_DeviceDescription_Kind_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Kind(dDesc.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.device_kind, args.device_kind_size), nil
+}
+
+// Attributes returns the vendor-specific attributes of the device (e.g.: "core_count", "compute_capability").
+func (dDesc *DeviceDescription) Attributes() (NamedValuesMap, error) {
+	args := C.new_PJRT_DeviceDescription_Attributes_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Attributes(dDesc.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	attributes := cDataToSlice[C.PJRT_NamedValue](unsafe.Pointer(args.attributes), int(args.num_attributes))
+	return pjrtNamedValuesToMap(attributes), nil
+}
+
+// ToString returns a terse string describing the device, suitable for user messages.
+// See also DebugString.
+func (dDesc *DeviceDescription) ToString() (string, error) {
+	args := C.new_PJRT_DeviceDescription_ToString_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_ToString(dDesc.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.to_string, args.to_string_size), nil
+}
+
+// String implements fmt.Stringer, see ToString.
+func (dDesc *DeviceDescription) String() string {
+	str, err := dDesc.ToString()
+	if err != nil {
+		return fmt.Sprintf("DeviceDescription failed to retrieve string: %v", err)
+	}
+	return str
+}
 
 // DebugString suitable for logging when errors occur.
 // Should be verbose enough to describe the current device unambiguously.
@@ -145,3 +217,74 @@ func (dDesc *DeviceDescription) DebugString() string {
 	}
 	return cCharArray(args.debug_string, args.debug_string_size)
 }
+
+// DeviceMemoryStats holds the memory (allocator) statistics of a device, in bytes, see Device.MemoryStats.
+//
+// Except BytesInUse, the statistics are optional: the ones not reported by the platform are set to -1.
+type DeviceMemoryStats struct {
+	// BytesInUse is the number of bytes currently in use.
+	BytesInUse int64
+
+	// PeakBytesInUse is the peak number of bytes in use.
+	PeakBytesInUse int64
+
+	// NumAllocs is the number of allocations.
+	NumAllocs int64
+
+	// LargestAllocSize is the largest single allocation seen.
+	LargestAllocSize int64
+
+	// BytesLimit is the upper limit of user-allocatable device memory.
+	BytesLimit int64
+
+	// BytesReserved is the number of bytes reserved, and PeakBytesReserved its peak.
+	BytesReserved, PeakBytesReserved int64
+
+	// BytesReservableLimit is the upper limit on the number of bytes of reservable memory.
+	BytesReservableLimit int64
+
+	// LargestFreeBlockBytes is the size of the largest free block.
+	LargestFreeBlockBytes int64
+
+	// PoolBytes is the number of bytes held by the allocator, and PeakPoolBytes its peak.
+	// This may be higher than BytesInUse if the allocator holds a pool of memory.
+	PoolBytes, PeakPoolBytes int64
+}
+
+// MemoryStats returns the current memory statistics of the device. It is intended for diagnostics, for instance
+// to alert before the device runs out of memory (see DeviceMemoryStats.BytesLimit).
+//
+// Not all platforms implement it: the CPU plugin, for instance, may return an "unimplemented" error.
+func (d *Device) MemoryStats() (DeviceMemoryStats, error) {
+	var stats DeviceMemoryStats
+	if d.plugin.api.PJRT_Device_MemoryStats == nil {
+		return stats, errors.Errorf("PJRT_Device_MemoryStats is not supported by the current plugin version %v", d.plugin)
+	}
+	args := C.new_PJRT_Device_MemoryStats_Args()
+	defer cFree(args)
+	args.device = d.cDevice
+	err := toError(d.plugin, C.call_PJRT_Device_MemoryStats(d.plugin.api, args))
+	if err != nil {
+		return stats, err
+	}
+	optional := func(value C.int64_t, isSet C.bool) int64 {
+		if !bool(isSet) {
+			return -1
+		}
+		return int64(value)
+	}
+	stats = DeviceMemoryStats{
+		BytesInUse:            int64(args.bytes_in_use),
+		PeakBytesInUse:        optional(args.peak_bytes_in_use, args.peak_bytes_in_use_is_set),
+		NumAllocs:             optional(args.num_allocs, args.num_allocs_is_set),
+		LargestAllocSize:      optional(args.largest_alloc_size, args.largest_alloc_size_is_set),
+		BytesLimit:            optional(args.bytes_limit, args.bytes_limit_is_set),
+		BytesReserved:         optional(args.bytes_reserved, args.bytes_reserved_is_set),
+		PeakBytesReserved:     optional(args.peak_bytes_reserved, args.peak_bytes_reserved_is_set),
+		BytesReservableLimit:  optional(args.bytes_reservable_limit, args.bytes_reservable_limit_is_set),
+		LargestFreeBlockBytes: optional(args.largest_free_block_bytes, args.largest_free_block_bytes_is_set),
+		PoolBytes:             optional(args.pool_bytes, args.pool_bytes_is_set),
+		PeakPoolBytes:         optional(args.peak_pool_bytes, args.peak_pool_bytes_is_set),
+	}
+	return stats, nil
+}
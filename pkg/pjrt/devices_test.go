@@ -36,6 +36,21 @@ func TestClient_Devices(t *testing.T) {
 		desc, err := d.GetDescription()
 		requireNoError(t, err)
 		fmt.Printf("\t\tDevice Local Hardware Id %d: %s\n", d.LocalHardwareID(), desc.DebugString())
+		id, err := desc.Id()
+		requireNoError(t, err)
+		kind, err := desc.Kind()
+		requireNoError(t, err)
+		assertTrue(t, kind != "", "empty device kind")
+		attributes, err := desc.Attributes()
+		requireNoError(t, err)
+		fmt.Printf("\t\t\tid=%d, kind=%q, %s, attributes=%v\n", id, kind, desc, attributes)
+		if isAddr {
+			stats, err := d.MemoryStats()
+			if !isNotSupportedError(err) {
+				requireNoError(t, err)
+				fmt.Printf("\t\t\tmemory stats: %+v\n", stats)
+			}
+		}
 	}
 	assertEqual(t, countAddressable, len(addressableDevices))
 	requireNoError(t, client.Destroy())
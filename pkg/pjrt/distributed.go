@@ -0,0 +1,99 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include "pjrt_c_api.h"
+
+// The key-value store callbacks are implemented in Go, see callbacks.go.
+extern PJRT_Error* goKeyValueGet(PJRT_KeyValueGetCallback_Args* args);
+extern PJRT_Error* goKeyValueTryGet(PJRT_KeyValueTryGetCallback_Args* args);
+extern PJRT_Error* goKeyValuePut(PJRT_KeyValuePutCallback_Args* args);
+
+// SetKeyValueCallbacks configures the client creation to use the Go key-value store given by the handle.
+void SetKeyValueCallbacks(PJRT_Client_Create_Args* args, uintptr_t handle) {
+	args->kv_get_callback = &goKeyValueGet;
+	args->kv_get_user_arg = (void*)handle;
+	args->kv_try_get_callback = &goKeyValueTryGet;
+	args->kv_try_get_user_arg = (void*)handle;
+	args->kv_put_callback = &goKeyValuePut;
+	args->kv_put_user_arg = (void*)handle;
+}
+
+// CallCallbackError creates a PJRT_Error with the function given by the plugin to the key-value callbacks.
+PJRT_Error* CallCallbackError(PJRT_CallbackError* callback_error, PJRT_Error_Code code, const char* message, size_t message_size) {
+	return (*callback_error)(code, message, message_size);
+}
+
+static void freeKeyValue(char* value) {
+	free(value);
+}
+
+// SetKeyValueGetResult copies the value to a C allocated buffer, freed by the plugin with the deleter callback.
+void SetKeyValueGetResult(PJRT_KeyValueGetCallback_Args* args, const void* value, size_t value_size) {
+	args->value = (char*)malloc(value_size > 0 ? value_size : 1);
+	memcpy(args->value, value, value_size);
+	args->value_size = value_size;
+	args->value_deleter_callback = &freeKeyValue;
+}
+
+// SetKeyValueTryGetResult is the same as SetKeyValueGetResult, for the try-get callback.
+void SetKeyValueTryGetResult(PJRT_KeyValueTryGetCallback_Args* args, const void* value, size_t value_size) {
+	args->value = (char*)malloc(value_size > 0 ? value_size : 1);
+	memcpy(args->value, value, value_size);
+	args->value_size = value_size;
+	args->value_deleter_callback = &freeKeyValue;
+}
+*/
+import "C"
+import (
+	"github.com/pkg/errors"
+)
+
+// DistributedOptions configures a client that is part of a multi-process (multi-node) distributed computation.
+// See Plugin.NewDistributedClient.
+type DistributedOptions struct {
+	// NodeID is the index of the current process (node), from 0 to NumNodes-1.
+	NodeID int
+
+	// NumNodes is the total number of processes (nodes) participating in the computation.
+	NumNodes int
+
+	// KeyValueStore shared by all nodes, used by the plugin to exchange information across processes.
+	// Typically, a TCPKeyValueStore connected to a KeyValueStoreServer running on node 0.
+	KeyValueStore KeyValueStore
+}
+
+// Plugin option names used to configure the node of a distributed client.
+const (
+	NodeIDOptionName   = "node_id"
+	NumNodesOptionName = "num_nodes"
+)
+
+// NewDistributedClient creates a new Client that participates in a distributed computation across several
+// processes (nodes), for instance to form one collective group with the CPU plugins of several local processes.
+//
+// The key-value store in distributed is made available to the plugin as the PJRT key-value get/try-get/put
+// callbacks. If distributed.NumNodes > 1, the NodeIDOptionName and NumNodesOptionName options are also set
+// (unless already given in options) -- the plugin must support them.
+//
+// Client.ProcessIndex returns the node of the client, and Client.AllDevices include the devices of all nodes.
+func (p *Plugin) NewDistributedClient(options NamedValuesMap, distributed DistributedOptions) (*Client, error) {
+	if distributed.KeyValueStore == nil {
+		return nil, errors.New("NewDistributedClient requires a KeyValueStore")
+	}
+	if distributed.NumNodes < 1 || distributed.NodeID < 0 || distributed.NodeID >= distributed.NumNodes {
+		return nil, errors.Errorf("NewDistributedClient got invalid NodeID=%d for NumNodes=%d",
+			distributed.NodeID, distributed.NumNodes)
+	}
+	if distributed.NumNodes > 1 {
+		withNodeOptions := make(NamedValuesMap, len(options)+2)
+		withNodeOptions[NodeIDOptionName] = int64(distributed.NodeID)
+		withNodeOptions[NumNodesOptionName] = int64(distributed.NumNodes)
+		for key, value := range options {
+			withNodeOptions[key] = value
+		}
+		options = withNodeOptions
+	}
+	return newClient(p, options, distributed.KeyValueStore)
+}
@@ -4,12 +4,27 @@ package pjrt
 #include "pjrt_c_api.h"
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
+
+// goEventOnReady is implemented in Go, see callbacks.go.
+extern void goEventOnReady(PJRT_Error* error, void* user_arg);
+
+// EventOnReady registers goEventOnReady to be called when the event is ready, with the given handle.
+PJRT_Error* EventOnReady(const PJRT_Api *api, PJRT_Event *event, uintptr_t handle) {
+	PJRT_Event_OnReady_Args args = {0};
+	args.struct_size = PJRT_Event_OnReady_Args_STRUCT_SIZE;
+	args.event = event;
+	args.callback = &goEventOnReady;
+	args.user_arg = (void *)handle;
+	return api->PJRT_Event_OnReady(&args);
+}
 */
 import "C"
 import (
+	"runtime"
+	"runtime/cgo"
+
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
-	"runtime"
 )
 
 // Event is a reference that a future event (when something is done), and it is created by asynchronous calls.
@@ -33,7 +48,7 @@ func newEvent(plugin *Plugin, cEvent *C.PJRT_Event) *Event {
 	}}
 
 	runtime.AddCleanup(e, func(wrapper *eventWrapper) {
-		err := e.Destroy()
+		err := wrapper.Destroy()
 		if err != nil {
 			klog.Errorf("pjrt.Event.Destroy failed: %+v", err)
 		}
@@ -93,3 +108,98 @@ func (e *Event) AwaitAndFree() error {
 	}
 	return err
 }
+
+// IsReady returns whether the event has completed, including if an error has occurred.
+// It doesn't block.
+func (e *Event) IsReady() (bool, error) {
+	if e == nil || !e.wrapper.IsValid() {
+		return false, errors.New("Event is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Event_IsReady_Args()
+	defer cFree(args)
+	args.event = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Event_IsReady(e.wrapper.plugin.api, args))
+	if err != nil {
+		return false, err
+	}
+	return bool(args.is_ready), nil
+}
+
+// Err returns the error the event completed with, or nil if it completed successfully.
+//
+// It should only be called after the event is ready (see IsReady), otherwise it returns an error saying so.
+func (e *Event) Err() error {
+	isReady, err := e.IsReady()
+	if err != nil {
+		return err
+	}
+	if !isReady {
+		return errors.New("Event.Err() called before the event is ready")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Event_Error_Args()
+	defer cFree(args)
+	args.event = e.wrapper.c
+	return toError(e.wrapper.plugin, C.call_PJRT_Event_Error(e.wrapper.plugin.api, args))
+}
+
+// eventOnReadyContext is what is passed (as a cgo.Handle) to the C callback of PJRT_Event_OnReady.
+type eventOnReadyContext struct {
+	// event is kept alive until the callback is called.
+	event    *Event
+	plugin   *Plugin
+	callback func(err error)
+}
+
+// OnReady registers the callback to be called once the event is ready, with the error the event completed
+// with (or nil).
+//
+// The callback is called in a separate goroutine, so it is fine for it to block.
+// The Event is kept alive (it is not garbage collected) until the callback is called.
+func (e *Event) OnReady(callback func(err error)) error {
+	if e == nil || !e.wrapper.IsValid() {
+		return errors.New("Event is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	if callback == nil {
+		return errors.New("Event.OnReady() given a nil callback")
+	}
+	defer runtime.KeepAlive(e)
+	handle := cgo.NewHandle(&eventOnReadyContext{event: e, plugin: e.wrapper.plugin, callback: callback})
+	err := toError(e.wrapper.plugin, C.EventOnReady(e.wrapper.plugin.api, e.wrapper.c, C.uintptr_t(handle)))
+	if err != nil {
+		handle.Delete()
+		return err
+	}
+	return nil
+}
+
+// Done returns a channel that receives the error the event completed with (or nil) once the event is ready.
+// The channel is buffered, so the event doesn't block on it if nobody is listening.
+//
+// If the callback can't be registered, the error is sent immediately to the channel.
+func (e *Event) Done() <-chan error {
+	ch := make(chan error, 1)
+	err := e.OnReady(func(err error) {
+		ch <- err
+	})
+	if err != nil {
+		ch <- err
+	}
+	return ch
+}
+
+// AwaitEvents blocks until all events are ready, and returns the first error found, if any.
+// nil events are ignored.
+func AwaitEvents(events ...*Event) error {
+	var firstErr error
+	for _, e := range events {
+		if e == nil {
+			continue
+		}
+		if err := e.Await(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,56 @@
+package pjrt
+
+import (
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestExecuteAsync(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	// f(x) = x + 1
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32)))
+	one := must1(mainFn.ConstantFromScalar(float32(1)))
+	must(mainFn.Return(must1(stablehlo.Add(x, one))))
+	exec, err := client.Compile().WithStableHLO(must1(builder.Build())).Done()
+	requireNoError(t, err)
+	defer func() { requireNoError(t, exec.Destroy()) }()
+
+	// Pipeline a few steps without waiting.
+	const numSteps = 5
+	buffer := must1(ScalarToBuffer(client, float32(0)))
+	var allCompletions []*Event
+	for range numSteps {
+		outputs, completions, err := exec.Execute(buffer).DonateAll().DoneAsync()
+		requireNoError(t, err)
+		assertLen(t, outputs, 1)
+		assertLen(t, completions, 1)
+		allCompletions = append(allCompletions, completions...)
+		buffer = outputs[0]
+	}
+
+	// Wait on the last output through its ready event, with a callback and with a channel.
+	readyEvent, err := buffer.ReadyEvent()
+	requireNoError(t, err)
+	callbackErr := make(chan error, 1)
+	requireNoError(t, readyEvent.OnReady(func(err error) { callbackErr <- err }))
+	requireNoError(t, <-callbackErr)
+	requireNoError(t, <-allCompletions[numSteps-1].Done())
+	requireNoError(t, AwaitEvents(allCompletions...))
+	for _, e := range allCompletions {
+		isReady, err := e.IsReady()
+		requireNoError(t, err)
+		assertTrue(t, isReady)
+		requireNoError(t, e.Err())
+	}
+	got, err := BufferToScalar[float32](buffer)
+	requireNoError(t, err)
+	assertEqual(t, float32(numSteps), got)
+	requireNoError(t, buffer.Destroy())
+}
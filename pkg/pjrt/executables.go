@@ -8,7 +8,10 @@ package pjrt
 import "C"
 import (
 	"runtime"
+	"unsafe"
 
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 )
@@ -19,6 +22,9 @@ import (
 // and related information.
 type Executable struct {
 	wrapper *executableWrapper
+
+	// metadata is set for executables compiled with Plugin.CompileForTopology, and it is used by Serialize.
+	metadata *executableMetadata
 }
 
 type executableWrapper struct {
@@ -168,3 +174,238 @@ func (e *Executable) GetMemoryStats() (onDevice, onHost ExecutableMemoryUsageSta
 	}
 	return
 }
+
+// check returns an error if the Executable is not valid.
+func (e *Executable) check() error {
+	if e == nil || !e.wrapper.IsValid() {
+		return errors.New("Executable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	return nil
+}
+
+// NumReplicas returns the number of replicas the executable was compiled for.
+func (e *Executable) NumReplicas() (int, error) {
+	if err := e.check(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_NumReplicas_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_NumReplicas(e.wrapper.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int(args.num_replicas), nil
+}
+
+// NumPartitions returns the number of partitions the executable was compiled for.
+func (e *Executable) NumPartitions() (int, error) {
+	if err := e.check(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_NumPartitions_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_NumPartitions(e.wrapper.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int(args.num_partitions), nil
+}
+
+// OutputElementTypes returns the dtypes of each of the outputs of the executable.
+func (e *Executable) OutputElementTypes() ([]dtypes.DType, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_OutputElementTypes_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_OutputElementTypes(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cTypes := cDataToSlice[C.PJRT_Buffer_Type](unsafe.Pointer(args.output_types), int(args.num_output_types))
+	outputDTypes := make([]dtypes.DType, len(cTypes))
+	for ii, cType := range cTypes {
+		outputDTypes[ii] = dtypes.DType(cType)
+	}
+	return outputDTypes, nil
+}
+
+// OutputDimensions returns the dimensions of each of the outputs of the executable.
+func (e *Executable) OutputDimensions() ([][]int, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_OutputDimensions_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_OutputDimensions(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	ranks := cDataToSlice[C.size_t](unsafe.Pointer(args.dim_sizes), int(args.num_outputs))
+	var totalDims int
+	for _, rank := range ranks {
+		totalDims += int(rank)
+	}
+	allDims := cDataToSlice[C.int64_t](unsafe.Pointer(args.dims), totalDims)
+	outputDims := make([][]int, len(ranks))
+	pos := 0
+	for ii, rank := range ranks {
+		outputDims[ii] = make([]int, int(rank))
+		for axis := range outputDims[ii] {
+			outputDims[ii][axis] = int(allDims[pos])
+			pos++
+		}
+	}
+	return outputDims, nil
+}
+
+// OutputShapes returns the shapes of each of the outputs of the executable.
+// It combines OutputElementTypes and OutputDimensions.
+func (e *Executable) OutputShapes() ([]shapes.Shape, error) {
+	outputDTypes, err := e.OutputElementTypes()
+	if err != nil {
+		return nil, err
+	}
+	outputDims, err := e.OutputDimensions()
+	if err != nil {
+		return nil, err
+	}
+	if len(outputDTypes) != len(outputDims) {
+		return nil, errors.Errorf("executable reported %d output dtypes but %d output dimensions",
+			len(outputDTypes), len(outputDims))
+	}
+	outputShapes := make([]shapes.Shape, len(outputDTypes))
+	for ii, dtype := range outputDTypes {
+		outputShapes[ii] = shapes.Make(dtype, outputDims[ii]...)
+	}
+	return outputShapes, nil
+}
+
+// OutputMemoryKinds returns the memory kinds (see Memory.Kind) where each of the outputs of the executable is stored.
+func (e *Executable) OutputMemoryKinds() ([]string, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_OutputMemoryKinds_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_OutputMemoryKinds(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cKinds := cDataToSlice[*C.char](unsafe.Pointer(args.memory_kinds), int(args.num_outputs))
+	cSizes := cDataToSlice[C.size_t](unsafe.Pointer(args.memory_kind_sizes), int(args.num_outputs))
+	kinds := make([]string, len(cKinds))
+	for ii, cKind := range cKinds {
+		kinds[ii] = cCharArray(cKind, cSizes[ii])
+	}
+	return kinds, nil
+}
+
+// Fingerprint returns a unique fingerprint for the executable: two executables compiled with identical inputs
+// (same program, compile options, compiler version, etc.) should have the same fingerprint.
+//
+// It may not be implemented by all plugins.
+func (e *Executable) Fingerprint() (string, error) {
+	if err := e.check(); err != nil {
+		return "", err
+	}
+	plugin := e.wrapper.plugin
+	if plugin.api.PJRT_Executable_Fingerprint == nil {
+		return "", errors.Errorf("PJRT_Executable_Fingerprint is not supported by the current plugin version %v", plugin)
+	}
+	defer runtime.KeepAlive(e)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_Executable_Fingerprint_Args](arena)
+	args.struct_size = C.PJRT_Executable_Fingerprint_Args_STRUCT_SIZE
+	args.executable = e.wrapper.c
+	err := toError(plugin, C.call_PJRT_Executable_Fingerprint(plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.executable_fingerprint, args.executable_fingerprint_size), nil
+}
+
+// SizeOfGeneratedCodeInBytes returns the size of the code generated for the executable.
+func (e *Executable) SizeOfGeneratedCodeInBytes() (int64, error) {
+	if err := e.check(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_SizeOfGeneratedCodeInBytes_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_SizeOfGeneratedCodeInBytes(e.wrapper.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int64(args.size_in_bytes), nil
+}
+
+// GetCostAnalysis returns the cost properties estimated for the executable.
+//
+// The properties are platform dependent: commonly "flops", "transcendentals", "bytes accessed" and
+// "optimal_seconds", usually as float32 values.
+func (e *Executable) GetCostAnalysis() (NamedValuesMap, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_GetCostAnalysis_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_GetCostAnalysis(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	properties := cDataToSlice[C.PJRT_NamedValue](unsafe.Pointer(args.properties), int(args.num_properties))
+	return pjrtNamedValuesToMap(properties), nil
+}
+
+// OptimizedProgram returns the program after the compiler optimizations, and its format.
+//
+// For XLA plugins, the format is "hlo", and the program is a serialized HloModuleProto, useful for debugging.
+func (e *Executable) OptimizedProgram() (program []byte, format string, err error) {
+	if err = e.check(); err != nil {
+		return
+	}
+	plugin := e.wrapper.plugin
+	defer runtime.KeepAlive(e)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	cProgram := arenaAlloc[C.PJRT_Program](arena)
+	cProgram.struct_size = C.PJRT_Program_STRUCT_SIZE
+	args := arenaAlloc[C.PJRT_Executable_OptimizedProgram_Args](arena)
+	args.struct_size = C.PJRT_Executable_OptimizedProgram_Args_STRUCT_SIZE
+	args.executable = e.wrapper.c
+	args.program = cProgram
+
+	// First call retrieves the size of the program.
+	err = toError(plugin, C.call_PJRT_Executable_OptimizedProgram(plugin.api, args))
+	if err != nil {
+		err = errors.WithMessage(err, "failed to get the size of the optimized program")
+		return
+	}
+	codeSize := int(cProgram.code_size)
+	cCode := cMallocArray[C.char](max(codeSize, 1))
+	defer cFree(cCode)
+	cProgram.code = cCode
+	err = toError(plugin, C.call_PJRT_Executable_OptimizedProgram(plugin.api, args))
+	if err != nil {
+		err = errors.WithMessage(err, "failed to get the optimized program")
+		return
+	}
+	program = C.GoBytes(unsafe.Pointer(cCode), C.int(cProgram.code_size))
+	format = cCharArray(cProgram.format, cProgram.format_size)
+	return
+}
@@ -0,0 +1,361 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include "pjrt_c_api.h"
+#include "ffi.h"
+
+// Implemented in Go, see callbacks.go.
+extern XLA_FFI_Error* goFFIHandler(int slot, XLA_FFI_CallFrame* call_frame);
+
+// ffiHandlerCommon answers the metadata queries of XLA, and calls the Go handler in the execution stage.
+static XLA_FFI_Error* ffiHandlerCommon(int slot, XLA_FFI_CallFrame* call_frame) {
+	XLA_FFI_Extension_Base* extension = call_frame->extension_start;
+	if (extension != NULL && extension->type == XLA_FFI_Extension_Metadata) {
+		XLA_FFI_Metadata* metadata = ((XLA_FFI_Metadata_Extension*)extension)->metadata;
+		metadata->api_version.major_version = XLA_FFI_API_MAJOR;
+		metadata->api_version.minor_version = XLA_FFI_API_MINOR;
+		metadata->traits = 0;
+		return NULL;
+	}
+	if (call_frame->stage != XLA_FFI_ExecutionStage_EXECUTE) {
+		return NULL;
+	}
+	return goFFIHandler(slot, call_frame);
+}
+
+// XLA calls handlers without a user argument, so there is one C handler per Go handler slot.
+#define FFI_HANDLER(N) static XLA_FFI_Error* ffiHandler##N(XLA_FFI_CallFrame* call_frame) { return ffiHandlerCommon(N, call_frame); }
+FFI_HANDLER(0) FFI_HANDLER(1) FFI_HANDLER(2) FFI_HANDLER(3) FFI_HANDLER(4) FFI_HANDLER(5) FFI_HANDLER(6) FFI_HANDLER(7)
+FFI_HANDLER(8) FFI_HANDLER(9) FFI_HANDLER(10) FFI_HANDLER(11) FFI_HANDLER(12) FFI_HANDLER(13) FFI_HANDLER(14) FFI_HANDLER(15)
+FFI_HANDLER(16) FFI_HANDLER(17) FFI_HANDLER(18) FFI_HANDLER(19) FFI_HANDLER(20) FFI_HANDLER(21) FFI_HANDLER(22) FFI_HANDLER(23)
+FFI_HANDLER(24) FFI_HANDLER(25) FFI_HANDLER(26) FFI_HANDLER(27) FFI_HANDLER(28) FFI_HANDLER(29) FFI_HANDLER(30) FFI_HANDLER(31)
+
+static XLA_FFI_Handler* ffiHandlers[] = {
+	ffiHandler0, ffiHandler1, ffiHandler2, ffiHandler3, ffiHandler4, ffiHandler5, ffiHandler6, ffiHandler7,
+	ffiHandler8, ffiHandler9, ffiHandler10, ffiHandler11, ffiHandler12, ffiHandler13, ffiHandler14, ffiHandler15,
+	ffiHandler16, ffiHandler17, ffiHandler18, ffiHandler19, ffiHandler20, ffiHandler21, ffiHandler22, ffiHandler23,
+	ffiHandler24, ffiHandler25, ffiHandler26, ffiHandler27, ffiHandler28, ffiHandler29, ffiHandler30, ffiHandler31,
+};
+
+// FindFFIExtension returns the FFI extension of the plugin, or NULL if the plugin doesn't support it.
+static PJRT_FFI_Extension* FindFFIExtension(const PJRT_Api* api) {
+	for (PJRT_Extension_Base* ext = api->extension_start; ext != NULL; ext = ext->next) {
+		if (ext->type == PJRT_Extension_Type_FFI) {
+			return (PJRT_FFI_Extension*)ext;
+		}
+	}
+	return NULL;
+}
+
+// HasFFIRegisterHandler returns whether the FFI extension version includes register_handler.
+static int HasFFIRegisterHandler(PJRT_FFI_Extension* ext) {
+	return ext->base.struct_size >= offsetof(PJRT_FFI_Extension, register_handler) + sizeof(void*) &&
+		ext->register_handler != NULL;
+}
+
+static PJRT_Error* CallFFIRegisterHandler(PJRT_FFI_Extension* ext, int slot, const char* target_name,
+		size_t target_name_size, const char* platform_name, size_t platform_name_size) {
+	PJRT_FFI_Register_Handler_Args args = {0};
+	args.struct_size = sizeof(PJRT_FFI_Register_Handler_Args);
+	args.target_name = target_name;
+	args.target_name_size = target_name_size;
+	args.api_version = 1;
+	args.handler = (void*)ffiHandlers[slot];
+	args.platform_name = platform_name;
+	args.platform_name_size = platform_name_size;
+	return ext->register_handler(&args);
+}
+
+// CreateFFIError creates an error to be returned by a FFI handler. The message is copied.
+XLA_FFI_Error* CreateFFIError(const XLA_FFI_Api* api, XLA_FFI_Error_Code code, const char* message) {
+	XLA_FFI_Error_Create_Args args = {0};
+	args.struct_size = sizeof(XLA_FFI_Error_Create_Args);
+	args.message = message;
+	args.errc = code;
+	return api->XLA_FFI_Error_Create(&args);
+}
+*/
+import "C"
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/gomlx/go-xla/internal/protos/xla_data"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/pkg/errors"
+)
+
+// FFIHandler implements a custom call target in Go, registered with Plugin.RegisterFFIHandler.
+//
+// It is called synchronously by the plugin during the execution of the program, possibly concurrently
+// from different threads. The buffers in call are only valid during the call.
+//
+// If it returns an error, the execution of the program fails with the error message.
+type FFIHandler func(call *FFICall) error
+
+// FFICall holds the arguments of a call to an FFIHandler.
+type FFICall struct {
+	// Args are the operands of the custom call.
+	Args []FFIBuffer
+
+	// Results are the output buffers of the custom call, to be filled by the handler.
+	Results []FFIBuffer
+
+	// Attributes are the values of the typed backend config of the custom call (see
+	// stablehlo.CustomCallOptions.TypedBackendConfig).
+	//
+	// Scalars are converted to the corresponding Go type (bool, int32, float32, etc.), arrays to slices of the
+	// corresponding Go type, strings to string and dictionaries to map[string]any.
+	Attributes map[string]any
+}
+
+// FFIBuffer is an argument or result of an FFIHandler call.
+//
+// The memory is owned by the plugin, and it is only valid during the call.
+type FFIBuffer struct {
+	DType      dtypes.DType
+	Dimensions []int
+	Data       unsafe.Pointer
+}
+
+// Size returns the number of elements of the buffer.
+func (b FFIBuffer) Size() int {
+	size := 1
+	for _, dim := range b.Dimensions {
+		size *= dim
+	}
+	return size
+}
+
+// Bytes returns a view of the raw data of the buffer.
+func (b FFIBuffer) Bytes() []byte {
+	numBytes := b.DType.SizeForDimensions(b.Dimensions...)
+	if numBytes == 0 || b.Data == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(b.Data), numBytes)
+}
+
+// FFIBufferData returns a view of the data of the buffer as a flat slice of T.
+// It returns an error if T doesn't match the buffer dtype.
+func FFIBufferData[T dtypes.Supported](b FFIBuffer) ([]T, error) {
+	dtype := dtypes.FromGenericsType[T]()
+	if dtype != b.DType {
+		return nil, errors.Errorf("FFIBufferData[%s] called on a buffer of dtype %s", dtype, b.DType)
+	}
+	size := b.Size()
+	if size == 0 || b.Data == nil {
+		return nil, nil
+	}
+	return unsafe.Slice((*T)(b.Data), size), nil
+}
+
+// MaxFFIHandlers is the maximum number of Go FFI handlers that can be registered in the process.
+const MaxFFIHandlers = 32
+
+// ffiRegistry holds the Go handlers registered, indexed by the slot of their C handler.
+var ffiRegistry struct {
+	mu       sync.RWMutex
+	handlers []FFIHandler
+	targets  map[string]int
+}
+
+// RegisterFFIHandler registers the handler as the custom call target targetName for the given platform
+// (e.g.: "Host" for the CPU plugin), using the PJRT FFI extension.
+//
+// Programs can then call it with stablehlo.CustomCall, using the typed FFI API version (the default).
+// It must be registered before the programs using it are compiled.
+//
+// XLA keeps a process-wide registry of FFI handlers, and handlers cannot be unregistered. At most MaxFFIHandlers
+// Go handlers can be registered in the process.
+//
+// It returns an error if the plugin doesn't support registering FFI handlers.
+func (p *Plugin) RegisterFFIHandler(targetName, platformName string, handler FFIHandler) error {
+	if handler == nil {
+		return errors.Errorf("RegisterFFIHandler(%q) requires a non-nil handler", targetName)
+	}
+	ext := C.FindFFIExtension(p.api)
+	if ext == nil || C.HasFFIRegisterHandler(ext) == 0 {
+		return errors.Errorf("registering FFI handlers is not supported by the current plugin version %v", p)
+	}
+
+	ffiRegistry.mu.Lock()
+	defer ffiRegistry.mu.Unlock()
+	if _, found := ffiRegistry.targets[targetName+"@"+platformName]; found {
+		return errors.Errorf("FFI handler for target %q and platform %q already registered", targetName, platformName)
+	}
+	slot := len(ffiRegistry.handlers)
+	if slot >= MaxFFIHandlers {
+		return errors.Errorf("cannot register FFI handler for target %q: maximum of %d Go FFI handlers reached",
+			targetName, MaxFFIHandlers)
+	}
+
+	cTargetName := C.CString(targetName)
+	defer cFree(cTargetName)
+	cPlatformName := C.CString(platformName)
+	defer cFree(cPlatformName)
+	err := toError(p, C.CallFFIRegisterHandler(ext, C.int(slot), cTargetName, C.size_t(len(targetName)),
+		cPlatformName, C.size_t(len(platformName))))
+	runtime.KeepAlive(p)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to register FFI handler for target %q and platform %q",
+			targetName, platformName)
+	}
+	ffiRegistry.handlers = append(ffiRegistry.handlers, handler)
+	if ffiRegistry.targets == nil {
+		ffiRegistry.targets = make(map[string]int)
+	}
+	ffiRegistry.targets[targetName+"@"+platformName] = slot
+	return nil
+}
+
+// ffiHandlerForSlot returns the Go handler registered for the slot.
+func ffiHandlerForSlot(slot int) FFIHandler {
+	ffiRegistry.mu.RLock()
+	defer ffiRegistry.mu.RUnlock()
+	if slot < 0 || slot >= len(ffiRegistry.handlers) {
+		return nil
+	}
+	return ffiRegistry.handlers[slot]
+}
+
+// ffiDTypeFromC converts the XLA FFI data type (an XLA PrimitiveType) to a DType.
+func ffiDTypeFromC(dtype C.XLA_FFI_DataType) dtypes.DType {
+	return dtypes.FromPrimitiveType(xla_data.PrimitiveType(dtype))
+}
+
+// ffiBufferFromC converts a XLA_FFI_Buffer to an FFIBuffer.
+func ffiBufferFromC(buffer *C.XLA_FFI_Buffer) FFIBuffer {
+	dims := cDataToSlice[C.int64_t](unsafe.Pointer(buffer.dims), int(buffer.rank))
+	b := FFIBuffer{
+		DType:      ffiDTypeFromC(buffer.dtype),
+		Dimensions: make([]int, len(dims)),
+		Data:       buffer.data,
+	}
+	for i, dim := range dims {
+		b.Dimensions[i] = int(dim)
+	}
+	return b
+}
+
+// newFFICall converts the call frame to an FFICall.
+func newFFICall(callFrame *C.XLA_FFI_CallFrame) (*FFICall, error) {
+	call := &FFICall{}
+	numArgs := int(callFrame.args.size)
+	argTypes := cDataToSlice[C.XLA_FFI_ArgType](unsafe.Pointer(callFrame.args.types), numArgs)
+	args := cDataToSlice[unsafe.Pointer](unsafe.Pointer(callFrame.args.args), numArgs)
+	for i := range numArgs {
+		if argTypes[i] != C.XLA_FFI_ArgType_BUFFER {
+			return nil, errors.Errorf("FFI argument #%d has unsupported type %d", i, argTypes[i])
+		}
+		call.Args = append(call.Args, ffiBufferFromC((*C.XLA_FFI_Buffer)(args[i])))
+	}
+
+	numRets := int(callFrame.rets.size)
+	retTypes := cDataToSlice[C.XLA_FFI_RetType](unsafe.Pointer(callFrame.rets.types), numRets)
+	rets := cDataToSlice[unsafe.Pointer](unsafe.Pointer(callFrame.rets.rets), numRets)
+	for i := range numRets {
+		if retTypes[i] != C.XLA_FFI_RetType_BUFFER {
+			return nil, errors.Errorf("FFI result #%d has unsupported type %d", i, retTypes[i])
+		}
+		call.Results = append(call.Results, ffiBufferFromC((*C.XLA_FFI_Buffer)(rets[i])))
+	}
+
+	var err error
+	call.Attributes, err = ffiAttributesFromC(&callFrame.attrs)
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// ffiAttributesFromC converts the attributes (a dictionary) of a FFI call to Go values.
+func ffiAttributesFromC(attrs *C.XLA_FFI_Attrs) (map[string]any, error) {
+	numAttrs := int(attrs.size)
+	result := make(map[string]any, numAttrs)
+	types := cDataToSlice[C.XLA_FFI_AttrType](unsafe.Pointer(attrs.types), numAttrs)
+	names := cDataToSlice[*C.XLA_FFI_ByteSpan](unsafe.Pointer(attrs.names), numAttrs)
+	values := cDataToSlice[unsafe.Pointer](unsafe.Pointer(attrs.attrs), numAttrs)
+	for i := range numAttrs {
+		name := cCharArray(names[i].ptr, names[i].len)
+		switch types[i] {
+		case C.XLA_FFI_AttrType_STRING:
+			span := (*C.XLA_FFI_ByteSpan)(values[i])
+			result[name] = cCharArray(span.ptr, span.len)
+		case C.XLA_FFI_AttrType_SCALAR:
+			scalar := (*C.XLA_FFI_Scalar)(values[i])
+			value, err := ffiValuesFromC(ffiDTypeFromC(scalar.dtype), scalar.value, 1, true)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "FFI attribute %q", name)
+			}
+			result[name] = value
+		case C.XLA_FFI_AttrType_ARRAY:
+			array := (*C.XLA_FFI_Array)(values[i])
+			value, err := ffiValuesFromC(ffiDTypeFromC(array.dtype), array.data, int(array.size), false)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "FFI attribute %q", name)
+			}
+			result[name] = value
+		case C.XLA_FFI_AttrType_DICTIONARY:
+			dict, err := ffiAttributesFromC((*C.XLA_FFI_Attrs)(values[i]))
+			if err != nil {
+				return nil, errors.WithMessagef(err, "FFI attribute %q", name)
+			}
+			result[name] = dict
+		default:
+			return nil, errors.Errorf("FFI attribute %q has unsupported type %d", name, types[i])
+		}
+	}
+	return result, nil
+}
+
+// ffiValuesFromC copies the size values of the given dtype from data, and returns them as a Go slice,
+// or as a Go scalar if isScalar is true.
+func ffiValuesFromC(dtype dtypes.DType, data unsafe.Pointer, size int, isScalar bool) (any, error) {
+	switch dtype {
+	case dtypes.Bool:
+		return copyFFIValues[bool](data, size, isScalar), nil
+	case dtypes.Int8:
+		return copyFFIValues[int8](data, size, isScalar), nil
+	case dtypes.Int16:
+		return copyFFIValues[int16](data, size, isScalar), nil
+	case dtypes.Int32:
+		return copyFFIValues[int32](data, size, isScalar), nil
+	case dtypes.Int64:
+		return copyFFIValues[int64](data, size, isScalar), nil
+	case dtypes.Uint8:
+		return copyFFIValues[uint8](data, size, isScalar), nil
+	case dtypes.Uint16:
+		return copyFFIValues[uint16](data, size, isScalar), nil
+	case dtypes.Uint32:
+		return copyFFIValues[uint32](data, size, isScalar), nil
+	case dtypes.Uint64:
+		return copyFFIValues[uint64](data, size, isScalar), nil
+	case dtypes.Float32:
+		return copyFFIValues[float32](data, size, isScalar), nil
+	case dtypes.Float64:
+		return copyFFIValues[float64](data, size, isScalar), nil
+	default:
+		return nil, errors.Errorf("unsupported dtype %s", dtype)
+	}
+}
+
+func copyFFIValues[T any](data unsafe.Pointer, size int, isScalar bool) any {
+	if isScalar {
+		return *(*T)(data)
+	}
+	values := make([]T, size)
+	if size > 0 {
+		copy(values, unsafe.Slice((*T)(data), size))
+	}
+	return values
+}
+
+// ffiError converts a Go error returned by an FFIHandler to a XLA_FFI_Error.
+func ffiError(callFrame *C.XLA_FFI_CallFrame, err error) *C.XLA_FFI_Error {
+	cMsg := C.CString(err.Error())
+	defer cFree(cMsg)
+	return C.CreateFFIError(callFrame.api, C.XLA_FFI_Error_Code_INTERNAL, cMsg)
+}
@@ -0,0 +1,213 @@
+/*
+ *	Copyright 2024 Jan Pfeifer
+ *
+ *	Licensed under the Apache License, Version 2.0 (the "License");
+ *	you may not use this file except in compliance with the License.
+ *	You may obtain a copy of the License at
+ *
+ *	http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *	Unless required by applicable law or agreed to in writing, software
+ *	distributed under the License is distributed on an "AS IS" BASIS,
+ *	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *	See the License for the specific language governing permissions and
+ *	limitations under the License.
+ */
+
+// Subset of the XLA FFI C API (xla/ffi/api/c_api.h) and of the PJRT FFI extension
+// (xla/pjrt/c/pjrt_c_api_ffi_extension.h) used to register Go FFI handlers.
+//
+// Only the leading fields of the structures that are used are declared: the structures are
+// versioned by their struct_size field, and new fields are always appended.
+
+#ifndef GOMLX_GOPJRT_FFI
+#define GOMLX_GOPJRT_FFI
+#include <stddef.h>
+#include <stdint.h>
+#include "pjrt_c_api.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// ------------------------------- XLA FFI API ---------------------------------
+
+#define XLA_FFI_API_MAJOR 0
+#define XLA_FFI_API_MINOR 1
+
+typedef enum {
+  XLA_FFI_Extension_Metadata = 1,
+} XLA_FFI_Extension_Type;
+
+typedef struct XLA_FFI_Extension_Base {
+  size_t struct_size;
+  XLA_FFI_Extension_Type type;
+  struct XLA_FFI_Extension_Base* next;
+} XLA_FFI_Extension_Base;
+
+typedef enum {
+  XLA_FFI_ExecutionStage_INSTANTIATE = 0,
+  XLA_FFI_ExecutionStage_PREPARE = 1,
+  XLA_FFI_ExecutionStage_INITIALIZE = 2,
+  XLA_FFI_ExecutionStage_EXECUTE = 3,
+} XLA_FFI_ExecutionStage;
+
+// XLA_FFI_DataType values are the same as XLA's PrimitiveType.
+typedef int XLA_FFI_DataType;
+
+typedef enum {
+  XLA_FFI_Error_Code_OK = 0,
+  XLA_FFI_Error_Code_CANCELLED = 1,
+  XLA_FFI_Error_Code_UNKNOWN = 2,
+  XLA_FFI_Error_Code_INVALID_ARGUMENT = 3,
+  XLA_FFI_Error_Code_INTERNAL = 13,
+} XLA_FFI_Error_Code;
+
+typedef struct XLA_FFI_Error XLA_FFI_Error;
+typedef struct XLA_FFI_ExecutionContext XLA_FFI_ExecutionContext;
+typedef struct XLA_FFI_InternalApi XLA_FFI_InternalApi;
+typedef struct XLA_FFI_Future XLA_FFI_Future;
+
+typedef struct XLA_FFI_Api_Version {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int major_version;
+  int minor_version;
+} XLA_FFI_Api_Version;
+
+typedef struct XLA_FFI_Error_Create_Args {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  const char* message;
+  XLA_FFI_Error_Code errc;
+} XLA_FFI_Error_Create_Args;
+
+typedef XLA_FFI_Error* XLA_FFI_Error_Create(XLA_FFI_Error_Create_Args* args);
+
+typedef struct XLA_FFI_Api {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_Api_Version api_version;
+  XLA_FFI_InternalApi* internal_api;
+  XLA_FFI_Error_Create* XLA_FFI_Error_Create;
+  // ... other fields not used.
+} XLA_FFI_Api;
+
+typedef enum {
+  XLA_FFI_ArgType_BUFFER = 1,
+} XLA_FFI_ArgType;
+
+typedef enum {
+  XLA_FFI_RetType_BUFFER = 1,
+} XLA_FFI_RetType;
+
+typedef enum {
+  XLA_FFI_AttrType_ARRAY = 1,
+  XLA_FFI_AttrType_DICTIONARY = 2,
+  XLA_FFI_AttrType_SCALAR = 3,
+  XLA_FFI_AttrType_STRING = 4,
+} XLA_FFI_AttrType;
+
+typedef struct XLA_FFI_Buffer {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_DataType dtype;
+  void* data;
+  int64_t rank;
+  int64_t* dims;
+} XLA_FFI_Buffer;
+
+typedef struct XLA_FFI_ByteSpan {
+  const char* ptr;
+  size_t len;
+} XLA_FFI_ByteSpan;
+
+typedef struct XLA_FFI_Scalar {
+  XLA_FFI_DataType dtype;
+  void* value;
+} XLA_FFI_Scalar;
+
+typedef struct XLA_FFI_Array {
+  XLA_FFI_DataType dtype;
+  size_t size;
+  void* data;
+} XLA_FFI_Array;
+
+typedef struct XLA_FFI_Args {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_ArgType* types;
+  void** args;
+} XLA_FFI_Args;
+
+typedef struct XLA_FFI_Rets {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_RetType* types;
+  void** rets;
+} XLA_FFI_Rets;
+
+typedef struct XLA_FFI_Attrs {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_AttrType* types;
+  XLA_FFI_ByteSpan** names;
+  void** attrs;
+} XLA_FFI_Attrs;
+
+typedef struct XLA_FFI_CallFrame {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  const XLA_FFI_Api* api;
+  XLA_FFI_ExecutionContext* ctx;
+  XLA_FFI_ExecutionStage stage;
+  XLA_FFI_Args args;
+  XLA_FFI_Rets rets;
+  XLA_FFI_Attrs attrs;
+} XLA_FFI_CallFrame;
+
+typedef uint32_t XLA_FFI_Handler_Traits;
+
+typedef struct XLA_FFI_Metadata {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_Api_Version api_version;
+  XLA_FFI_Handler_Traits traits;
+} XLA_FFI_Metadata;
+
+typedef struct XLA_FFI_Metadata_Extension {
+  XLA_FFI_Extension_Base extension_base;
+  XLA_FFI_Metadata* metadata;
+} XLA_FFI_Metadata_Extension;
+
+typedef XLA_FFI_Error* XLA_FFI_Handler(XLA_FFI_CallFrame* call_frame);
+
+// --------------------------- PJRT FFI extension ------------------------------
+
+typedef struct PJRT_FFI_Register_Handler_Args {
+  size_t struct_size;
+  const char* target_name;
+  size_t target_name_size;
+  int api_version;  // 0 for an untyped call, 1 -- for typed
+  void* handler;
+  const char* platform_name;
+  size_t platform_name_size;
+} PJRT_FFI_Register_Handler_Args;
+
+typedef PJRT_Error* PJRT_FFI_Register_Handler(PJRT_FFI_Register_Handler_Args* args);
+
+typedef struct PJRT_FFI_Extension {
+  PJRT_Extension_Base base;
+  void* type_id_register;
+  void* user_data_add;
+  PJRT_FFI_Register_Handler* register_handler;
+} PJRT_FFI_Extension;
+
+#ifdef __cplusplus
+}  // extern "C"
+#endif
+
+#endif  // GOMLX_GOPJRT_FFI
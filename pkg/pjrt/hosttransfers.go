@@ -0,0 +1,281 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// Implemented in Go, see callbacks.go.
+extern PJRT_Error* goHostSendCallback(PJRT_Chunk* chunk, PJRT_CallbackError* callback_error,
+		size_t total_size_in_bytes, _Bool done, void* user_arg);
+extern void goHostRecvCallback(PJRT_CopyToDeviceStream* stream, void* user_arg);
+
+// Implemented in distributed.go.
+extern PJRT_Error* CallCallbackError(PJRT_CallbackError* callback_error, PJRT_Error_Code code, const char* message, size_t message_size);
+
+// SetSendCallbackInfo configures info to call goHostSendCallback with the given handle.
+static void SetSendCallbackInfo(PJRT_SendCallbackInfo* info, int64_t channel_id, uintptr_t handle) {
+	info->channel_id = channel_id;
+	info->user_arg = (void*)handle;
+	info->send_callback = &goHostSendCallback;
+}
+
+// SetRecvCallbackInfo configures info to call goHostRecvCallback with the given handle.
+static void SetRecvCallbackInfo(PJRT_RecvCallbackInfo* info, int64_t channel_id, uintptr_t handle) {
+	info->channel_id = channel_id;
+	info->user_arg = (void*)handle;
+	info->recv_callback = &goHostRecvCallback;
+}
+
+// DeleteChunk releases the chunk data with its deleter.
+static void DeleteChunk(PJRT_Chunk* chunk) {
+	if (chunk->deleter != NULL) {
+		chunk->deleter(chunk->data, chunk->deleter_arg);
+	}
+}
+
+static void freeChunkData(void* data, void* deleter_arg) {
+	free(data);
+}
+
+// CopyToDeviceStreamAddData copies the data to a new chunk, and adds it to the stream. It doesn't wait for the
+// transfer to complete.
+static PJRT_Error* CopyToDeviceStreamAddData(const PJRT_Api* api, PJRT_CopyToDeviceStream* stream,
+		const void* data, size_t size) {
+	PJRT_Chunk chunk = {0};
+	chunk.data = malloc(size > 0 ? size : 1);
+	if (size > 0) {
+		memcpy(chunk.data, data, size);
+	}
+	chunk.size = size;
+	chunk.deleter = &freeChunkData;
+	PJRT_CopyToDeviceStream_AddChunk_Args args = {0};
+	args.struct_size = PJRT_CopyToDeviceStream_AddChunk_Args_STRUCT_SIZE;
+	args.stream = stream;
+	args.chunk = &chunk;
+	PJRT_Error* err = api->PJRT_CopyToDeviceStream_AddChunk(&args);
+	if (err) {
+		// The chunk was not accepted, so its deleter won't be called.
+		free(chunk.data);
+		return err;
+	}
+	if (args.transfer_complete) {
+		PJRT_Event_Destroy_Args event_args = {0};
+		event_args.struct_size = PJRT_Event_Destroy_Args_STRUCT_SIZE;
+		event_args.event = args.transfer_complete;
+		api->PJRT_Event_Destroy(&event_args);
+	}
+	return NULL;
+}
+*/
+import "C"
+import (
+	"maps"
+	"runtime/cgo"
+	"slices"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// HostSendCallback is called when a Send op with host transfer (see stablehlo.Send) is executed, with the raw
+// bytes of the values sent.
+//
+// The deviceNum is the index of the device executing the op, in the list of devices of the execution (see
+// LoadedExecutable.GetDeviceAssignment). The data is owned by the callback.
+//
+// It is called from a thread owned by PJRT, and it may be called concurrently for different devices.
+type HostSendCallback func(deviceNum int, data []byte) error
+
+// HostRecvCallback is called when a Recv op with host transfer (see stablehlo.Recv) is executed, and it must
+// return the numBytes raw bytes of the values to be received by the device.
+//
+// The deviceNum is the index of the device executing the op, in the list of devices of the execution (see
+// LoadedExecutable.GetDeviceAssignment).
+//
+// It is called from a thread owned by PJRT, and it may be called concurrently for different devices.
+// If it returns an error, the error is logged and the device receives zeros, since there is no way to report
+// the error to the execution.
+type HostRecvCallback func(deviceNum int, numBytes int) ([]byte, error)
+
+// WithHostSendCallback sets the callback for the Send ops with host transfer, configured with the given channelID.
+//
+// Host callbacks are only supported with Done, not with DoneAsync.
+func (c *ExecutionConfig) WithHostSendCallback(channelID int, callback HostSendCallback) *ExecutionConfig {
+	if c.err != nil {
+		return c
+	}
+	if callback == nil {
+		c.err = errors.Errorf("LoadedExecutable.Execute().WithHostSendCallback() given a nil callback for channel %d", channelID)
+		return c
+	}
+	if c.hostSendCallbacks == nil {
+		c.hostSendCallbacks = make(map[int]HostSendCallback)
+	}
+	c.hostSendCallbacks[channelID] = callback
+	return c
+}
+
+// WithHostRecvCallback sets the callback for the Recv ops with host transfer, configured with the given channelID.
+//
+// Host callbacks are only supported with Done, not with DoneAsync.
+func (c *ExecutionConfig) WithHostRecvCallback(channelID int, callback HostRecvCallback) *ExecutionConfig {
+	if c.err != nil {
+		return c
+	}
+	if callback == nil {
+		c.err = errors.Errorf("LoadedExecutable.Execute().WithHostRecvCallback() given a nil callback for channel %d", channelID)
+		return c
+	}
+	if c.hostRecvCallbacks == nil {
+		c.hostRecvCallbacks = make(map[int]HostRecvCallback)
+	}
+	c.hostRecvCallbacks[channelID] = callback
+	return c
+}
+
+// numHostCallbacks returns the number of host send/recv callbacks configured.
+func (c *ExecutionConfig) numHostCallbacks() int {
+	return len(c.hostSendCallbacks) + len(c.hostRecvCallbacks)
+}
+
+// setHostCallbacks configures the options with the host send/recv callbacks, for each device.
+//
+// It returns the handles passed to the C callbacks, that must be deleted after the execution.
+func (c *ExecutionConfig) setHostCallbacks(arena *arenaContainer, options *C.PJRT_ExecuteOptions, numDevices int) []cgo.Handle {
+	handles := make([]cgo.Handle, 0, numDevices*c.numHostCallbacks())
+	if len(c.hostSendCallbacks) > 0 {
+		channels := slices.Sorted(maps.Keys(c.hostSendCallbacks))
+		perDevice := arenaAllocSlice[*C.PJRT_SendCallbackInfo](arena, numDevices)
+		for deviceNum := range perDevice {
+			infos := arenaAllocSlice[C.PJRT_SendCallbackInfo](arena, len(channels))
+			for ii, channelID := range channels {
+				handle := cgo.NewHandle(&hostSendContext{callback: c.hostSendCallbacks[channelID], deviceNum: deviceNum})
+				handles = append(handles, handle)
+				C.SetSendCallbackInfo(&infos[ii], C.int64_t(channelID), C.uintptr_t(handle))
+			}
+			perDevice[deviceNum] = &infos[0]
+		}
+		options.send_callbacks = &perDevice[0]
+		options.num_send_ops = C.size_t(len(channels))
+	}
+	if len(c.hostRecvCallbacks) > 0 {
+		channels := slices.Sorted(maps.Keys(c.hostRecvCallbacks))
+		perDevice := arenaAllocSlice[*C.PJRT_RecvCallbackInfo](arena, numDevices)
+		for deviceNum := range perDevice {
+			infos := arenaAllocSlice[C.PJRT_RecvCallbackInfo](arena, len(channels))
+			for ii, channelID := range channels {
+				handle := cgo.NewHandle(&hostRecvContext{
+					plugin:    c.executable.plugin,
+					channelID: channelID,
+					callback:  c.hostRecvCallbacks[channelID],
+					deviceNum: deviceNum,
+				})
+				handles = append(handles, handle)
+				C.SetRecvCallbackInfo(&infos[ii], C.int64_t(channelID), C.uintptr_t(handle))
+			}
+			perDevice[deviceNum] = &infos[0]
+		}
+		options.recv_callbacks = &perDevice[0]
+		options.num_recv_ops = C.size_t(len(channels))
+	}
+	return handles
+}
+
+// hostSendContext is passed (as a cgo.Handle) to goHostSendCallback, for one channel and device.
+type hostSendContext struct {
+	callback  HostSendCallback
+	deviceNum int
+
+	// data accumulates the chunks of the current transfer.
+	data []byte
+}
+
+// send handles one chunk of a transfer, and calls the callback when the transfer is done.
+// It takes ownership of the chunk.
+func (ctx *hostSendContext) send(chunk *C.PJRT_Chunk, totalSize int, done bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("host send callback panicked: %v", r)
+		}
+	}()
+	if ctx.data == nil {
+		ctx.data = make([]byte, 0, totalSize)
+	}
+	ctx.data = append(ctx.data, unsafe.Slice((*byte)(chunk.data), int(chunk.size))...)
+	C.DeleteChunk(chunk)
+	if !done {
+		return nil
+	}
+	data := ctx.data
+	ctx.data = nil
+	return ctx.callback(ctx.deviceNum, data)
+}
+
+// hostSendCallbackError converts an error of a host send callback to a PJRT_Error created with the plugin
+// provided callbackError.
+func hostSendCallbackError(callbackError *C.PJRT_CallbackError, err error) *C.PJRT_Error {
+	msg := err.Error()
+	cMsg := C.CString(msg)
+	defer cFree(cMsg)
+	return C.CallCallbackError(callbackError, C.PJRT_Error_Code(PJRT_Error_Code_INTERNAL), cMsg, C.size_t(len(msg)))
+}
+
+// hostRecvContext is passed (as a cgo.Handle) to goHostRecvCallback, for one channel and device.
+type hostRecvContext struct {
+	plugin    *Plugin
+	channelID int
+	callback  HostRecvCallback
+	deviceNum int
+}
+
+// receive fills the stream with the data returned by the callback. It takes ownership of the stream.
+func (ctx *hostRecvContext) receive(stream *C.PJRT_CopyToDeviceStream) {
+	api := ctx.plugin.api
+	defer func() {
+		args := C.new_PJRT_CopyToDeviceStream_Destroy_Args()
+		defer cFree(args)
+		args.stream = stream
+		err := toError(ctx.plugin, C.call_PJRT_CopyToDeviceStream_Destroy(api, args))
+		if err != nil {
+			klog.Errorf("failed to destroy the stream of host Recv on channel %d: %+v", ctx.channelID, err)
+		}
+	}()
+
+	totalBytesArgs := C.new_PJRT_CopyToDeviceStream_TotalBytes_Args()
+	defer cFree(totalBytesArgs)
+	totalBytesArgs.stream = stream
+	err := toError(ctx.plugin, C.call_PJRT_CopyToDeviceStream_TotalBytes(api, totalBytesArgs))
+	if err != nil {
+		klog.Errorf("failed to get the size of host Recv on channel %d: %+v", ctx.channelID, err)
+		return
+	}
+	numBytes := int(totalBytesArgs.total_bytes)
+
+	data, err := ctx.callRecvCallback(numBytes)
+	if err != nil {
+		klog.Errorf("host Recv callback on channel %d failed, the device will receive zeros: %+v", ctx.channelID, err)
+		data = make([]byte, numBytes)
+	}
+	err = toError(ctx.plugin, C.CopyToDeviceStreamAddData(api, stream, unsafe.Pointer(unsafe.SliceData(data)), C.size_t(len(data))))
+	if err != nil {
+		klog.Errorf("failed to transfer data of host Recv on channel %d: %+v", ctx.channelID, err)
+	}
+}
+
+// callRecvCallback calls the callback, checking the size of the returned data and recovering from panics.
+func (ctx *hostRecvContext) callRecvCallback(numBytes int) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("host recv callback panicked: %v", r)
+		}
+	}()
+	data, err = ctx.callback(ctx.deviceNum, numBytes)
+	if err == nil && len(data) != numBytes {
+		err = errors.Errorf("host recv callback returned %d bytes, but %d bytes were expected", len(data), numBytes)
+	}
+	return
+}
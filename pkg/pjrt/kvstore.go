@@ -0,0 +1,395 @@
+package pjrt
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// ErrKeyNotFound is returned by KeyValueStore.TryGet when the key is not in the store.
+var ErrKeyNotFound = errors.New("key not found in key-value store")
+
+// ErrKeyValueStoreServerClosed is returned for requests interrupted by KeyValueStoreServer.Close.
+var ErrKeyValueStoreServerClosed = errors.New("key-value store server closed")
+
+// KeyValueStore is used by distributed clients (see Plugin.NewDistributedClient) to share information
+// (e.g.: device topology, collective identifiers) across the processes (nodes) of a distributed computation.
+//
+// All processes must use a store that sees the same data: e.g.: TCPKeyValueStore connected to the same
+// KeyValueStoreServer.
+//
+// Implementations must be safe for concurrent use.
+type KeyValueStore interface {
+	// Get returns the value for the key, waiting up to timeout for it to be set.
+	// If the timeout is reached it returns an error wrapping context.DeadlineExceeded.
+	Get(key string, timeout time.Duration) ([]byte, error)
+
+	// TryGet returns the value for the key, or ErrKeyNotFound if it is not set, without waiting.
+	TryGet(key string) ([]byte, error)
+
+	// Put sets the value for the key.
+	Put(key string, value []byte) error
+}
+
+// MemoryKeyValueStore is an in-memory KeyValueStore.
+//
+// It can only be shared by clients in the same process. It is also used to hold the values of a
+// KeyValueStoreServer.
+type MemoryKeyValueStore struct {
+	mu      sync.Mutex
+	data    map[string][]byte
+	waiters map[string]chan struct{}
+}
+
+var _ KeyValueStore = (*MemoryKeyValueStore)(nil)
+
+// NewMemoryKeyValueStore creates an empty MemoryKeyValueStore.
+func NewMemoryKeyValueStore() *MemoryKeyValueStore {
+	return &MemoryKeyValueStore{
+		data:    make(map[string][]byte),
+		waiters: make(map[string]chan struct{}),
+	}
+}
+
+// Get implements KeyValueStore.
+func (s *MemoryKeyValueStore) Get(key string, timeout time.Duration) ([]byte, error) {
+	return s.get(key, timeout, nil)
+}
+
+// get implements Get, and it can be interrupted by closing done, in which case it returns
+// ErrKeyValueStoreServerClosed. A nil done is never closed.
+func (s *MemoryKeyValueStore) get(key string, timeout time.Duration, done <-chan struct{}) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		value, found := s.data[key]
+		if found {
+			s.mu.Unlock()
+			return value, nil
+		}
+		waiter, found := s.waiters[key]
+		if !found {
+			waiter = make(chan struct{})
+			s.waiters[key] = waiter
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-waiter:
+			// Key was set, loop to read it.
+		case <-timer.C:
+			return nil, errors.Wrapf(context.DeadlineExceeded, "timed out after %s waiting for key %q", timeout, key)
+		case <-done:
+			return nil, errors.Wrapf(ErrKeyValueStoreServerClosed, "while waiting for key %q", key)
+		}
+	}
+}
+
+// TryGet implements KeyValueStore.
+func (s *MemoryKeyValueStore) TryGet(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, found := s.data[key]
+	if !found {
+		return nil, errors.Wrapf(ErrKeyNotFound, "key %q", key)
+	}
+	return value, nil
+}
+
+// Put implements KeyValueStore.
+func (s *MemoryKeyValueStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = append([]byte(nil), value...)
+	if waiter, found := s.waiters[key]; found {
+		close(waiter)
+		delete(s.waiters, key)
+	}
+	return nil
+}
+
+// kvOp is the operation requested to a KeyValueStoreServer.
+type kvOp int
+
+const (
+	kvOpGet kvOp = iota
+	kvOpTryGet
+	kvOpPut
+)
+
+// kvStatus is the status of the response of a KeyValueStoreServer.
+type kvStatus int
+
+const (
+	kvStatusOK kvStatus = iota
+	kvStatusNotFound
+	kvStatusTimeout
+	kvStatusError
+)
+
+// kvRequest is sent (gob encoded) by TCPKeyValueStore to a KeyValueStoreServer.
+type kvRequest struct {
+	Op      kvOp
+	Key     string
+	Value   []byte
+	Timeout time.Duration
+}
+
+// kvResponse is sent (gob encoded) by KeyValueStoreServer to TCPKeyValueStore.
+type kvResponse struct {
+	Status  kvStatus
+	Value   []byte
+	Message string
+}
+
+// KeyValueStoreServer serves a MemoryKeyValueStore over TCP, to be used by TCPKeyValueStore clients.
+//
+// Usually it is started by the process of node 0 of a distributed computation, and all processes (including
+// the one of node 0) connect to it with NewTCPKeyValueStore.
+type KeyValueStoreServer struct {
+	listener net.Listener
+	store    *MemoryKeyValueStore
+
+	// done is closed by Close, to interrupt pending Get requests.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewKeyValueStoreServer listens on the given TCP address (e.g.: ":8477" or "localhost:0") and serves an
+// in-memory key-value store in the background, until Close is called.
+func NewKeyValueStoreServer(address string) (*KeyValueStoreServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %q for key-value store server", address)
+	}
+	s := &KeyValueStoreServer{
+		listener: listener,
+		store:    NewMemoryKeyValueStore(),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Address returns the address the server is listening to.
+func (s *KeyValueStoreServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Store returns the in-memory store served.
+func (s *KeyValueStoreServer) Store() *MemoryKeyValueStore {
+	return s.store
+}
+
+// Close stops the server and closes all connections.
+// Pending Get requests fail immediately with ErrKeyValueStoreServerClosed.
+func (s *KeyValueStoreServer) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *KeyValueStoreServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				klog.Errorf("KeyValueStoreServer failed to accept connection: %v", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *KeyValueStoreServer) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+	for {
+		var req kvRequest
+		if err := decoder.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				klog.Errorf("KeyValueStoreServer failed to read request from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := encoder.Encode(s.handle(req)); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.Errorf("KeyValueStoreServer failed to send response to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func (s *KeyValueStoreServer) handle(req kvRequest) kvResponse {
+	var value []byte
+	var err error
+	switch req.Op {
+	case kvOpGet:
+		value, err = s.store.get(req.Key, req.Timeout, s.done)
+	case kvOpTryGet:
+		value, err = s.store.TryGet(req.Key)
+	case kvOpPut:
+		err = s.store.Put(req.Key, req.Value)
+	default:
+		err = errors.Errorf("unknown key-value store operation %d", req.Op)
+	}
+	switch {
+	case err == nil:
+		return kvResponse{Status: kvStatusOK, Value: value}
+	case errors.Is(err, ErrKeyNotFound):
+		return kvResponse{Status: kvStatusNotFound, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		return kvResponse{Status: kvStatusTimeout, Message: err.Error()}
+	default:
+		return kvResponse{Status: kvStatusError, Message: err.Error()}
+	}
+}
+
+// TCPKeyValueStore is a KeyValueStore client of a KeyValueStoreServer.
+//
+// It keeps a pool of connections to the server, so concurrent blocking Get calls don't block each other.
+type TCPKeyValueStore struct {
+	address string
+
+	mu   sync.Mutex
+	idle []*kvConn
+}
+
+var _ KeyValueStore = (*TCPKeyValueStore)(nil)
+
+// kvConn is a connection to a KeyValueStoreServer.
+type kvConn struct {
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// TCPKeyValueStoreDialTimeout is the timeout used to connect to a KeyValueStoreServer.
+var TCPKeyValueStoreDialTimeout = 30 * time.Second
+
+// NewTCPKeyValueStore creates a KeyValueStore connected to the KeyValueStoreServer at the given address.
+// It checks the server is reachable.
+func NewTCPKeyValueStore(address string) (*TCPKeyValueStore, error) {
+	s := &TCPKeyValueStore{address: address}
+	conn, err := s.getConn()
+	if err != nil {
+		return nil, err
+	}
+	s.putConn(conn)
+	return s, nil
+}
+
+// Close closes the idle connections to the server.
+func (s *TCPKeyValueStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, c := range s.idle {
+		if err := c.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.idle = nil
+	return firstErr
+}
+
+func (s *TCPKeyValueStore) getConn() (*kvConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", s.address, TCPKeyValueStoreDialTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to key-value store server at %q", s.address)
+	}
+	return &kvConn{conn: conn, encoder: gob.NewEncoder(conn), decoder: gob.NewDecoder(conn)}, nil
+}
+
+func (s *TCPKeyValueStore) putConn(c *kvConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle = append(s.idle, c)
+}
+
+// call sends the request to the server and converts the response status back to an error.
+func (s *TCPKeyValueStore) call(req kvRequest) ([]byte, error) {
+	c, err := s.getConn()
+	if err != nil {
+		return nil, err
+	}
+	var resp kvResponse
+	err = c.encoder.Encode(req)
+	if err == nil {
+		err = c.decoder.Decode(&resp)
+	}
+	if err != nil {
+		// Connection is in an unknown state, drop it.
+		_ = c.conn.Close()
+		return nil, errors.Wrapf(err, "failed to communicate with key-value store server at %q", s.address)
+	}
+	s.putConn(c)
+	switch resp.Status {
+	case kvStatusOK:
+		return resp.Value, nil
+	case kvStatusNotFound:
+		return nil, errors.Wrap(ErrKeyNotFound, resp.Message)
+	case kvStatusTimeout:
+		return nil, errors.Wrap(context.DeadlineExceeded, resp.Message)
+	default:
+		return nil, errors.Errorf("key-value store server error: %s", resp.Message)
+	}
+}
+
+// Get implements KeyValueStore.
+func (s *TCPKeyValueStore) Get(key string, timeout time.Duration) ([]byte, error) {
+	return s.call(kvRequest{Op: kvOpGet, Key: key, Timeout: timeout})
+}
+
+// TryGet implements KeyValueStore.
+func (s *TCPKeyValueStore) TryGet(key string) ([]byte, error) {
+	return s.call(kvRequest{Op: kvOpTryGet, Key: key})
+}
+
+// Put implements KeyValueStore.
+func (s *TCPKeyValueStore) Put(key string, value []byte) error {
+	_, err := s.call(kvRequest{Op: kvOpPut, Key: key, Value: value})
+	return err
+}
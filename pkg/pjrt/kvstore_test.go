@@ -0,0 +1,193 @@
+package pjrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+// testKeyValueStore runs the common tests for KeyValueStore implementations.
+func testKeyValueStore(t *testing.T, store KeyValueStore) {
+	_, err := store.TryGet("a")
+	assertTrue(t, errors.Is(err, ErrKeyNotFound), "expected ErrKeyNotFound, got %v", err)
+	_, err = store.Get("a", 10*time.Millisecond)
+	assertTrue(t, errors.Is(err, context.DeadlineExceeded), "expected context.DeadlineExceeded, got %v", err)
+
+	requireNoError(t, store.Put("a", []byte("1")))
+	assertEqualSlice(t, []byte("1"), must1(store.TryGet("a")))
+	assertEqualSlice(t, []byte("1"), must1(store.Get("a", time.Second)))
+
+	// Blocking Get is woken up by a later Put, without blocking other calls.
+	var wg sync.WaitGroup
+	var value []byte
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		value, err = store.Get("b", time.Minute)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	requireNoError(t, store.Put("c", []byte("3")))
+	requireNoError(t, store.Put("b", []byte("2")))
+	wg.Wait()
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("2"), value)
+	assertEqualSlice(t, []byte("3"), must1(store.TryGet("c")))
+}
+
+func TestMemoryKeyValueStore(t *testing.T) {
+	testKeyValueStore(t, NewMemoryKeyValueStore())
+}
+
+func TestTCPKeyValueStore(t *testing.T) {
+	server, err := NewKeyValueStoreServer("localhost:0")
+	requireNoError(t, err)
+	defer func() { requireNoError(t, server.Close()) }()
+	store, err := NewTCPKeyValueStore(server.Address())
+	requireNoError(t, err)
+	defer func() { requireNoError(t, store.Close()) }()
+	testKeyValueStore(t, store)
+
+	// Values are visible to other clients of the same server.
+	other, err := NewTCPKeyValueStore(server.Address())
+	requireNoError(t, err)
+	defer func() { requireNoError(t, other.Close()) }()
+	assertEqualSlice(t, []byte("1"), must1(other.TryGet("a")))
+	assertEqualSlice(t, []byte("1"), must1(server.Store().TryGet("a")))
+}
+
+func TestTCPKeyValueStoreTwoNodes(t *testing.T) {
+	server, err := NewKeyValueStoreServer("localhost:0")
+	requireNoError(t, err)
+	defer func() { requireNoError(t, server.Close()) }()
+
+	// Each node publishes its keys and concurrently waits for the keys of the other node.
+	const numNodes, numKeys = 2, 20
+	stores := make([]*TCPKeyValueStore, numNodes)
+	for node := range numNodes {
+		stores[node], err = NewTCPKeyValueStore(server.Address())
+		requireNoError(t, err)
+		defer func() { requireNoError(t, stores[node].Close()) }()
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*numNodes*numKeys)
+	for node := range numNodes {
+		other := (node + 1) % numNodes
+		for key := range numKeys {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				errs <- stores[node].Put(fmt.Sprintf("node%d/key%d", node, key), []byte(fmt.Sprintf("%d:%d", node, key)))
+			}()
+			go func() {
+				defer wg.Done()
+				value, err := stores[node].Get(fmt.Sprintf("node%d/key%d", other, key), 10*time.Second)
+				if err == nil && string(value) != fmt.Sprintf("%d:%d", other, key) {
+					err = fmt.Errorf("node %d got %q for key %d of node %d", node, value, key, other)
+				}
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		requireNoError(t, err)
+	}
+}
+
+func TestKeyValueStoreServerClose(t *testing.T) {
+	server, err := NewKeyValueStoreServer("localhost:0")
+	requireNoError(t, err)
+	store, err := NewTCPKeyValueStore(server.Address())
+	requireNoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	// Get without timeout, as PJRT requests with a negative timeout, must be interrupted by Close.
+	getErr := make(chan error, 1)
+	go func() {
+		_, err := store.Get("never_set", time.Duration(math.MaxInt64))
+		getErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- server.Close() }()
+	select {
+	case err := <-closed:
+		requireNoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("KeyValueStoreServer.Close blocked by a pending Get")
+	}
+	requireError(t, <-getErr)
+
+	// The in-memory store interrupts the Get directly.
+	done := make(chan struct{})
+	close(done)
+	_, err = server.Store().get("never_set", time.Duration(math.MaxInt64), done)
+	assertTrue(t, errors.Is(err, ErrKeyValueStoreServerClosed), "expected ErrKeyValueStoreServerClosed, got %v", err)
+}
+
+func TestDistributedClient(t *testing.T) {
+	plugin, err := GetPlugin(*FlagPluginName)
+	requireNoError(t, err)
+	store := NewMemoryKeyValueStore()
+	_, err = plugin.NewDistributedClient(nil, DistributedOptions{NodeID: 1, NumNodes: 1, KeyValueStore: store})
+	requireError(t, err)
+
+	client, err := plugin.NewDistributedClient(nil, DistributedOptions{NodeID: 0, NumNodes: 1, KeyValueStore: store})
+	requireNoError(t, err)
+	assertEqual(t, 0, client.ProcessIndex())
+
+	// f(x) = x*x
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32)))
+	must(mainFn.Return(must1(stablehlo.Multiply(x, x))))
+	exec, err := client.Compile().WithStableHLO(must1(builder.Build())).Done()
+	requireNoError(t, err)
+	assertEqual(t, float32(9), execWithScalars(t, client, exec, float32(3)))
+	requireNoError(t, client.Destroy())
+}
+
+func TestDistributedClientTwoNodes(t *testing.T) {
+	plugin, err := GetPlugin(*FlagPluginName)
+	requireNoError(t, err)
+	server, err := NewKeyValueStoreServer("localhost:0")
+	requireNoError(t, err)
+	defer func() { requireNoError(t, server.Close()) }()
+
+	// Both nodes must be created concurrently, since each waits for the other to publish its topology.
+	const numNodes = 2
+	clients := make([]*Client, numNodes)
+	errs := make([]error, numNodes)
+	var wg sync.WaitGroup
+	for node := range numNodes {
+		wg.Add(1)
+		store, err := NewTCPKeyValueStore(server.Address())
+		requireNoError(t, err)
+		defer func() { requireNoError(t, store.Close()) }()
+		go func() {
+			defer wg.Done()
+			clients[node], errs[node] = plugin.NewDistributedClient(nil,
+				DistributedOptions{NodeID: node, NumNodes: numNodes, KeyValueStore: store})
+		}()
+	}
+	wg.Wait()
+	for node, client := range clients {
+		requireNoError(t, errs[node])
+		assertEqual(t, node, client.ProcessIndex())
+		allDevices, err := client.AllDevices()
+		requireNoError(t, err)
+		assertEqual(t, numNodes*len(client.AddressableDevices()), len(allDevices))
+	}
+	for _, client := range clients {
+		requireNoError(t, client.Destroy())
+	}
+}
@@ -204,6 +204,17 @@ func (e *LoadedExecutable) GetDeviceAssignment() (numReplicas, numPartitions int
 	return e.numReplicas, e.numPartitions, e.deviceAssignment, nil
 }
 
+// Executable returns the Executable associated with the LoadedExecutable, which can be used for introspection
+// (e.g.: Executable.OutputShapes, Executable.GetCostAnalysis and Executable.OptimizedProgram).
+//
+// It is owned by the LoadedExecutable, and it shouldn't be destroyed.
+func (e *LoadedExecutable) Executable() (*Executable, error) {
+	if e == nil || e.plugin == nil || e.wrapper == nil || e.executable == nil {
+		return nil, errors.New("LoadedExecutable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	return e.executable, nil
+}
+
 // IsPortable returns whether the computation was compiled to be device-portable -- it can run on any device.
 func (e *LoadedExecutable) IsPortable() bool {
 	return e.isPortable
@@ -257,6 +268,10 @@ type ExecutionConfig struct {
 	// portableDevice is the device to execute the computation on, if it is portable.
 	portableDevice int
 
+	// hostSendCallbacks and hostRecvCallbacks are indexed by channel ID.
+	hostSendCallbacks map[int]HostSendCallback
+	hostRecvCallbacks map[int]HostRecvCallback
+
 	// err saves an error during the configuration.
 	err error
 }
@@ -369,15 +384,36 @@ func (c *ExecutionConfig) SetDonate(donate []bool) *ExecutionConfig {
 }
 
 // Done triggers the execution of the compiled computation.
+// It blocks until the execution is finished.
 func (c *ExecutionConfig) Done() ([]*Buffer, error) {
+	outputs, _, err := c.execute(true)
+	return outputs, err
+}
+
+// DoneAsync triggers the execution of the compiled computation, and returns immediately, without waiting for the
+// execution to finish.
+//
+// The output buffers can be used right away, e.g., as inputs to another execution -- this allows pipelining
+// several steps. Transferring them to host (e.g., with Buffer.ToHost) waits for them to be ready.
+//
+// It also returns one completion Event per device (see LoadedExecutable.GetDeviceAssignment), that becomes
+// ready when the execution on the device finishes, with the execution error, if any.
+// See Event.Await, Event.OnReady, Event.Done and AwaitEvents.
+func (c *ExecutionConfig) DoneAsync() (outputs []*Buffer, completions []*Event, err error) {
+	return c.execute(false)
+}
+
+// execute the compiled computation. If wait is true, it waits for the execution to finish and no completion
+// events are returned.
+func (c *ExecutionConfig) execute(wait bool) ([]*Buffer, []*Event, error) {
 	if c.err != nil {
-		return nil, c.err
+		return nil, nil, c.err
 	}
 	e := c.executable
 	plugin := e.plugin
 
 	if plugin == nil || e.wrapper == nil {
-		return nil, errors.New("LoadedExecutable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+		return nil, nil, errors.New("LoadedExecutable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
 	}
 	defer runtime.KeepAlive(e)
 
@@ -385,7 +421,7 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	numDevices := e.numReplicas * e.numPartitions
 	numInputs := len(c.inputs)
 	if numInputs%numDevices != 0 {
-		return nil, errors.Errorf("LoadedExecutable.Execute() requires that the number of inputs be "+
+		return nil, nil, errors.Errorf("LoadedExecutable.Execute() requires that the number of inputs be "+
 			"divisible by the number of devices, but got %d inputs and %d devices", numInputs, numDevices)
 	}
 	numInputsPerDevice := numInputs / numDevices
@@ -395,6 +431,13 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	// Allocations that CGO will use.
 	// Except if the number of inputs/outputs is very large, used the default arena size.
 	minSize := (numInputs+numOutputs)*3*8 /*pointer size*/ + 1024
+	if c.numHostCallbacks() > 0 {
+		if !wait {
+			return nil, nil, errors.New("LoadedExecutable.Execute() with host send/recv callbacks is only supported " +
+				"with Done, not DoneAsync")
+		}
+		minSize += numDevices * (c.numHostCallbacks() + 1) * int(unsafe.Sizeof(C.PJRT_SendCallbackInfo{}))
+	}
 	arena := plugin.getArena(minSize)
 	defer plugin.returnArena(arena)
 
@@ -406,17 +449,17 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	args.num_devices = C.size_t(numDevices)
 	if e.isPortable {
 		if numDevices > 1 {
-			return nil, errors.Errorf("invalid number of devices for portable executable, portable "+
+			return nil, nil, errors.Errorf("invalid number of devices for portable executable, portable "+
 				"executables only work for one device, got %d devices", numDevices)
 		}
 		if c.onDevice == nil {
-			return nil, errors.Errorf("LoadedExecutable.Execute() requires that OnDevice to be set to" +
+			return nil, nil, errors.Errorf("LoadedExecutable.Execute() requires that OnDevice to be set to" +
 				" non-nil device before Done")
 		}
 		args.execute_device = c.onDevice.cDevice
 	} else {
 		if c.onDevice != nil {
-			return nil, errors.Errorf("LoadedExecutable.Execute(): non-portable computation cannot set " +
+			return nil, nil, errors.Errorf("LoadedExecutable.Execute(): non-portable computation cannot set " +
 				"OnDevice or OnDeviceNum: the device(s) was(were) determined during the compilation")
 		}
 		args.execute_device = nil
@@ -437,12 +480,22 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 		options.non_donatable_input_indices = &nonDonatableIndices[0]
 	}
 
+	// Host send/recv callbacks: the handles are only used during the execution.
+	if c.numHostCallbacks() > 0 {
+		handles := c.setHostCallbacks(arena, options, numDevices)
+		defer func() {
+			for _, handle := range handles {
+				handle.Delete()
+			}
+		}()
+	}
+
 	// Inputs organized per device.
 	args.num_args = C.size_t(numInputsPerDevice)
 	if args.num_args > 0 {
 		args.argument_lists = allocatePerDeviceBufferListWithArena(arena, numDevices, numInputsPerDevice, c.inputs)
 		if args.argument_lists == nil {
-			return nil, errors.Errorf("LoadedExecutable.Execute() failed to allocate argument_lists")
+			return nil, nil, errors.Errorf("LoadedExecutable.Execute() failed to allocate argument_lists")
 		}
 	}
 
@@ -456,9 +509,23 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	perDeviceEvents := arenaAllocSlice[*C.PJRT_Event](arena, numDevices)
 	args.device_complete_events = (**C.PJRT_Event)(unsafe.SliceData(perDeviceEvents))
 
-	err := toError(e.plugin, C.ExecuteAndWait(e.plugin.api, args))
-	if err != nil {
-		return nil, err
+	var completions []*Event
+	if wait {
+		err := toError(e.plugin, C.ExecuteAndWait(e.plugin.api, args))
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		err := toError(e.plugin, C.call_PJRT_LoadedExecutable_Execute(e.plugin.api, args))
+		if err != nil {
+			return nil, nil, err
+		}
+		completions = make([]*Event, 0, numDevices)
+		for _, cEvent := range perDeviceEvents {
+			if cEvent != nil {
+				completions = append(completions, newEvent(plugin, cEvent))
+			}
+		}
 	}
 
 	// We only support one device for now, so we return the results from the first device.
@@ -474,11 +541,11 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 			err := input.Destroy()
 			if err != nil {
 				err = errors.WithMessagef(err, "LoadedExecutable.Execute().Done() failed to destroy donated input %d: %v", idx, err)
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
-	return outputs, nil
+	return outputs, completions, nil
 }
 
 // Allocate [numDevices][numBuffers]*Buffer C 2D-array to be used by PJRT C API.
@@ -46,3 +46,50 @@ func TestDonatableConfig(t *testing.T) {
 	err = client.Destroy()
 	requireNoError(t, err, "Failed to destroy the client")
 }
+
+func TestExecutableIntrospection(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	// f(x, y) = (x+y, x*x) with x, y float32[2,3]
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32, 2, 3)))
+	y := must1(mainFn.NamedInput("y", shapes.Make(dtypes.F32, 2, 3)))
+	must(mainFn.Return(must1(stablehlo.Add(x, y)), must1(stablehlo.Multiply(x, x))))
+	exec, err := client.Compile().WithStableHLO(must1(builder.Build())).Done()
+	requireNoError(t, err, "Failed to compile program")
+	defer func() { requireNoError(t, exec.Destroy()) }()
+
+	executable, err := exec.Executable()
+	requireNoError(t, err)
+	outputShapes, err := executable.OutputShapes()
+	requireNoError(t, err)
+	assertLen(t, outputShapes, 2)
+	for _, shape := range outputShapes {
+		assertTrue(t, shape.Equal(shapes.Make(dtypes.F32, 2, 3)), "got output shape %s", shape)
+	}
+	memoryKinds, err := executable.OutputMemoryKinds()
+	requireNoError(t, err)
+	assertLen(t, memoryKinds, 2)
+	assertEqual(t, 1, must1(executable.NumReplicas()))
+	assertEqual(t, 1, must1(executable.NumPartitions()))
+	codeSize, err := executable.SizeOfGeneratedCodeInBytes()
+	requireNoError(t, err)
+	fmt.Printf("Output memory kinds: %q, generated code size: %d bytes\n", memoryKinds, codeSize)
+
+	costs, err := executable.GetCostAnalysis()
+	requireNoError(t, err)
+	fmt.Printf("Cost analysis: %v\n", costs)
+
+	program, format, err := executable.OptimizedProgram()
+	requireNoError(t, err)
+	assertNotEmpty(t, program)
+	fmt.Printf("Optimized program: format=%q, %d bytes\n", format, len(program))
+
+	fingerprint, err := executable.Fingerprint()
+	if !isNotSupportedError(err) {
+		requireNoError(t, err)
+		fmt.Printf("Fingerprint: %q\n", fingerprint)
+	}
+}
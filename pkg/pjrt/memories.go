@@ -0,0 +1,281 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Common memory kinds -- they are platform-dependent, these are the ones commonly used by XLA.
+const (
+	// MemoryKindDevice is the default on-device memory kind.
+	MemoryKindDevice = "device"
+
+	// MemoryKindPinnedHost is host memory pinned (page-locked) for fast transfers with the device.
+	MemoryKindPinnedHost = "pinned_host"
+
+	// MemoryKindUnpinnedHost is regular (pageable) host memory.
+	MemoryKindUnpinnedHost = "unpinned_host"
+)
+
+// Memory is a lightweight reference to a memory space managed by a Client -- it doesn't own the underlying object.
+//
+// Devices can address one or more memory spaces, each of a different kind (e.g.: "device", "pinned_host",
+// "unpinned_host"), and buffers can be moved across them (see Buffer.CopyToMemory), for instance to offload
+// on-device values to the host memory.
+//
+// See Client.AddressableMemories, Device.AddressableMemories and Device.DefaultMemory.
+type Memory struct {
+	plugin  *Plugin
+	client  *Client
+	cMemory *C.PJRT_Memory // (PJRT) `memory` has the same lifetime as (PJRT) `client`. It is owned by (PJRT) `client`.
+}
+
+// newMemory creates a new Memory reference.
+func newMemory(client *Client, memory *C.PJRT_Memory) *Memory {
+	return &Memory{plugin: client.plugin, client: client, cMemory: memory}
+}
+
+// cMemoriesToSlice converts a C array of PJRT_Memory pointers to a slice of Memory references.
+func cMemoriesToSlice(client *Client, cMemories **C.PJRT_Memory, numMemories C.size_t) []*Memory {
+	cSlice := cDataToSlice[*C.PJRT_Memory](unsafe.Pointer(cMemories), int(numMemories))
+	memories := make([]*Memory, len(cSlice))
+	for ii, m := range cSlice {
+		memories[ii] = newMemory(client, m)
+	}
+	return memories
+}
+
+// check returns an error if the Memory reference is invalid.
+func (m *Memory) check() error {
+	if m == nil || m.cMemory == nil || m.plugin == nil || !m.client.IsValid() {
+		return errors.New("Memory is nil, or its client has been destroyed")
+	}
+	return nil
+}
+
+// Id returns the ID of this memory. IDs are unique among memories of the same kind.
+func (m *Memory) Id() (int, error) {
+	if err := m.check(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_Id_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_Id(m.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int(args.id), nil
+}
+
+// Kind returns a platform-dependent string that uniquely identifies the kind of the memory.
+// Common values are MemoryKindDevice, MemoryKindPinnedHost and MemoryKindUnpinnedHost.
+func (m *Memory) Kind() (string, error) {
+	if err := m.check(); err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_Kind_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_Kind(m.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.kind, args.kind_size), nil
+}
+
+// KindId returns a platform-dependent ID that uniquely identifies the kind of the memory.
+func (m *Memory) KindId() (int, error) {
+	if err := m.check(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_Kind_Id_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_Kind_Id(m.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int(args.kind_id), nil
+}
+
+// DebugString suitable for logging when errors occur.
+// Should be verbose enough to describe the current memory unambiguously.
+func (m *Memory) DebugString() string {
+	if err := m.check(); err != nil {
+		return fmt.Sprintf("Memory failed to retrieve debug string: %v", err)
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_DebugString_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_DebugString(m.plugin.api, args))
+	if err != nil {
+		return fmt.Sprintf("Memory failed to retrieve debug string: %v", err)
+	}
+	return cCharArray(args.debug_string, args.debug_string_size)
+}
+
+// String implements fmt.Stringer. It returns a terse description of the memory.
+func (m *Memory) String() string {
+	if err := m.check(); err != nil {
+		return "Invalid memory"
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_ToString_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_ToString(m.plugin.api, args))
+	if err != nil {
+		return fmt.Sprintf("Memory failed to retrieve string: %v", err)
+	}
+	return cCharArray(args.to_string, args.to_string_size)
+}
+
+// AddressableByDevices returns the devices that can address this memory.
+func (m *Memory) AddressableByDevices() ([]*Device, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(m)
+	args := C.new_PJRT_Memory_AddressableByDevices_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(m.plugin, C.call_PJRT_Memory_AddressableByDevices(m.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cDevices := cDataToSlice[*C.PJRT_Device](unsafe.Pointer(args.devices), int(args.num_devices))
+	devices := make([]*Device, len(cDevices))
+	for ii, d := range cDevices {
+		devices[ii] = m.client.deviceFromC(d)
+	}
+	return devices, nil
+}
+
+// AddressableMemories returns the list of memories that are addressable from the client.
+// Addressable memories are those that the client can directly transfer data to and from.
+// All memories are addressable in a single-process environment.
+func (c *Client) AddressableMemories() ([]*Memory, error) {
+	if !c.IsValid() {
+		return nil, errors.New("Client is nil or it has already been destroyed")
+	}
+	defer runtime.KeepAlive(c)
+	args := C.new_PJRT_Client_AddressableMemories_Args()
+	defer cFree(args)
+	args.client = c.client.c
+	err := toError(c.plugin, C.call_PJRT_Client_AddressableMemories(c.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return cMemoriesToSlice(c, args.addressable_memories, args.num_addressable_memories), nil
+}
+
+// AddressableMemories returns the memories that the device can address.
+func (d *Device) AddressableMemories() ([]*Memory, error) {
+	args := C.new_PJRT_Device_AddressableMemories_Args()
+	defer cFree(args)
+	args.device = d.cDevice
+	err := toError(d.plugin, C.call_PJRT_Device_AddressableMemories(d.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return cMemoriesToSlice(d.client, args.memories, args.num_memories), nil
+}
+
+// DefaultMemory returns the default memory of the device, that is, where the data processed by the device is
+// stored by default.
+func (d *Device) DefaultMemory() (*Memory, error) {
+	args := C.new_PJRT_Device_DefaultMemory_Args()
+	defer cFree(args)
+	args.device = d.cDevice
+	err := toError(d.plugin, C.call_PJRT_Device_DefaultMemory(d.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newMemory(d.client, args.memory), nil
+}
+
+// MemoryByKind returns the memory of the given kind (e.g.: MemoryKindPinnedHost) addressable by the device.
+// It returns an error if the device can't address any memory of the given kind.
+func (d *Device) MemoryByKind(kind string) (*Memory, error) {
+	memories, err := d.AddressableMemories()
+	if err != nil {
+		return nil, err
+	}
+	for _, memory := range memories {
+		memoryKind, err := memory.Kind()
+		if err != nil {
+			return nil, err
+		}
+		if memoryKind == kind {
+			return memory, nil
+		}
+	}
+	return nil, errors.Errorf("device %d has no addressable memory of kind %q", d.localHardwareId, kind)
+}
+
+// Memory returns the memory where the buffer is stored.
+func (b *Buffer) Memory() (*Memory, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_Buffer_Memory_Args](arena)
+	args.struct_size = C.PJRT_Buffer_Memory_Args_STRUCT_SIZE
+	args.buffer = b.wrapper.c
+	err = toError(plugin, C.call_PJRT_Buffer_Memory(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newMemory(b.wrapper.client, args.memory), nil
+}
+
+// CopyToMemory copies the buffer to the given memory (of the same client) and returns a new buffer.
+// The original buffer is not affected.
+//
+// This can be used, for instance, to offload on-device values to the host memory (see MemoryKindPinnedHost)
+// and bring them back later.
+//
+// It returns an error if the buffer is already in dstMemory.
+func (b *Buffer) CopyToMemory(dstMemory *Memory) (*Buffer, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if err = dstMemory.check(); err != nil {
+		return nil, errors.WithMessage(err, "invalid destination memory")
+	}
+	defer runtime.KeepAlive(b)
+	defer runtime.KeepAlive(dstMemory)
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+
+	args := arenaAlloc[C.PJRT_Buffer_CopyToMemory_Args](arena)
+	args.struct_size = C.PJRT_Buffer_CopyToMemory_Args_STRUCT_SIZE
+	args.buffer = b.wrapper.c
+	args.dst_memory = dstMemory.cMemory
+
+	err = toError(plugin, C.call_PJRT_Buffer_CopyToMemory(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to copy buffer to memory %s", dstMemory)
+	}
+	return newBuffer(b.wrapper.client, args.dst_buffer), nil
+}
@@ -0,0 +1,77 @@
+package pjrt
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMemories(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	memories, err := client.AddressableMemories()
+	requireNoError(t, err)
+	assertNotEmpty(t, memories)
+	for _, memory := range memories {
+		kind, err := memory.Kind()
+		requireNoError(t, err)
+		id, err := memory.Id()
+		requireNoError(t, err)
+		devices, err := memory.AddressableByDevices()
+		requireNoError(t, err)
+		for _, device := range devices {
+			// The client's own devices are returned, so they can be compared by pointer.
+			if !slices.Contains(client.AddressableDevices(), device) {
+				t.Errorf("Memory #%d: AddressableByDevices() returned a device not in client.AddressableDevices()", id)
+			}
+		}
+		fmt.Printf("\tMemory #%d: kind=%q, %d device(s), %s\n", id, kind, len(devices), memory.DebugString())
+	}
+
+	device := client.AddressableDevices()[0]
+	defaultMemory, err := device.DefaultMemory()
+	requireNoError(t, err)
+	deviceMemories, err := device.AddressableMemories()
+	requireNoError(t, err)
+	assertNotEmpty(t, deviceMemories)
+
+	// Buffer is created in the default memory of the device.
+	buffer, err := ArrayToBuffer(client, []float32{1, 2, 3}, 3)
+	requireNoError(t, err)
+	defer func() { requireNoError(t, buffer.Destroy()) }()
+	bufferMemory, err := buffer.Memory()
+	requireNoError(t, err)
+	assertEqual(t, defaultMemory.String(), bufferMemory.String())
+
+	// Copy to any other memory and back.
+	for _, memory := range deviceMemories {
+		if memory.String() == defaultMemory.String() {
+			continue
+		}
+		kind := must1(memory.Kind())
+		copied, err := buffer.CopyToMemory(memory)
+		requireNoError(t, err, "Failed to copy buffer to memory of kind %q", kind)
+		assertEqual(t, kind, must1(must1(copied.Memory()).Kind()))
+		back, err := copied.CopyToMemory(defaultMemory)
+		requireNoError(t, err, "Failed to copy buffer back from memory of kind %q", kind)
+		flat, dims, err := BufferToArray[float32](back)
+		requireNoError(t, err)
+		assertEqualSlice(t, []int{3}, dims)
+		assertEqualSlice(t, []float32{1, 2, 3}, flat)
+		requireNoError(t, copied.Destroy())
+		requireNoError(t, back.Destroy())
+
+		// Transfer from host directly to the memory.
+		direct, err := client.BufferFromHost().FromFlatDataWithDimensions([]float32{4, 5}, []int{2}).ToMemory(memory).Done()
+		requireNoError(t, err, "Failed to transfer to memory of kind %q", kind)
+		assertEqual(t, kind, must1(must1(direct.Memory()).Kind()))
+		requireNoError(t, direct.Destroy())
+	}
+
+	// Memories of another client are rejected.
+	otherClient := getPJRTClient(t)
+	defer func() { requireNoError(t, otherClient.Destroy()) }()
+	_, err = otherClient.BufferFromHost().FromFlatDataWithDimensions([]float32{4, 5}, []int{2}).ToMemory(memories[0]).Done()
+	requireError(t, err)
+}
@@ -0,0 +1,259 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// FreeSerializedExecutable calls the deleter returned by PJRT_Executable_Serialize, if one was given.
+void FreeSerializedExecutable(PJRT_Executable_Serialize_Args *args) {
+	if (args->serialized_executable_deleter != NULL && args->serialized_executable != NULL) {
+		args->serialized_executable_deleter(args->serialized_executable);
+	}
+	args->serialized_executable = NULL;
+}
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// serializedExecutableMagic prefixes the bytes generated by LoadedExecutable.Serialize, so we can tell
+// them apart from the raw PJRT serialization.
+const serializedExecutableMagic = "GOPJRTEX"
+
+// serializedExecutableVersion is the version of the header format written by LoadedExecutable.Serialize.
+const serializedExecutableVersion = 1
+
+// executableMetadata is the information kept on the Go side of a LoadedExecutable that is not part
+// of the PJRT serialized executable.
+type executableMetadata struct {
+	numReplicas, numPartitions int
+	deviceAssignment           []int
+	isPortable                 bool
+}
+
+// encodeExecutableMetadata writes the header with the metadata, followed by the PJRT serialized executable.
+func encodeExecutableMetadata(meta executableMetadata, pjrtSerialized []byte) []byte {
+	buf := make([]byte, 0, len(serializedExecutableMagic)+8*(4+len(meta.deviceAssignment))+len(pjrtSerialized))
+	buf = append(buf, serializedExecutableMagic...)
+	buf = binary.AppendUvarint(buf, serializedExecutableVersion)
+	buf = binary.AppendUvarint(buf, uint64(meta.numReplicas))
+	buf = binary.AppendUvarint(buf, uint64(meta.numPartitions))
+	var isPortable byte
+	if meta.isPortable {
+		isPortable = 1
+	}
+	buf = append(buf, isPortable)
+	if meta.deviceAssignment == nil {
+		buf = binary.AppendVarint(buf, -1)
+	} else {
+		buf = binary.AppendVarint(buf, int64(len(meta.deviceAssignment)))
+		for _, deviceIdx := range meta.deviceAssignment {
+			buf = binary.AppendVarint(buf, int64(deviceIdx))
+		}
+	}
+	buf = append(buf, pjrtSerialized...)
+	return buf
+}
+
+// decodeExecutableMetadata parses the header written by encodeExecutableMetadata and returns the metadata and
+// the remaining PJRT serialized executable.
+//
+// The returned pjrtSerialized slice shares the underlying data with data.
+func decodeExecutableMetadata(data []byte) (meta executableMetadata, pjrtSerialized []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(serializedExecutableMagic)) {
+		err = errors.New("invalid serialized executable: it was not created by LoadedExecutable.Serialize")
+		return
+	}
+	reader := bytes.NewReader(data[len(serializedExecutableMagic):])
+	readUint := func(name string) int {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(reader)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid serialized executable: failed to read %s", name)
+		}
+		return int(v)
+	}
+	readInt := func(name string) int {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(reader)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid serialized executable: failed to read %s", name)
+		}
+		return int(v)
+	}
+
+	version := readUint("format version")
+	if err == nil && version != serializedExecutableVersion {
+		err = errors.Errorf("invalid serialized executable: format version %d not supported, expected version %d",
+			version, serializedExecutableVersion)
+	}
+	meta.numReplicas = readUint("number of replicas")
+	meta.numPartitions = readUint("number of partitions")
+	if err != nil {
+		return
+	}
+	var isPortable byte
+	isPortable, err = reader.ReadByte()
+	if err != nil {
+		err = errors.Wrap(err, "invalid serialized executable: failed to read portability")
+		return
+	}
+	meta.isPortable = isPortable != 0
+	assignmentLen := readInt("device assignment length")
+	if err != nil {
+		return
+	}
+	if assignmentLen >= 0 {
+		if assignmentLen > reader.Len() {
+			err = errors.Errorf("invalid serialized executable: device assignment length %d larger than the data", assignmentLen)
+			return
+		}
+		meta.deviceAssignment = make([]int, assignmentLen)
+		for ii := range meta.deviceAssignment {
+			meta.deviceAssignment[ii] = readInt("device assignment")
+		}
+	}
+	if err != nil {
+		return
+	}
+	if meta.numReplicas <= 0 || meta.numPartitions <= 0 {
+		err = errors.Errorf("invalid serialized executable: got %d replicas and %d partitions",
+			meta.numReplicas, meta.numPartitions)
+		return
+	}
+	pjrtSerialized = data[len(data)-reader.Len():]
+	if len(pjrtSerialized) == 0 {
+		err = errors.New("invalid serialized executable: missing PJRT serialized executable")
+	}
+	return
+}
+
+// Serialize returns a platform-specific serialization of the compiled program, including the metadata set during
+// compilation (number of replicas, number of partitions, device assignment and portability).
+//
+// It can be loaded back with Client.DeserializeAndLoad, on a client using the same plugin and plugin version.
+// PJRT doesn't guarantee the serialization to be stable over time.
+func (e *LoadedExecutable) Serialize() ([]byte, error) {
+	if e == nil || e.plugin == nil || e.wrapper == nil || e.executable == nil || !e.executable.wrapper.IsValid() {
+		return nil, errors.New("LoadedExecutable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	return e.executable.serialize(executableMetadata{
+		numReplicas:      e.numReplicas,
+		numPartitions:    e.numPartitions,
+		deviceAssignment: e.deviceAssignment,
+		isPortable:       e.isPortable,
+	})
+}
+
+// Serialize returns a platform-specific serialization of the compiled program, including the metadata set during
+// compilation (number of replicas, number of partitions, device assignment and portability).
+//
+// It is used to serialize the executables compiled with Plugin.CompileForTopology, to be loaded later with
+// Client.DeserializeAndLoad, on a client using the same plugin and plugin version.
+func (e *Executable) Serialize() ([]byte, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	meta := e.metadata
+	if meta == nil {
+		// No metadata from compilation: take the number of replicas and partitions from the executable.
+		numReplicas, err := e.NumReplicas()
+		if err != nil {
+			return nil, err
+		}
+		numPartitions, err := e.NumPartitions()
+		if err != nil {
+			return nil, err
+		}
+		meta = &executableMetadata{
+			numReplicas:   numReplicas,
+			numPartitions: numPartitions,
+			isPortable:    numReplicas*numPartitions == 1,
+		}
+	}
+	return e.serialize(*meta)
+}
+
+// serialize the executable with PJRT_Executable_Serialize, prefixed with the given metadata.
+func (e *Executable) serialize(meta executableMetadata) ([]byte, error) {
+	plugin := e.wrapper.plugin
+	if plugin.api.PJRT_Executable_Serialize == nil {
+		return nil, errors.Errorf("PJRT_Executable_Serialize is not supported by the current plugin version %v", plugin)
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_Serialize_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(plugin, C.call_PJRT_Executable_Serialize(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to serialize executable")
+	}
+	defer C.FreeSerializedExecutable(args)
+	pjrtSerialized := cDataToSlice[byte](unsafe.Pointer(args.serialized_bytes), int(args.serialized_bytes_size))
+	return encodeExecutableMetadata(meta, pjrtSerialized), nil // Notice the PJRT bytes are copied.
+}
+
+// pjrtExecutableDeserializeAndLoad calls PJRT_Executable_DeserializeAndLoad with the raw PJRT serialized executable.
+func pjrtExecutableDeserializeAndLoad(plugin *Plugin, client *Client, pjrtSerialized []byte) (*LoadedExecutable, error) {
+	if plugin.api.PJRT_Executable_DeserializeAndLoad == nil {
+		return nil, errors.Errorf("PJRT_Executable_DeserializeAndLoad is not supported by the current plugin version %v", plugin)
+	}
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	dataPtr := unsafe.SliceData(pjrtSerialized)
+	pinner.Pin(dataPtr)
+
+	args := C.new_PJRT_Executable_DeserializeAndLoad_Args()
+	defer cFree(args)
+	args.client = client.client.c
+	args.serialized_executable = (*C.char)(unsafe.Pointer(dataPtr))
+	args.serialized_executable_size = C.size_t(len(pjrtSerialized))
+	err := toError(plugin, C.call_PJRT_Executable_DeserializeAndLoad(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newLoadedExecutable(plugin, client, args.loaded_executable)
+}
+
+// DeserializeAndLoad loads an executable serialized with LoadedExecutable.Serialize, restoring also the
+// metadata set during compilation (number of replicas, number of partitions, device assignment and portability).
+//
+// The serialized executable must have been produced by the same platform and plugin version as this client.
+func (c *Client) DeserializeAndLoad(data []byte) (*LoadedExecutable, error) {
+	if !c.IsValid() {
+		return nil, errors.New("Client is nil or it has already been destroyed")
+	}
+	defer runtime.KeepAlive(c)
+	meta, pjrtSerialized, err := decodeExecutableMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.isPortable && len(meta.deviceAssignment) != meta.numReplicas*meta.numPartitions {
+		return nil, errors.Errorf("invalid serialized executable: %d replicas and %d partitions require a device "+
+			"assignment with %d devices, got %v", meta.numReplicas, meta.numPartitions,
+			meta.numReplicas*meta.numPartitions, meta.deviceAssignment)
+	}
+	exec, err := pjrtExecutableDeserializeAndLoad(c.plugin, c, pjrtSerialized)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to deserialize and load executable")
+	}
+	exec.numReplicas = meta.numReplicas
+	exec.numPartitions = meta.numPartitions
+	exec.deviceAssignment = meta.deviceAssignment
+	exec.isPortable = meta.isPortable
+	return exec, nil
+}
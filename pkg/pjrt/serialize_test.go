@@ -0,0 +1,61 @@
+package pjrt
+
+import (
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestExecutableMetadataEncoding(t *testing.T) {
+	for _, meta := range []executableMetadata{
+		{numReplicas: 1, numPartitions: 1, isPortable: true},
+		{numReplicas: 2, numPartitions: 3, deviceAssignment: []int{5, 4, 3, 2, 1, 0}},
+		{numReplicas: 1, numPartitions: 1, deviceAssignment: []int{}},
+	} {
+		payload := []byte{1, 2, 3}
+		encoded := encodeExecutableMetadata(meta, payload)
+		got, gotPayload, err := decodeExecutableMetadata(encoded)
+		requireNoError(t, err)
+		assertEqual(t, meta.numReplicas, got.numReplicas)
+		assertEqual(t, meta.numPartitions, got.numPartitions)
+		assertEqual(t, meta.isPortable, got.isPortable)
+		assertEqual(t, meta.deviceAssignment == nil, got.deviceAssignment == nil)
+		assertEqualSlice(t, meta.deviceAssignment, got.deviceAssignment)
+		assertEqualSlice(t, payload, gotPayload)
+	}
+
+	_, _, err := decodeExecutableMetadata([]byte("not a serialized executable"))
+	requireErrorContains(t, err, "not created by LoadedExecutable.Serialize")
+	encoded := encodeExecutableMetadata(executableMetadata{numReplicas: 1, numPartitions: 1}, nil)
+	_, _, err = decodeExecutableMetadata(encoded)
+	requireErrorContains(t, err, "missing PJRT serialized executable")
+}
+
+func TestSerializeAndDeserialize(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	// f(x) = x*x + 1
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32)))
+	one := must1(mainFn.ConstantFromScalar(float32(1)))
+	fX := must1(stablehlo.Multiply(x, x))
+	fX = must1(stablehlo.Add(fX, one))
+	must(mainFn.Return(fX))
+	exec, err := client.Compile().WithStableHLO(must1(builder.Build())).Done()
+	requireNoError(t, err, "Failed to compile program")
+
+	serialized, err := exec.Serialize()
+	requireNoError(t, err, "Failed to serialize executable")
+	requireNoError(t, exec.Destroy())
+
+	loaded, err := client.DeserializeAndLoad(serialized)
+	requireNoError(t, err, "Failed to deserialize executable")
+	defer func() { requireNoError(t, loaded.Destroy()) }()
+	assertTrue(t, loaded.IsPortable())
+	assertEqual(t, 1, loaded.NumOutputs)
+	assertEqual(t, float32(10), execWithScalars(t, client, loaded, float32(3)))
+}
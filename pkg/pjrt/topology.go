@@ -0,0 +1,376 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// FreeSerializedTopology calls the deleter returned by PJRT_TopologyDescription_Serialize, if one was given.
+void FreeSerializedTopology(PJRT_TopologyDescription_Serialize_Args *args) {
+	if (args->serialized_topology_deleter != NULL && args->serialized_topology != NULL) {
+		args->serialized_topology_deleter(args->serialized_topology);
+	}
+	args->serialized_topology = NULL;
+}
+*/
+import "C"
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// TopologyDescription describes the devices of a platform (e.g.: a slice of TPUs), possibly without having
+// access to them. It can be used to compile programs ahead-of-time (see Plugin.CompileForTopology), for instance
+// to produce executables in a CI environment, that are later loaded with Client.DeserializeAndLoad.
+//
+// It is either owned by a client (see Client.TopologyDescription), or created by the plugin
+// (see Plugin.NewTopologyDescription and Plugin.DeserializeTopologyDescription), in which case it should be
+// destroyed when no longer used -- it is automatically destroyed when garbage collected.
+type TopologyDescription struct {
+	wrapper *topologyWrapper
+
+	// client owning the topology, if any: it is kept alive while the topology is in use.
+	client *Client
+}
+
+type topologyWrapper struct {
+	c      *C.PJRT_TopologyDescription
+	plugin *Plugin
+
+	// owned is true if the topology was created by the plugin, and it must be destroyed by us.
+	owned bool
+}
+
+// newTopologyDescription creates a TopologyDescription and, if it is owned, registers it for freeing.
+func newTopologyDescription(plugin *Plugin, client *Client, cTopology *C.PJRT_TopologyDescription) *TopologyDescription {
+	t := &TopologyDescription{
+		wrapper: &topologyWrapper{c: cTopology, plugin: plugin, owned: client == nil},
+		client:  client,
+	}
+	if client == nil {
+		runtime.AddCleanup(t, func(wrapper *topologyWrapper) {
+			err := wrapper.Destroy()
+			if err != nil {
+				klog.Errorf("pjrt.TopologyDescription.Destroy failed: %v", err)
+			}
+		}, t.wrapper)
+	}
+	return t
+}
+
+func (wrapper *topologyWrapper) IsValid() bool {
+	return wrapper != nil && wrapper.c != nil && wrapper.plugin != nil && wrapper.plugin.api != nil
+}
+
+func (wrapper *topologyWrapper) Destroy() error {
+	if !wrapper.IsValid() {
+		// Already destroyed, no-op.
+		return nil
+	}
+	defer runtime.KeepAlive(wrapper)
+	var err error
+	if wrapper.owned {
+		args := C.new_PJRT_TopologyDescription_Destroy_Args()
+		defer cFree(args)
+		args.topology = wrapper.c
+		err = toError(wrapper.plugin, C.call_PJRT_TopologyDescription_Destroy(wrapper.plugin.api, args))
+	}
+	wrapper.plugin = nil
+	wrapper.c = nil
+	return err
+}
+
+// Destroy the TopologyDescription, releasing its resources if it is not owned by a client.
+// After this the TopologyDescription is no longer valid, and shouldn't be used.
+func (t *TopologyDescription) Destroy() error {
+	if t == nil {
+		return nil
+	}
+	return t.wrapper.Destroy()
+}
+
+// check returns an error if the TopologyDescription is not valid.
+func (t *TopologyDescription) check() error {
+	if t == nil || !t.wrapper.IsValid() || (t.client != nil && !t.client.IsValid()) {
+		return errors.New("TopologyDescription is nil, or its plugin, client or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	return nil
+}
+
+// TopologyDescription returns the description of the topology of the devices of the client.
+// It is owned by the client and doesn't need to be destroyed.
+func (c *Client) TopologyDescription() (*TopologyDescription, error) {
+	if !c.IsValid() {
+		return nil, errors.New("Client is nil or it has already been destroyed")
+	}
+	if c.plugin.api.PJRT_Client_TopologyDescription == nil {
+		return nil, errors.Errorf("PJRT_Client_TopologyDescription is not supported by the current plugin version %v", c.plugin)
+	}
+	defer runtime.KeepAlive(c)
+	args := C.new_PJRT_Client_TopologyDescription_Args()
+	defer cFree(args)
+	args.client = c.client.c
+	err := toError(c.plugin, C.call_PJRT_Client_TopologyDescription(c.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newTopologyDescription(c.plugin, c, args.topology), nil
+}
+
+// NewTopologyDescription creates a description of a topology of devices, without requiring access to them.
+// The topology name and options are platform-specific.
+func (p *Plugin) NewTopologyDescription(name string, options NamedValuesMap) (*TopologyDescription, error) {
+	if p.api.PJRT_TopologyDescription_Create == nil {
+		return nil, errors.Errorf("PJRT_TopologyDescription_Create is not supported by the current plugin version %v", p)
+	}
+	args := C.new_PJRT_TopologyDescription_Create_Args()
+	defer cFree(args)
+	cName := C.CString(name)
+	defer cFree(cName)
+	args.topology_name = cName
+	args.topology_name_size = C.size_t(len(name))
+	var err error
+	args.create_options, args.num_options, err = options.mallocArrayPJRT_NamedValue()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid options when creating a new pjrt.TopologyDescription")
+	}
+	defer destroyPJRT_NamedValue(args.create_options, args.num_options)
+	err = toError(p, C.call_PJRT_TopologyDescription_Create(p.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create topology %q", name)
+	}
+	return newTopologyDescription(p, nil, args.topology), nil
+}
+
+// DeserializeTopologyDescription loads a topology serialized with TopologyDescription.Serialize.
+func (p *Plugin) DeserializeTopologyDescription(data []byte) (*TopologyDescription, error) {
+	if p.api.PJRT_TopologyDescription_Deserialize == nil {
+		return nil, errors.Errorf("PJRT_TopologyDescription_Deserialize is not supported by the current plugin version %v", p)
+	}
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	dataPtr := unsafe.SliceData(data)
+	pinner.Pin(dataPtr)
+	args := C.new_PJRT_TopologyDescription_Deserialize_Args()
+	defer cFree(args)
+	args.serialized_topology = (*C.char)(unsafe.Pointer(dataPtr))
+	args.serialized_topology_size = C.size_t(len(data))
+	err := toError(p, C.call_PJRT_TopologyDescription_Deserialize(p.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to deserialize topology")
+	}
+	return newTopologyDescription(p, nil, args.topology), nil
+}
+
+// Serialize the topology description, so it can be loaded later with Plugin.DeserializeTopologyDescription.
+// It can also be used as part of cache keys.
+func (t *TopologyDescription) Serialize() ([]byte, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	plugin := t.wrapper.plugin
+	if plugin.api.PJRT_TopologyDescription_Serialize == nil {
+		return nil, errors.Errorf("PJRT_TopologyDescription_Serialize is not supported by the current plugin version %v", plugin)
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_Serialize_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(plugin, C.call_PJRT_TopologyDescription_Serialize(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to serialize topology")
+	}
+	defer C.FreeSerializedTopology(args)
+	return C.GoBytes(unsafe.Pointer(args.serialized_bytes), C.int(args.serialized_bytes_size)), nil
+}
+
+// PlatformName returns the name of the platform of the topology (e.g.: "cpu", "cuda", "tpu").
+func (t *TopologyDescription) PlatformName() (string, error) {
+	if err := t.check(); err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_PlatformName_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.wrapper.plugin, C.call_PJRT_TopologyDescription_PlatformName(t.wrapper.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.platform_name, args.platform_name_size), nil
+}
+
+// PlatformVersion returns the human-readable platform-specific version of the topology (e.g.: the CUDA version).
+func (t *TopologyDescription) PlatformVersion() (string, error) {
+	if err := t.check(); err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_PlatformVersion_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.wrapper.plugin, C.call_PJRT_TopologyDescription_PlatformVersion(t.wrapper.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.platform_version, args.platform_version_size), nil
+}
+
+// DeviceDescriptions returns the description of all devices in the topology.
+// They are only valid while the topology is valid.
+func (t *TopologyDescription) DeviceDescriptions() ([]*DeviceDescription, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_GetDeviceDescriptions_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.wrapper.plugin, C.call_PJRT_TopologyDescription_GetDeviceDescriptions(t.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cDescriptions := cDataToSlice[*C.PJRT_DeviceDescription](unsafe.Pointer(args.descriptions), int(args.num_descriptions))
+	descriptions := make([]*DeviceDescription, len(cDescriptions))
+	for ii, cDesc := range cDescriptions {
+		descriptions[ii] = newDeviceDescription(t.wrapper.plugin, cDesc)
+	}
+	return descriptions, nil
+}
+
+// Attributes returns the platform-specific attributes of the topology.
+func (t *TopologyDescription) Attributes() (NamedValuesMap, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_Attributes_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.wrapper.plugin, C.call_PJRT_TopologyDescription_Attributes(t.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	attributes := cDataToSlice[C.PJRT_NamedValue](unsafe.Pointer(args.attributes), int(args.num_attributes))
+	return pjrtNamedValuesToMap(attributes), nil
+}
+
+// TopologyCompileOptions configures Plugin.CompileForTopology.
+type TopologyCompileOptions struct {
+	// ProgramFormat of the program: "mlir" for StableHLO (the default, if left empty) or "hlo" for a
+	// serialized HloModuleProto.
+	ProgramFormat string
+
+	// NumReplicas and NumPartitions of the program. If left as 0, they default to 1.
+	NumReplicas, NumPartitions int
+
+	// UseSpmdPartitioning and UseShardy configure the partitioning of the program, see CompileConfig.WithSPMD
+	// and CompileConfig.WithShardy.
+	UseSpmdPartitioning, UseShardy bool
+
+	// DeviceAssignment of the program, in replica-major order, see CompileConfig.WithDeviceAssignment.
+	// If nil, the program is compiled as portable, which requires a single device.
+	DeviceAssignment []int
+}
+
+// CompileForTopology compiles the program for the given topology, without requiring access to its devices.
+//
+// The returned Executable can't be executed directly: serialize it with Executable.Serialize, and later load
+// it with Client.DeserializeAndLoad on a client of the same platform and plugin version.
+//
+// If options is nil, the program is assumed to be StableHLO, and it is compiled as portable, to run on one device.
+func (p *Plugin) CompileForTopology(topology *TopologyDescription, program []byte, options *TopologyCompileOptions) (*Executable, error) {
+	if p.api.PJRT_Compile == nil {
+		return nil, errors.Errorf("PJRT_Compile is not supported by the current plugin version %v", p)
+	}
+	if err := topology.check(); err != nil {
+		return nil, err
+	}
+	if len(program) == 0 {
+		return nil, errors.New("CompileForTopology requires a program")
+	}
+	if options == nil {
+		options = &TopologyCompileOptions{}
+	}
+	programFormat := options.ProgramFormat
+	if programFormat == "" {
+		programFormat = "mlir"
+	}
+	numReplicas := max(1, options.NumReplicas)
+	numPartitions := max(1, options.NumPartitions)
+	isPortable := options.DeviceAssignment == nil
+	if isPortable && numReplicas*numPartitions > 1 {
+		return nil, errors.Errorf("CompileForTopology requires a device assignment for NumReplicas=%d and "+
+			"NumPartitions=%d: portable computations must be on one device only", numReplicas, numPartitions)
+	}
+	if !isPortable && len(options.DeviceAssignment) != numReplicas*numPartitions {
+		return nil, errors.Errorf("with %d replicas and %d partitions CompileForTopology requires a device "+
+			"assignment with %d devices, got %d", numReplicas, numPartitions, numReplicas*numPartitions,
+			len(options.DeviceAssignment))
+	}
+
+	optionsProto, err := newCompileOptionsProto()
+	if err != nil {
+		return nil, err
+	}
+	optionsProto.CompilePortableExecutable = isPortable
+	buildOptions := optionsProto.ExecutableBuildOptions
+	buildOptions.NumReplicas = int64(numReplicas)
+	buildOptions.NumPartitions = int64(numPartitions)
+	buildOptions.UseSpmdPartitioning = options.UseSpmdPartitioning || options.UseShardy
+	buildOptions.UseShardyPartitioner = options.UseShardy
+	if !isPortable {
+		buildOptions.DeviceAssignment = newDeviceAssignmentProto(options.DeviceAssignment, numReplicas, numPartitions)
+	}
+	binOptions, err := proto.MarshalOptions{Deterministic: true}.Marshal(optionsProto)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to marshal the CompileOptionsProto to be passed to the PJRT plugin")
+	}
+	if klog.V(1).Enabled() {
+		klog.Infof("CompileOptions: {\n%s}\n", prototext.Format(optionsProto))
+	}
+
+	defer runtime.KeepAlive(topology)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	programPtr := unsafe.SliceData(program)
+	pinner.Pin(programPtr)
+	optionsPtr := unsafe.SliceData(binOptions)
+	pinner.Pin(optionsPtr)
+
+	cProgram := C.new_PJRT_Program()
+	defer cFree(cProgram)
+	cProgramFormat := C.CString(programFormat)
+	defer cFree(cProgramFormat)
+	cProgram.format = cProgramFormat
+	cProgram.format_size = C.size_t(len(programFormat))
+	cProgram.code = (*C.char)(unsafe.Pointer(programPtr))
+	cProgram.code_size = C.size_t(len(program))
+
+	args := C.new_PJRT_Compile_Args()
+	defer cFree(args)
+	args.topology = topology.wrapper.c
+	args.program = cProgram
+	args.compile_options = (*C.char)(unsafe.Pointer(optionsPtr))
+	args.compile_options_size = C.size_t(len(binOptions))
+	if topology.client != nil {
+		args.client = topology.client.client.c
+	}
+	err = toError(p, C.call_PJRT_Compile(p.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to compile program for topology")
+	}
+	exec := newExecutable(p, args.executable)
+	exec.metadata = &executableMetadata{
+		numReplicas:      numReplicas,
+		numPartitions:    numPartitions,
+		deviceAssignment: options.DeviceAssignment,
+		isPortable:       isPortable,
+	}
+	return exec, nil
+}
@@ -0,0 +1,68 @@
+package pjrt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestCompileForTopology(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	topology, err := client.TopologyDescription()
+	if isNotSupportedError(err) {
+		t.Skipf("TopologyDescription not supported by %s: %v", client.plugin, err)
+	}
+	requireNoError(t, err)
+	platform, err := topology.PlatformName()
+	requireNoError(t, err)
+	descriptions, err := topology.DeviceDescriptions()
+	requireNoError(t, err)
+	assertNotEmpty(t, descriptions)
+	attributes, err := topology.Attributes()
+	requireNoError(t, err)
+	fmt.Printf("Topology: platform=%q, %d device(s), attributes=%v\n", platform, len(descriptions), attributes)
+
+	// Round trip serialization of the topology.
+	serializedTopology, err := topology.Serialize()
+	if !isNotSupportedError(err) {
+		requireNoError(t, err)
+		deserialized, err := client.plugin.DeserializeTopologyDescription(serializedTopology)
+		requireNoError(t, err)
+		assertEqual(t, platform, must1(deserialized.PlatformName()))
+		requireNoError(t, deserialized.Destroy())
+	}
+
+	// f(x) = x*x + 1
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.F32)))
+	one := must1(mainFn.ConstantFromScalar(float32(1)))
+	must(mainFn.Return(must1(stablehlo.Add(must1(stablehlo.Multiply(x, x)), one))))
+	program := must1(builder.Build())
+
+	executable, err := client.plugin.CompileForTopology(topology, program, nil)
+	requireNoError(t, err, "Failed to compile for topology")
+	serialized, err := executable.Serialize()
+	requireNoError(t, err)
+	requireNoError(t, executable.Destroy())
+
+	exec, err := client.DeserializeAndLoad(serialized)
+	requireNoError(t, err)
+	assertTrue(t, exec.IsPortable())
+	assertEqual(t, float32(10), execWithScalars(t, client, exec, float32(3)))
+	requireNoError(t, exec.Destroy())
+
+	// Invalid options.
+	_, err = client.plugin.CompileForTopology(topology, program, &TopologyCompileOptions{NumReplicas: 2})
+	requireError(t, err)
+
+	// $XLA_DEBUG_OPTIONS is used as with Client.Compile.
+	t.Setenv(EnvXlaDebugOptions, "not a valid proto")
+	_, err = client.plugin.CompileForTopology(topology, program, nil)
+	requireError(t, err)
+}
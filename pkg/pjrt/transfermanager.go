@@ -0,0 +1,409 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// TransferDataAndWait transfers the data and waits until the host data is no longer needed by PJRT.
+PJRT_Error* TransferDataAndWait(const PJRT_Api *api, PJRT_AsyncHostToDeviceTransferManager_TransferData_Args *args) {
+	PJRT_Error* err = api->PJRT_AsyncHostToDeviceTransferManager_TransferData(args);
+	if (err) {
+		return err;
+	}
+	if (args->done_with_h2d_transfer == NULL) {
+		return NULL;
+	}
+	PJRT_Event_Await_Args event_args = {0};
+	event_args.struct_size = PJRT_Event_Await_Args_STRUCT_SIZE;
+	event_args.event = args->done_with_h2d_transfer;
+	err = api->PJRT_Event_Await(&event_args);
+
+	PJRT_Event_Destroy_Args efree_args = {0};
+	efree_args.struct_size = PJRT_Event_Destroy_Args_STRUCT_SIZE;
+	efree_args.event = args->done_with_h2d_transfer;
+	api->PJRT_Event_Destroy(&efree_args);
+	args->done_with_h2d_transfer = NULL;
+	return err;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"unsafe"
+
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// TransferManager handles asynchronous transfers of data from host to device, for a fixed set of buffers.
+//
+// The buffers (see Buffers) are created up front, when the TransferManager is created with
+// Client.CreateBuffersForAsyncHostToDevice, and they can be immediately passed along, e.g., as inputs to
+// LoadedExecutable.Execute. Their contents are filled incrementally with TransferData (or TransferFromReader),
+// and consumers of the buffers will wait until the last chunk of data is transferred.
+//
+// If the data for a buffer can't be provided, call SetBufferError: the error is propagated to the consumers
+// of the buffer.
+//
+// Once all transfers are done, call Destroy to release the TransferManager -- the buffers remain valid.
+// It is also automatically destroyed when garbage collected.
+type TransferManager struct {
+	wrapper *transferManagerWrapper
+	client  *Client
+	shapes  []shapes.Shape
+	buffers []*Buffer
+}
+
+// transferManagerWrapper wraps the C pointer, so we can use runtime.AddCleanup.
+type transferManagerWrapper struct {
+	c      *C.PJRT_AsyncHostToDeviceTransferManager
+	plugin *Plugin
+}
+
+func (wrapper *transferManagerWrapper) IsValid() bool {
+	return wrapper != nil && wrapper.c != nil && wrapper.plugin != nil && wrapper.plugin.api != nil
+}
+
+func (wrapper *transferManagerWrapper) Destroy() error {
+	if !wrapper.IsValid() {
+		// Already destroyed, no-op.
+		return nil
+	}
+	defer runtime.KeepAlive(wrapper)
+	args := C.new_PJRT_AsyncHostToDeviceTransferManager_Destroy_Args()
+	defer cFree(args)
+	args.transfer_manager = wrapper.c
+	err := toError(wrapper.plugin, C.call_PJRT_AsyncHostToDeviceTransferManager_Destroy(wrapper.plugin.api, args))
+	wrapper.plugin = nil
+	wrapper.c = nil
+	return err
+}
+
+// CreateBuffersForAsyncHostToDevice creates buffers with the given shapes, in the given memory, whose contents
+// are going to be transferred asynchronously using the returned TransferManager.
+//
+// If memory is nil, the default memory of the first addressable device is used.
+func (c *Client) CreateBuffersForAsyncHostToDevice(memory *Memory, bufferShapes ...shapes.Shape) (*TransferManager, error) {
+	if !c.IsValid() {
+		return nil, errors.New("Client is nil or it has already been destroyed")
+	}
+	if len(bufferShapes) == 0 {
+		return nil, errors.New("CreateBuffersForAsyncHostToDevice requires at least one shape")
+	}
+	for ii, shape := range bufferShapes {
+		if !shape.Ok() || shape.IsTuple() || shape.IsDynamic() {
+			return nil, errors.Errorf("CreateBuffersForAsyncHostToDevice given an invalid shape #%d: %s -- "+
+				"it must be a static array shape", ii, shape)
+		}
+	}
+	if memory == nil {
+		devices := c.AddressableDevices()
+		if len(devices) == 0 {
+			return nil, errors.New("CreateBuffersForAsyncHostToDevice can't find addressable device to transfer to")
+		}
+		var err error
+		memory, err = devices[0].DefaultMemory()
+		if err != nil {
+			return nil, errors.WithMessage(err, "CreateBuffersForAsyncHostToDevice failed to get the default memory of the first device")
+		}
+	} else if err := memory.check(); err != nil {
+		return nil, errors.WithMessage(err, "CreateBuffersForAsyncHostToDevice given an invalid memory")
+	}
+	plugin := c.plugin
+	if plugin.api.PJRT_Client_CreateBuffersForAsyncHostToDevice == nil {
+		return nil, errors.Errorf("PJRT_Client_CreateBuffersForAsyncHostToDevice is not supported by the current plugin version %v", plugin)
+	}
+	defer runtime.KeepAlive(c)
+	defer runtime.KeepAlive(memory)
+
+	// Arena for memory allocations used by CGO.
+	minSize := len(bufferShapes)*(int(cSizeOf[C.PJRT_ShapeSpec]())+arenaAlignBytes) + 1024
+	for _, shape := range bufferShapes {
+		minSize += 8 * (shape.Rank() + 1)
+	}
+	arena := plugin.getArena(minSize)
+	defer plugin.returnArena(arena)
+
+	specs := arenaAllocSlice[C.PJRT_ShapeSpec](arena, len(bufferShapes))
+	for ii, shape := range bufferShapes {
+		spec := &specs[ii]
+		spec.struct_size = C.PJRT_ShapeSpec_STRUCT_SIZE
+		spec.element_type = C.PJRT_Buffer_Type(shape.DType)
+		spec.num_dims = C.size_t(shape.Rank())
+		if shape.Rank() > 0 {
+			dims := arenaAllocSlice[C.int64_t](arena, shape.Rank())
+			for axis, dim := range shape.Dimensions {
+				dims[axis] = C.int64_t(dim)
+			}
+			spec.dims = unsafe.SliceData(dims)
+		}
+	}
+	args := arenaAlloc[C.PJRT_Client_CreateBuffersForAsyncHostToDevice_Args](arena)
+	args.struct_size = C.PJRT_Client_CreateBuffersForAsyncHostToDevice_Args_STRUCT_SIZE
+	args.client = c.client.c
+	args.shape_specs = unsafe.SliceData(specs)
+	args.num_shape_specs = C.size_t(len(specs))
+	args.memory = memory.cMemory
+	err := toError(plugin, C.call_PJRT_Client_CreateBuffersForAsyncHostToDevice(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create buffers for asynchronous host to device transfer")
+	}
+
+	tm := &TransferManager{
+		wrapper: &transferManagerWrapper{c: args.transfer_manager, plugin: plugin},
+		client:  c,
+		shapes:  slices.Clone(bufferShapes),
+	}
+	runtime.AddCleanup(tm, func(wrapper *transferManagerWrapper) {
+		err := wrapper.Destroy()
+		if err != nil {
+			klog.Errorf("pjrt.TransferManager.Destroy failed: %v", err)
+		}
+	}, tm.wrapper)
+
+	// Retrieve the buffers up front: PJRT only allows retrieving each buffer once.
+	tm.buffers = make([]*Buffer, len(bufferShapes))
+	for ii := range tm.buffers {
+		tm.buffers[ii], err = tm.retrieveBuffer(ii)
+		if err != nil {
+			tm.destroyOrLog()
+			return nil, err
+		}
+	}
+	return tm, nil
+}
+
+// check returns an error if the TransferManager has been destroyed.
+func (tm *TransferManager) check() error {
+	if tm == nil || !tm.wrapper.IsValid() || !tm.client.IsValid() {
+		return errors.New("TransferManager is nil, or it or its client has already been destroyed")
+	}
+	return nil
+}
+
+// checkBufferIdx returns an error if bufferIdx is out of range.
+func (tm *TransferManager) checkBufferIdx(bufferIdx int) error {
+	if bufferIdx < 0 || bufferIdx >= len(tm.shapes) {
+		return errors.Errorf("TransferManager invalid buffer index %d, it only has %d buffers", bufferIdx, len(tm.shapes))
+	}
+	return nil
+}
+
+// Destroy the TransferManager, releasing its resources. The buffers it created remain valid.
+// This is automatically called if the TransferManager is garbage collected.
+func (tm *TransferManager) Destroy() error {
+	if tm == nil {
+		return nil
+	}
+	return tm.wrapper.Destroy()
+}
+
+// destroyOrLog destroys the TransferManager and log any errors.
+func (tm *TransferManager) destroyOrLog() {
+	err := tm.Destroy()
+	if err != nil {
+		klog.Errorf("TransferManager.Destroy failed: %v", err)
+	}
+}
+
+// retrieveBuffer calls PJRT_AsyncHostToDeviceTransferManager_RetrieveBuffer.
+func (tm *TransferManager) retrieveBuffer(bufferIdx int) (*Buffer, error) {
+	plugin := tm.wrapper.plugin
+	defer runtime.KeepAlive(tm)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_AsyncHostToDeviceTransferManager_RetrieveBuffer_Args](arena)
+	args.struct_size = C.PJRT_AsyncHostToDeviceTransferManager_RetrieveBuffer_Args_STRUCT_SIZE
+	args.transfer_manager = tm.wrapper.c
+	args.buffer_index = C.int(bufferIdx)
+	err := toError(plugin, C.call_PJRT_AsyncHostToDeviceTransferManager_RetrieveBuffer(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to retrieve buffer #%d from TransferManager", bufferIdx)
+	}
+	buffer := newBuffer(tm.client, args.buffer_out)
+	shape := tm.shapes[bufferIdx]
+	buffer.dims = slices.Clone(shape.Dimensions)
+	buffer.dimsSet = true
+	buffer.dtype = shape.DType
+	buffer.dtypeSet = true
+	return buffer, nil
+}
+
+// Buffers returns the buffers being transferred, in the order of the shapes given at creation.
+//
+// They can be used (e.g., as inputs to an execution) before the transfers complete: consumers will wait for
+// the data to be transferred. The TransferManager owns the returned slice, don't change it.
+func (tm *TransferManager) Buffers() []*Buffer {
+	return tm.buffers
+}
+
+// Buffer returns the buffer with the given index.
+func (tm *TransferManager) Buffer(bufferIdx int) (*Buffer, error) {
+	if err := tm.checkBufferIdx(bufferIdx); err != nil {
+		return nil, err
+	}
+	return tm.buffers[bufferIdx], nil
+}
+
+// BufferCount returns the number of buffers managed by the TransferManager.
+func (tm *TransferManager) BufferCount() int {
+	return len(tm.shapes)
+}
+
+// BufferSize returns the size in bytes of the on-device buffer with the given index -- the total amount of data
+// to transfer to it.
+func (tm *TransferManager) BufferSize(bufferIdx int) (int, error) {
+	if err := tm.check(); err != nil {
+		return 0, err
+	}
+	if err := tm.checkBufferIdx(bufferIdx); err != nil {
+		return 0, err
+	}
+	plugin := tm.wrapper.plugin
+	defer runtime.KeepAlive(tm)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_AsyncHostToDeviceTransferManager_BufferSize_Args](arena)
+	args.struct_size = C.PJRT_AsyncHostToDeviceTransferManager_BufferSize_Args_STRUCT_SIZE
+	args.transfer_manager = tm.wrapper.c
+	args.buffer_index = C.int(bufferIdx)
+	err := toError(plugin, C.call_PJRT_AsyncHostToDeviceTransferManager_BufferSize(plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return int(args.buffer_size), nil
+}
+
+// Device returns the device the buffers are being transferred to.
+func (tm *TransferManager) Device() (*Device, error) {
+	if err := tm.check(); err != nil {
+		return nil, err
+	}
+	plugin := tm.wrapper.plugin
+	defer runtime.KeepAlive(tm)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_AsyncHostToDeviceTransferManager_Device_Args](arena)
+	args.struct_size = C.PJRT_AsyncHostToDeviceTransferManager_Device_Args_STRUCT_SIZE
+	args.transfer_manager = tm.wrapper.c
+	err := toError(plugin, C.call_PJRT_AsyncHostToDeviceTransferManager_Device(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newDevice(tm.client, args.device_out), nil
+}
+
+// TransferData transfers a chunk of data to the buffer with the given index, starting at the given offset (in bytes)
+// of the on-device buffer.
+//
+// isLast must be set on the last transfer to the buffer, after which the buffer becomes ready for its consumers.
+//
+// It returns once the data has been consumed by PJRT, so the data slice can be reused by the caller afterward.
+func (tm *TransferManager) TransferData(bufferIdx int, data []byte, offset int, isLast bool) error {
+	if err := tm.check(); err != nil {
+		return err
+	}
+	if err := tm.checkBufferIdx(bufferIdx); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return errors.Errorf("TransferManager.TransferData given a negative offset %d", offset)
+	}
+	plugin := tm.wrapper.plugin
+	defer runtime.KeepAlive(tm)
+
+	// Makes sure data is not moved around by the GC during the C/C++ call.
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	dataPtr := unsafe.SliceData(data)
+	if dataPtr != nil {
+		pinner.Pin(dataPtr)
+	}
+
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
+	args := arenaAlloc[C.PJRT_AsyncHostToDeviceTransferManager_TransferData_Args](arena)
+	args.struct_size = C.PJRT_AsyncHostToDeviceTransferManager_TransferData_Args_STRUCT_SIZE
+	args.transfer_manager = tm.wrapper.c
+	args.buffer_index = C.int(bufferIdx)
+	args.data = unsafe.Pointer(dataPtr)
+	args.offset = C.int64_t(offset)
+	args.transfer_size = C.int64_t(len(data))
+	args.is_last_transfer = C.bool(isLast)
+	err := toError(plugin, C.TransferDataAndWait(plugin.api, args))
+	if err != nil {
+		return errors.WithMessagef(err, "failed to transfer %d bytes at offset %d to buffer #%d", len(data), offset, bufferIdx)
+	}
+	return nil
+}
+
+// TransferFromReader transfers the contents of the buffer with the given index from the reader, in chunks of
+// chunkSize bytes -- if chunkSize <= 0, a default of 1MB is used.
+//
+// It reads exactly BufferSize(bufferIdx) bytes. If the reader fails (or it ends prematurely), the error is set
+// in the buffer with SetBufferError -- so it is propagated to the buffer consumers -- and it is also returned.
+func (tm *TransferManager) TransferFromReader(bufferIdx int, reader io.Reader, chunkSize int) error {
+	size, err := tm.BufferSize(bufferIdx)
+	if err != nil {
+		return err
+	}
+	if chunkSize <= 0 {
+		chunkSize = 1024 * 1024
+	}
+	chunk := make([]byte, min(chunkSize, size))
+	offset := 0
+	for offset < size {
+		n := min(chunkSize, size-offset)
+		_, err = io.ReadFull(reader, chunk[:n])
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read data for buffer #%d at offset %d (of %d bytes)", bufferIdx, offset, size)
+			if setErr := tm.SetBufferError(bufferIdx, err); setErr != nil {
+				klog.Errorf("TransferManager.SetBufferError failed: %+v", setErr)
+			}
+			return err
+		}
+		isLast := offset+n == size
+		err = tm.TransferData(bufferIdx, chunk[:n], offset, isLast)
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+	if size == 0 {
+		// Zero-sized buffer still needs to be marked as done.
+		return tm.TransferData(bufferIdx, nil, 0, true)
+	}
+	return nil
+}
+
+// SetBufferError sets the buffer with the given index to an error state: consumers of the buffer (e.g., an
+// execution that uses it as input) will fail with the given error.
+func (tm *TransferManager) SetBufferError(bufferIdx int, bufferErr error) error {
+	if err := tm.check(); err != nil {
+		return err
+	}
+	if err := tm.checkBufferIdx(bufferIdx); err != nil {
+		return err
+	}
+	if bufferErr == nil {
+		return errors.New("TransferManager.SetBufferError requires a non-nil error")
+	}
+	plugin := tm.wrapper.plugin
+	defer runtime.KeepAlive(tm)
+	msg := fmt.Sprintf("%v", bufferErr)
+	cMsg := C.CString(msg)
+	defer cFree(cMsg)
+	args := C.new_PJRT_AsyncHostToDeviceTransferManager_SetBufferError_Args()
+	defer cFree(args)
+	args.transfer_manager = tm.wrapper.c
+	args.buffer_index = C.int(bufferIdx)
+	args.error_code = C.PJRT_Error_Code(PJRT_Error_Code_UNKNOWN)
+	args.error_message = cMsg
+	args.error_message_size = C.size_t(len(msg))
+	return toError(plugin, C.call_PJRT_AsyncHostToDeviceTransferManager_SetBufferError(plugin.api, args))
+}
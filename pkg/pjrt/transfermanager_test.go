@@ -0,0 +1,44 @@
+package pjrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestTransferManager(t *testing.T) {
+	client := getPJRTClient(t)
+	defer func() { requireNoError(t, client.Destroy()) }()
+
+	shape := shapes.Make(dtypes.Int32, 4, 3)
+	tm, err := client.CreateBuffersForAsyncHostToDevice(nil, shape, shapes.Make(dtypes.Float32))
+	requireNoError(t, err)
+	defer func() { requireNoError(t, tm.Destroy()) }()
+	assertEqual(t, 2, tm.BufferCount())
+	assertLen(t, tm.Buffers(), 2)
+	size, err := tm.BufferSize(0)
+	requireNoError(t, err)
+	assertEqual(t, 4*3*4, size)
+
+	// Transfer the first buffer in chunks from a reader: chunks don't align with rows on purpose.
+	want := make([]int32, shape.Size())
+	for ii := range want {
+		want[ii] = int32(ii)
+	}
+	var data bytes.Buffer
+	requireNoError(t, binary.Write(&data, binary.NativeEndian, want))
+	requireNoError(t, tm.TransferFromReader(0, &data, 7))
+	got, dims, err := BufferToArray[int32](tm.Buffers()[0])
+	requireNoError(t, err)
+	assertEqualSlice(t, shape.Dimensions, dims)
+	assertEqualSlice(t, want, got)
+
+	// The second buffer fails on a short read, and the error is propagated to the buffer.
+	err = tm.TransferFromReader(1, bytes.NewReader([]byte{1, 2}), 0)
+	requireError(t, err)
+	_, err = BufferToScalar[float32](tm.Buffers()[1])
+	requireError(t, err)
+}
@@ -0,0 +1,419 @@
+// Package autodiff implements reverse-mode automatic differentiation of stablehlo functions.
+//
+// Grad emits the backward computation (the vector-Jacobian product) of a function into the function itself,
+// so the gradients can be returned along with the original outputs, or used to build further computation
+// (e.g.: the update of the parameters of a model).
+//
+// Example:
+//
+//	fn := builder.Main()
+//	x, _ := fn.Input(shapes.Make(dtypes.Float32, 3))
+//	loss, _ := stablehlo.Reduce(...) // Some scalar function of x.
+//	_ = fn.Return(loss)
+//	grads, _ := autodiff.Grad(fn, []*stablehlo.Value{x})
+//	_ = fn.Return(loss, grads[0])
+//
+// Gradients only flow through values of float dtypes: integer, boolean and complex values are treated as
+// constants. Ops without a gradient rule (e.g.: Sort, or the reduction of a Reduce other than a sum,
+// product, max or min) return an error if a gradient must flow through them.
+//
+// Control flow ops (If, Case, While, Call and Composite) are differentiated. While loops must have a static number
+// of iterations: a counter state initialized with a constant, incremented (or decremented) by a constant in the
+// body, and compared with a constant in the condition. The loop is run again to record the states of every
+// iteration, and a reverse loop differentiates the body for each iteration, backward. Grad returns an error if a
+// gradient must flow through any other While loop. Values computed by a While loop can still be used if they
+// don't depend on the wrt values (e.g.: a loop only over integer counters).
+package autodiff
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes/bfloat16"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+	"github.com/x448/float16"
+)
+
+// Grad emits into fn the reverse-mode computation of the gradients of fn's outputs with respect to the values
+// in wrt, and returns them: one gradient per wrt value, with the same shape.
+//
+// The values in wrt are usually inputs of fn, but they can be any value of fn.
+//
+// fn must have returned (see stablehlo.Function.Return): its returned values are the outputs being
+// differentiated. Grad removes the return statement, so fn can be extended with the gradients, and fn.Return
+// must be called again afterward -- typically with the original outputs and the gradients.
+//
+// outputCotangents are the cotangents (the "vector" of the vector-Jacobian product) of each output of fn, and
+// they must be values of fn with the same shapes as the outputs. A nil cotangent means no gradient flows from
+// that output. If outputCotangents is not given, all outputs must be scalars, and their cotangents are 1 --
+// so the gradients of the sum of the outputs are computed.
+//
+// If no gradient flows to a wrt value, its gradient is 0.
+//
+// If Grad fails (e.g.: a gradient must flow through an op without a gradient rule), fn is left as it was, with
+// its return statement, so it can still be built.
+func Grad(fn *stablehlo.Function, wrt []*stablehlo.Value, outputCotangents ...*stablehlo.Value) (
+	[]*stablehlo.Value, error) {
+	if !fn.Returned || len(fn.Statements) == 0 || fn.Statements[len(fn.Statements)-1].OpType != optypes.FuncReturn {
+		return nil, errors.Errorf("autodiff.Grad requires function %q to have returned its outputs", fn.Name)
+	}
+	returnStmt := fn.Statements[len(fn.Statements)-1]
+	outputs := returnStmt.Inputs
+	if len(outputCotangents) != 0 && len(outputCotangents) != len(outputs) {
+		return nil, errors.Errorf("autodiff.Grad got %d output cotangents, but function %q has %d outputs",
+			len(outputCotangents), fn.Name, len(outputs))
+	}
+	for i, ct := range outputCotangents {
+		if ct != nil && !ct.Shape().Equal(outputs[i].Shape()) {
+			return nil, errors.Errorf("autodiff.Grad output cotangent #%d has shape %s, but output has shape %s",
+				i, ct.Shape(), outputs[i].Shape())
+		}
+	}
+
+	// Re-open fn, to emit the backward computation. The capacity of the statements is limited, so the
+	// statements appended don't overwrite the return statement kept in stmts.
+	stmts, returnedOutputs := fn.Statements, fn.Outputs
+	fn.Statements = stmts[: len(stmts)-1 : len(stmts)-1]
+	fn.Outputs = nil
+	fn.Returned = false
+
+	grads, err := newGradient(fn).grad(outputs, outputCotangents, wrt)
+	if err != nil {
+		// Restore fn as it was before the call, so it can still be built: the backward computation emitted so
+		// far is discarded.
+		fn.Statements, fn.Outputs, fn.Returned = stmts, returnedOutputs, true
+		return nil, err
+	}
+	return grads, nil
+}
+
+// grad seeds the cotangents of the outputs and runs the reverse pass over the statements of g.fn.
+func (g *gradient) grad(outputs, outputCotangents, wrt []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	seeds := make(map[string]*stablehlo.Value)
+	for i, output := range outputs {
+		var ct *stablehlo.Value
+		if len(outputCotangents) > 0 {
+			ct = outputCotangents[i]
+		} else {
+			if !output.Shape().IsScalar() {
+				return nil, errors.Errorf("autodiff.Grad requires output cotangents for non-scalar output #%d (shape %s)",
+					i, output.Shape())
+			}
+			var err error
+			if ct, err = g.scalar(output.Shape().DType, 1); err != nil {
+				return nil, err
+			}
+		}
+		if ct != nil {
+			if err := g.seed(seeds, output, ct); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return g.backprop(g.fn.Statements, seeds, wrt)
+}
+
+// gradient holds the state of the reverse pass over the statements of a function.
+//
+// Values are identified by name, since closures refer to the values of their parent functions by name.
+type gradient struct {
+	// fn is where the backward computation is emitted.
+	fn *stablehlo.Function
+
+	// cotangents accumulated for each value.
+	cotangents map[string]*stablehlo.Value
+
+	// needed values are those that depend on the values being differentiated with respect to.
+	needed map[string]bool
+}
+
+func newGradient(fn *stablehlo.Function) *gradient {
+	return &gradient{
+		fn:         fn,
+		cotangents: make(map[string]*stablehlo.Value),
+		needed:     make(map[string]bool),
+	}
+}
+
+// seed adds the cotangent of an output to seeds, accumulating it if the output appears more than once.
+func (g *gradient) seed(seeds map[string]*stablehlo.Value, output, ct *stablehlo.Value) error {
+	name := output.String()
+	if previous, found := seeds[name]; found {
+		var err error
+		if ct, err = stablehlo.Add(previous, ct); err != nil {
+			return err
+		}
+	}
+	seeds[name] = ct
+	return nil
+}
+
+// backprop runs the reverse pass over the statements (listed in forward order), starting from the cotangents
+// in seeds, and returns the cotangents of the targets (0 if no gradient flows to them).
+func (g *gradient) backprop(stmts []*stablehlo.Statement, seeds map[string]*stablehlo.Value,
+	targets []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	for _, target := range targets {
+		if isDifferentiable(target) {
+			g.needed[target.String()] = true
+		}
+	}
+	for _, stmt := range stmts {
+		if g.dependsOnNeeded(stmt) {
+			for _, output := range stmt.Outputs {
+				if isDifferentiable(output) {
+					g.needed[output.String()] = true
+				}
+			}
+		}
+	}
+	for name, ct := range seeds {
+		if g.needed[name] {
+			g.cotangents[name] = ct
+		}
+	}
+
+	for _, stmt := range slices.Backward(stmts) {
+		if err := g.backpropStatement(stmt); err != nil {
+			return nil, errors.WithMessagef(err, "autodiff of %s (outputs %v)", stmt.OpType, stmt.Outputs)
+		}
+	}
+
+	grads := make([]*stablehlo.Value, len(targets))
+	for i, target := range targets {
+		grads[i] = g.cotangents[target.String()]
+		if grads[i] == nil {
+			var err error
+			if grads[i], err = g.zeros(target.Shape()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return grads, nil
+}
+
+// dependsOnNeeded returns whether any of the inputs of the statement, or any value used by its closures,
+// is needed.
+func (g *gradient) dependsOnNeeded(stmt *stablehlo.Statement) bool {
+	for _, input := range stmt.Inputs {
+		if g.needed[input.String()] {
+			return true
+		}
+	}
+	for _, closure := range stmt.FunctionParameters {
+		for _, name := range capturedValues(closure) {
+			if g.needed[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backpropStatement accumulates the cotangents of the inputs of the statement, given the cotangents of its
+// outputs.
+func (g *gradient) backpropStatement(stmt *stablehlo.Statement) error {
+	if !g.dependsOnNeeded(stmt) {
+		return nil
+	}
+	outputCotangents := make([]*stablehlo.Value, len(stmt.Outputs))
+	var hasCotangent bool
+	for i, output := range stmt.Outputs {
+		outputCotangents[i] = g.cotangents[output.String()]
+		hasCotangent = hasCotangent || outputCotangents[i] != nil
+	}
+	if !hasCotangent {
+		return nil
+	}
+	rule, found := vjpRules[stmt.OpType]
+	if !found {
+		return errors.Errorf("op %s is not differentiable", stmt.OpType)
+	}
+	inputCotangents, err := rule(g, stmt, outputCotangents)
+	if err != nil {
+		return err
+	}
+	for i, ct := range inputCotangents {
+		if ct == nil {
+			continue
+		}
+		if err := g.accumulate(stmt.Inputs[i], ct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accumulate adds ct to the cotangent of the value v, if it is needed.
+func (g *gradient) accumulate(v, ct *stablehlo.Value) error {
+	name := v.String()
+	if !g.needed[name] {
+		return nil
+	}
+	if !ct.Shape().Equal(v.Shape()) {
+		return errors.Errorf("cotangent of %s has shape %s, but the value has shape %s", v, ct.Shape(), v.Shape())
+	}
+	if previous, found := g.cotangents[name]; found {
+		var err error
+		if ct, err = stablehlo.Add(previous, ct); err != nil {
+			return err
+		}
+	}
+	g.cotangents[name] = ct
+	return nil
+}
+
+// vjpRule returns the cotangents of the inputs of stmt (nil for inputs that are not differentiable), given the
+// cotangents of its outputs. Output cotangents are nil for outputs without a gradient: use
+// gradient.outputCotangentsOrZeros if needed.
+type vjpRule func(g *gradient, stmt *stablehlo.Statement, outputCotangents []*stablehlo.Value) (
+	[]*stablehlo.Value, error)
+
+// vjpRules for each op, registered by the files of each group of ops.
+var vjpRules = make(map[optypes.OpType]vjpRule)
+
+// isDifferentiable returns whether gradients flow through values of the dtype of v.
+func isDifferentiable(v *stablehlo.Value) bool {
+	return v.Shape().DType.IsFloat()
+}
+
+// capturedValues returns the names of the values of enclosing functions used by the closure, including
+// the ones used by its nested closures.
+func capturedValues(closure *stablehlo.Function) []string {
+	defined := make(map[string]bool)
+	for _, input := range closure.Inputs {
+		defined[input.String()] = true
+	}
+	var captured []string
+	capture := func(name string) {
+		if !defined[name] && !slices.Contains(captured, name) {
+			captured = append(captured, name)
+		}
+	}
+	for _, stmt := range closure.Statements {
+		for _, input := range stmt.Inputs {
+			capture(input.String())
+		}
+		for _, nested := range stmt.FunctionParameters {
+			for _, name := range capturedValues(nested) {
+				capture(name)
+			}
+		}
+		for _, output := range stmt.Outputs {
+			defined[output.String()] = true
+		}
+	}
+	return captured
+}
+
+// outputCotangentsOrZeros replaces the missing output cotangents by zeros.
+func (g *gradient) outputCotangentsOrZeros(stmt *stablehlo.Statement, outputCotangents []*stablehlo.Value) (
+	[]*stablehlo.Value, error) {
+	cts := slices.Clone(outputCotangents)
+	for i, ct := range cts {
+		if ct == nil {
+			var err error
+			if cts[i], err = g.zeros(stmt.Outputs[i].Shape()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cts, nil
+}
+
+// scalar returns a constant scalar of the given dtype.
+func (g *gradient) scalar(dtype dtypes.DType, value float64) (*stablehlo.Value, error) {
+	switch dtype {
+	case dtypes.Float64:
+		return g.fn.ConstantFromScalar(value)
+	case dtypes.Float32:
+		return g.fn.ConstantFromScalar(float32(value))
+	case dtypes.Float16:
+		return g.fn.ConstantFromScalar(float16.Fromfloat32(float32(value)))
+	case dtypes.BFloat16:
+		return g.fn.ConstantFromScalar(bfloat16.FromFloat64(value))
+	default:
+		c, err := g.fn.ConstantFromScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		return stablehlo.Convert(c, dtype)
+	}
+}
+
+// full returns a value of the given shape filled with value.
+func (g *gradient) full(shape shapes.Shape, value float64) (*stablehlo.Value, error) {
+	c, err := g.scalar(shape.DType, value)
+	if err != nil || shape.IsScalar() {
+		return c, err
+	}
+	return stablehlo.BroadcastInDim(c, shape, nil)
+}
+
+// zeros returns a value of the given shape filled with 0.
+func (g *gradient) zeros(shape shapes.Shape) (*stablehlo.Value, error) {
+	return g.full(shape, 0)
+}
+
+// fullLike returns a value with the shape of v filled with value.
+func (g *gradient) fullLike(v *stablehlo.Value, value float64) (*stablehlo.Value, error) {
+	return g.full(v.Shape(), value)
+}
+
+// scalarClosure returns a closure of g.fn that applies the binary op to two scalars of the given dtype.
+func (g *gradient) scalarClosure(dtype dtypes.DType,
+	op func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error)) (*stablehlo.Function, error) {
+	closure := g.fn.Closure()
+	lhs, err := closure.Input(shapes.Make(dtype))
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := closure.Input(shapes.Make(dtype))
+	if err != nil {
+		return nil, err
+	}
+	result, err := op(lhs, rhs)
+	if err != nil {
+		return nil, err
+	}
+	if err := closure.Return(result); err != nil {
+		return nil, err
+	}
+	return closure, nil
+}
+
+// reduceSum sums x over the given axes.
+func (g *gradient) reduceSum(x *stablehlo.Value, axes ...int) (*stablehlo.Value, error) {
+	if len(axes) == 0 {
+		return x, nil
+	}
+	zero, err := g.scalar(x.Shape().DType, 0)
+	if err != nil {
+		return nil, err
+	}
+	addFn, err := g.scalarClosure(x.Shape().DType, stablehlo.Add)
+	if err != nil {
+		return nil, err
+	}
+	return stablehlo.Reduce(x, zero, addFn, axes...)
+}
+
+// reduceSumAll sums all the elements of x into a scalar.
+func (g *gradient) reduceSumAll(x *stablehlo.Value) (*stablehlo.Value, error) {
+	axes := make([]int, x.Shape().Rank())
+	for i := range axes {
+		axes[i] = i
+	}
+	return g.reduceSum(x, axes...)
+}
+
+// selectOrZero returns ct where pred is true, and 0 elsewhere.
+func (g *gradient) selectOrZero(pred, ct *stablehlo.Value) (*stablehlo.Value, error) {
+	zeros, err := g.fullLike(ct, 0)
+	if err != nil {
+		return nil, err
+	}
+	return stablehlo.Select(pred, ct, zeros)
+}
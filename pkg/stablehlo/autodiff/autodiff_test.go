@@ -0,0 +1,463 @@
+package autodiff
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/stablehlo/interpreter"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func must1[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// testTensor returns a Float64 tensor with the given dimensions, filled with distinct values starting at offset.
+func testTensor(offset float64, dimensions ...int) *interpreter.Tensor {
+	size := 1
+	for _, dim := range dimensions {
+		size *= dim
+	}
+	flat := make([]float64, size)
+	for i := range flat {
+		flat[i] = offset + 0.1*float64((i*7)%13) + 0.001*float64(i)
+	}
+	return must1(interpreter.NewTensor(flat, dimensions...))
+}
+
+// buildFn builds the function being differentiated from its inputs.
+type buildFn func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value
+
+// checkGrad builds a program with the output of build, reduced to a scalar by a weighted sum, and its
+// gradients with respect to the Float64 inputs computed with Grad. It then checks the gradients against
+// central finite differences.
+func checkGrad(t *testing.T, build buildFn, inputs ...*interpreter.Tensor) {
+	t.Helper()
+	b := stablehlo.New(t.Name())
+	fn := b.Main()
+	inputValues := make([]*stablehlo.Value, len(inputs))
+	var wrt []*stablehlo.Value
+	for i, input := range inputs {
+		inputValues[i] = must1(fn.Input(input.Shape))
+		if input.Shape.DType == dtypes.Float64 {
+			wrt = append(wrt, inputValues[i])
+		}
+	}
+	output := build(fn, inputValues)
+	weights := make([]float64, output.Shape().Size())
+	for i := range weights {
+		weights[i] = 1 + 0.25*float64(i%5)
+	}
+	weighted := must1(stablehlo.Multiply(output,
+		must1(fn.ConstantFromFlatAndDimensions(weights, output.Shape().Dimensions...))))
+	loss := must1(newGradient(fn).reduceSumAll(weighted))
+	must(fn.Return(loss))
+	grads, err := Grad(fn, wrt)
+	if err != nil {
+		t.Fatalf("Grad failed: %+v", err)
+	}
+	must(fn.Return(append([]*stablehlo.Value{loss}, grads...)...))
+
+	it := interpreter.New(b)
+	run := func() []*interpreter.Tensor {
+		outputs, err := it.Run(inputs...)
+		if err != nil {
+			t.Fatalf("failed to run program: %+v", err)
+		}
+		return outputs
+	}
+	outputs := run()
+	const epsilon, tolerance = 1e-5, 1e-5
+	gradIdx := 1
+	for i, input := range inputs {
+		if input.Shape.DType != dtypes.Float64 {
+			continue
+		}
+		got := outputs[gradIdx].Flat.([]float64)
+		gradIdx++
+		flat := input.Flat.([]float64)
+		for j := range flat {
+			original := flat[j]
+			flat[j] = original + epsilon
+			lossPlus := run()[0].Flat.([]float64)[0]
+			flat[j] = original - epsilon
+			lossMinus := run()[0].Flat.([]float64)[0]
+			flat[j] = original
+			want := (lossPlus - lossMinus) / (2 * epsilon)
+			if math.Abs(got[j]-want) > tolerance*max(1, math.Abs(want)) {
+				t.Fatalf("gradient of input #%d element #%d: got %g, finite differences give %g", i, j, got[j], want)
+			}
+		}
+	}
+}
+
+func TestElementWise(t *testing.T) {
+	unaryOps := map[string]func(x *stablehlo.Value) (*stablehlo.Value, error){
+		"Abs": stablehlo.Abs, "Cbrt": stablehlo.Cbrt, "Cosine": stablehlo.Cosine, "Erf": stablehlo.Erf,
+		"Exponential": stablehlo.Exponential, "ExponentialMinusOne": stablehlo.ExponentialMinusOne,
+		"Log": stablehlo.Log, "LogPlusOne": stablehlo.LogPlusOne, "Logistic": stablehlo.Logistic,
+		"Negate": stablehlo.Negate, "Rsqrt": stablehlo.Rsqrt, "Sine": stablehlo.Sine, "Sqrt": stablehlo.Sqrt,
+		"Tan": stablehlo.Tan, "Tanh": stablehlo.Tanh, "Floor": stablehlo.Floor,
+	}
+	for name, op := range unaryOps {
+		t.Run(name, func(t *testing.T) {
+			checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+				return must1(op(inputs[0]))
+			}, testTensor(0.15, 2, 3))
+		})
+	}
+
+	binaryOps := map[string]func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error){
+		"Add": stablehlo.Add, "Subtract": stablehlo.Subtract, "Multiply": stablehlo.Multiply,
+		"Divide": stablehlo.Divide, "Maximum": stablehlo.Maximum, "Minimum": stablehlo.Minimum,
+		"Power": stablehlo.Power, "Atan2": stablehlo.Atan2, "Remainder": stablehlo.Remainder,
+	}
+	for name, op := range binaryOps {
+		t.Run(name, func(t *testing.T) {
+			checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+				return must1(op(inputs[0], inputs[1]))
+			}, testTensor(0.55, 2, 3), testTensor(0.2, 2, 3))
+		})
+	}
+
+	t.Run("SameInputTwice", func(t *testing.T) {
+		checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			square := must1(stablehlo.Multiply(inputs[0], inputs[0]))
+			return must1(stablehlo.Add(square, inputs[0]))
+		}, testTensor(0.1, 4))
+	})
+
+	t.Run("Select", func(t *testing.T) {
+		checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			pred := must1(stablehlo.Compare(inputs[0], inputs[1], types.CompareGT, types.CompareFloat))
+			return must1(stablehlo.Select(pred, inputs[0], must1(stablehlo.Sine(inputs[1]))))
+		}, testTensor(0.5, 5), testTensor(0.9, 5))
+	})
+
+	t.Run("Clamp", func(t *testing.T) {
+		checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Clamp(inputs[0], inputs[1], inputs[2]))
+		}, must1(interpreter.NewTensor([]float64{0.55}, 1)), testTensor(0, 1),
+			must1(interpreter.NewTensor([]float64{1.05}, 1)))
+		checkGrad(t, func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Clamp(inputs[0], inputs[1], inputs[2]))
+		}, interpreter.NewScalar(0.55), testTensor(0, 6), interpreter.NewScalar(1.05))
+	})
+}
+
+func TestShapeOps(t *testing.T) {
+	testCases := map[string]struct {
+		build  buildFn
+		inputs []*interpreter.Tensor
+	}{
+		"Reshape": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Reshape(inputs[0], shapes.Make(dtypes.Float64, 3, 2)))
+		}, []*interpreter.Tensor{testTensor(0, 2, 3)}},
+		"Transpose": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Transpose(inputs[0], 2, 0, 1))
+		}, []*interpreter.Tensor{testTensor(0, 2, 3, 4)}},
+		"Reverse": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Reverse(inputs[0], 1))
+		}, []*interpreter.Tensor{testTensor(0, 2, 3)}},
+		"BroadcastInDim": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.BroadcastInDim(inputs[0], shapes.Make(dtypes.Float64, 4, 3, 2, 5), []int{2, 1}))
+		}, []*interpreter.Tensor{testTensor(0, 2, 1)}},
+		"Slice": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Slice(inputs[0], []int{1, 0}, []int{6, 3}, []int{2, 2}))
+		}, []*interpreter.Tensor{testTensor(0, 7, 4)}},
+		"Pad": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Pad(inputs[0], inputs[1], []int{1, -1}, []int{2, 0}, []int{1, 2}))
+		}, []*interpreter.Tensor{testTensor(0, 3, 4), interpreter.NewScalar(0.5)}},
+		"Concatenate": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Concatenate(1, inputs[0], inputs[1], inputs[0]))
+		}, []*interpreter.Tensor{testTensor(0, 2, 3), testTensor(1, 2, 1)}},
+		"DynamicSlice": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.DynamicSlice(inputs[0], inputs[1:], []int{2, 2}))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3), interpreter.NewScalar(int32(1)), interpreter.NewScalar(int32(5))}},
+		"DynamicUpdateSlice": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.DynamicUpdateSlice(inputs[0], inputs[1], inputs[2:]))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3), testTensor(1, 2, 2),
+			interpreter.NewScalar(int32(1)), interpreter.NewScalar(int32(1))}},
+		"Gather": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			// Gather rows 2, 0 and 2 again.
+			return must1(stablehlo.Gather(inputs[0], inputs[1], 1, []int{1}, []int{0}, nil, nil, []int{0},
+				[]int{1, 3}, false))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3), must1(interpreter.NewTensor([]int32{2, 0, 2}, 3, 1))}},
+		"ScatterAdd": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			addFn := must1(newGradient(fn).scalarClosure(dtypes.Float64, stablehlo.Add))
+			return must1(stablehlo.Scatter(inputs[0], inputs[2], inputs[1], []int{1}, []int{0}, nil, nil, []int{0}, 1,
+				false, false, addFn))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3), testTensor(1, 3, 3),
+			must1(interpreter.NewTensor([]int32{2, 0, 2}, 3, 1))}},
+		"ScatterReplace": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			replaceFn := must1(newGradient(fn).scalarClosure(dtypes.Float64,
+				func(_, rhs *stablehlo.Value) (*stablehlo.Value, error) { return rhs, nil }))
+			return must1(stablehlo.Scatter(inputs[0], inputs[2], inputs[1], []int{1}, []int{0}, nil, nil, []int{0}, 1,
+				false, true, replaceFn))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3), testTensor(1, 2, 3),
+			must1(interpreter.NewTensor([]int32{3, 1}, 2, 1))}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			checkGrad(t, tc.build, tc.inputs...)
+		})
+	}
+}
+
+func TestLinearAlgebraAndReductions(t *testing.T) {
+	reduce := func(fn *stablehlo.Function, x *stablehlo.Value, init float64,
+		op func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error), axes ...int) *stablehlo.Value {
+		reductionFn := must1(newGradient(fn).scalarClosure(dtypes.Float64, op))
+		return must1(stablehlo.Reduce(x, must1(fn.ConstantFromScalar(init)), reductionFn, axes...))
+	}
+	testCases := map[string]struct {
+		build  buildFn
+		inputs []*interpreter.Tensor
+	}{
+		"DotGeneral": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			// Batch axis 0 on the lhs and 2 on the rhs, contracting axes [2, 1] with [0, 1].
+			return must1(stablehlo.DotGeneral(inputs[0], []int{2, 1}, []int{0}, inputs[1], []int{0, 1}, []int{2}).Done())
+		}, []*interpreter.Tensor{testTensor(0, 2, 3, 4, 2), testTensor(1, 4, 3, 2, 3)}},
+		"Convolution": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			// Input [batch, height, width, channels], kernel [out, in, height, width], output [batch, out, height, width].
+			return must1(stablehlo.Convolution(inputs[0], inputs[1],
+				[]int{2, 1}, [][2]int{{1, 0}, {1, 2}}, []int{1, 2}, []int{1, 2},
+				0, 3, []int{1, 2},
+				1, 0, []int{2, 3},
+				0, 1, []int{2, 3},
+				1, 1, types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))
+		}, []*interpreter.Tensor{testTensor(0, 2, 5, 4, 2), testTensor(1, 3, 2, 2, 2)}},
+		"ReduceSum": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Reduce(inputs[0], inputs[1],
+				must1(newGradient(fn).scalarClosure(dtypes.Float64, stablehlo.Add)), 0, 2))
+		}, []*interpreter.Tensor{testTensor(0, 2, 3, 4), interpreter.NewScalar(0.5)}},
+		"ReduceProduct": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.Reduce(inputs[0], inputs[1],
+				must1(newGradient(fn).scalarClosure(dtypes.Float64, stablehlo.Multiply)), 1))
+		}, []*interpreter.Tensor{testTensor(0.5, 3, 4), interpreter.NewScalar(1.5)}},
+		"ReduceMax": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return reduce(fn, inputs[0], math.Inf(-1), stablehlo.Maximum, 1)
+		}, []*interpreter.Tensor{testTensor(0, 3, 4)}},
+		"ReduceMin": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return reduce(fn, inputs[0], math.Inf(1), stablehlo.Minimum, 0)
+		}, []*interpreter.Tensor{testTensor(0, 3, 4)}},
+		"ReduceWindowSum": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			addFn := must1(newGradient(fn).scalarClosure(dtypes.Float64, stablehlo.Add))
+			return must1(stablehlo.ReduceWindow(inputs[0], inputs[1], addFn, []int{2, 3}, []int{2, 1}, nil, nil,
+				[][2]int{{0, 1}, {1, 1}}))
+		}, []*interpreter.Tensor{testTensor(0, 5, 4), interpreter.NewScalar(0.0)}},
+		"ReduceWindowMax": {func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			maxFn := must1(newGradient(fn).scalarClosure(dtypes.Float64, stablehlo.Maximum))
+			return must1(stablehlo.ReduceWindow(inputs[0], must1(fn.ConstantFromScalar(math.Inf(-1))), maxFn,
+				[]int{2, 2}, []int{2, 1}, nil, nil, nil))
+		}, []*interpreter.Tensor{testTensor(0, 4, 3)}},
+		"BatchNormInference": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			return must1(stablehlo.BatchNormInference(inputs[0], inputs[1], inputs[2], inputs[3], inputs[4], 1e-3, 1))
+		}, []*interpreter.Tensor{testTensor(0, 4, 2), testTensor(1, 2), testTensor(0, 2), testTensor(0.3, 2),
+			testTensor(0.5, 2)}},
+		"BatchNormTraining": {func(_ *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			normalized, _, _, err := stablehlo.BatchNormTraining(inputs[0], inputs[1], inputs[2], 1e-3, 0)
+			must(err)
+			return normalized
+		}, []*interpreter.Tensor{testTensor(0, 2, 3), testTensor(1, 2), testTensor(0, 2)}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			checkGrad(t, tc.build, tc.inputs...)
+		})
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	t.Run("If", func(t *testing.T) {
+		for _, pred := range []bool{true, false} {
+			checkGrad(t, func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+				x, y := inputs[1], inputs[2]
+				trueBranch := fn.Closure()
+				trueX := must1(trueBranch.UseParentValue(x))
+				trueY := must1(trueBranch.UseParentValue(y))
+				must(trueBranch.Return(must1(stablehlo.Multiply(must1(stablehlo.Sine(trueX)), trueY))))
+				falseBranch := fn.Closure()
+				must(falseBranch.Return(must1(falseBranch.UseParentValue(x))))
+				return must1(stablehlo.If(inputs[0], trueBranch, falseBranch))[0]
+			}, interpreter.NewScalar(pred), testTensor(0, 3), testTensor(1, 3))
+		}
+	})
+
+	t.Run("Case", func(t *testing.T) {
+		for index := range int32(3) {
+			checkGrad(t, func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+				x := inputs[1]
+				branches := make([]*stablehlo.Function, 3)
+				for i := range branches {
+					branches[i] = fn.Closure()
+					branchX := must1(branches[i].UseParentValue(x))
+					switch i {
+					case 0:
+						must(branches[i].Return(must1(stablehlo.Exponential(branchX))))
+					case 1:
+						// A reduction closure nested in the branch.
+						sum := must1(newGradient(branches[i]).reduceSum(must1(stablehlo.Multiply(branchX, branchX)), 0))
+						must(branches[i].Return(must1(stablehlo.BroadcastInDim(sum, x.Shape(), nil))))
+					case 2:
+						must(branches[i].Return(must1(branches[i].ConstantFromFlatAndDimensions([]float64{1, 2}, 2))))
+					}
+				}
+				return must1(stablehlo.Case(inputs[0], branches...))[0]
+			}, interpreter.NewScalar(index), testTensor(0.5, 2))
+		}
+	})
+
+	t.Run("While", func(t *testing.T) {
+		// The loops are either counting up (0 <= c < 3) or down (4 >= c > 0, with the comparison swapped).
+		for _, countDown := range []bool{false, true} {
+			checkGrad(t, func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+				x, w := inputs[0], inputs[1]
+				start, limit := int32(0), int32(3)
+				if countDown {
+					start, limit = 4, 0
+				}
+				counter := must1(fn.ConstantFromScalar(start))
+				condFn := fn.Closure()
+				condCounter := must1(condFn.Input(counter.Shape()))
+				_ = must1(condFn.Input(x.Shape()))
+				_ = must1(condFn.Input(x.Shape()))
+				condLimit := must1(condFn.ConstantFromScalar(limit))
+				if countDown {
+					must(condFn.Return(must1(stablehlo.Compare(condLimit, condCounter, types.CompareLT, types.CompareSigned))))
+				} else {
+					must(condFn.Return(must1(stablehlo.Compare(condCounter, condLimit, types.CompareLT, types.CompareSigned))))
+				}
+
+				// Each iteration updates x using the captured w, and accumulates x into acc.
+				bodyFn := fn.Closure()
+				bodyCounter := must1(bodyFn.Input(counter.Shape()))
+				bodyX := must1(bodyFn.Input(x.Shape()))
+				bodyAcc := must1(bodyFn.Input(x.Shape()))
+				one := must1(bodyFn.ConstantFromScalar(int32(1)))
+				nextCounter := must1(stablehlo.Add(bodyCounter, one))
+				if countDown {
+					nextCounter = must1(stablehlo.Subtract(bodyCounter, one))
+				}
+				nextX := must1(stablehlo.Sine(must1(stablehlo.Multiply(bodyX, must1(bodyFn.UseParentValue(w))))))
+				must(bodyFn.Return(nextCounter, nextX, must1(stablehlo.Add(bodyAcc, nextX))))
+				outputs := must1(stablehlo.While(condFn, bodyFn, counter, x, x))
+				return must1(stablehlo.Multiply(outputs[1], outputs[2]))
+			}, testTensor(0.5, 3), testTensor(1, 3))
+		}
+	})
+
+	t.Run("Call", func(t *testing.T) {
+		checkGrad(t, func(fn *stablehlo.Function, inputs []*stablehlo.Value) *stablehlo.Value {
+			callee := fn.Builder.NewFunction("weighted_product")
+			calleeX := must1(callee.Input(inputs[0].Shape()))
+			calleeY := must1(callee.Input(inputs[1].Shape()))
+			product := must1(stablehlo.Multiply(calleeX, calleeY))
+			must(callee.Return(must1(stablehlo.Tanh(product)), calleeX))
+			outputs := must1(stablehlo.Call(callee, inputs[0], inputs[1]))
+			return must1(stablehlo.Add(outputs[0], must1(stablehlo.Multiply(outputs[1], inputs[0]))))
+		}, testTensor(0, 3), testTensor(1, 3))
+	})
+}
+
+func TestGradErrors(t *testing.T) {
+	b := stablehlo.New(t.Name())
+	fn := b.Main()
+	x := must1(fn.Input(shapes.Make(dtypes.Float64, 3)))
+	if _, err := Grad(fn, []*stablehlo.Value{x}); err == nil {
+		t.Errorf("expected error for a function that has not returned")
+	}
+	must(fn.Return(x))
+	if _, err := Grad(fn, []*stablehlo.Value{x}); err == nil || !strings.Contains(err.Error(), "non-scalar") {
+		t.Errorf("expected error for non-scalar output without cotangents, got %v", err)
+	}
+
+	b = stablehlo.New(t.Name())
+	fn = b.Main()
+	x = must1(fn.Input(shapes.Make(dtypes.Float64)))
+	sorted := must1(stablehlo.Sort(must1(newGradient(fn).scalarClosure(dtypes.Float64,
+		func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error) {
+			return stablehlo.Compare(lhs, rhs, types.CompareLT, types.CompareFloat)
+		})), 0, false, must1(stablehlo.Reshape(x, shapes.Make(dtypes.Float64, 1)))))
+	// The multiplication is differentiated (and its backward computation emitted) before reaching Sort.
+	must(fn.Return(must1(stablehlo.Multiply(must1(stablehlo.Reshape(sorted[0], x.Shape())), x))))
+	want := string(must1(b.Build()))
+	if _, err := Grad(fn, []*stablehlo.Value{x}); err == nil || !strings.Contains(err.Error(), "not differentiable") {
+		t.Errorf("expected error for op without gradient, got %v", err)
+	}
+
+	// After a failed Grad, fn is unchanged and can still be built.
+	if !fn.Returned {
+		t.Fatal("expected fn to remain returned after a failed Grad")
+	}
+	if got := string(must1(b.Build())); got != want {
+		t.Errorf("program changed after a failed Grad, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// While loops are only differentiated with a static number of iterations: the limit is an input.
+	b = stablehlo.New(t.Name())
+	fn = b.Main()
+	x = must1(fn.Input(shapes.Make(dtypes.Float64)))
+	limit := must1(fn.Input(shapes.Make(dtypes.Int32)))
+	counter := must1(fn.ConstantFromScalar(int32(0)))
+	condFn := fn.Closure()
+	condCounter := must1(condFn.Input(counter.Shape()))
+	_ = must1(condFn.Input(x.Shape()))
+	must(condFn.Return(must1(stablehlo.Compare(condCounter, must1(condFn.UseParentValue(limit)),
+		types.CompareLT, types.CompareSigned))))
+	bodyFn := fn.Closure()
+	bodyCounter := must1(bodyFn.Input(counter.Shape()))
+	bodyX := must1(bodyFn.Input(x.Shape()))
+	must(bodyFn.Return(must1(stablehlo.Add(bodyCounter, must1(bodyFn.ConstantFromScalar(int32(1))))),
+		must1(stablehlo.Add(bodyX, bodyX))))
+	must(fn.Return(must1(stablehlo.While(condFn, bodyFn, counter, x))[1]))
+	if _, err := Grad(fn, []*stablehlo.Value{x}); err == nil || !strings.Contains(err.Error(), "static number of iterations") {
+		t.Errorf("expected error for While without a static number of iterations, got %v", err)
+	}
+	if _, err := b.Build(); err != nil {
+		t.Errorf("Build failed after a failed Grad: %v", err)
+	}
+}
+
+func TestGradWithCotangents(t *testing.T) {
+	b := stablehlo.New(t.Name())
+	fn := b.Main()
+	x := must1(fn.Input(shapes.Make(dtypes.Float64, 2)))
+	ct := must1(fn.Input(shapes.Make(dtypes.Float64, 2)))
+	unused := must1(fn.Input(shapes.Make(dtypes.Float64)))
+	// Gradients flow through conversions between float dtypes.
+	x32 := must1(stablehlo.Convert(x, dtypes.Float32))
+	y := must1(stablehlo.Convert(must1(stablehlo.Multiply(x32, x32)), dtypes.Float64))
+	must(fn.Return(y, y))
+	grads, err := Grad(fn, []*stablehlo.Value{x, unused}, ct, nil)
+	if err != nil {
+		t.Fatalf("Grad failed: %+v", err)
+	}
+	must(fn.Return(grads...))
+	outputs, err := interpreter.New(b).Run(
+		must1(interpreter.NewTensor([]float64{1, 2}, 2)),
+		must1(interpreter.NewTensor([]float64{10, 100}, 2)),
+		interpreter.NewScalar(3.0))
+	if err != nil {
+		t.Fatalf("failed to run program: %+v", err)
+	}
+	if got := outputs[0].Flat.([]float64); got[0] != 20 || got[1] != 400 {
+		t.Errorf("got gradient %v, wanted [20 400]", got)
+	}
+	if got := outputs[1].Value(); got != 0.0 {
+		t.Errorf("got gradient %v for unused input, wanted 0", got)
+	}
+}
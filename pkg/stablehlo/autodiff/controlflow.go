@@ -0,0 +1,710 @@
+package autodiff
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	vjpRules[optypes.Call] = vjpCall
+	vjpRules[optypes.Composite] = vjpCall
+	vjpRules[optypes.If] = vjpBranches
+	vjpRules[optypes.Case] = vjpBranches
+	vjpRules[optypes.While] = vjpWhile
+}
+
+// vjpCall differentiates Call and Composite by inlining a copy of the callee (or of the decomposition) and
+// differentiating the copy.
+func vjpCall(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	key := "callee"
+	if stmt.OpType == optypes.Composite {
+		key = "decomposition"
+	}
+	symbol, err := attributes.Text(stmt, key)
+	if err != nil {
+		return nil, err
+	}
+	var callee *stablehlo.Function
+	for _, fn := range g.fn.Builder.Functions() {
+		if fn.Parent == nil && "@"+stablehlo.NormalizeIdentifier(fn.Name) == symbol {
+			callee = fn
+			break
+		}
+	}
+	if callee == nil {
+		return nil, errors.Errorf("function %s not found", symbol)
+	}
+
+	// The values of the callee are mapped by name: its inputs to the inputs of the call, and the values it
+	// defines to their copies.
+	mapped := make(map[string]*stablehlo.Value)
+	for i, input := range callee.Inputs {
+		mapped[input.String()] = stmt.Inputs[i]
+	}
+	mapValue := func(v *stablehlo.Value) (*stablehlo.Value, error) {
+		return mapped[v.String()], nil
+	}
+	var copied []*stablehlo.Statement
+	seeds := make(map[string]*stablehlo.Value)
+	sub := newGradient(g.fn)
+	for _, calleeStmt := range callee.Statements {
+		if calleeStmt.OpType == optypes.FuncReturn {
+			for i, output := range calleeStmt.Inputs {
+				if cts[i] == nil {
+					continue
+				}
+				if err := sub.seed(seeds, mapped[output.String()], cts[i]); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		outputs, err := g.fn.CopyStatement(calleeStmt, mapValue)
+		if err != nil {
+			return nil, err
+		}
+		copied = append(copied, g.fn.Statements[len(g.fn.Statements)-1])
+		for i, output := range calleeStmt.Outputs {
+			mapped[output.String()] = outputs[i]
+		}
+	}
+
+	// Only the needed inputs are differentiated, and the gradient of an input used more than once in the call is
+	// only returned once.
+	var targets []*stablehlo.Value
+	for _, input := range stmt.Inputs {
+		if g.needed[input.String()] && !slices.Contains(targets, input) {
+			targets = append(targets, input)
+		}
+	}
+	grads, err := sub.backprop(copied, seeds, targets)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "in function %q", callee.Name)
+	}
+	inputCts := make([]*stablehlo.Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		if idx := slices.Index(targets, input); idx != -1 && slices.Index(stmt.Inputs, input) == i {
+			inputCts[i] = grads[idx]
+		}
+	}
+	return inputCts, nil
+}
+
+// vjpBranches differentiates If and Case. The branches don't have inputs, and gradients flow to the values of
+// the enclosing functions they use: the backward computation is a new If (or Case) whose branches differentiate
+// a copy of the corresponding forward branch, and return the cotangents of the used values.
+func vjpBranches(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	// Values used by any of the branches that need a gradient.
+	var targetNames []string
+	for _, branch := range stmt.FunctionParameters {
+		for _, name := range capturedValues(branch) {
+			if g.needed[name] && !slices.Contains(targetNames, name) {
+				targetNames = append(targetNames, name)
+			}
+		}
+	}
+	slices.Sort(targetNames)
+	targets := make([]*stablehlo.Value, len(targetNames))
+	for i, name := range targetNames {
+		var err error
+		if targets[i], err = g.lookup(name); err != nil {
+			return nil, err
+		}
+	}
+
+	backwardBranches := make([]*stablehlo.Function, len(stmt.FunctionParameters))
+	for i, branch := range stmt.FunctionParameters {
+		var err error
+		if backwardBranches[i], err = g.backwardBranch(branch, cts, targets); err != nil {
+			return nil, errors.WithMessagef(err, "branch %q", stmt.FunctionParametersNames[i])
+		}
+	}
+	var targetCts []*stablehlo.Value
+	var err error
+	if stmt.OpType == optypes.If {
+		targetCts, err = stablehlo.If(stmt.Inputs[0], backwardBranches[0], backwardBranches[1])
+	} else {
+		targetCts, err = stablehlo.Case(stmt.Inputs[0], backwardBranches...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, target := range targets {
+		if err := g.accumulate(target, targetCts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// backwardBranch returns a closure of g.fn that copies the statements of the forward branch and returns the
+// cotangents of the targets, given the cotangents of the outputs of the branch.
+func (g *gradient) backwardBranch(branch *stablehlo.Function, cts, targets []*stablehlo.Value) (
+	*stablehlo.Function, error) {
+	bwd := g.fn.Closure()
+	// Values defined in the branch are mapped to their copies, and values of the enclosing functions to
+	// the original values.
+	mapped := make(map[string]*stablehlo.Value)
+	mapValue := func(v *stablehlo.Value) (*stablehlo.Value, error) {
+		if copied, found := mapped[v.String()]; found {
+			return copied, nil
+		}
+		return g.lookup(v.String())
+	}
+	var copied []*stablehlo.Statement
+	seeds := make(map[string]*stablehlo.Value)
+	sub := newGradient(bwd)
+	for _, branchStmt := range branch.Statements {
+		if branchStmt.OpType == optypes.FuncReturn {
+			for i, output := range branchStmt.Inputs {
+				if cts[i] == nil {
+					continue
+				}
+				ct, err := bwd.UseParentValue(cts[i])
+				if err != nil {
+					return nil, err
+				}
+				copiedOutput, err := mapValue(output)
+				if err != nil {
+					return nil, err
+				}
+				if err := sub.seed(seeds, copiedOutput, ct); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		outputs, err := bwd.CopyStatement(branchStmt, mapValue)
+		if err != nil {
+			return nil, err
+		}
+		copied = append(copied, bwd.Statements[len(bwd.Statements)-1])
+		for i, output := range branchStmt.Outputs {
+			mapped[output.String()] = outputs[i]
+		}
+	}
+	bwdTargets := make([]*stablehlo.Value, len(targets))
+	for i, target := range targets {
+		var err error
+		if bwdTargets[i], err = bwd.UseParentValue(target); err != nil {
+			return nil, err
+		}
+	}
+	grads, err := sub.backprop(copied, seeds, bwdTargets)
+	if err != nil {
+		return nil, err
+	}
+	if err := bwd.Return(grads...); err != nil {
+		return nil, err
+	}
+	return bwd, nil
+}
+
+// maxWhileIterations limits the number of iterations of a differentiated While loop, since the states of every
+// iteration are kept for the reverse pass.
+const maxWhileIterations = 1 << 20
+
+// vjpWhile differentiates While loops with a static number of iterations (see whileIterations).
+//
+// The loop is run again, recording the states at the start of each iteration in stacked buffers (one row per
+// iteration). Then a reverse loop goes over the iterations backward: it reads the states of the iteration from
+// the buffers, differentiates a copy of the body, and accumulates the cotangents of the values of the enclosing
+// functions used by the body.
+func vjpWhile(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	bodyFn := stmt.FunctionParameters[1]
+	numIterations, err := whileIterations(g.fn, stmt)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range stmt.Inputs {
+		if state.Shape().IsTuple() || state.Shape().DType == dtypes.TOKEN {
+			return nil, errors.Errorf("gradients through While loops with tuple or token states are not supported, "+
+				"got state of shape %s", state.Shape())
+		}
+	}
+
+	// The cotangents of the differentiable states are carried by the reverse loop.
+	var diffStates []int
+	for i, state := range stmt.Inputs {
+		if isDifferentiable(state) {
+			diffStates = append(diffStates, i)
+		}
+	}
+	inputCts := make([]*stablehlo.Value, len(stmt.Inputs))
+	if numIterations == 0 {
+		for _, i := range diffStates {
+			inputCts[i] = cts[i]
+		}
+		return inputCts, nil
+	}
+
+	// Values used by the body that need a gradient.
+	var targetNames []string
+	for _, name := range capturedValues(bodyFn) {
+		if g.needed[name] {
+			targetNames = append(targetNames, name)
+		}
+	}
+	slices.Sort(targetNames)
+	targets := make([]*stablehlo.Value, len(targetNames))
+	for i, name := range targetNames {
+		if targets[i], err = g.lookup(name); err != nil {
+			return nil, err
+		}
+	}
+
+	buffers, err := g.recordWhileStates(bodyFn, stmt.Inputs, numIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reverse loop states are: the iteration counter, the cotangents of the differentiable states, and the
+	// accumulated cotangents of the targets.
+	counterShape := shapes.Make(dtypes.Int32)
+	stateShapes := []shapes.Shape{counterShape}
+	for _, i := range diffStates {
+		stateShapes = append(stateShapes, stmt.Inputs[i].Shape())
+	}
+	for _, target := range targets {
+		stateShapes = append(stateShapes, target.Shape())
+	}
+	rev := g.fn.Closure()
+	inputs, err := closureInputs(rev, stateShapes)
+	if err != nil {
+		return nil, err
+	}
+	counter, stateCts, accumulators := inputs[0], inputs[1:1+len(diffStates)], inputs[1+len(diffStates):]
+	states := make([]*stablehlo.Value, len(stmt.Inputs))
+	for i, buffer := range buffers {
+		if buffer, err = rev.UseParentValue(buffer); err != nil {
+			return nil, err
+		}
+		indices, err := iterationIndices(rev, counter, buffer.Shape().Rank())
+		if err != nil {
+			return nil, err
+		}
+		row, err := stablehlo.DynamicSlice(buffer, indices, stackedShape(stmt.Inputs[i].Shape(), 1).Dimensions)
+		if err != nil {
+			return nil, err
+		}
+		if states[i], err = stablehlo.Reshape(row, stmt.Inputs[i].Shape()); err != nil {
+			return nil, err
+		}
+	}
+	copied, nextStates, err := g.copyClosure(rev, bodyFn, states)
+	if err != nil {
+		return nil, err
+	}
+	sub := newGradient(rev)
+	seeds := make(map[string]*stablehlo.Value)
+	revTargets := make([]*stablehlo.Value, 0, len(diffStates)+len(targets))
+	for k, i := range diffStates {
+		if err := sub.seed(seeds, nextStates[i], stateCts[k]); err != nil {
+			return nil, err
+		}
+		revTargets = append(revTargets, states[i])
+	}
+	for _, target := range targets {
+		revTarget, err := rev.UseParentValue(target)
+		if err != nil {
+			return nil, err
+		}
+		revTargets = append(revTargets, revTarget)
+	}
+	grads, err := sub.backprop(copied, seeds, revTargets)
+	if err != nil {
+		return nil, errors.WithMessage(err, "in the body of the While loop")
+	}
+	revOutputs := make([]*stablehlo.Value, 0, len(inputs))
+	one, err := rev.ConstantFromScalar(int32(1))
+	if err != nil {
+		return nil, err
+	}
+	previousCounter, err := stablehlo.Subtract(counter, one)
+	if err != nil {
+		return nil, err
+	}
+	revOutputs = append(revOutputs, previousCounter)
+	revOutputs = append(revOutputs, grads[:len(diffStates)]...)
+	for i, accumulator := range accumulators {
+		sum, err := stablehlo.Add(accumulator, grads[len(diffStates)+i])
+		if err != nil {
+			return nil, err
+		}
+		revOutputs = append(revOutputs, sum)
+	}
+	if err := rev.Return(revOutputs...); err != nil {
+		return nil, err
+	}
+	revCond, err := g.counterCond(stateShapes, types.CompareGE, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	lastIteration, err := g.fn.ConstantFromScalar(int32(numIterations - 1))
+	if err != nil {
+		return nil, err
+	}
+	initialStates := []*stablehlo.Value{lastIteration}
+	for _, i := range diffStates {
+		ct := cts[i]
+		if ct == nil {
+			if ct, err = g.zeros(stmt.Inputs[i].Shape()); err != nil {
+				return nil, err
+			}
+		}
+		initialStates = append(initialStates, ct)
+	}
+	for _, target := range targets {
+		zeros, err := g.zeros(target.Shape())
+		if err != nil {
+			return nil, err
+		}
+		initialStates = append(initialStates, zeros)
+	}
+	outputs, err := stablehlo.While(revCond, rev, initialStates...)
+	if err != nil {
+		return nil, err
+	}
+	for k, i := range diffStates {
+		inputCts[i] = outputs[1+k]
+	}
+	for i, target := range targets {
+		if err := g.accumulate(target, outputs[1+len(diffStates)+i]); err != nil {
+			return nil, err
+		}
+	}
+	return inputCts, nil
+}
+
+// recordWhileStates runs a copy of the While loop body numIterations times from the initial states, and returns
+// one buffer per state, with the states at the start of each iteration stacked on a new leading axis.
+func (g *gradient) recordWhileStates(bodyFn *stablehlo.Function, initialStates []*stablehlo.Value,
+	numIterations int) ([]*stablehlo.Value, error) {
+	// The loop states are: the iteration counter, the original states and the buffers.
+	numStates := len(initialStates)
+	stateShapes := []shapes.Shape{shapes.Make(dtypes.Int32)}
+	for _, state := range initialStates {
+		stateShapes = append(stateShapes, state.Shape())
+	}
+	for _, state := range initialStates {
+		stateShapes = append(stateShapes, stackedShape(state.Shape(), numIterations))
+	}
+	body := g.fn.Closure()
+	inputs, err := closureInputs(body, stateShapes)
+	if err != nil {
+		return nil, err
+	}
+	counter, states, buffers := inputs[0], inputs[1:1+numStates], inputs[1+numStates:]
+	_, nextStates, err := g.copyClosure(body, bodyFn, states)
+	if err != nil {
+		return nil, err
+	}
+	one, err := body.ConstantFromScalar(int32(1))
+	if err != nil {
+		return nil, err
+	}
+	nextCounter, err := stablehlo.Add(counter, one)
+	if err != nil {
+		return nil, err
+	}
+	bodyOutputs := append([]*stablehlo.Value{nextCounter}, nextStates...)
+	for i, state := range states {
+		row, err := stablehlo.Reshape(state, stackedShape(state.Shape(), 1))
+		if err != nil {
+			return nil, err
+		}
+		indices, err := iterationIndices(body, counter, buffers[i].Shape().Rank())
+		if err != nil {
+			return nil, err
+		}
+		buffer, err := stablehlo.DynamicUpdateSlice(buffers[i], row, indices)
+		if err != nil {
+			return nil, err
+		}
+		bodyOutputs = append(bodyOutputs, buffer)
+	}
+	if err := body.Return(bodyOutputs...); err != nil {
+		return nil, err
+	}
+	cond, err := g.counterCond(stateShapes, types.CompareLT, numIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	firstIteration, err := g.fn.ConstantFromScalar(int32(0))
+	if err != nil {
+		return nil, err
+	}
+	loopStates := append([]*stablehlo.Value{firstIteration}, initialStates...)
+	for _, state := range initialStates {
+		buffer, err := g.zeros(stackedShape(state.Shape(), numIterations))
+		if err != nil {
+			return nil, err
+		}
+		loopStates = append(loopStates, buffer)
+	}
+	outputs, err := stablehlo.While(cond, body, loopStates...)
+	if err != nil {
+		return nil, err
+	}
+	return outputs[1+numStates:], nil
+}
+
+// copyClosure adds to fn a copy of the statements of closure, with the inputs of the closure replaced by the
+// given values. It returns the copied statements, and the values returned by the copy.
+func (g *gradient) copyClosure(fn, closure *stablehlo.Function, inputs []*stablehlo.Value) (
+	[]*stablehlo.Statement, []*stablehlo.Value, error) {
+	mapped := make(map[string]*stablehlo.Value)
+	for i, input := range closure.Inputs {
+		mapped[input.String()] = inputs[i]
+	}
+	mapValue := func(v *stablehlo.Value) (*stablehlo.Value, error) {
+		if copied, found := mapped[v.String()]; found {
+			return copied, nil
+		}
+		return g.lookup(v.String())
+	}
+	var copied []*stablehlo.Statement
+	for _, closureStmt := range closure.Statements {
+		if closureStmt.OpType == optypes.FuncReturn {
+			outputs := make([]*stablehlo.Value, len(closureStmt.Inputs))
+			for i, output := range closureStmt.Inputs {
+				if copiedOutput, found := mapped[output.String()]; found {
+					outputs[i] = copiedOutput
+					continue
+				}
+				// The closure returns a value of an enclosing function.
+				parentValue, err := g.lookup(output.String())
+				if err != nil {
+					return nil, nil, err
+				}
+				if outputs[i], err = fn.UseParentValue(parentValue); err != nil {
+					return nil, nil, err
+				}
+			}
+			return copied, outputs, nil
+		}
+		outputs, err := fn.CopyStatement(closureStmt, mapValue)
+		if err != nil {
+			return nil, nil, err
+		}
+		copied = append(copied, fn.Statements[len(fn.Statements)-1])
+		for i, output := range closureStmt.Outputs {
+			mapped[output.String()] = outputs[i]
+		}
+	}
+	return nil, nil, errors.Errorf("closure %q has no return statement", closure.Name)
+}
+
+// counterCond returns a closure of g.fn, the condition of a While loop with the given states, that compares the
+// first state (an int32 counter) with limit.
+func (g *gradient) counterCond(stateShapes []shapes.Shape, direction types.ComparisonDirection, limit int) (
+	*stablehlo.Function, error) {
+	cond := g.fn.Closure()
+	inputs, err := closureInputs(cond, stateShapes)
+	if err != nil {
+		return nil, err
+	}
+	limitValue, err := cond.ConstantFromScalar(int32(limit))
+	if err != nil {
+		return nil, err
+	}
+	pred, err := stablehlo.Compare(inputs[0], limitValue, direction, types.CompareSigned)
+	if err != nil {
+		return nil, err
+	}
+	if err := cond.Return(pred); err != nil {
+		return nil, err
+	}
+	return cond, nil
+}
+
+// closureInputs creates the inputs of the closure with the given shapes.
+func closureInputs(closure *stablehlo.Function, inputShapes []shapes.Shape) ([]*stablehlo.Value, error) {
+	inputs := make([]*stablehlo.Value, len(inputShapes))
+	for i, shape := range inputShapes {
+		var err error
+		if inputs[i], err = closure.Input(shape); err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+// stackedShape returns the shape of n values of the given shape stacked on a new leading axis.
+func stackedShape(shape shapes.Shape, n int) shapes.Shape {
+	return shapes.Make(shape.DType, append([]int{n}, shape.Dimensions...)...)
+}
+
+// iterationIndices returns the start indices, in a stacked buffer of the given rank, of the row of the iteration
+// given by counter.
+func iterationIndices(fn *stablehlo.Function, counter *stablehlo.Value, rank int) ([]*stablehlo.Value, error) {
+	indices := []*stablehlo.Value{counter}
+	if rank > 1 {
+		zero, err := fn.ConstantFromScalar(int32(0))
+		if err != nil {
+			return nil, err
+		}
+		for range rank - 1 {
+			indices = append(indices, zero)
+		}
+	}
+	return indices, nil
+}
+
+// whileIterations returns the number of iterations of the While loop stmt of fn, which must be static: the
+// condition must compare a state (the counter) with a constant, the body must add a constant to (or subtract a
+// constant from) the counter, and the counter must be initialized with a constant.
+func whileIterations(fn *stablehlo.Function, stmt *stablehlo.Statement) (int, error) {
+	condFn, bodyFn := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	errNotStatic := errors.New("gradients through While loops require a static number of iterations: a counter " +
+		"initialized with a constant, incremented by a constant in the body and compared with a constant in " +
+		"the condition")
+	compare := definingStatement(condFn, condFn.Outputs[0])
+	if compare == nil || compare.OpType != optypes.Compare {
+		return 0, errNotStatic
+	}
+	direction, err := attributes.Enum(compare, "comparison_direction")
+	if err != nil {
+		return 0, err
+	}
+	for side, operand := range compare.Inputs {
+		counter := slices.IndexFunc(condFn.Inputs, func(input *stablehlo.Value) bool {
+			return input.String() == operand.String()
+		})
+		if counter == -1 {
+			continue
+		}
+		limit, isConstant := constantInt(condFn, compare.Inputs[1-side])
+		if !isConstant {
+			continue
+		}
+		start, isConstant := constantInt(fn, stmt.Inputs[counter])
+		if !isConstant {
+			continue
+		}
+		step, isConstant := counterStep(bodyFn, counter)
+		if !isConstant {
+			continue
+		}
+		var numIterations int
+		for value := start; compareInts(direction, value, limit, side == 1); value += step {
+			if numIterations == maxWhileIterations {
+				return 0, errors.Errorf("gradients through While loops are limited to %d iterations",
+					maxWhileIterations)
+			}
+			numIterations++
+		}
+		return numIterations, nil
+	}
+	return 0, errNotStatic
+}
+
+// counterStep returns the constant added to the counter state by the body of a While loop.
+func counterStep(bodyFn *stablehlo.Function, counter int) (int, bool) {
+	next := definingStatement(bodyFn, bodyFn.Outputs[counter])
+	if next == nil {
+		return 0, false
+	}
+	counterName := bodyFn.Inputs[counter].String()
+	switch next.OpType {
+	case optypes.Add:
+		for side, operand := range next.Inputs {
+			if operand.String() == counterName {
+				return constantInt(bodyFn, next.Inputs[1-side])
+			}
+		}
+	case optypes.Subtract:
+		if next.Inputs[0].String() == counterName {
+			step, isConstant := constantInt(bodyFn, next.Inputs[1])
+			return -step, isConstant
+		}
+	}
+	return 0, false
+}
+
+// compareInts evaluates the comparison of the counter value with the limit, or of the limit with the value if
+// swapped.
+func compareInts(direction string, value, limit int, swapped bool) bool {
+	lhs, rhs := value, limit
+	if swapped {
+		lhs, rhs = limit, value
+	}
+	switch direction {
+	case "EQ":
+		return lhs == rhs
+	case "NE":
+		return lhs != rhs
+	case "LT":
+		return lhs < rhs
+	case "LE":
+		return lhs <= rhs
+	case "GT":
+		return lhs > rhs
+	case "GE":
+		return lhs >= rhs
+	}
+	return false
+}
+
+// definingStatement returns the statement of fn that defines v, or nil if v is not defined by fn.
+func definingStatement(fn *stablehlo.Function, v *stablehlo.Value) *stablehlo.Statement {
+	for _, stmt := range fn.Statements {
+		for _, output := range stmt.Outputs {
+			if output.String() == v.String() {
+				return stmt
+			}
+		}
+	}
+	return nil
+}
+
+// constantInt returns the value of v if it is an integer scalar constant defined by fn or by one of its
+// enclosing functions.
+func constantInt(fn *stablehlo.Function, v *stablehlo.Value) (int, bool) {
+	if !v.Shape().IsScalar() || !v.Shape().DType.IsInt() {
+		return 0, false
+	}
+	for ; fn != nil; fn = fn.Parent {
+		stmt := definingStatement(fn, v)
+		if stmt == nil {
+			continue
+		}
+		if stmt.OpType != optypes.Constant {
+			return 0, false
+		}
+		values, err := attributes.Ints(stmt, "value")
+		if err != nil || len(values) != 1 {
+			return 0, false
+		}
+		return values[0], true
+	}
+	return 0, false
+}
+
+// lookup returns the value with the given name defined in g.fn or in one of its enclosing functions.
+func (g *gradient) lookup(name string) (*stablehlo.Value, error) {
+	for fn := g.fn; fn != nil; fn = fn.Parent {
+		for _, input := range fn.Inputs {
+			if input.String() == name {
+				return input, nil
+			}
+		}
+		for _, stmt := range fn.Statements {
+			for _, output := range stmt.Outputs {
+				if output.String() == name {
+					return output, nil
+				}
+			}
+		}
+	}
+	return nil, errors.Errorf("value %s not found in function %q or its enclosing functions", name, g.fn.Name)
+}
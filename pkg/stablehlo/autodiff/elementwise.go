@@ -0,0 +1,429 @@
+package autodiff
+
+import (
+	"math"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+)
+
+// derivativeFn returns the derivative of an element-wise unary op y = f(x), given x and y.
+type derivativeFn func(g *gradient, x, y *stablehlo.Value) (*stablehlo.Value, error)
+
+// unaryDerivatives of the differentiable unary ops.
+var unaryDerivatives = map[optypes.OpType]derivativeFn{
+	optypes.Abs: func(_ *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		return stablehlo.Sign(x)
+	},
+	optypes.Cbrt: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		// 1 / (3 * y^2)
+		return divideScaled(g, 1.0/3.0, y, y)
+	},
+	optypes.Cosine: func(_ *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		sin, err := stablehlo.Sine(x)
+		if err != nil {
+			return nil, err
+		}
+		return stablehlo.Negate(sin)
+	},
+	optypes.Sine: func(_ *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		return stablehlo.Cosine(x)
+	},
+	optypes.Tan: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		// 1 + y^2
+		return onePlusScaledSquare(g, 1, y)
+	},
+	optypes.Tanh: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		// 1 - y^2
+		return onePlusScaledSquare(g, -1, y)
+	},
+	optypes.Erf: func(g *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		// 2/sqrt(pi) * exp(-x^2)
+		minusXSquare, err := scaledProduct(g, -1, x, x)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stablehlo.Exponential(minusXSquare)
+		if err != nil {
+			return nil, err
+		}
+		return scaled(g, 2/math.Sqrt(math.Pi), exp)
+	},
+	optypes.Exponential: func(_ *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		return y, nil
+	},
+	optypes.ExponentialMinusOne: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		return addScalar(g, y, 1)
+	},
+	optypes.Log: func(g *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		return divideScaled(g, 1, x)
+	},
+	optypes.LogPlusOne: func(g *gradient, x, _ *stablehlo.Value) (*stablehlo.Value, error) {
+		onePlusX, err := addScalar(g, x, 1)
+		if err != nil {
+			return nil, err
+		}
+		return divideScaled(g, 1, onePlusX)
+	},
+	optypes.Logistic: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		// y * (1 - y)
+		minusY, err := stablehlo.Negate(y)
+		if err != nil {
+			return nil, err
+		}
+		oneMinusY, err := addScalar(g, minusY, 1)
+		if err != nil {
+			return nil, err
+		}
+		return stablehlo.Multiply(y, oneMinusY)
+	},
+	optypes.Rsqrt: func(g *gradient, x, y *stablehlo.Value) (*stablehlo.Value, error) {
+		// -0.5 * y / x
+		yOverX, err := stablehlo.Divide(y, x)
+		if err != nil {
+			return nil, err
+		}
+		return scaled(g, -0.5, yOverX)
+	},
+	optypes.Sqrt: func(g *gradient, _, y *stablehlo.Value) (*stablehlo.Value, error) {
+		return divideScaled(g, 0.5, y)
+	},
+}
+
+// zeroDerivativeOps are piecewise constant: their gradient is 0.
+var zeroDerivativeOps = []optypes.OpType{
+	optypes.Ceil, optypes.Floor, optypes.RoundNearestAfz, optypes.RoundNearestEven, optypes.Sign,
+}
+
+func init() {
+	for op, derivative := range unaryDerivatives {
+		vjpRules[op] = func(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+			d, err := derivative(g, stmt.Inputs[0], stmt.Outputs[0])
+			if err != nil {
+				return nil, err
+			}
+			ct, err := stablehlo.Multiply(cts[0], d)
+			return []*stablehlo.Value{ct}, err
+		}
+	}
+	for _, op := range zeroDerivativeOps {
+		vjpRules[op] = noGradient
+	}
+	vjpRules[optypes.Negate] = func(_ *gradient, _ *stablehlo.Statement, cts []*stablehlo.Value) (
+		[]*stablehlo.Value, error) {
+		ct, err := stablehlo.Negate(cts[0])
+		return []*stablehlo.Value{ct}, err
+	}
+	vjpRules[optypes.Convert] = vjpConvert
+	vjpRules[optypes.ReducePrecision] = passThrough
+	vjpRules[optypes.Identity] = passThrough
+	vjpRules[optypes.OptimizationBarrier] = passThrough
+	vjpRules[optypes.Add] = vjpAdd
+	vjpRules[optypes.Subtract] = vjpSubtract
+	vjpRules[optypes.Multiply] = vjpMultiply
+	vjpRules[optypes.Divide] = vjpDivide
+	vjpRules[optypes.Maximum] = vjpMaxMin(types.CompareGE)
+	vjpRules[optypes.Minimum] = vjpMaxMin(types.CompareLE)
+	vjpRules[optypes.Power] = vjpPower
+	vjpRules[optypes.Atan2] = vjpAtan2
+	vjpRules[optypes.Remainder] = vjpRemainder
+	vjpRules[optypes.Select] = vjpSelect
+	vjpRules[optypes.Clamp] = vjpClamp
+}
+
+// noGradient is the rule of ops whose inputs get no gradient.
+func noGradient(_ *gradient, _ *stablehlo.Statement, _ []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	return nil, nil
+}
+
+// passThrough is the rule of ops whose input cotangents are the output cotangents (e.g.: Identity).
+func passThrough(_ *gradient, _ *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	return cts, nil
+}
+
+// scaled returns factor * x.
+func scaled(g *gradient, factor float64, x *stablehlo.Value) (*stablehlo.Value, error) {
+	if factor == 1 {
+		return x, nil
+	}
+	c, err := g.fullLike(x, factor)
+	if err != nil {
+		return nil, err
+	}
+	return stablehlo.Multiply(c, x)
+}
+
+// scaledProduct returns factor * x * y.
+func scaledProduct(g *gradient, factor float64, x, y *stablehlo.Value) (*stablehlo.Value, error) {
+	product, err := stablehlo.Multiply(x, y)
+	if err != nil {
+		return nil, err
+	}
+	return scaled(g, factor, product)
+}
+
+// divideScaled returns factor / (x * y * ...).
+func divideScaled(g *gradient, factor float64, x *stablehlo.Value, others ...*stablehlo.Value) (
+	*stablehlo.Value, error) {
+	denominator := x
+	for _, other := range others {
+		var err error
+		if denominator, err = stablehlo.Multiply(denominator, other); err != nil {
+			return nil, err
+		}
+	}
+	numerator, err := g.fullLike(x, factor)
+	if err != nil {
+		return nil, err
+	}
+	return stablehlo.Divide(numerator, denominator)
+}
+
+// addScalar returns x + value.
+func addScalar(g *gradient, x *stablehlo.Value, value float64) (*stablehlo.Value, error) {
+	c, err := g.fullLike(x, value)
+	if err != nil {
+		return nil, err
+	}
+	return stablehlo.Add(x, c)
+}
+
+// onePlusScaledSquare returns 1 + factor * y^2.
+func onePlusScaledSquare(g *gradient, factor float64, y *stablehlo.Value) (*stablehlo.Value, error) {
+	square, err := scaledProduct(g, factor, y, y)
+	if err != nil {
+		return nil, err
+	}
+	return addScalar(g, square, 1)
+}
+
+func vjpConvert(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	x := stmt.Inputs[0]
+	if !isDifferentiable(x) {
+		return nil, nil
+	}
+	ct, err := stablehlo.Convert(cts[0], x.Shape().DType)
+	return []*stablehlo.Value{ct}, err
+}
+
+func vjpAdd(_ *gradient, _ *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	return []*stablehlo.Value{cts[0], cts[0]}, nil
+}
+
+func vjpSubtract(_ *gradient, _ *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	negated, err := stablehlo.Negate(cts[0])
+	return []*stablehlo.Value{cts[0], negated}, err
+}
+
+func vjpMultiply(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	lhsCt, err := stablehlo.Multiply(cts[0], stmt.Inputs[1])
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err := stablehlo.Multiply(cts[0], stmt.Inputs[0])
+	return []*stablehlo.Value{lhsCt, rhsCt}, err
+}
+
+// vjpDivide: for y = lhs / rhs, the cotangents are ct / rhs and -ct * y / rhs.
+func vjpDivide(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	rhs, y := stmt.Inputs[1], stmt.Outputs[0]
+	lhsCt, err := stablehlo.Divide(cts[0], rhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err := stablehlo.Multiply(lhsCt, y)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err = stablehlo.Negate(rhsCt)
+	return []*stablehlo.Value{lhsCt, rhsCt}, err
+}
+
+// vjpMaxMin returns the rule for Maximum (direction GE) or Minimum (direction LE): the cotangent goes to the
+// selected input, and to the lhs in case of ties.
+func vjpMaxMin(direction types.ComparisonDirection) vjpRule {
+	return func(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+		lhsSelected, err := stablehlo.Compare(stmt.Inputs[0], stmt.Inputs[1], direction, types.CompareFloat)
+		if err != nil {
+			return nil, err
+		}
+		zeros, err := g.fullLike(cts[0], 0)
+		if err != nil {
+			return nil, err
+		}
+		lhsCt, err := stablehlo.Select(lhsSelected, cts[0], zeros)
+		if err != nil {
+			return nil, err
+		}
+		rhsCt, err := stablehlo.Select(lhsSelected, zeros, cts[0])
+		return []*stablehlo.Value{lhsCt, rhsCt}, err
+	}
+}
+
+// vjpPower: for y = lhs^rhs, the cotangents are ct * rhs * lhs^(rhs-1) and ct * y * log(lhs) (0 where lhs <= 0).
+func vjpPower(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	lhs, rhs, y := stmt.Inputs[0], stmt.Inputs[1], stmt.Outputs[0]
+	rhsMinusOne, err := addScalar(g, rhs, -1)
+	if err != nil {
+		return nil, err
+	}
+	lhsCt, err := stablehlo.Power(lhs, rhsMinusOne)
+	if err != nil {
+		return nil, err
+	}
+	if lhsCt, err = stablehlo.Multiply(lhsCt, rhs); err != nil {
+		return nil, err
+	}
+	if lhsCt, err = stablehlo.Multiply(lhsCt, cts[0]); err != nil {
+		return nil, err
+	}
+
+	logLhs, err := stablehlo.Log(lhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err := scaledProduct(g, 1, logLhs, y)
+	if err != nil {
+		return nil, err
+	}
+	if rhsCt, err = stablehlo.Multiply(rhsCt, cts[0]); err != nil {
+		return nil, err
+	}
+	zeros, err := g.fullLike(lhs, 0)
+	if err != nil {
+		return nil, err
+	}
+	isPositive, err := stablehlo.Compare(lhs, zeros, types.CompareGT, types.CompareFloat)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err = g.selectOrZero(isPositive, rhsCt)
+	return []*stablehlo.Value{lhsCt, rhsCt}, err
+}
+
+// vjpAtan2: for y = atan2(lhs, rhs), the cotangents are ct * rhs / (lhs^2 + rhs^2) and
+// -ct * lhs / (lhs^2 + rhs^2).
+func vjpAtan2(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	lhsSquare, err := stablehlo.Multiply(lhs, lhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsSquare, err := stablehlo.Multiply(rhs, rhs)
+	if err != nil {
+		return nil, err
+	}
+	denominator, err := stablehlo.Add(lhsSquare, rhsSquare)
+	if err != nil {
+		return nil, err
+	}
+	ctOverDenominator, err := stablehlo.Divide(cts[0], denominator)
+	if err != nil {
+		return nil, err
+	}
+	lhsCt, err := stablehlo.Multiply(ctOverDenominator, rhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err := stablehlo.Multiply(ctOverDenominator, lhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsCt, err = stablehlo.Negate(rhsCt)
+	return []*stablehlo.Value{lhsCt, rhsCt}, err
+}
+
+// vjpRemainder: for y = lhs - trunc(lhs/rhs) * rhs, the cotangents are ct and -ct * trunc(lhs/rhs).
+func vjpRemainder(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	quotient, err := stablehlo.Divide(lhs, rhs)
+	if err != nil {
+		return nil, err
+	}
+	// trunc(q) = sign(q) * floor(|q|)
+	absQuotient, err := stablehlo.Abs(quotient)
+	if err != nil {
+		return nil, err
+	}
+	truncated, err := stablehlo.Floor(absQuotient)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := stablehlo.Sign(quotient)
+	if err != nil {
+		return nil, err
+	}
+	if truncated, err = stablehlo.Multiply(truncated, sign); err != nil {
+		return nil, err
+	}
+	rhsCt, err := scaledProduct(g, -1, cts[0], truncated)
+	return []*stablehlo.Value{cts[0], rhsCt}, err
+}
+
+func vjpSelect(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	pred := stmt.Inputs[0]
+	zeros, err := g.fullLike(cts[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	onTrueCt, err := stablehlo.Select(pred, cts[0], zeros)
+	if err != nil {
+		return nil, err
+	}
+	onFalseCt, err := stablehlo.Select(pred, zeros, cts[0])
+	return []*stablehlo.Value{nil, onTrueCt, onFalseCt}, err
+}
+
+// vjpClamp: for y = clamp(min, x, max), the cotangent goes to x where min <= x <= max, and otherwise to the
+// bound selected. Bounds can be scalars.
+func vjpClamp(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	minV, x, maxV := stmt.Inputs[0], stmt.Inputs[1], stmt.Inputs[2]
+	broadcastBound := func(bound *stablehlo.Value) (*stablehlo.Value, error) {
+		if bound.Shape().IsScalar() && !x.Shape().IsScalar() {
+			return stablehlo.BroadcastInDim(bound, x.Shape(), nil)
+		}
+		return bound, nil
+	}
+	minB, err := broadcastBound(minV)
+	if err != nil {
+		return nil, err
+	}
+	maxB, err := broadcastBound(maxV)
+	if err != nil {
+		return nil, err
+	}
+	belowMin, err := stablehlo.Compare(x, minB, types.CompareLT, types.CompareFloat)
+	if err != nil {
+		return nil, err
+	}
+	aboveMax, err := stablehlo.Compare(x, maxB, types.CompareGT, types.CompareFloat)
+	if err != nil {
+		return nil, err
+	}
+	outside, err := stablehlo.Or(belowMin, aboveMax)
+	if err != nil {
+		return nil, err
+	}
+	inside, err := stablehlo.Not(outside)
+	if err != nil {
+		return nil, err
+	}
+	xCt, err := g.selectOrZero(inside, cts[0])
+	if err != nil {
+		return nil, err
+	}
+	boundCt := func(bound *stablehlo.Value, selected *stablehlo.Value) (*stablehlo.Value, error) {
+		ct, err := g.selectOrZero(selected, cts[0])
+		if err != nil || !bound.Shape().IsScalar() || x.Shape().IsScalar() {
+			return ct, err
+		}
+		return g.reduceSumAll(ct)
+	}
+	minCt, err := boundCt(minV, belowMin)
+	if err != nil {
+		return nil, err
+	}
+	maxCt, err := boundCt(maxV, aboveMax)
+	return []*stablehlo.Value{minCt, xCt, maxCt}, err
+}
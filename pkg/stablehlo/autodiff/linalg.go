@@ -0,0 +1,335 @@
+package autodiff
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	vjpRules[optypes.DotGeneral] = vjpDotGeneral
+	vjpRules[optypes.Convolution] = vjpConvolution
+	vjpRules[optypes.BatchNormTraining] = vjpBatchNormTraining
+	vjpRules[optypes.BatchNormInference] = vjpBatchNormInference
+}
+
+// vjpDotGeneral contracts the cotangent with the other operand over its free axes. The output axes of
+// DotGeneral are the batch axes, followed by the free axes of the lhs and then the free axes of the rhs.
+func vjpDotGeneral(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	fields, err := attributes.Struct(stmt, "dot_dimension_numbers")
+	if err != nil {
+		return nil, err
+	}
+	var axes [4][]int
+	for i, name := range []string{"lhs_batching_dimensions", "rhs_batching_dimensions",
+		"lhs_contracting_dimensions", "rhs_contracting_dimensions"} {
+		if axes[i], err = attributes.StructInts(fields, name); err != nil {
+			return nil, err
+		}
+	}
+	lhsBatchAxes, rhsBatchAxes, lhsContractingAxes, rhsContractingAxes := axes[0], axes[1], axes[2], axes[3]
+	lhsFreeAxes := freeAxes(lhs.Shape().Rank(), lhsBatchAxes, lhsContractingAxes)
+	rhsFreeAxes := freeAxes(rhs.Shape().Rank(), rhsBatchAxes, rhsContractingAxes)
+	numBatch := len(lhsBatchAxes)
+	ctBatchAxes := axesRange(0, numBatch)
+	ctLhsFreeAxes := axesRange(numBatch, numBatch+len(lhsFreeAxes))
+	ctRhsFreeAxes := axesRange(numBatch+len(lhsFreeAxes), stmt.Outputs[0].Shape().Rank())
+
+	var lhsCt, rhsCt *stablehlo.Value
+	if isDifferentiable(lhs) {
+		lhsCt, err = dotTranspose(cts[0], ctRhsFreeAxes, ctBatchAxes, rhs, rhsFreeAxes, rhsBatchAxes,
+			lhs, lhsBatchAxes, lhsFreeAxes, lhsContractingAxes, rhsContractingAxes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isDifferentiable(rhs) {
+		rhsCt, err = dotTranspose(cts[0], ctLhsFreeAxes, ctBatchAxes, lhs, lhsFreeAxes, lhsBatchAxes,
+			rhs, rhsBatchAxes, rhsFreeAxes, rhsContractingAxes, lhsContractingAxes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []*stablehlo.Value{lhsCt, rhsCt}, nil
+}
+
+// dotTranspose returns the cotangent of the target operand of a DotGeneral, by contracting the cotangent ct with
+// the other operand over the other operand's free axes (ctOtherFreeAxes in ct).
+//
+// The result axes are the batch axes, the target free axes and the remaining axes of the other operand (its
+// contracting axes, in increasing order), which are then transposed to the axes order of the target.
+func dotTranspose(ct *stablehlo.Value, ctOtherFreeAxes, ctBatchAxes []int,
+	other *stablehlo.Value, otherFreeAxes, otherBatchAxes []int,
+	target *stablehlo.Value, targetBatchAxes, targetFreeAxes, targetContractingAxes, otherContractingAxes []int) (
+	*stablehlo.Value, error) {
+	dtype := target.Shape().DType
+	var err error
+	if ct.Shape().DType != dtype {
+		if ct, err = stablehlo.Convert(ct, dtype); err != nil {
+			return nil, err
+		}
+	}
+	if other.Shape().DType != dtype {
+		if other, err = stablehlo.Convert(other, dtype); err != nil {
+			return nil, err
+		}
+	}
+	result, err := stablehlo.DotGeneral(ct, ctOtherFreeAxes, ctBatchAxes, other, otherFreeAxes, otherBatchAxes).Done()
+	if err != nil {
+		return nil, err
+	}
+
+	// resultAxes[i] is the target axis of the result axis i.
+	resultAxes := slices.Concat(targetBatchAxes, targetFreeAxes)
+	sortedOtherContracting := slices.Sorted(slices.Values(otherContractingAxes))
+	for _, otherAxis := range sortedOtherContracting {
+		resultAxes = append(resultAxes, targetContractingAxes[slices.Index(otherContractingAxes, otherAxis)])
+	}
+	permutation := make([]int, len(resultAxes))
+	for resultAxis, targetAxis := range resultAxes {
+		permutation[targetAxis] = resultAxis
+	}
+	if slices.IsSorted(permutation) {
+		return result, nil
+	}
+	return stablehlo.Transpose(result, permutation...)
+}
+
+// freeAxes returns the axes of an operand of DotGeneral that are neither batch nor contracting axes.
+func freeAxes(rank int, batchAxes, contractingAxes []int) []int {
+	var free []int
+	for axis := range rank {
+		if !slices.Contains(batchAxes, axis) && !slices.Contains(contractingAxes, axis) {
+			free = append(free, axis)
+		}
+	}
+	return free
+}
+
+// axesRange returns the axes from start to end (exclusive).
+func axesRange(start, end int) []int {
+	axes := make([]int, 0, end-start)
+	for axis := start; axis < end; axis++ {
+		axes = append(axes, axis)
+	}
+	return axes
+}
+
+// convConfig holds the attributes of a Convolution statement.
+type convConfig struct {
+	input, kernel, output                              attributes.ConvAxes
+	strides, paddings, inputDilations, kernelDilations []int
+}
+
+func parseConvConfig(stmt *stablehlo.Statement) (cfg convConfig, err error) {
+	if cfg.input, cfg.kernel, cfg.output, err = attributes.ConvDimensionNumbers(stmt, "dimension_numbers"); err != nil {
+		return
+	}
+	numSpatial := len(cfg.input.Spatial)
+	ones := slices.Repeat([]int{1}, numSpatial)
+	if cfg.strides, err = attributes.OptionalInts(stmt, "window_strides", ones); err != nil {
+		return
+	}
+	if cfg.paddings, err = attributes.OptionalInts(stmt, "padding", make([]int, 2*numSpatial)); err != nil {
+		return
+	}
+	if cfg.inputDilations, err = attributes.OptionalInts(stmt, "lhs_dilation", ones); err != nil {
+		return
+	}
+	if cfg.kernelDilations, err = attributes.OptionalInts(stmt, "rhs_dilation", ones); err != nil {
+		return
+	}
+	reversal, err := attributes.OptionalInts(stmt, "window_reversal", make([]int, numSpatial))
+	if err != nil {
+		return
+	}
+	featureGroupCount, err := attributes.Int(stmt, "feature_group_count")
+	if err != nil {
+		return
+	}
+	batchGroupCount, err := attributes.Int(stmt, "batch_group_count")
+	if err != nil {
+		return
+	}
+	if featureGroupCount != 1 || batchGroupCount != 1 || slices.ContainsFunc(reversal, isPositive) {
+		err = errors.New("gradient of Convolution with feature or batch groups, or window reversal, not supported")
+	}
+	return
+}
+
+// dilatedSize returns the size of an axis of dimension dim dilated by dilation.
+func dilatedSize(dim, dilation int) int {
+	if dim == 0 {
+		return 0
+	}
+	return (dim-1)*dilation + 1
+}
+
+// vjpConvolution follows the transposition of the convolution: the cotangent of the input is the convolution of
+// the cotangent with the spatially reversed kernel, and the cotangent of the kernel is the convolution of the input
+// with the cotangent, with batch and feature axes swapped.
+func vjpConvolution(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	input, kernel := stmt.Inputs[0], stmt.Inputs[1]
+	cfg, err := parseConvConfig(stmt)
+	if err != nil {
+		return nil, err
+	}
+	ct := cts[0]
+	if ct.Shape().DType != input.Shape().DType {
+		if ct, err = stablehlo.Convert(ct, input.Shape().DType); err != nil {
+			return nil, err
+		}
+	}
+	numSpatial := len(cfg.input.Spatial)
+	inputPaddings := make([][2]int, numSpatial)
+	kernelPaddings := make([][2]int, numSpatial)
+	for i := range numSpatial {
+		inputSize := dilatedSize(input.Shape().Dimensions[cfg.input.Spatial[i]], cfg.inputDilations[i])
+		kernelSize := dilatedSize(kernel.Shape().Dimensions[cfg.kernel.Spatial[i]], cfg.kernelDilations[i])
+		outputSize := dilatedSize(ct.Shape().Dimensions[cfg.output.Spatial[i]], cfg.strides[i])
+		low := cfg.paddings[2*i]
+		before := kernelSize - low - 1
+		inputPaddings[i] = [2]int{before, inputSize + kernelSize - 1 - outputSize - before}
+		kernelPaddings[i] = [2]int{low, outputSize - inputSize + kernelSize - low - 1}
+	}
+
+	var inputCt, kernelCt *stablehlo.Value
+	if isDifferentiable(input) {
+		reversedKernel, err := stablehlo.Reverse(kernel, cfg.kernel.Spatial...)
+		if err != nil {
+			return nil, err
+		}
+		inputCt, err = stablehlo.Convolution(ct, reversedKernel,
+			cfg.inputDilations, inputPaddings, cfg.strides, cfg.kernelDilations,
+			cfg.output.BatchOrInput, cfg.output.FeatureOrOutput, cfg.output.Spatial,
+			cfg.kernel.FeatureOrOutput, cfg.kernel.BatchOrInput, cfg.kernel.Spatial,
+			cfg.input.BatchOrInput, cfg.input.FeatureOrOutput, cfg.input.Spatial,
+			1, 1, types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isDifferentiable(kernel) {
+		kernelCt, err = stablehlo.Convolution(input, ct,
+			cfg.kernelDilations, kernelPaddings, cfg.inputDilations, cfg.strides,
+			cfg.input.FeatureOrOutput, cfg.input.BatchOrInput, cfg.input.Spatial,
+			cfg.output.BatchOrInput, cfg.output.FeatureOrOutput, cfg.output.Spatial,
+			cfg.kernel.BatchOrInput, cfg.kernel.FeatureOrOutput, cfg.kernel.Spatial,
+			1, 1, types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []*stablehlo.Value{inputCt, kernelCt}, nil
+}
+
+// batchNormConfig returns the epsilon and feature axis attributes of a batch normalization statement.
+func batchNormConfig(stmt *stablehlo.Statement) (epsilon float64, featureAxis int, err error) {
+	if epsilon, err = attributes.Float(stmt, "epsilon"); err != nil {
+		return
+	}
+	featureAxis, err = attributes.Int(stmt, "feature_index")
+	return
+}
+
+// vjpBatchNormTraining uses BatchNormGradient. Gradients flowing through the batch mean and variance outputs are
+// not supported.
+func vjpBatchNormTraining(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	if cts[1] != nil || cts[2] != nil {
+		return nil, errors.New("gradient of BatchNormTraining through the batch mean or variance not supported")
+	}
+	epsilon, featureAxis, err := batchNormConfig(stmt)
+	if err != nil {
+		return nil, err
+	}
+	operandCt, scaleCt, offsetCt, err := stablehlo.BatchNormGradient(stmt.Inputs[0], stmt.Inputs[1],
+		stmt.Outputs[1], stmt.Outputs[2], cts[0], float32(epsilon), featureAxis)
+	return []*stablehlo.Value{operandCt, scaleCt, offsetCt}, err
+}
+
+// vjpBatchNormInference differentiates y = (x - mean) * scale / sqrt(variance + epsilon) + offset, where scale,
+// offset, mean and variance are broadcast along the feature axis.
+func vjpBatchNormInference(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) (
+	[]*stablehlo.Value, error) {
+	operand, scale, mean, variance := stmt.Inputs[0], stmt.Inputs[1], stmt.Inputs[3], stmt.Inputs[4]
+	epsilon, featureAxis, err := batchNormConfig(stmt)
+	if err != nil {
+		return nil, err
+	}
+	shape := operand.Shape()
+	broadcast := func(v *stablehlo.Value) (*stablehlo.Value, error) {
+		return stablehlo.BroadcastInDim(v, shape, []int{featureAxis})
+	}
+	var otherAxes []int
+	for axis := range shape.Rank() {
+		if axis != featureAxis {
+			otherAxes = append(otherAxes, axis)
+		}
+	}
+
+	// invStdDev = 1 / sqrt(variance + epsilon)
+	varianceEps, err := addScalar(g, variance, epsilon)
+	if err != nil {
+		return nil, err
+	}
+	invStdDev, err := stablehlo.Rsqrt(varianceEps)
+	if err != nil {
+		return nil, err
+	}
+	scaleInvStdDev, err := stablehlo.Multiply(scale, invStdDev)
+	if err != nil {
+		return nil, err
+	}
+	scaleInvStdDevB, err := broadcast(scaleInvStdDev)
+	if err != nil {
+		return nil, err
+	}
+	operandCt, err := stablehlo.Multiply(cts[0], scaleInvStdDevB)
+	if err != nil {
+		return nil, err
+	}
+	offsetCt, err := g.reduceSum(cts[0], otherAxes...)
+	if err != nil {
+		return nil, err
+	}
+	meanCt, err := scaledProduct(g, -1, offsetCt, scaleInvStdDev)
+	if err != nil {
+		return nil, err
+	}
+
+	// sum(ct * (x - mean)) over the non-feature axes.
+	meanB, err := broadcast(mean)
+	if err != nil {
+		return nil, err
+	}
+	centered, err := stablehlo.Subtract(operand, meanB)
+	if err != nil {
+		return nil, err
+	}
+	ctCentered, err := stablehlo.Multiply(cts[0], centered)
+	if err != nil {
+		return nil, err
+	}
+	ctCenteredSum, err := g.reduceSum(ctCentered, otherAxes...)
+	if err != nil {
+		return nil, err
+	}
+	scaleCt, err := stablehlo.Multiply(ctCenteredSum, invStdDev)
+	if err != nil {
+		return nil, err
+	}
+	// d/dvariance = -0.5 * scale * sum(ct * (x - mean)) * invStdDev^3
+	varianceCt, err := scaledProduct(g, -0.5, scaleCt, scaleInvStdDev)
+	if err != nil {
+		return nil, err
+	}
+	if varianceCt, err = stablehlo.Multiply(varianceCt, invStdDev); err != nil {
+		return nil, err
+	}
+	return []*stablehlo.Value{operandCt, scaleCt, offsetCt, meanCt, varianceCt}, nil
+}
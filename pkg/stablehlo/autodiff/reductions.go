@@ -0,0 +1,240 @@
+package autodiff
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	vjpRules[optypes.Reduce] = vjpReduce
+	vjpRules[optypes.ReduceWindow] = vjpReduceWindow
+}
+
+// vjpReduce supports reductions of a single input by sum, product, max or min. For max and min, the cotangent is
+// split evenly among the tied maximum (or minimum) values.
+func vjpReduce(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	if len(stmt.Inputs) != 2 {
+		return nil, errors.Errorf("gradient of Reduce with %d inputs not supported", len(stmt.Inputs)/2)
+	}
+	x, init, y := stmt.Inputs[0], stmt.Inputs[1], stmt.Outputs[0]
+	shape := x.Shape()
+	reducedAxes, err := attributes.Ints(stmt, "dimensions")
+	if err != nil {
+		return nil, err
+	}
+	var keptAxes []int
+	for axis := range shape.Rank() {
+		if !slices.Contains(reducedAxes, axis) {
+			keptAxes = append(keptAxes, axis)
+		}
+	}
+	broadcast := func(v *stablehlo.Value) (*stablehlo.Value, error) {
+		return stablehlo.BroadcastInDim(v, shape, keptAxes)
+	}
+	ctB, err := broadcast(cts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var xCt, initCt *stablehlo.Value
+	switch op := updateOp(stmt.FunctionParameters[0]); op {
+	case optypes.Add:
+		xCt = ctB
+		if g.needed[init.String()] {
+			if initCt, err = g.reduceSumAll(cts[0]); err != nil {
+				return nil, err
+			}
+		}
+
+	case optypes.Multiply:
+		// d/dx_i = y / x_i, and d/dinit = y / init.
+		ctY, err := stablehlo.Multiply(cts[0], y)
+		if err != nil {
+			return nil, err
+		}
+		ctYB, err := broadcast(ctY)
+		if err != nil {
+			return nil, err
+		}
+		if xCt, err = stablehlo.Divide(ctYB, x); err != nil {
+			return nil, err
+		}
+		if g.needed[init.String()] {
+			ctYSum, err := g.reduceSumAll(ctY)
+			if err != nil {
+				return nil, err
+			}
+			if initCt, err = stablehlo.Divide(ctYSum, init); err != nil {
+				return nil, err
+			}
+		}
+
+	case optypes.Maximum, optypes.Minimum:
+		if g.needed[init.String()] {
+			return nil, errors.Errorf("gradient of Reduce by %s with respect to the initial value not supported", op)
+		}
+		yB, err := broadcast(y)
+		if err != nil {
+			return nil, err
+		}
+		isSelected, err := stablehlo.Compare(x, yB, types.CompareEQ, types.CompareFloat)
+		if err != nil {
+			return nil, err
+		}
+		selected, err := stablehlo.Convert(isSelected, shape.DType)
+		if err != nil {
+			return nil, err
+		}
+		count, err := g.reduceSum(selected, reducedAxes...)
+		if err != nil {
+			return nil, err
+		}
+		ctPerSelected, err := stablehlo.Divide(cts[0], count)
+		if err != nil {
+			return nil, err
+		}
+		if ctB, err = broadcast(ctPerSelected); err != nil {
+			return nil, err
+		}
+		if xCt, err = stablehlo.Multiply(ctB, selected); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("gradient of Reduce only supported for sum, product, max or min reductions")
+	}
+	return []*stablehlo.Value{xCt, initCt}, nil
+}
+
+// reduceWindowConfig holds the attributes of a ReduceWindow statement.
+type reduceWindowConfig struct {
+	dimensions, strides []int
+	paddings            [][2]int
+}
+
+func parseReduceWindowConfig(stmt *stablehlo.Statement) (cfg reduceWindowConfig, err error) {
+	rank := stmt.Inputs[0].Shape().Rank()
+	ones := slices.Repeat([]int{1}, rank)
+	if cfg.dimensions, err = attributes.Ints(stmt, "window_dimensions"); err != nil {
+		return
+	}
+	if cfg.strides, err = attributes.OptionalInts(stmt, "window_strides", ones); err != nil {
+		return
+	}
+	for _, key := range []string{"base_dilations", "window_dilations"} {
+		var dilations []int
+		if dilations, err = attributes.OptionalInts(stmt, key, ones); err != nil {
+			return
+		}
+		if !slices.Equal(dilations, ones) {
+			err = errors.New("gradient of ReduceWindow with dilations not supported")
+			return
+		}
+	}
+	paddings, err := attributes.OptionalInts(stmt, "padding", make([]int, 2*rank))
+	if err != nil {
+		return
+	}
+	cfg.paddings = make([][2]int, rank)
+	for axis := range rank {
+		cfg.paddings[axis] = [2]int{paddings[2*axis], paddings[2*axis+1]}
+	}
+	return
+}
+
+// vjpReduceWindow supports windowed sums, max and min (e.g.: pooling) of a single input, without dilations.
+func vjpReduceWindow(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	if len(stmt.Inputs) != 2 {
+		return nil, errors.Errorf("gradient of ReduceWindow with %d inputs not supported", len(stmt.Inputs)/2)
+	}
+	x, init := stmt.Inputs[0], stmt.Inputs[1]
+	shape := x.Shape()
+	cfg, err := parseReduceWindowConfig(stmt)
+	if err != nil {
+		return nil, err
+	}
+	zero, err := g.scalar(shape.DType, 0)
+	if err != nil {
+		return nil, err
+	}
+	addFn, err := g.scalarClosure(shape.DType, stablehlo.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	var xCt, initCt *stablehlo.Value
+	switch op := updateOp(stmt.FunctionParameters[0]); op {
+	case optypes.Add:
+		// Each input element receives the sum of the cotangents of the windows that include it: dilate the
+		// cotangent by the strides, pad it so that each input element is aligned with the windows that include it,
+		// and sum it over windows of the same size with stride 1.
+		rank := shape.Rank()
+		low, high, interior := make([]int, rank), make([]int, rank), make([]int, rank)
+		for axis := range rank {
+			window, stride := cfg.dimensions[axis], cfg.strides[axis]
+			dilatedCt := dilatedSize(cts[0].Shape().Dimensions[axis], stride)
+			interior[axis] = stride - 1
+			low[axis] = window - 1 - cfg.paddings[axis][0]
+			high[axis] = shape.Dimensions[axis] + window - 1 - dilatedCt - low[axis]
+		}
+		paddedCt, err := stablehlo.Pad(cts[0], zero, low, high, interior)
+		if err != nil {
+			return nil, err
+		}
+		if xCt, err = stablehlo.ReduceWindow(paddedCt, zero, addFn, cfg.dimensions, slices.Repeat([]int{1}, rank),
+			nil, nil, nil); err != nil {
+			return nil, err
+		}
+		if g.needed[init.String()] {
+			// The padding is filled with the initial value, so it is used once per window plus once per padded
+			// element in the window: count them by reducing zeros with 1 as the initial value.
+			zeros, err := g.zeros(shape)
+			if err != nil {
+				return nil, err
+			}
+			one, err := g.scalar(shape.DType, 1)
+			if err != nil {
+				return nil, err
+			}
+			uses, err := stablehlo.ReduceWindow(zeros, one, addFn, cfg.dimensions, cfg.strides, nil, nil, cfg.paddings)
+			if err != nil {
+				return nil, err
+			}
+			if initCt, err = stablehlo.Multiply(cts[0], uses); err != nil {
+				return nil, err
+			}
+			if initCt, err = g.reduceSumAll(initCt); err != nil {
+				return nil, err
+			}
+		}
+
+	case optypes.Maximum, optypes.Minimum:
+		if g.needed[init.String()] {
+			return nil, errors.Errorf("gradient of ReduceWindow by %s with respect to the initial value not supported",
+				op)
+		}
+		direction := types.CompareGE
+		if op == optypes.Minimum {
+			direction = types.CompareLE
+		}
+		selectFn, err := g.scalarClosure(shape.DType, func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error) {
+			return stablehlo.Compare(lhs, rhs, direction, types.CompareFloat)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if xCt, err = stablehlo.SelectAndScatter(x, cts[0], zero, selectFn, addFn,
+			cfg.dimensions, cfg.strides, cfg.paddings); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("gradient of ReduceWindow only supported for sum, max or min reductions")
+	}
+	return []*stablehlo.Value{xCt, initCt}, nil
+}
@@ -0,0 +1,389 @@
+package autodiff
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	vjpRules[optypes.Reshape] = vjpReshape
+	vjpRules[optypes.Transpose] = vjpTranspose
+	vjpRules[optypes.Reverse] = vjpReverse
+	vjpRules[optypes.BroadcastInDim] = vjpBroadcastInDim
+	vjpRules[optypes.Slice] = vjpSlice
+	vjpRules[optypes.Pad] = vjpPad
+	vjpRules[optypes.Concatenate] = vjpConcatenate
+	vjpRules[optypes.DynamicSlice] = vjpDynamicSlice
+	vjpRules[optypes.DynamicUpdateSlice] = vjpDynamicUpdateSlice
+	vjpRules[optypes.Gather] = vjpGather
+	vjpRules[optypes.Scatter] = vjpScatter
+}
+
+func vjpReshape(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	ct, err := stablehlo.Reshape(cts[0], stmt.Inputs[0].Shape())
+	return []*stablehlo.Value{ct}, err
+}
+
+func vjpTranspose(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	permutation, err := attributes.Ints(stmt, "permutation")
+	if err != nil {
+		return nil, err
+	}
+	inverse := make([]int, len(permutation))
+	for i, axis := range permutation {
+		inverse[axis] = i
+	}
+	ct, err := stablehlo.Transpose(cts[0], inverse...)
+	return []*stablehlo.Value{ct}, err
+}
+
+func vjpReverse(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	axes, err := attributes.Ints(stmt, "dimensions")
+	if err != nil {
+		return nil, err
+	}
+	ct, err := stablehlo.Reverse(cts[0], axes...)
+	return []*stablehlo.Value{ct}, err
+}
+
+// vjpBroadcastInDim sums the cotangent over the broadcast axes: the output axes not mapped to the operand, and
+// the ones mapped to operand axes of dimension 1 that were expanded.
+func vjpBroadcastInDim(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	operandShape, outputShape := stmt.Inputs[0].Shape(), stmt.Outputs[0].Shape()
+	axesMapping, err := attributes.Ints(stmt, "broadcast_dimensions")
+	if err != nil {
+		return nil, err
+	}
+	var reducedAxes, keptAxes []int
+	for outputAxis := range outputShape.Rank() {
+		operandAxis := slices.Index(axesMapping, outputAxis)
+		if operandAxis == -1 || operandShape.Dimensions[operandAxis] != outputShape.Dimensions[outputAxis] {
+			reducedAxes = append(reducedAxes, outputAxis)
+		} else {
+			keptAxes = append(keptAxes, outputAxis)
+		}
+	}
+	ct, err := g.reduceSum(cts[0], reducedAxes...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kept axes are in the order of the output: transpose them to the order of the operand, if needed.
+	permutation := make([]int, 0, len(keptAxes))
+	for _, outputAxis := range axesMapping {
+		if idx := slices.Index(keptAxes, outputAxis); idx != -1 {
+			permutation = append(permutation, idx)
+		}
+	}
+	if !slices.IsSorted(permutation) {
+		if ct, err = stablehlo.Transpose(ct, permutation...); err != nil {
+			return nil, err
+		}
+	}
+	ct, err = stablehlo.Reshape(ct, operandShape)
+	return []*stablehlo.Value{ct}, err
+}
+
+// vjpSlice pads the cotangent with zeros back to the shape of the operand.
+func vjpSlice(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	operandShape, outputShape := stmt.Inputs[0].Shape(), stmt.Outputs[0].Shape()
+	starts, err := attributes.Ints(stmt, "start_indices")
+	if err != nil {
+		return nil, err
+	}
+	strides, err := attributes.Ints(stmt, "strides")
+	if err != nil {
+		return nil, err
+	}
+	rank := operandShape.Rank()
+	if outputShape.Size() == 0 {
+		ct, err := g.zeros(operandShape)
+		return []*stablehlo.Value{ct}, err
+	}
+	high := make([]int, rank)
+	interior := make([]int, rank)
+	for axis := range rank {
+		interior[axis] = strides[axis] - 1
+		last := starts[axis] + (outputShape.Dimensions[axis]-1)*strides[axis]
+		high[axis] = operandShape.Dimensions[axis] - last - 1
+	}
+	zero, err := g.scalar(operandShape.DType, 0)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := stablehlo.Pad(cts[0], zero, starts, high, interior)
+	return []*stablehlo.Value{ct}, err
+}
+
+// vjpPad slices the cotangent of the operand out of the output cotangent. The cotangent of the fill value is
+// the sum of the cotangents of the padded positions.
+func vjpPad(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	operand := stmt.Inputs[0]
+	operandShape := operand.Shape()
+	low, err := attributes.Ints(stmt, "edge_padding_low")
+	if err != nil {
+		return nil, err
+	}
+	high, err := attributes.Ints(stmt, "edge_padding_high")
+	if err != nil {
+		return nil, err
+	}
+	interior, err := attributes.Ints(stmt, "interior_padding")
+	if err != nil {
+		return nil, err
+	}
+	if operandShape.Size() == 0 {
+		fillCt, err := g.reduceSumAll(cts[0])
+		return []*stablehlo.Value{nil, fillCt}, err
+	}
+
+	// Negative paddings removed elements of the operand: add them back as zeros first.
+	rank := operandShape.Rank()
+	restoreLow, restoreHigh := make([]int, rank), make([]int, rank)
+	starts, limits, strides := make([]int, rank), make([]int, rank), make([]int, rank)
+	for axis := range rank {
+		restoreLow[axis] = max(-low[axis], 0)
+		restoreHigh[axis] = max(-high[axis], 0)
+		strides[axis] = interior[axis] + 1
+		starts[axis] = max(low[axis], 0)
+		limits[axis] = starts[axis] + (operandShape.Dimensions[axis]-1)*strides[axis] + 1
+	}
+	ct := cts[0]
+	if slices.ContainsFunc(restoreLow, isPositive) || slices.ContainsFunc(restoreHigh, isPositive) {
+		zero, err := g.scalar(operandShape.DType, 0)
+		if err != nil {
+			return nil, err
+		}
+		if ct, err = stablehlo.Pad(ct, zero, restoreLow, restoreHigh, make([]int, rank)); err != nil {
+			return nil, err
+		}
+	}
+	operandCt, err := stablehlo.Slice(ct, starts, limits, strides)
+	if err != nil {
+		return nil, err
+	}
+
+	var fillCt *stablehlo.Value
+	if g.needed[stmt.Inputs[1].String()] {
+		totalCt, err := g.reduceSumAll(cts[0])
+		if err != nil {
+			return nil, err
+		}
+		operandTotalCt, err := g.reduceSumAll(operandCt)
+		if err != nil {
+			return nil, err
+		}
+		if fillCt, err = stablehlo.Subtract(totalCt, operandTotalCt); err != nil {
+			return nil, err
+		}
+	}
+	return []*stablehlo.Value{operandCt, fillCt}, nil
+}
+
+func isPositive(x int) bool { return x > 0 }
+
+// vjpConcatenate slices the cotangent of each operand.
+func vjpConcatenate(_ *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	axis, err := attributes.Int(stmt, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	outputShape := stmt.Outputs[0].Shape()
+	starts := make([]int, outputShape.Rank())
+	limits := slices.Clone(outputShape.Dimensions)
+	operandCts := make([]*stablehlo.Value, len(stmt.Inputs))
+	for i, operand := range stmt.Inputs {
+		limits[axis] = starts[axis] + operand.Shape().Dimensions[axis]
+		if isDifferentiable(operand) {
+			if operandCts[i], err = stablehlo.Slice(cts[0], starts, limits, nil); err != nil {
+				return nil, err
+			}
+		}
+		starts[axis] = limits[axis]
+	}
+	return operandCts, nil
+}
+
+// vjpDynamicSlice places the cotangent into zeros with the shape of the operand, at the same (clamped) position.
+func vjpDynamicSlice(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	operand, startIndices := stmt.Inputs[0], stmt.Inputs[1:]
+	zeros, err := g.zeros(operand.Shape())
+	if err != nil {
+		return nil, err
+	}
+	ct, err := stablehlo.DynamicUpdateSlice(zeros, cts[0], startIndices)
+	return []*stablehlo.Value{ct}, err
+}
+
+// vjpDynamicUpdateSlice: the operand gets the cotangent with zeros in the updated slice, and the update gets the
+// cotangent of the updated slice.
+func vjpDynamicUpdateSlice(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) (
+	[]*stablehlo.Value, error) {
+	update, startIndices := stmt.Inputs[1], stmt.Inputs[2:]
+	zeros, err := g.zeros(update.Shape())
+	if err != nil {
+		return nil, err
+	}
+	operandCt, err := stablehlo.DynamicUpdateSlice(cts[0], zeros, startIndices)
+	if err != nil {
+		return nil, err
+	}
+	updateCt, err := stablehlo.DynamicSlice(cts[0], startIndices, update.Shape().Dimensions)
+	return []*stablehlo.Value{operandCt, updateCt}, err
+}
+
+// gatherAxes are the dimension numbers shared by Gather and Scatter, using the Gather names.
+type gatherAxes struct {
+	offsetAxes, collapsedAxes, operandBatchingAxes, indicesBatchingAxes, startIndexMap []int
+	indexVectorAxis                                                                    int
+}
+
+// parseGatherAxes parses the dimension numbers attribute key of a Gather (isScatter=false) or Scatter
+// (isScatter=true) statement.
+func parseGatherAxes(stmt *stablehlo.Statement, key string, isScatter bool) (axes gatherAxes, err error) {
+	fields, err := attributes.Struct(stmt, key)
+	if err != nil {
+		return
+	}
+	names := []string{"offset_dims", "collapsed_slice_dims", "operand_batching_dims",
+		"start_indices_batching_dims", "start_index_map"}
+	if isScatter {
+		names = []string{"update_window_dims", "inserted_window_dims", "input_batching_dims",
+			"scatter_indices_batching_dims", "scatter_dims_to_operand_dims"}
+	}
+	targets := []*[]int{&axes.offsetAxes, &axes.collapsedAxes, &axes.operandBatchingAxes,
+		&axes.indicesBatchingAxes, &axes.startIndexMap}
+	for i, name := range names {
+		if *targets[i], err = attributes.StructInts(fields, name); err != nil {
+			return
+		}
+	}
+	axes.indexVectorAxis, err = attributes.StructInt(fields, "index_vector_dim")
+	return
+}
+
+// vjpGather scatter-adds the cotangent into zeros with the shape of the operand.
+func vjpGather(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	operand, indices := stmt.Inputs[0], stmt.Inputs[1]
+	axes, err := parseGatherAxes(stmt, "dimension_numbers", false)
+	if err != nil {
+		return nil, err
+	}
+	sorted, err := attributes.OptionalBool(stmt, "indices_are_sorted", false)
+	if err != nil {
+		return nil, err
+	}
+	zeros, err := g.zeros(operand.Shape())
+	if err != nil {
+		return nil, err
+	}
+	addFn, err := g.scalarClosure(operand.Shape().DType, stablehlo.Add)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := stablehlo.Scatter(zeros, indices, cts[0],
+		axes.offsetAxes, axes.collapsedAxes, axes.operandBatchingAxes, axes.indicesBatchingAxes,
+		axes.startIndexMap, axes.indexVectorAxis, sorted, false, addFn)
+	return []*stablehlo.Value{ct}, err
+}
+
+// vjpScatter supports scatters that add the updates or replace the input values with them. The cotangent of the
+// updates is gathered from the output cotangent.
+func vjpScatter(g *gradient, stmt *stablehlo.Statement, cts []*stablehlo.Value) ([]*stablehlo.Value, error) {
+	if len(stmt.Inputs) != 3 {
+		return nil, errors.Errorf("gradient of Scatter with %d inputs not supported", (len(stmt.Inputs)-1)/2)
+	}
+	input, indices, updates := stmt.Inputs[0], stmt.Inputs[1], stmt.Inputs[2]
+	axes, err := parseGatherAxes(stmt, "scatter_dimension_numbers", true)
+	if err != nil {
+		return nil, err
+	}
+	sorted, err := attributes.OptionalBool(stmt, "indices_are_sorted", false)
+	if err != nil {
+		return nil, err
+	}
+	unique, err := attributes.OptionalBool(stmt, "unique_indices", false)
+	if err != nil {
+		return nil, err
+	}
+
+	inputCt := cts[0]
+	switch updateOp(stmt.FunctionParameters[0]) {
+	case optypes.Add:
+	case optypes.Invalid:
+		// Replaced values get no gradient: scatter zeros into the cotangent.
+		zeros, err := g.zeros(updates.Shape())
+		if err != nil {
+			return nil, err
+		}
+		replaceFn, err := g.scalarClosure(input.Shape().DType, func(_, rhs *stablehlo.Value) (*stablehlo.Value, error) {
+			return rhs, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if inputCt, err = stablehlo.Scatter(cts[0], indices, zeros,
+			axes.offsetAxes, axes.collapsedAxes, axes.operandBatchingAxes, axes.indicesBatchingAxes,
+			axes.startIndexMap, axes.indexVectorAxis, sorted, unique, replaceFn); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("gradient of Scatter only supported for updates that add or replace values")
+	}
+
+	var updatesCt *stablehlo.Value
+	if g.needed[updates.String()] {
+		// The update window axes map, in order, to the input axes that are neither inserted nor batching.
+		sliceSizes := make([]int, input.Shape().Rank())
+		windowAxis := 0
+		for axis := range sliceSizes {
+			if slices.Contains(axes.collapsedAxes, axis) || slices.Contains(axes.operandBatchingAxes, axis) {
+				sliceSizes[axis] = 1
+				continue
+			}
+			sliceSizes[axis] = updates.Shape().Dimensions[axes.offsetAxes[windowAxis]]
+			windowAxis++
+		}
+		if updatesCt, err = stablehlo.Gather(cts[0], indices, axes.indexVectorAxis,
+			axes.offsetAxes, axes.collapsedAxes, axes.operandBatchingAxes, axes.indicesBatchingAxes,
+			axes.startIndexMap, sliceSizes, sorted); err != nil {
+			return nil, err
+		}
+	}
+	return []*stablehlo.Value{inputCt, nil, updatesCt}, nil
+}
+
+// updateOp returns the op of a closure that combines two scalars with a single binary op (e.g.: the reduction
+// function of a Reduce), or optypes.Invalid if it returns its second input (a replacement).
+// It returns optypes.Last for other closures.
+func updateOp(closure *stablehlo.Function) optypes.OpType {
+	if len(closure.Inputs) != 2 || len(closure.Statements) == 0 {
+		return optypes.Last
+	}
+	returnStmt := closure.Statements[len(closure.Statements)-1]
+	if returnStmt.OpType != optypes.FuncReturn || len(returnStmt.Inputs) != 1 {
+		return optypes.Last
+	}
+	lhs, rhs := closure.Inputs[0].String(), closure.Inputs[1].String()
+	result := returnStmt.Inputs[0].String()
+	switch len(closure.Statements) {
+	case 1:
+		if result == rhs {
+			return optypes.Invalid
+		}
+	case 2:
+		stmt := closure.Statements[0]
+		if len(stmt.Inputs) != 2 || stmt.Outputs[0].String() != result {
+			return optypes.Last
+		}
+		a, b := stmt.Inputs[0].String(), stmt.Inputs[1].String()
+		if (a == lhs && b == rhs) || (a == rhs && b == lhs) {
+			return stmt.OpType
+		}
+	}
+	return optypes.Last
+}
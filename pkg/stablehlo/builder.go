@@ -137,7 +137,10 @@ func (b *Builder) Write(writer io.Writer) error {
 	}
 
 	// Write module header
-	w("module @%s", NormalizeIdentifier(b.name))
+	w("module")
+	if b.name != "" {
+		w(" @%s", NormalizeIdentifier(b.name))
+	}
 	attrs := b.getModuleAttributes()
 	if len(attrs) > 0 {
 		w(" attributes {")
@@ -208,27 +211,22 @@ func (b *Builder) Build() ([]byte, error) {
 // It uses the config if provided (for MPMD), or the builder's internal
 // counter if not (for SPMD).
 func (b *Builder) getChannelHandle(config *types.CollectiveConfig) literalStr {
-	var id int
-	var typ int64
-
+	typ := int64(types.CrossReplica) // Default for the simple SPMD case.
 	if config != nil {
 		typ = int64(config.ChannelType) // Use specified type
-		if config.ChannelID != nil {
-			// Manual ID provided (MPMD case)
-			id = *config.ChannelID
-		} else {
-			// Automatic ID (SPMD case)
-			id = b.nextChannelID
-			b.nextChannelID++
-		}
-	} else {
-		// Defaults for the simple SPMD case.
-		typ = int64(types.CrossReplica)
-		id = b.nextChannelID
-		b.nextChannelID++
 	}
+	return literalStrF("#stablehlo.channel_handle<handle = %d, type = %d>", b.getChannelID(config), typ)
+}
 
-	return literalStrF("#stablehlo.channel_handle<handle = %d, type = %d>", id, typ)
+// getChannelID returns the ChannelID set in the config, if any (MPMD case), or otherwise
+// a new unique ID from the builder's internal counter (SPMD case).
+func (b *Builder) getChannelID(config *types.CollectiveConfig) int {
+	if config != nil && config.ChannelID != nil {
+		return *config.ChannelID
+	}
+	id := b.nextChannelID
+	b.nextChannelID++
+	return id
 }
 
 // WithNumReplicas sets the number of replicas (for data parallelism).
@@ -89,3 +89,75 @@ func TestCall(t *testing.T) {
 		}
 	})
 }
+
+func TestComposite(t *testing.T) {
+	b := New(t.Name())
+
+	// Decomposition: f(x, bias) = x + bias
+	decomposition := b.NewFunction("my.add_bias.impl")
+	x := must1(decomposition.Input(shapes.Make(dtypes.F32, 3)))
+	bias := must1(decomposition.Input(shapes.Make(dtypes.F32, 3)))
+	if err := decomposition.Return(must1(Add(x, bias))); err != nil {
+		t.Fatalf("decomposition.Return: %v", err)
+	}
+
+	fn := b.Main()
+	x = must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+	bias = must1(fn.NamedInput("bias", shapes.Make(dtypes.F32, 3)))
+	results, err := Composite("my.add_bias", map[string]any{"approximate": true}, decomposition, x, bias)
+	if err != nil {
+		t.Fatalf("Composite: %v", err)
+	}
+	if len(results) != 1 || !results[0].Shape().Equal(shapes.Make(dtypes.F32, 3)) {
+		t.Fatalf("expected one result of shape (Float32)[3], got %v", results)
+	}
+
+	// Errors:
+	if _, err := Composite("", nil, decomposition, x, bias); err == nil {
+		t.Error("expected error for Composite without a name, got nil")
+	}
+	if _, err := Composite("my.add_bias", nil, decomposition, x); err == nil {
+		t.Error("expected error for Composite with the wrong number of operands, got nil")
+	}
+	notReturned := b.NewFunction("not_returned")
+	if _, err := Composite("my.add_bias", nil, notReturned, x, bias); err == nil {
+		t.Error("expected error for Composite with a decomposition that hasn't returned, got nil")
+	}
+	if _, err := Composite("my.add_bias", map[string]any{"a": struct{}{}}, decomposition, x, bias); err == nil {
+		t.Error("expected error for Composite with unsupported attribute, got nil")
+	}
+	if err := notReturned.Return(must1(notReturned.ConstantFromScalar(float32(0)))); err != nil {
+		t.Fatalf("notReturned.Return: %v", err)
+	}
+
+	if err := fn.Return(results[0]); err != nil {
+		t.Fatalf("fn.Return: %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestComposite {
+  func.func @my_add_bias_impl(%arg0: tensor<3xf32>, %arg1: tensor<3xf32>) -> tensor<3xf32> {
+    %0 = "stablehlo.add"(%arg0, %arg1) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    "stablehlo.return"(%0) : (tensor<3xf32>) -> ()
+  }
+
+  func.func @main(%x: tensor<3xf32>, %bias: tensor<3xf32>) -> tensor<3xf32> {
+    %0 = "stablehlo.composite"(%x, %bias) {
+      composite_attributes = {approximate = true},
+      decomposition = @my_add_bias_impl,
+      name = "my.add_bias"
+    } : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    "stablehlo.return"(%0) : (tensor<3xf32>) -> ()
+  }
+
+  func.func @not_returned() -> tensor<f32> {
+    %0 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    "stablehlo.return"(%0) : (tensor<f32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+}
@@ -0,0 +1,110 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestCase(t *testing.T) {
+	t.Run("three branches with parent values", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		index := must1(fn.NamedInput("index", shapes.Make(dtypes.Int32)))
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+
+		negateBranch := fn.Closure()
+		if err := negateBranch.Return(must1(Negate(must1(negateBranch.UseParentValue(x))))); err != nil {
+			t.Fatalf("negateBranch.Return: %v", err)
+		}
+		identityBranch := fn.Closure()
+		if err := identityBranch.Return(must1(identityBranch.UseParentValue(x))); err != nil {
+			t.Fatalf("identityBranch.Return: %v", err)
+		}
+		doubleBranch := fn.Closure()
+		xInBranch := must1(doubleBranch.UseParentValue(x))
+		if err := doubleBranch.Return(must1(Add(xInBranch, xInBranch))); err != nil {
+			t.Fatalf("doubleBranch.Return: %v", err)
+		}
+
+		results, err := Case(index, negateBranch, identityBranch, doubleBranch)
+		if err != nil {
+			t.Fatalf("Case: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		if !results[0].Shape().Equal(x.Shape()) {
+			t.Fatalf("expected result shape %s, got %s", x.Shape(), results[0].Shape())
+		}
+		if err := fn.Return(results[0]); err != nil {
+			t.Fatalf("fn.Return: %v", err)
+		}
+
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s\n", t.Name(), program)
+		want := `module @TestCase_three_branches_with_parent_values {
+  func.func @main(%index: tensor<i32>, %x: tensor<3xf32>) -> tensor<3xf32> {
+    %2 = "stablehlo.case"(%index) ({
+      ^branch0() :
+          %0 = "stablehlo.negate"(%x) : (tensor<3xf32>) -> tensor<3xf32>
+          "stablehlo.return"(%0) : (tensor<3xf32>) -> ()
+    }, {
+      ^branch1() :
+          "stablehlo.return"(%x) : (tensor<3xf32>) -> ()
+    }, {
+      ^branch2() :
+          %1 = "stablehlo.add"(%x, %x) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+          "stablehlo.return"(%1) : (tensor<3xf32>) -> ()
+    }) : (tensor<i32>) -> tensor<3xf32>
+    "stablehlo.return"(%2) : (tensor<3xf32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		index := must1(fn.ConstantFromScalar(int32(0)))
+
+		// No branches.
+		if _, err := Case(index); err == nil {
+			t.Error("expected error for Case without branches")
+		}
+
+		// Branch not a closure of fn.
+		other := b.NewFunction("other")
+		if err := other.Return(must1(other.ConstantFromScalar(float32(1)))); err != nil {
+			t.Fatalf("other.Return: %v", err)
+		}
+		if _, err := Case(index, other); err == nil {
+			t.Error("expected error for branch that is not a closure")
+		}
+
+		// Mismatched branch outputs.
+		branch0 := fn.Closure()
+		if err := branch0.Return(must1(branch0.ConstantFromScalar(float32(1)))); err != nil {
+			t.Fatalf("branch0.Return: %v", err)
+		}
+		branch1 := fn.Closure()
+		if err := branch1.Return(must1(branch1.ConstantFromScalar(int32(1)))); err != nil {
+			t.Fatalf("branch1.Return: %v", err)
+		}
+		if _, err := Case(index, branch0, branch1); err == nil {
+			t.Error("expected error for branches with different output dtypes")
+		}
+
+		// Index not int32.
+		floatIndex := must1(fn.ConstantFromScalar(float32(0)))
+		if _, err := Case(floatIndex, branch0); err == nil {
+			t.Error("expected error for non-int32 index")
+		}
+	})
+}
@@ -153,6 +153,63 @@ func AllReduce(operands []*Value, replicaGroups [][]int, computation *Function,
 	return stmt.Outputs, nil
 }
 
+// ReduceScatter reduces the operand across the replicas of each group, like AllReduce, and then splits
+// the result along scatterDimension into one part per replica of the group: each replica gets its part.
+//
+//   - operand: The tensor from the *local* replica to be reduced.
+//   - replicaGroups: A 2D array defining the communicating device groups, e.g., `[[0, 1, 2, 3]]`. All groups
+//     must have the same size, and the dimension of the operand along scatterDimension must be divisible by it.
+//   - scatterDimension: The dimension along which to split the reduced result.
+//   - computation: A closure function that defines the reduction operation (e.g., SUM). It must
+//     take two scalar inputs of the operand's dtype and return one scalar output of the same dtype.
+//   - config: Optional configuration of the channels to be used.
+//
+// Consider using Builder.WithShardy for distributed computation instead: other forms of distributed
+// (collective) computation across devices are not tested and may not work.
+func ReduceScatter(operand *Value, replicaGroups [][]int, scatterDimension int, computation *Function,
+	config ...*types.CollectiveConfig) (*Value, error) {
+	op := optypes.ReduceScatter
+	fn := operand.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q", op, fn.Name)
+	}
+	if computation.Parent != fn {
+		return nil, errors.Errorf(
+			"cannot add operation %s because computation is not a StableHLO closure of %s",
+			op, fn.Name)
+	}
+
+	outputShape, err := shapeinference.ReduceScatter(
+		operand.shape,
+		valuesToShapes(computation.Inputs),
+		valuesToShapes(computation.Outputs),
+		replicaGroups, scatterDimension)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg *types.CollectiveConfig
+	if len(config) > 1 {
+		return nil, errors.Errorf("only one config can be provided, got %d", len(config))
+	} else if len(config) == 1 {
+		cfg = config[0]
+	}
+
+	stmt := fn.addOp(op, outputShape, operand)
+	stmt.Attributes = map[string]any{
+		"replica_groups":    formatReplicaGroups(replicaGroups),
+		"scatter_dimension": int64(scatterDimension),
+	}
+	if cfg != nil {
+		stmt.Attributes["channel_handle"] = fn.Builder.getChannelHandle(cfg)
+	}
+	if cfg != nil && cfg.UseGlobalDeviceIDs {
+		stmt.Attributes["use_global_device_ids"] = true
+	}
+	stmt.AddFunctionParameter("computation", computation)
+	return stmt.Outputs[0], nil
+}
+
 // AllGather concatenates the operand from each replica along a specified dimension.
 //
 //   - operand: The tensor from the *local* replica to be gathered.
@@ -0,0 +1,294 @@
+package stablehlo
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// CustomCallAPIVersion is the version of the calling convention used by the custom call target.
+// See https://openxla.org/xla/custom_call.
+type CustomCallAPIVersion int
+
+const (
+	// CustomCallAPIVersionUnspecified lets the compiler pick the default.
+	CustomCallAPIVersionUnspecified CustomCallAPIVersion = 0
+
+	// CustomCallAPIVersionOriginal is the original (deprecated) calling convention.
+	CustomCallAPIVersionOriginal CustomCallAPIVersion = 1
+
+	// CustomCallAPIVersionStatusReturning is the calling convention where the target can return an error status.
+	CustomCallAPIVersionStatusReturning CustomCallAPIVersion = 2
+
+	// CustomCallAPIVersionStatusReturningUnified is like CustomCallAPIVersionStatusReturning, with a unified
+	// signature across platforms.
+	CustomCallAPIVersionStatusReturningUnified CustomCallAPIVersion = 3
+
+	// CustomCallAPIVersionTypedFFI is the XLA FFI calling convention: the backend config is a dictionary of
+	// typed attributes, decoded by the handler. This is the one used by handlers registered with
+	// pjrt.Plugin.RegisterFFIHandler, and the default.
+	CustomCallAPIVersionTypedFFI CustomCallAPIVersion = 4
+)
+
+// CustomCallOutputOperandAlias specifies that an output of the custom call aliases (shares the buffer with)
+// an operand.
+type CustomCallOutputOperandAlias struct {
+	// OutputTupleIndices is the index of the output, if the custom call has more than one output,
+	// or empty if it has only one.
+	OutputTupleIndices []int
+
+	// OperandIndex is the index of the operand aliased.
+	OperandIndex int
+
+	// OperandTupleIndices is the index within the operand, if the operand is a tuple, or empty otherwise.
+	OperandTupleIndices []int
+}
+
+// CustomCallOptions holds the optional configuration of a CustomCall.
+type CustomCallOptions struct {
+	// APIVersion of the custom call target. If left unspecified, it defaults to CustomCallAPIVersionTypedFFI.
+	APIVersion CustomCallAPIVersion
+
+	// BackendConfig is an opaque string passed to the custom call target, for API versions before
+	// CustomCallAPIVersionTypedFFI.
+	BackendConfig string
+
+	// TypedBackendConfig is the dictionary of attributes passed to a CustomCallAPIVersionTypedFFI target.
+	//
+	// Supported values are strings, bool, Go numbers (rendered with their corresponding dtype),
+	// slices of numbers or bool (rendered as arrays) and nested map[string]any dictionaries.
+	TypedBackendConfig map[string]any
+
+	// HasSideEffect prevents the compiler from removing or de-duplicating the call.
+	HasSideEffect bool
+
+	// OperandLayouts and ResultLayouts are the minor-to-major layout of each operand and of each result.
+	// They must either be both set or both left empty (the default layouts are used).
+	OperandLayouts, ResultLayouts [][]int
+
+	// OutputOperandAliases lists the outputs that reuse the buffers of operands.
+	OutputOperandAliases []CustomCallOutputOperandAlias
+}
+
+// CustomCall calls the targetName, a function registered in the backend (e.g.: with pjrt.Plugin.RegisterFFIHandler)
+// on the operands, and returns values of the given outputShapes.
+//
+// The fn is the function where to add the custom call: operands must be from fn or from one of its parents
+// (if fn is a closure). It is required because operands can be empty.
+//
+// opts can be nil, in which case the defaults are used.
+//
+// See https://openxla.org/stablehlo/spec#custom_call and https://openxla.org/xla/custom_call.
+func CustomCall(fn *Function, targetName string, operands []*Value, outputShapes []shapes.Shape,
+	opts *CustomCallOptions) ([]*Value, error) {
+	op := optypes.CustomCall
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if targetName == "" {
+		return nil, errors.Errorf("%s requires a target name", op)
+	}
+	for i, operand := range operands {
+		if !isAncestor(operand.fn, fn) {
+			return nil, errors.Errorf("cannot add operation %s to function %q, because operand #%d is from function %q",
+				op, fn.Name, i, operand.fn.Name)
+		}
+	}
+	if opts == nil {
+		opts = &CustomCallOptions{}
+	}
+	apiVersion := opts.APIVersion
+	if apiVersion == CustomCallAPIVersionUnspecified {
+		apiVersion = CustomCallAPIVersionTypedFFI
+	}
+
+	attributes := map[string]any{
+		"call_target_name": targetName,
+		"api_version":      int32(apiVersion),
+	}
+	if opts.HasSideEffect {
+		attributes["has_side_effect"] = true
+	}
+
+	// Backend config: an opaque string, or a dictionary for the typed FFI.
+	if apiVersion == CustomCallAPIVersionTypedFFI {
+		if opts.BackendConfig != "" {
+			return nil, errors.Errorf("%s with the typed FFI API version must use TypedBackendConfig instead of BackendConfig", op)
+		}
+		if opts.TypedBackendConfig != nil {
+			config, err := dictionaryToStableHLO(opts.TypedBackendConfig)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "in %s TypedBackendConfig", op)
+			}
+			attributes["backend_config"] = config
+		}
+	} else {
+		if opts.TypedBackendConfig != nil {
+			return nil, errors.Errorf("%s TypedBackendConfig requires the typed FFI API version, got api version %d",
+				op, apiVersion)
+		}
+		if opts.BackendConfig != "" {
+			attributes["backend_config"] = opts.BackendConfig
+		}
+	}
+
+	// Layouts:
+	if (opts.OperandLayouts == nil) != (opts.ResultLayouts == nil) {
+		return nil, errors.Errorf("%s requires both OperandLayouts and ResultLayouts to be set, or neither", op)
+	}
+	if opts.OperandLayouts != nil {
+		operandShapes := valuesToShapes(operands)
+		layouts, err := layoutsToStableHLO(opts.OperandLayouts, operandShapes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "in %s OperandLayouts", op)
+		}
+		attributes["operand_layouts"] = layouts
+		layouts, err = layoutsToStableHLO(opts.ResultLayouts, outputShapes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "in %s ResultLayouts", op)
+		}
+		attributes["result_layouts"] = layouts
+	}
+
+	// Aliases:
+	if len(opts.OutputOperandAliases) > 0 {
+		var sb strings.Builder
+		sb.WriteString("[")
+		for i, alias := range opts.OutputOperandAliases {
+			if alias.OperandIndex < 0 || alias.OperandIndex >= len(operands) {
+				return nil, errors.Errorf("%s output operand alias #%d refers to operand %d, but there are only %d operands",
+					op, i, alias.OperandIndex, len(operands))
+			}
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "#stablehlo.output_operand_alias<output_tuple_indices = %s, operand_index = %d, operand_tuple_indices = %s>",
+				intSliceToStableHLO(alias.OutputTupleIndices), alias.OperandIndex, intSliceToStableHLO(alias.OperandTupleIndices))
+		}
+		sb.WriteString("]")
+		attributes["output_operand_aliases"] = literalStr(sb.String())
+	}
+
+	stmt := fn.addMultiOp(op, slices.Clone(outputShapes), operands)
+	stmt.Attributes = attributes
+	return stmt.Outputs, nil
+}
+
+// layoutsToStableHLO converts the minor-to-major layouts of the given shapes to a StableHLO array of
+// index tensors.
+func layoutsToStableHLO(layouts [][]int, shapesList []shapes.Shape) (literalStr, error) {
+	if len(layouts) != len(shapesList) {
+		return "", errors.Errorf("got %d layouts for %d shapes", len(layouts), len(shapesList))
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, layout := range layouts {
+		rank := shapesList[i].Rank()
+		if len(layout) != rank {
+			return "", errors.Errorf("layout #%d %v has length %d, but the shape %s has rank %d",
+				i, layout, len(layout), shapesList[i], rank)
+		}
+		seen := make([]bool, rank)
+		for _, axis := range layout {
+			if axis < 0 || axis >= rank || seen[axis] {
+				return "", errors.Errorf("layout #%d %v is not a permutation of the axes of shape %s",
+					i, layout, shapesList[i])
+			}
+			seen[axis] = true
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if rank == 0 {
+			sb.WriteString("dense<> : tensor<0xindex>")
+		} else {
+			fmt.Fprintf(&sb, "dense<%s> : tensor<%dxindex>", intSliceToStableHLO(layout), rank)
+		}
+	}
+	sb.WriteString("]")
+	return literalStr(sb.String()), nil
+}
+
+// dictionaryToStableHLO converts a dictionary of attributes to a StableHLO dictionary attribute, with
+// its keys sorted.
+func dictionaryToStableHLO(dict map[string]any) (literalStr, error) {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, key := range slices.Sorted(maps.Keys(dict)) {
+		value, err := attributeValueToStableHLO(dict[key])
+		if err != nil {
+			return "", errors.WithMessagef(err, "for key %q", key)
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s = %s", key, value)
+	}
+	sb.WriteString("}")
+	return literalStr(sb.String()), nil
+}
+
+// attributeValueToStableHLO converts a value of a dictionary attribute to StableHLO.
+func attributeValueToStableHLO(value any) (string, error) {
+	switch v := value.(type) {
+	case string, bool, float32, float64, int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return literalToStableHLO(v), nil
+	case map[string]any:
+		dict, err := dictionaryToStableHLO(v)
+		return string(dict), err
+	case []bool:
+		return string(boolSliceToArrayI1StableHLO(v)), nil
+	case []int:
+		return string(intSliceToArrayI64StableHLO(v)), nil
+	case []int8:
+		return numberSliceToArrayStableHLO(v), nil
+	case []int16:
+		return numberSliceToArrayStableHLO(v), nil
+	case []int32:
+		return numberSliceToArrayStableHLO(v), nil
+	case []int64:
+		return numberSliceToArrayStableHLO(v), nil
+	case []uint8:
+		return numberSliceToArrayStableHLO(v), nil
+	case []uint16:
+		return numberSliceToArrayStableHLO(v), nil
+	case []uint32:
+		return numberSliceToArrayStableHLO(v), nil
+	case []uint64:
+		return numberSliceToArrayStableHLO(v), nil
+	case []float32:
+		return numberSliceToArrayStableHLO(v), nil
+	case []float64:
+		return numberSliceToArrayStableHLO(v), nil
+	case hasToStableHLO:
+		return v.ToStableHLO(), nil
+	default:
+		return "", errors.Errorf("unsupported attribute value type %T", value)
+	}
+}
+
+// numberSliceToArrayStableHLO converts a slice of numbers to a StableHLO dense array attribute,
+// e.g.: "array<f32: 1.0, 2.0>".
+func numberSliceToArrayStableHLO[T dtypes.NumberNotComplex](values []T) string {
+	dtype := dtypes.FromGenericsType[T]()
+	var sb strings.Builder
+	sb.WriteString("array<")
+	sb.WriteString(dtype.ToStableHLO())
+	for i, v := range values {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(podToStableHLO(v))
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
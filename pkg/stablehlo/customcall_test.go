@@ -0,0 +1,109 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestCustomCall(t *testing.T) {
+	t.Run("typed FFI", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+		outputs := must1(CustomCall(fn, "my_target", []*Value{x},
+			[]shapes.Shape{shapes.Make(dtypes.Float32, 3, 2), shapes.Make(dtypes.Int32)},
+			&CustomCallOptions{
+				TypedBackendConfig: map[string]any{
+					"name":    "foo",
+					"alpha":   float32(0.5),
+					"axes":    []int64{1, 0},
+					"options": map[string]any{"verbose": true},
+				},
+				HasSideEffect:  true,
+				OperandLayouts: [][]int{{0, 1}},
+				ResultLayouts:  [][]int{{1, 0}, {}},
+				OutputOperandAliases: []CustomCallOutputOperandAlias{
+					{OutputTupleIndices: []int{0}, OperandIndex: 0},
+				},
+			}))
+		if len(outputs) != 2 {
+			t.Fatalf("expected 2 outputs, got %d", len(outputs))
+		}
+		if err := fn.Return(outputs[0], outputs[1]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s\n", t.Name(), program)
+		want := `module @TestCustomCall_typed_FFI {
+  func.func @main(%x: tensor<2x3xf32>) -> (tensor<3x2xf32>, tensor<i32>) {
+    %0, %1 = "stablehlo.custom_call"(%x) {
+      api_version = 4 : i32,
+      backend_config = {alpha = 0.5 : f32, axes = array<i64: 1, 0>, name = "foo", options = {verbose = true}},
+      call_target_name = "my_target",
+      has_side_effect = true,
+      operand_layouts = [dense<[0, 1]> : tensor<2xindex>],
+      output_operand_aliases = [#stablehlo.output_operand_alias<output_tuple_indices = [0], operand_index = 0, operand_tuple_indices = []>],
+      result_layouts = [dense<[1, 0]> : tensor<2xindex>, dense<> : tensor<0xindex>]
+    } : (tensor<2x3xf32>) -> (tensor<3x2xf32>, tensor<i32>)
+    "stablehlo.return"(%0, %1) : (tensor<3x2xf32>, tensor<i32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("string backend config", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		outputs := must1(CustomCall(fn, "legacy_target", nil, []shapes.Shape{shapes.Make(dtypes.Float32)},
+			&CustomCallOptions{
+				APIVersion:    CustomCallAPIVersionStatusReturning,
+				BackendConfig: "opaque",
+			}))
+		if err := fn.Return(outputs[0]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s\n", t.Name(), program)
+		want := `module @TestCustomCall_string_backend_config {
+  func.func @main() -> tensor<f32> {
+    %0 = "stablehlo.custom_call"() {
+      api_version = 2 : i32,
+      backend_config = "opaque",
+      call_target_name = "legacy_target"
+    } : () -> tensor<f32>
+    "stablehlo.return"(%0) : (tensor<f32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+		outputShapes := []shapes.Shape{shapes.Make(dtypes.Float32)}
+		for name, opts := range map[string]*CustomCallOptions{
+			"string config for typed FFI": {BackendConfig: "opaque"},
+			"typed config for old API":    {APIVersion: CustomCallAPIVersionOriginal, TypedBackendConfig: map[string]any{"a": 1}},
+			"only operand layouts":        {OperandLayouts: [][]int{{1, 0}}},
+			"invalid layout":              {OperandLayouts: [][]int{{0, 0}}, ResultLayouts: [][]int{{}}},
+			"invalid alias":               {OutputOperandAliases: []CustomCallOutputOperandAlias{{OperandIndex: 1}}},
+			"unsupported config value":    {TypedBackendConfig: map[string]any{"a": struct{}{}}},
+		} {
+			if _, err := CustomCall(fn, "my_target", []*Value{x}, outputShapes, opts); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+}
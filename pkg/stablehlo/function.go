@@ -3,6 +3,7 @@ package stablehlo
 import (
 	"fmt"
 	"io"
+	"maps"
 	"reflect"
 	"slices"
 	"strconv"
@@ -264,6 +265,9 @@ func (fn *Function) ConstantFromFlatAndDimensions(flat any, dimensions ...int) (
 //
 // If you are doing distributed computation, you can use WithReturnShardingSpecs to specify
 // the sharding requirements for each of the return values.
+//
+// The main function can't return tuples, since PJRT doesn't support tuple outputs: return each of
+// their elements instead.
 func (fn *Function) Return(values ...*Value) error {
 	return fn.ReturnWithAttributes(values, nil)
 }
@@ -313,6 +317,15 @@ func (fn *Function) ReturnWithAttributes(values []*Value, attributes []map[strin
 			"if attributes is defined (!=nil) Function.ReturnWithAttributes requires the same number of "+
 				"values and attributes, got %d and %d", len(values), len(attributes))
 	}
+	if fn.Name == MainFunctionName && fn.Parent == nil {
+		for i, value := range values {
+			if value.shape.IsTuple() {
+				return errors.Errorf("Function.Return of %q: output #%d has the tuple shape %s, but tuple "+
+					"outputs of the main function are not supported by PJRT -- return each element "+
+					"(see GetTupleElement) as a separate output instead", fn.Name, i, value.shape)
+			}
+		}
+	}
 	fn.Returned = true
 	outputValues := make([]*Value, len(values))
 	for i, value := range values {
@@ -353,6 +366,30 @@ func (fn *Function) Iota(shape shapes.Shape, axis int) (*Value, error) {
 	return stmt.Outputs[0], nil
 }
 
+// ReplicaId returns a scalar ui32 with the index of the replica executing the program.
+//
+// See Builder.WithNumReplicas.
+func (fn *Function) ReplicaId() (*Value, error) {
+	return fn.indexOp(optypes.ReplicaId)
+}
+
+// PartitionId returns a scalar ui32 with the index of the partition executing the program.
+//
+// See Builder.WithNumPartitions.
+func (fn *Function) PartitionId() (*Value, error) {
+	return fn.indexOp(optypes.PartitionId)
+}
+
+// indexOp adds an operation without inputs that returns a scalar ui32, like ReplicaId and PartitionId.
+func (fn *Function) indexOp(op optypes.OpType) (*Value, error) {
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	stmt := fn.addOp(op, shapes.Make(dtypes.Uint32))
+	return stmt.Outputs[0], nil
+}
+
 // Closure creates an unnamed closure function that can be used as an argument to operations like
 // Reduce, ReduceWindow, ScatterAndUpdate, etc.
 //
@@ -427,6 +464,107 @@ func (fn *Function) UseParentValue(parentValue *Value) (*Value, error) {
 	return v, nil
 }
 
+// CopyStatement adds to fn a copy of the statement stmt, which may come from another function, and returns the
+// outputs of the copy.
+//
+// mapValue returns the value to use in the copy for each input of stmt. It is also called for the values of
+// enclosing functions used by the closures of the statement (e.g.: the branches of an If using values of the
+// function calling If), since the closures are copied as new closures of fn.
+// The values it returns must belong to fn or to one of its parents.
+//
+// It is meant for tools that transform programs, like the autodiff package.
+func (fn *Function) CopyStatement(stmt *Statement, mapValue func(v *Value) (*Value, error)) ([]*Value, error) {
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			stmt.OpType, fn.Name)
+	}
+	if stmt.OpType == optypes.FuncReturn {
+		return nil, errors.Errorf("cannot copy a return statement to function %q, use Function.Return instead", fn.Name)
+	}
+	inputs := make([]*Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		var err error
+		if inputs[i], err = fn.mapCopiedValue(input, mapValue); err != nil {
+			return nil, err
+		}
+	}
+	closures := make([]*Function, len(stmt.FunctionParameters))
+	for i, closure := range stmt.FunctionParameters {
+		var err error
+		if closures[i], err = fn.copyClosure(closure, mapValue); err != nil {
+			return nil, errors.WithMessagef(err, "copying closure %q of %s", stmt.FunctionParametersNames[i], stmt.OpType)
+		}
+	}
+	outputShapes := make([]shapes.Shape, len(stmt.Outputs))
+	for i, output := range stmt.Outputs {
+		outputShapes[i] = output.shape
+	}
+	newStmt := fn.addMultiOp(stmt.OpType, outputShapes, inputs)
+	newStmt.Attributes = maps.Clone(stmt.Attributes)
+	for i, closure := range closures {
+		newStmt.AddFunctionParameter(stmt.FunctionParametersNames[i], closure)
+	}
+	return newStmt.Outputs, nil
+}
+
+// mapCopiedValue maps a value with mapValue, and makes sure it can be used in fn.
+func (fn *Function) mapCopiedValue(v *Value, mapValue func(v *Value) (*Value, error)) (*Value, error) {
+	mapped, err := mapValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if mapped == nil {
+		return nil, errors.Errorf("no value given for %s, when copying to function %q", v, fn.Name)
+	}
+	if mapped.fn == fn {
+		return mapped, nil
+	}
+	return fn.UseParentValue(mapped)
+}
+
+// copyClosure creates a closure of fn with a copy of the src closure. See CopyStatement.
+func (fn *Function) copyClosure(src *Function, mapValue func(v *Value) (*Value, error)) (*Function, error) {
+	closure := fn.Closure()
+	// The values defined in src are mapped by name, which also covers their uses in nested closures.
+	localValues := make(map[string]*Value)
+	for _, input := range src.Inputs {
+		v, err := closure.Input(input.shape)
+		if err != nil {
+			return nil, err
+		}
+		localValues[input.name] = v
+	}
+	closureMapValue := func(v *Value) (*Value, error) {
+		if mapped, found := localValues[v.name]; found {
+			return mapped, nil
+		}
+		return mapValue(v)
+	}
+	for _, stmt := range src.Statements {
+		if stmt.OpType == optypes.FuncReturn {
+			outputs := make([]*Value, len(stmt.Inputs))
+			for i, v := range stmt.Inputs {
+				var err error
+				if outputs[i], err = closure.mapCopiedValue(v, closureMapValue); err != nil {
+					return nil, err
+				}
+			}
+			if err := closure.Return(outputs...); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		outputs, err := closure.CopyStatement(stmt, closureMapValue)
+		if err != nil {
+			return nil, err
+		}
+		for i, output := range stmt.Outputs {
+			localValues[output.name] = outputs[i]
+		}
+	}
+	return closure, nil
+}
+
 // Write the function as StableHLO code, with the given indentation.
 func (fn *Function) Write(writer io.Writer, indentation string) error {
 	// Create the formatting w() and we() internal functions to facilitate handling error while generating the statement code.
@@ -453,7 +591,7 @@ func (fn *Function) Write(writer io.Writer, indentation string) error {
 	normalFunction := fn.Parent == nil
 	isClosure := fn.Parent != nil
 	if normalFunction {
-		w("%sfunc.func @%s(", indentation, fn.Name)
+		w("%sfunc.func @%s(", indentation, NormalizeIdentifier(fn.Name))
 	} else if isClosure {
 		w("(")
 	}
@@ -0,0 +1,731 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"math/cmplx"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes/bfloat16"
+	"github.com/pkg/errors"
+	"github.com/x448/float16"
+)
+
+// numberKind groups the dtypes by how the interpreter computes with them.
+type numberKind int
+
+const (
+	boolKind numberKind = iota
+	signedKind
+	unsignedKind
+	floatKind
+	complexKind
+)
+
+// kindOf returns the numberKind of a supported dtype.
+func kindOf(dtype dtypes.DType) numberKind {
+	switch {
+	case dtype == dtypes.Bool:
+		return boolKind
+	case dtype.IsComplex():
+		return complexKind
+	case dtype.IsFloat():
+		return floatKind
+	case dtype.IsUnsigned():
+		return unsignedKind
+	default:
+		return signedKind
+	}
+}
+
+// number is the set of Go types used by the interpreter to compute real values: values of every dtype
+// are converted to one of these types, and the results converted back to the output dtype.
+type number interface {
+	int64 | uint64 | float64
+}
+
+// realNumber are the Go types of real values that can be converted directly with Go conversions.
+type realNumber interface {
+	int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 | float32 | float64
+}
+
+// asNumbers converts a flat slice of any real (non-complex) supported dtype to a slice of N.
+// Conversions from floats to integers truncate towards zero and saturate.
+func asNumbers[N number](flat any) []N {
+	switch values := flat.(type) {
+	case []bool:
+		return convertSlice(values, func(v bool) N {
+			if v {
+				return 1
+			}
+			return 0
+		})
+	case []int8:
+		return convertReals[int8, N](values)
+	case []int16:
+		return convertReals[int16, N](values)
+	case []int32:
+		return convertReals[int32, N](values)
+	case []int64:
+		return convertReals[int64, N](values)
+	case []uint8:
+		return convertReals[uint8, N](values)
+	case []uint16:
+		return convertReals[uint16, N](values)
+	case []uint32:
+		return convertReals[uint32, N](values)
+	case []uint64:
+		return convertReals[uint64, N](values)
+	case []float16.Float16:
+		return convertSlice(values, func(v float16.Float16) N { return fromFloat64[N](float64(v.Float32())) })
+	case []bfloat16.BFloat16:
+		return convertSlice(values, func(v bfloat16.BFloat16) N { return fromFloat64[N](float64(v.Float32())) })
+	case []float32:
+		return convertSlice(values, func(v float32) N { return fromFloat64[N](float64(v)) })
+	case []float64:
+		return convertSlice(values, fromFloat64[N])
+	case []complex64:
+		return convertSlice(values, func(v complex64) N { return fromFloat64[N](float64(real(v))) })
+	case []complex128:
+		return convertSlice(values, func(v complex128) N { return fromFloat64[N](real(v)) })
+	default:
+		panic(errors.Errorf("interpreter: unsupported flat values type %T", flat))
+	}
+}
+
+func convertReals[T realNumber, N number](values []T) []N {
+	return convertSlice(values, func(v T) N { return N(v) })
+}
+
+// fromFloat64 converts a float64 to N: conversions to integers truncate towards zero and saturate, and NaN
+// becomes 0.
+func fromFloat64[N number](v float64) N {
+	var zero N
+	switch any(zero).(type) {
+	case int64:
+		switch {
+		case math.IsNaN(v):
+			return 0
+		case v >= math.MaxInt64:
+			return any(int64(math.MaxInt64)).(N)
+		case v <= math.MinInt64:
+			return any(int64(math.MinInt64)).(N)
+		}
+	case uint64:
+		switch {
+		case math.IsNaN(v) || v <= 0:
+			return 0
+		case v >= math.MaxUint64:
+			return any(uint64(math.MaxUint64)).(N)
+		}
+	}
+	return N(v)
+}
+
+// asComplexes converts a flat slice of any supported dtype to a []complex128.
+func asComplexes(flat any) []complex128 {
+	switch values := flat.(type) {
+	case []complex64:
+		return convertSlice(values, func(v complex64) complex128 { return complex128(v) })
+	case []complex128:
+		return values
+	default:
+		return convertSlice(asNumbers[float64](flat), func(v float64) complex128 { return complex(v, 0) })
+	}
+}
+
+// fromNumbers converts the values to a flat slice of the given dtype.
+// Integer conversions wrap around, and conversions from floats to integers truncate towards zero and saturate.
+func fromNumbers[N number](dtype dtypes.DType, values []N) any {
+	switch dtype {
+	case dtypes.Bool:
+		return convertSlice(values, func(v N) bool { return v != 0 })
+	case dtypes.Int8:
+		return convertSlice(values, func(v N) int8 { return int8(toInt64(v)) })
+	case dtypes.Int16:
+		return convertSlice(values, func(v N) int16 { return int16(toInt64(v)) })
+	case dtypes.Int32:
+		return convertSlice(values, func(v N) int32 { return int32(toInt64(v)) })
+	case dtypes.Int64:
+		return convertSlice(values, toInt64[N])
+	case dtypes.Uint8:
+		return convertSlice(values, func(v N) uint8 { return uint8(toUint64(v)) })
+	case dtypes.Uint16:
+		return convertSlice(values, func(v N) uint16 { return uint16(toUint64(v)) })
+	case dtypes.Uint32:
+		return convertSlice(values, func(v N) uint32 { return uint32(toUint64(v)) })
+	case dtypes.Uint64:
+		return convertSlice(values, toUint64[N])
+	case dtypes.Float16:
+		return convertSlice(values, func(v N) float16.Float16 { return float16.Fromfloat32(float32(v)) })
+	case dtypes.BFloat16:
+		return convertSlice(values, func(v N) bfloat16.BFloat16 { return bfloat16.FromFloat64(float64(v)) })
+	case dtypes.Float32:
+		return convertSlice(values, func(v N) float32 { return float32(v) })
+	case dtypes.Float64:
+		return convertSlice(values, func(v N) float64 { return float64(v) })
+	case dtypes.Complex64:
+		return convertSlice(values, func(v N) complex64 { return complex(float32(v), 0) })
+	case dtypes.Complex128:
+		return convertSlice(values, func(v N) complex128 { return complex(float64(v), 0) })
+	default:
+		panic(errors.Errorf("interpreter: unsupported dtype %s", dtype))
+	}
+}
+
+func toInt64[N number](v N) int64 {
+	if f, ok := any(v).(float64); ok {
+		return fromFloat64[int64](f)
+	}
+	return int64(v)
+}
+
+func toUint64[N number](v N) uint64 {
+	if f, ok := any(v).(float64); ok {
+		return fromFloat64[uint64](f)
+	}
+	return uint64(v)
+}
+
+// fromComplexes converts the values to a flat slice of the given dtype: for non-complex dtypes, only the real
+// part is used.
+func fromComplexes(dtype dtypes.DType, values []complex128) any {
+	switch dtype {
+	case dtypes.Complex64:
+		return convertSlice(values, func(v complex128) complex64 { return complex64(v) })
+	case dtypes.Complex128:
+		return values
+	default:
+		return fromNumbers(dtype, convertSlice(values, func(v complex128) float64 { return real(v) }))
+	}
+}
+
+// convertFlat converts a flat slice of values of one dtype to another dtype.
+func convertFlat(flat any, from, to dtypes.DType) any {
+	switch kindOf(from) {
+	case complexKind:
+		return fromComplexes(to, asComplexes(flat))
+	case floatKind:
+		return fromNumbers(to, asNumbers[float64](flat))
+	case signedKind:
+		return fromNumbers(to, asNumbers[int64](flat))
+	default:
+		return fromNumbers(to, asNumbers[uint64](flat))
+	}
+}
+
+// Unary operations for each kind of dtype.
+var (
+	floatUnaryOps = map[optypes.OpType]func(x float64) float64{
+		optypes.Abs:                 math.Abs,
+		optypes.Negate:              func(x float64) float64 { return -x },
+		optypes.Exponential:         math.Exp,
+		optypes.ExponentialMinusOne: math.Expm1,
+		optypes.Log:                 math.Log,
+		optypes.LogPlusOne:          math.Log1p,
+		optypes.Logistic:            func(x float64) float64 { return 1 / (1 + math.Exp(-x)) },
+		optypes.Sqrt:                math.Sqrt,
+		optypes.Rsqrt:               func(x float64) float64 { return 1 / math.Sqrt(x) },
+		optypes.Cbrt:                math.Cbrt,
+		optypes.Sine:                math.Sin,
+		optypes.Cosine:              math.Cos,
+		optypes.Tan:                 math.Tan,
+		optypes.Tanh:                math.Tanh,
+		optypes.Floor:               math.Floor,
+		optypes.Ceil:                math.Ceil,
+		optypes.RoundNearestAfz:     math.Round,
+		optypes.RoundNearestEven:    math.RoundToEven,
+		optypes.Erf:                 math.Erf,
+		optypes.Sign: func(x float64) float64 {
+			if x > 0 {
+				return 1
+			} else if x < 0 {
+				return -1
+			}
+			return x // Keeps the sign of zeros, and NaNs.
+		},
+	}
+
+	// signedUnaryOps take the number of bits of the dtype, the result is wrapped around to it.
+	signedUnaryOps = map[optypes.OpType]func(x int64, numBits int) int64{
+		optypes.Abs: func(x int64, _ int) int64 {
+			if x < 0 {
+				return -x
+			}
+			return x
+		},
+		optypes.Negate: func(x int64, _ int) int64 { return -x },
+		optypes.Sign: func(x int64, _ int) int64 {
+			if x > 0 {
+				return 1
+			} else if x < 0 {
+				return -1
+			}
+			return 0
+		},
+		optypes.Not: func(x int64, _ int) int64 { return ^x },
+		optypes.Popcnt: func(x int64, numBits int) int64 {
+			return int64(bits.OnesCount64(uint64(x) & bitsMask(numBits)))
+		},
+		optypes.CountLeadingZeros: func(x int64, numBits int) int64 {
+			return int64(bits.LeadingZeros64(uint64(x)&bitsMask(numBits)) - (64 - numBits))
+		},
+	}
+
+	unsignedUnaryOps = map[optypes.OpType]func(x uint64, numBits int) uint64{
+		optypes.Abs:    func(x uint64, _ int) uint64 { return x },
+		optypes.Negate: func(x uint64, _ int) uint64 { return -x },
+		optypes.Sign: func(x uint64, _ int) uint64 {
+			if x > 0 {
+				return 1
+			}
+			return 0
+		},
+		optypes.Not:    func(x uint64, _ int) uint64 { return ^x },
+		optypes.Popcnt: func(x uint64, _ int) uint64 { return uint64(bits.OnesCount64(x)) },
+		optypes.CountLeadingZeros: func(x uint64, numBits int) uint64 {
+			return uint64(bits.LeadingZeros64(x) - (64 - numBits))
+		},
+	}
+
+	complexUnaryOps = map[optypes.OpType]func(x complex128) complex128{
+		optypes.Negate:              func(x complex128) complex128 { return -x },
+		optypes.Exponential:         cmplx.Exp,
+		optypes.ExponentialMinusOne: func(x complex128) complex128 { return cmplx.Exp(x) - 1 },
+		optypes.Log:                 cmplx.Log,
+		optypes.LogPlusOne:          func(x complex128) complex128 { return cmplx.Log(1 + x) },
+		optypes.Logistic:            func(x complex128) complex128 { return 1 / (1 + cmplx.Exp(-x)) },
+		optypes.Sqrt:                cmplx.Sqrt,
+		optypes.Rsqrt:               func(x complex128) complex128 { return 1 / cmplx.Sqrt(x) },
+		optypes.Sine:                cmplx.Sin,
+		optypes.Cosine:              cmplx.Cos,
+		optypes.Tan:                 cmplx.Tan,
+		optypes.Tanh:                cmplx.Tanh,
+		optypes.Sign: func(x complex128) complex128 {
+			if x == 0 {
+				return 0
+			}
+			return x / complex(cmplx.Abs(x), 0)
+		},
+	}
+)
+
+// bitsMask returns a mask with the lower numBits set.
+func bitsMask(numBits int) uint64 {
+	if numBits >= 64 {
+		return math.MaxUint64
+	}
+	return (uint64(1) << numBits) - 1
+}
+
+// unaryOp evaluates a standard unary operation. The outputDType is usually the same as the operand's, except
+// for ops like IsFinite or Abs of complex numbers.
+func unaryOp(op optypes.OpType, x *Tensor, outputDType dtypes.DType) (any, error) {
+	dtype := x.Shape.DType
+	switch op {
+	case optypes.IsFinite:
+		return convertSlice(asNumbers[float64](x.Flat), func(v float64) bool {
+			return !math.IsInf(v, 0) && !math.IsNaN(v)
+		}), nil
+	case optypes.Real:
+		return fromComplexes(outputDType, asComplexes(x.Flat)), nil
+	case optypes.Imag:
+		return fromNumbers(outputDType, convertSlice(asComplexes(x.Flat), func(v complex128) float64 { return imag(v) })), nil
+	}
+
+	switch kindOf(dtype) {
+	case floatKind:
+		if fn, found := floatUnaryOps[op]; found {
+			return fromNumbers(outputDType, convertSlice(asNumbers[float64](x.Flat), fn)), nil
+		}
+	case signedKind:
+		if fn, found := signedUnaryOps[op]; found {
+			numBits := dtype.Bits()
+			return fromNumbers(outputDType, convertSlice(asNumbers[int64](x.Flat), func(v int64) int64 {
+				return fn(v, numBits)
+			})), nil
+		}
+	case unsignedKind:
+		if fn, found := unsignedUnaryOps[op]; found {
+			numBits := dtype.Bits()
+			return fromNumbers(outputDType, convertSlice(asNumbers[uint64](x.Flat), func(v uint64) uint64 {
+				return fn(v, numBits)
+			})), nil
+		}
+	case boolKind:
+		if op == optypes.Not {
+			return convertSlice(x.Flat.([]bool), func(v bool) bool { return !v }), nil
+		}
+	case complexKind:
+		if op == optypes.Abs {
+			return fromNumbers(outputDType, convertSlice(asComplexes(x.Flat), cmplx.Abs)), nil
+		}
+		if fn, found := complexUnaryOps[op]; found {
+			return fromComplexes(outputDType, convertSlice(asComplexes(x.Flat), fn)), nil
+		}
+	}
+	return nil, errors.Errorf("%s not supported for dtype %s", op, dtype)
+}
+
+// Binary operations for each kind of dtype.
+var (
+	floatBinaryOps = map[optypes.OpType]func(x, y float64) float64{
+		optypes.Add:       func(x, y float64) float64 { return x + y },
+		optypes.Subtract:  func(x, y float64) float64 { return x - y },
+		optypes.Multiply:  func(x, y float64) float64 { return x * y },
+		optypes.Divide:    func(x, y float64) float64 { return x / y },
+		optypes.Remainder: math.Mod,
+		optypes.Power:     math.Pow,
+		optypes.Atan2:     math.Atan2,
+		optypes.Maximum: func(x, y float64) float64 {
+			if math.IsNaN(x) || math.IsNaN(y) {
+				return math.NaN()
+			}
+			return math.Max(x, y)
+		},
+		optypes.Minimum: func(x, y float64) float64 {
+			if math.IsNaN(x) || math.IsNaN(y) {
+				return math.NaN()
+			}
+			return math.Min(x, y)
+		},
+	}
+
+	// signedBinaryOps take the number of bits of the dtype, the result is wrapped around to it.
+	signedBinaryOps = map[optypes.OpType]func(x, y int64, numBits int) int64{
+		optypes.Add:      func(x, y int64, _ int) int64 { return x + y },
+		optypes.Subtract: func(x, y int64, _ int) int64 { return x - y },
+		optypes.Multiply: func(x, y int64, _ int) int64 { return x * y },
+		optypes.Divide: func(x, y int64, _ int) int64 {
+			if y == 0 {
+				return -1
+			}
+			return x / y
+		},
+		optypes.Remainder: func(x, y int64, _ int) int64 {
+			if y == 0 {
+				return x
+			}
+			return x % y
+		},
+		optypes.Power: func(x, y int64, _ int) int64 {
+			if y < 0 {
+				switch x {
+				case 1:
+					return 1
+				case -1:
+					if y%2 == 0 {
+						return 1
+					}
+					return -1
+				default:
+					return 0
+				}
+			}
+			return int64(powUint64(uint64(x), uint64(y)))
+		},
+		optypes.Maximum: func(x, y int64, _ int) int64 { return max(x, y) },
+		optypes.Minimum: func(x, y int64, _ int) int64 { return min(x, y) },
+		optypes.And:     func(x, y int64, _ int) int64 { return x & y },
+		optypes.Or:      func(x, y int64, _ int) int64 { return x | y },
+		optypes.Xor:     func(x, y int64, _ int) int64 { return x ^ y },
+		optypes.ShiftLeft: func(x, y int64, numBits int) int64 {
+			if y < 0 || y >= int64(numBits) {
+				return 0
+			}
+			return x << y
+		},
+		optypes.ShiftRightArithmetic: func(x, y int64, numBits int) int64 {
+			if y < 0 || y >= int64(numBits) {
+				y = int64(numBits) - 1
+			}
+			return x >> y
+		},
+		optypes.ShiftRightLogical: func(x, y int64, numBits int) int64 {
+			if y < 0 || y >= int64(numBits) {
+				return 0
+			}
+			return int64((uint64(x) & bitsMask(numBits)) >> y)
+		},
+	}
+
+	unsignedBinaryOps = map[optypes.OpType]func(x, y uint64, numBits int) uint64{
+		optypes.Add:      func(x, y uint64, _ int) uint64 { return x + y },
+		optypes.Subtract: func(x, y uint64, _ int) uint64 { return x - y },
+		optypes.Multiply: func(x, y uint64, _ int) uint64 { return x * y },
+		optypes.Divide: func(x, y uint64, _ int) uint64 {
+			if y == 0 {
+				return math.MaxUint64
+			}
+			return x / y
+		},
+		optypes.Remainder: func(x, y uint64, _ int) uint64 {
+			if y == 0 {
+				return x
+			}
+			return x % y
+		},
+		optypes.Power:   func(x, y uint64, _ int) uint64 { return powUint64(x, y) },
+		optypes.Maximum: func(x, y uint64, _ int) uint64 { return max(x, y) },
+		optypes.Minimum: func(x, y uint64, _ int) uint64 { return min(x, y) },
+		optypes.And:     func(x, y uint64, _ int) uint64 { return x & y },
+		optypes.Or:      func(x, y uint64, _ int) uint64 { return x | y },
+		optypes.Xor:     func(x, y uint64, _ int) uint64 { return x ^ y },
+		optypes.ShiftLeft: func(x, y uint64, numBits int) uint64 {
+			if y >= uint64(numBits) {
+				return 0
+			}
+			return x << y
+		},
+		optypes.ShiftRightArithmetic: func(x, y uint64, numBits int) uint64 {
+			// Sign-extend the value from numBits.
+			signed := int64(x<<(64-numBits)) >> (64 - numBits)
+			if y >= uint64(numBits) {
+				y = uint64(numBits) - 1
+			}
+			return uint64(signed >> y)
+		},
+		optypes.ShiftRightLogical: func(x, y uint64, numBits int) uint64 {
+			if y >= uint64(numBits) {
+				return 0
+			}
+			return x >> y
+		},
+	}
+
+	boolBinaryOps = map[optypes.OpType]func(x, y bool) bool{
+		optypes.And:     func(x, y bool) bool { return x && y },
+		optypes.Or:      func(x, y bool) bool { return x || y },
+		optypes.Xor:     func(x, y bool) bool { return x != y },
+		optypes.Maximum: func(x, y bool) bool { return x || y },
+		optypes.Minimum: func(x, y bool) bool { return x && y },
+	}
+
+	complexBinaryOps = map[optypes.OpType]func(x, y complex128) complex128{
+		optypes.Add:      func(x, y complex128) complex128 { return x + y },
+		optypes.Subtract: func(x, y complex128) complex128 { return x - y },
+		optypes.Multiply: func(x, y complex128) complex128 { return x * y },
+		optypes.Divide:   func(x, y complex128) complex128 { return x / y },
+		optypes.Power:    cmplx.Pow,
+	}
+)
+
+// powUint64 returns x^y with wrap-around, using exponentiation by squaring.
+func powUint64(x, y uint64) uint64 {
+	result := uint64(1)
+	for y > 0 {
+		if y&1 != 0 {
+			result *= x
+		}
+		x *= x
+		y >>= 1
+	}
+	return result
+}
+
+// zipSlices returns a new slice with fn applied to the corresponding elements of x and y.
+func zipSlices[S, T any](x, y []S, fn func(x, y S) T) []T {
+	result := make([]T, len(x))
+	for i := range x {
+		result[i] = fn(x[i], y[i])
+	}
+	return result
+}
+
+// binaryOp evaluates a standard binary operation on operands of the same shape.
+func binaryOp(op optypes.OpType, x, y *Tensor, outputDType dtypes.DType) (any, error) {
+	dtype := x.Shape.DType
+	if op == optypes.Complex {
+		xs, ys := asNumbers[float64](x.Flat), asNumbers[float64](y.Flat)
+		return fromComplexes(outputDType, zipSlices(xs, ys, func(re, im float64) complex128 {
+			return complex(re, im)
+		})), nil
+	}
+	switch kindOf(dtype) {
+	case floatKind:
+		if fn, found := floatBinaryOps[op]; found {
+			return fromNumbers(outputDType, zipSlices(asNumbers[float64](x.Flat), asNumbers[float64](y.Flat), fn)), nil
+		}
+	case signedKind:
+		if fn, found := signedBinaryOps[op]; found {
+			numBits := dtype.Bits()
+			return fromNumbers(outputDType, zipSlices(asNumbers[int64](x.Flat), asNumbers[int64](y.Flat),
+				func(x, y int64) int64 { return fn(x, y, numBits) })), nil
+		}
+	case unsignedKind:
+		if fn, found := unsignedBinaryOps[op]; found {
+			numBits := dtype.Bits()
+			return fromNumbers(outputDType, zipSlices(asNumbers[uint64](x.Flat), asNumbers[uint64](y.Flat),
+				func(x, y uint64) uint64 { return fn(x, y, numBits) })), nil
+		}
+	case boolKind:
+		if fn, found := boolBinaryOps[op]; found {
+			return zipSlices(x.Flat.([]bool), y.Flat.([]bool), fn), nil
+		}
+	case complexKind:
+		if fn, found := complexBinaryOps[op]; found {
+			return fromComplexes(outputDType, zipSlices(asComplexes(x.Flat), asComplexes(y.Flat), fn)), nil
+		}
+	}
+	return nil, errors.Errorf("%s not supported for dtype %s", op, dtype)
+}
+
+// compareOp evaluates a Compare operation, given the comparison direction (e.g.: "LT") and the comparison type
+// (e.g.: "FLOAT", "TOTALORDER", "SIGNED" or "UNSIGNED").
+func compareOp(x, y *Tensor, direction, compareType string) ([]bool, error) {
+	switch kindOf(x.Shape.DType) {
+	case complexKind:
+		if direction != "EQ" && direction != "NE" {
+			return nil, errors.Errorf("comparison direction %s not supported for complex numbers", direction)
+		}
+		return zipSlices(asComplexes(x.Flat), asComplexes(y.Flat), func(x, y complex128) bool {
+			return (x == y) == (direction == "EQ")
+		}), nil
+	case floatKind:
+		xs, ys := asNumbers[float64](x.Flat), asNumbers[float64](y.Flat)
+		if compareType == "TOTALORDER" {
+			return compareValues(convertSlice(xs, totalOrderKey), convertSlice(ys, totalOrderKey), direction)
+		}
+		return compareValues(xs, ys, direction)
+	case signedKind:
+		return compareValues(asNumbers[int64](x.Flat), asNumbers[int64](y.Flat), direction)
+	default:
+		return compareValues(asNumbers[uint64](x.Flat), asNumbers[uint64](y.Flat), direction)
+	}
+}
+
+// totalOrderKey returns an integer whose order matches the IEEE 754 totalOrder of the float.
+func totalOrderKey(v float64) int64 {
+	key := int64(math.Float64bits(v))
+	if key < 0 {
+		key ^= math.MaxInt64
+	}
+	return key
+}
+
+func compareValues[N number](xs, ys []N, direction string) ([]bool, error) {
+	var fn func(x, y N) bool
+	switch direction {
+	case "EQ":
+		fn = func(x, y N) bool { return x == y }
+	case "NE":
+		fn = func(x, y N) bool { return x != y }
+	case "LT":
+		fn = func(x, y N) bool { return x < y }
+	case "LE":
+		fn = func(x, y N) bool { return x <= y }
+	case "GT":
+		fn = func(x, y N) bool { return x > y }
+	case "GE":
+		fn = func(x, y N) bool { return x >= y }
+	default:
+		return nil, errors.Errorf("unknown comparison direction %q", direction)
+	}
+	return zipSlices(xs, ys, fn), nil
+}
+
+// broadcastScalar returns the flat values of x broadcast to the given size, if x is a scalar.
+func broadcastScalar(x *Tensor, size int) *Tensor {
+	if !x.Shape.IsScalar() || size == 1 {
+		return x
+	}
+	return &Tensor{Shape: x.Shape, Flat: takeElements(x.Flat, make([]int, size), nil)}
+}
+
+// selectOp evaluates Select: pred may be a scalar.
+func selectOp(pred, onTrue, onFalse *Tensor) any {
+	size := onTrue.Shape.Size()
+	predFlat := broadcastScalar(pred, size).Flat.([]bool)
+	indices := make([]int, size)
+	for i, p := range predFlat {
+		if p {
+			indices[i] = i
+		} else {
+			indices[i] = size + i
+		}
+	}
+	return takeElements(concatFlats(onTrue.Flat, onFalse.Flat), indices, nil)
+}
+
+// clampOp evaluates Clamp: minimum and maximum may be scalars.
+func clampOp(minimum, x, maximum *Tensor) (any, error) {
+	size := x.Shape.Size()
+	minimum, maximum = broadcastScalar(minimum, size), broadcastScalar(maximum, size)
+	dtype := x.Shape.DType
+	lowerBounded, err := binaryOp(optypes.Maximum, x, minimum, dtype)
+	if err != nil {
+		return nil, err
+	}
+	return binaryOp(optypes.Minimum, &Tensor{Shape: x.Shape, Flat: lowerBounded}, maximum, dtype)
+}
+
+// bitcastConvertOp reinterprets the bytes of the flat values as the target dtype, with the given size.
+func bitcastConvertOp(x *Tensor, dtype dtypes.DType, size int) (any, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, x.Flat); err != nil {
+		return nil, errors.Wrapf(err, "failed to encode %s values", x.Shape.DType)
+	}
+	flat := newFlat(dtype, size)
+	if err := binary.Read(&buf, binary.LittleEndian, flat); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s values", dtype)
+	}
+	return flat, nil
+}
+
+// reducePrecisionOp rounds the float values to the given number of exponent and mantissa bits, following
+// the XLA implementation. It is computed on the float64 representation, which gives the same results for
+// lower precision floats, since the reductions are skipped if the dtype doesn't have more bits than requested.
+func reducePrecisionOp(x *Tensor, exponentBits, mantissaBits int) (any, error) {
+	dtype := x.Shape.DType
+	dtypeExponentBits, dtypeMantissaBits, ok := dtype.ExponentAndMantissaBits()
+	if !ok || kindOf(dtype) != floatKind {
+		return nil, errors.Errorf("ReducePrecision not supported for dtype %s", dtype)
+	}
+	const (
+		srcExponentBits = 11
+		srcMantissaBits = 52
+	)
+	if exponentBits >= dtypeExponentBits {
+		exponentBits = srcExponentBits
+	}
+	if mantissaBits >= dtypeMantissaBits {
+		mantissaBits = srcMantissaBits
+	}
+	values := convertSlice(asNumbers[float64](x.Flat), func(v float64) float64 {
+		if math.IsNaN(v) {
+			if mantissaBits > 0 {
+				return v
+			}
+			return math.Inf(1)
+		}
+		xBits := math.Float64bits(v)
+		if mantissaBits < srcMantissaBits {
+			lastMantissaBitMask := uint64(1) << (srcMantissaBits - mantissaBits)
+			baseRoundingBias := (lastMantissaBitMask >> 1) - 1
+			xLastMantissaBit := (xBits & lastMantissaBitMask) >> (srcMantissaBits - mantissaBits)
+			xRoundingBias := xLastMantissaBit + baseRoundingBias
+			truncationMask := ^(lastMantissaBitMask - 1)
+			xBits = (xBits + xRoundingBias) & truncationMask
+		}
+		if exponentBits < srcExponentBits {
+			const signBitMask = uint64(1) << 63
+			const expBitsMask = uint64((1<<srcExponentBits)-1) << srcMantissaBits
+			const exponentBias = uint64(1<<(srcExponentBits-1)) - 1
+			reducedExponentBias := uint64(1<<(exponentBits-1)) - 1
+			reducedMaxExponent := exponentBias + reducedExponentBias
+			reducedMinExponent := exponentBias - reducedExponentBias
+			xExponent := xBits & expBitsMask
+			xSignedZero := xBits & signBitMask
+			if xExponent > reducedMaxExponent<<srcMantissaBits {
+				xBits = xSignedZero | expBitsMask // Overflows to infinity.
+			} else if xExponent <= reducedMinExponent<<srcMantissaBits {
+				xBits = xSignedZero // Underflows to zero.
+			}
+		}
+		return math.Float64frombits(xBits)
+	})
+	return fromNumbers(dtype, values), nil
+}
@@ -0,0 +1,434 @@
+// Package interpreter evaluates StableHLO programs built with the stablehlo package (or loaded with
+// stablehlo.Parse) directly in Go, on Go slices, without PJRT.
+//
+// It is a reference implementation, written for clarity and not for speed: the values are computed element by
+// element, and closures (like the reduction function of a Reduce) are interpreted for each element.
+// It serves as a correctness oracle for PJRT results, to run tests on machines without a PJRT plugin, and to
+// debug numerics step by step (see Interpreter.WithTrace).
+//
+// Float values are computed in float64 and rounded to the dtype of the result, so transcendental functions
+// may differ from PJRT in the last bits.
+//
+// Most of the implemented ops are supported, except: collectives (AllReduce, AllGather, etc.), CustomCall,
+// Infeed, Outfeed, Send, Recv, RNGBitGenerator, quantization ops, ops with dynamic shapes and sub-byte dtypes.
+// ReplicaId and PartitionId return 0, as in a single device execution.
+//
+// Example:
+//
+//	b := stablehlo.New("my_program")
+//	fn := b.Main()
+//	... // Build the program.
+//	x, _ := interpreter.NewTensor([]float32{1, 2, 3}, 3)
+//	outputs, err := interpreter.New(b).Run(x)
+package interpreter
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Interpreter evaluates the functions of a stablehlo.Builder.
+//
+// The builder's functions must have returned (see stablehlo.Function.Return) before being run,
+// and they should not be changed while running.
+type Interpreter struct {
+	builder *stablehlo.Builder
+	traceFn TraceFn
+}
+
+// TraceFn is called after each statement is evaluated, with its inputs and outputs.
+// See Interpreter.WithTrace.
+type TraceFn func(stmt *stablehlo.Statement, inputs, outputs []*Tensor)
+
+// New creates an interpreter for the program in the builder.
+func New(builder *stablehlo.Builder) *Interpreter {
+	return &Interpreter{builder: builder}
+}
+
+// WithTrace sets a function to be called after each statement is evaluated, including statements of closures
+// and called functions. It can be used to debug numerics step by step, e.g.: printing the outputs of every
+// statement or checking for NaNs.
+//
+// It returns the interpreter itself, so calls can be chained.
+func (it *Interpreter) WithTrace(traceFn TraceFn) *Interpreter {
+	it.traceFn = traceFn
+	return it
+}
+
+// Run evaluates the main function with the given inputs, and returns its outputs.
+func (it *Interpreter) Run(inputs ...*Tensor) ([]*Tensor, error) {
+	return it.RunFunction(stablehlo.MainFunctionName, inputs...)
+}
+
+// RunFunction evaluates the top-level function with the given name, and returns its outputs.
+func (it *Interpreter) RunFunction(name string, inputs ...*Tensor) ([]*Tensor, error) {
+	fn := it.findFunction(name)
+	if fn == nil {
+		return nil, errors.Errorf("interpreter: function %q not found", name)
+	}
+	if len(inputs) != len(fn.Inputs) {
+		return nil, errors.Errorf("interpreter: function %q takes %d inputs, %d given", name, len(fn.Inputs), len(inputs))
+	}
+	for i, input := range inputs {
+		if !input.Shape.Equal(fn.Inputs[i].Shape()) {
+			return nil, errors.Errorf("interpreter: function %q input #%d has shape %s, %s given",
+				name, i, fn.Inputs[i].Shape(), input.Shape)
+		}
+	}
+	return it.callFunction(fn, nil, inputs)
+}
+
+// findFunction returns the top-level function with the given name, or nil if not found.
+func (it *Interpreter) findFunction(name string) *stablehlo.Function {
+	for _, fn := range it.builder.Functions() {
+		if fn.Parent == nil && fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// findFunctionBySymbol returns the top-level function referred by a symbol attribute (e.g.: "@my_function").
+func (it *Interpreter) findFunctionBySymbol(symbol string) (*stablehlo.Function, error) {
+	for _, fn := range it.builder.Functions() {
+		if fn.Parent == nil && "@"+stablehlo.NormalizeIdentifier(fn.Name) == symbol {
+			return fn, nil
+		}
+	}
+	return nil, errors.Errorf("function %s not found", symbol)
+}
+
+// frame holds the values of a function being evaluated.
+// Closures can refer to the values of their parent functions, so frames are chained.
+type frame struct {
+	parent *frame
+	values map[string]*Tensor
+}
+
+// get returns the tensor of a value, looking up in the parent frames if needed.
+func (f *frame) get(value *stablehlo.Value) (*Tensor, error) {
+	name := value.String()
+	for current := f; current != nil; current = current.parent {
+		if t, found := current.values[name]; found {
+			return t, nil
+		}
+	}
+	return nil, errors.Errorf("value %s not defined", name)
+}
+
+// callFunction evaluates fn with the given inputs. For closures, parent is the frame of the parent function.
+func (it *Interpreter) callFunction(fn *stablehlo.Function, parent *frame, inputs []*Tensor) ([]*Tensor, error) {
+	if !fn.Returned {
+		return nil, errors.Errorf("interpreter: function %q has not returned (see Function.Return)", fn.Name)
+	}
+	if len(inputs) != len(fn.Inputs) {
+		return nil, errors.Errorf("interpreter: function %q takes %d inputs, %d given", fn.Name, len(fn.Inputs), len(inputs))
+	}
+	f := &frame{parent: parent, values: make(map[string]*Tensor, len(fn.Inputs)+len(fn.Statements))}
+	for i, input := range fn.Inputs {
+		f.values[input.String()] = inputs[i]
+	}
+	for _, stmt := range fn.Statements {
+		inputs := make([]*Tensor, len(stmt.Inputs))
+		for i, input := range stmt.Inputs {
+			var err error
+			inputs[i], err = f.get(input)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "interpreter: in function %q, %s", fn.Name, stmt.OpType)
+			}
+		}
+		if stmt.OpType == optypes.FuncReturn {
+			return inputs, nil
+		}
+		outputs, err := it.evalStatement(stmt, f, inputs)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "interpreter: in function %q, %s", fn.Name, statementName(stmt))
+		}
+		if len(outputs) != len(stmt.Outputs) {
+			return nil, errors.Errorf("interpreter: in function %q, %s returned %d outputs, expected %d",
+				fn.Name, statementName(stmt), len(outputs), len(stmt.Outputs))
+		}
+		for i, output := range stmt.Outputs {
+			f.values[output.String()] = outputs[i]
+		}
+		if it.traceFn != nil {
+			it.traceFn(stmt, inputs, outputs)
+		}
+	}
+	return nil, errors.Errorf("interpreter: function %q has no return statement", fn.Name)
+}
+
+// statementName returns a description of the statement for error messages, e.g.: "%3 = Add".
+func statementName(stmt *stablehlo.Statement) string {
+	if len(stmt.Outputs) == 0 {
+		return stmt.OpType.String()
+	}
+	return stmt.Outputs[0].String() + " = " + stmt.OpType.String()
+}
+
+// Element-wise ops evaluated by unaryOp and binaryOp.
+var (
+	unaryOpTypes = []optypes.OpType{
+		optypes.Abs, optypes.Cbrt, optypes.Ceil, optypes.Cosine, optypes.CountLeadingZeros, optypes.Erf,
+		optypes.Exponential, optypes.ExponentialMinusOne, optypes.Floor, optypes.Imag, optypes.IsFinite,
+		optypes.Log, optypes.LogPlusOne, optypes.Logistic, optypes.Negate, optypes.Not, optypes.Popcnt,
+		optypes.Real, optypes.RoundNearestAfz, optypes.RoundNearestEven, optypes.Rsqrt, optypes.Sign,
+		optypes.Sine, optypes.Sqrt, optypes.Tan, optypes.Tanh,
+	}
+	binaryOpTypes = []optypes.OpType{
+		optypes.Add, optypes.And, optypes.Atan2, optypes.Complex, optypes.Divide, optypes.Maximum,
+		optypes.Minimum, optypes.Multiply, optypes.Or, optypes.Power, optypes.Remainder, optypes.ShiftLeft,
+		optypes.ShiftRightArithmetic, optypes.ShiftRightLogical, optypes.Subtract, optypes.Xor,
+	}
+)
+
+// evalStatement evaluates one statement, given the values of its inputs.
+func (it *Interpreter) evalStatement(stmt *stablehlo.Statement, f *frame, inputs []*Tensor) ([]*Tensor, error) {
+	for _, output := range stmt.Outputs {
+		if err := checkSupportedShape(output.Shape()); err != nil {
+			return nil, err
+		}
+	}
+	var outputShape shapes.Shape
+	if len(stmt.Outputs) > 0 {
+		outputShape = stmt.Outputs[0].Shape()
+	}
+	// single wraps the flat values of a single output statement.
+	single := func(flat any, err error) ([]*Tensor, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []*Tensor{{Shape: outputShape, Flat: flat}}, nil
+	}
+	// closure evaluates the i-th function parameter of the statement.
+	closure := func(i int) closureFn {
+		return func(inputs ...*Tensor) ([]*Tensor, error) {
+			return it.callFunction(stmt.FunctionParameters[i], f, inputs)
+		}
+	}
+
+	op := stmt.OpType
+	switch {
+	case slices.Contains(unaryOpTypes, op):
+		return single(unaryOp(op, inputs[0], outputShape.DType))
+	case slices.Contains(binaryOpTypes, op):
+		return single(binaryOp(op, inputs[0], inputs[1], outputShape.DType))
+	}
+
+	switch op {
+	case optypes.Constant:
+		return single(constantOp(stmt, outputShape))
+	case optypes.Identity, optypes.OptimizationBarrier:
+		return inputs, nil
+	case optypes.Iota:
+		axis, err := attributes.Int(stmt, "iota_dimension")
+		if err != nil {
+			return nil, err
+		}
+		return single(iotaOp(outputShape, axis), nil)
+	case optypes.ReplicaId, optypes.PartitionId:
+		return single(newFlat(outputShape.DType, 1), nil)
+	case optypes.AfterAll:
+		return []*Tensor{newToken()}, nil
+
+	case optypes.Compare:
+		direction, err := attributes.Enum(stmt, "comparison_direction")
+		if err != nil {
+			return nil, err
+		}
+		var compareType string
+		if _, found := stmt.Attributes["compare_type"]; found {
+			compareType, err = attributes.Enum(stmt, "compare_type")
+			if err != nil {
+				return nil, err
+			}
+		}
+		return single(compareOp(inputs[0], inputs[1], direction, compareType))
+	case optypes.Select:
+		return single(selectOp(inputs[0], inputs[1], inputs[2]), nil)
+	case optypes.Clamp:
+		return single(clampOp(inputs[0], inputs[1], inputs[2]))
+	case optypes.Convert:
+		return single(convertFlat(inputs[0].Flat, inputs[0].Shape.DType, outputShape.DType), nil)
+	case optypes.BitcastConvert:
+		return single(bitcastConvertOp(inputs[0], outputShape.DType, outputShape.Size()))
+	case optypes.ReducePrecision:
+		exponentBits, err := attributes.Int(stmt, "exponent_bits")
+		if err != nil {
+			return nil, err
+		}
+		mantissaBits, err := attributes.Int(stmt, "mantissa_bits")
+		if err != nil {
+			return nil, err
+		}
+		return single(reducePrecisionOp(inputs[0], exponentBits, mantissaBits))
+
+	case optypes.Reshape:
+		return single(inputs[0].Flat, nil)
+	case optypes.BroadcastInDim:
+		return single(broadcastInDimOp(stmt, inputs[0], outputShape))
+	case optypes.Transpose:
+		return single(transposeOp(stmt, inputs[0], outputShape))
+	case optypes.Slice:
+		return single(sliceOp(stmt, inputs[0], outputShape))
+	case optypes.DynamicSlice:
+		return single(dynamicSliceOp(inputs[0], inputs[1:], outputShape), nil)
+	case optypes.DynamicUpdateSlice:
+		return single(dynamicUpdateSliceOp(inputs[0], inputs[1], inputs[2:]), nil)
+	case optypes.Concatenate:
+		return single(concatenateOp(stmt, inputs, outputShape))
+	case optypes.Pad:
+		return single(padOp(stmt, inputs[0], inputs[1], outputShape))
+	case optypes.Reverse:
+		return single(reverseOp(stmt, inputs[0]))
+	case optypes.Gather:
+		return single(gatherOp(stmt, inputs[0], inputs[1], outputShape))
+	case optypes.GetDimensionSize:
+		axis, err := attributes.Int(stmt, "dimension")
+		if err != nil {
+			return nil, err
+		}
+		return single(fromNumbers(outputShape.DType, []int64{int64(inputs[0].Shape.Dim(axis))}), nil)
+
+	case optypes.DotGeneral:
+		return single(dotGeneralOp(stmt, inputs[0], inputs[1], outputShape))
+	case optypes.Convolution:
+		return single(convolutionOp(stmt, inputs[0], inputs[1], outputShape))
+	case optypes.Cholesky:
+		return single(choleskyOp(stmt, inputs[0]))
+	case optypes.TriangularSolve:
+		return single(triangularSolveOp(stmt, inputs[0], inputs[1]))
+	case optypes.Fft:
+		return single(fftOp(stmt, inputs[0], outputShape))
+	case optypes.BatchNormInference:
+		return single(batchNormInferenceOp(stmt, inputs))
+	case optypes.BatchNormTraining:
+		return batchNormTrainingOp(stmt, inputs)
+	case optypes.BatchNormGrad:
+		return batchNormGradOp(stmt, inputs)
+
+	case optypes.Reduce:
+		return reduceOp(stmt, inputs, closure(0))
+	case optypes.ReduceWindow:
+		return reduceWindowOp(stmt, inputs, closure(0))
+	case optypes.SelectAndScatter:
+		return single(selectAndScatterOp(stmt, inputs, closure(0), closure(1)))
+	case optypes.Scatter:
+		return scatterOp(stmt, inputs, closure(0))
+	case optypes.Sort:
+		return sortOp(stmt, inputs, closure(0))
+	case optypes.Map:
+		return single(mapOp(inputs, outputShape, closure(0)))
+
+	case optypes.While:
+		return it.whileOp(stmt, f, inputs)
+	case optypes.If:
+		branch := 1
+		if inputs[0].Flat.([]bool)[0] {
+			branch = 0
+		}
+		return closure(branch)()
+	case optypes.Case:
+		branch := int(asNumbers[int64](inputs[0].Flat)[0])
+		if branch < 0 || branch >= len(stmt.FunctionParameters) {
+			// Out-of-range indices execute the last branch.
+			branch = len(stmt.FunctionParameters) - 1
+		}
+		return closure(branch)()
+	case optypes.Call, optypes.Composite:
+		key := "callee"
+		if op == optypes.Composite {
+			key = "decomposition"
+		}
+		symbol, err := attributes.Text(stmt, key)
+		if err != nil {
+			return nil, err
+		}
+		callee, err := it.findFunctionBySymbol(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return it.callFunction(callee, nil, inputs)
+
+	case optypes.Tuple:
+		return []*Tensor{NewTuple(inputs...)}, nil
+	case optypes.GetTupleElement:
+		index, err := attributes.Int(stmt, "index")
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= len(inputs[0].Elements) {
+			return nil, errors.Errorf("tuple index %d out of range for %s", index, inputs[0].Shape)
+		}
+		return []*Tensor{inputs[0].Elements[index]}, nil
+	}
+	return nil, errors.Errorf("op %s not supported by the interpreter", op)
+}
+
+// checkSupportedShape returns an error if the interpreter can't hold values of the shape.
+func checkSupportedShape(shape shapes.Shape) error {
+	if shape.IsTuple() {
+		for _, element := range shape.TupleShapes {
+			if err := checkSupportedShape(element); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if shape.DType == dtypes.TOKEN {
+		return nil
+	}
+	if !isSupportedDType(shape.DType) {
+		return errors.Errorf("dtype %s not supported by the interpreter", shape.DType)
+	}
+	if shape.IsDynamic() {
+		return errors.Errorf("dynamic shape %s not supported by the interpreter", shape)
+	}
+	if shape.Quantization != nil {
+		return errors.Errorf("quantized shape %s not supported by the interpreter", shape)
+	}
+	return nil
+}
+
+// closureFn evaluates a closure (a function parameter of a statement) with the given inputs.
+type closureFn func(inputs ...*Tensor) ([]*Tensor, error)
+
+// constantOp returns the flat values of a Constant statement.
+func constantOp(stmt *stablehlo.Statement, shape shapes.Shape) (any, error) {
+	value, _, ok := stmt.ConstantValue()
+	if !ok {
+		return nil, errors.Errorf("constant value of shape %s not available", shape)
+	}
+	if shape.IsScalar() {
+		return NewScalar(value).Flat, nil
+	}
+	t, err := NewTensor(value, shape.Dimensions...)
+	if err != nil {
+		return nil, err
+	}
+	return cloneFlat(t.Flat), nil
+}
+
+// whileOp evaluates a While loop: the first function parameter is the condition, the second is the body.
+func (it *Interpreter) whileOp(stmt *stablehlo.Statement, f *frame, state []*Tensor) ([]*Tensor, error) {
+	condFn, bodyFn := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	for {
+		cond, err := it.callFunction(condFn, f, state)
+		if err != nil {
+			return nil, err
+		}
+		if !cond[0].Flat.([]bool)[0] {
+			return state, nil
+		}
+		state, err = it.callFunction(bodyFn, f, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func must1[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// buildMain creates a builder whose main function is built by buildFn, which returns the values to return.
+func buildMain(t *testing.T, buildFn func(fn *stablehlo.Function) []*stablehlo.Value) *stablehlo.Builder {
+	t.Helper()
+	b := stablehlo.New(t.Name())
+	fn := b.Main()
+	if err := fn.Return(buildFn(fn)...); err != nil {
+		t.Fatalf("failed to build program: %+v", err)
+	}
+	return b
+}
+
+// runMain builds the main function with buildFn and runs it with the given inputs.
+func runMain(t *testing.T, buildFn func(fn *stablehlo.Function) []*stablehlo.Value, inputs ...*Tensor) []*Tensor {
+	t.Helper()
+	outputs, err := New(buildMain(t, buildFn)).Run(inputs...)
+	if err != nil {
+		t.Fatalf("failed to run program: %+v", err)
+	}
+	return outputs
+}
+
+// checkValues checks that the tensor values (see Tensor.Value) match want.
+// Float and complex values are compared with a small tolerance.
+func checkValues(t *testing.T, got *Tensor, want any) {
+	t.Helper()
+	if reflect.TypeOf(got.Value()) != reflect.TypeOf(want) {
+		t.Fatalf("got %s, wanted %#v", got, want)
+	}
+	gotV, wantV := reflect.ValueOf(got.Value()), reflect.ValueOf(want)
+	if gotV.Kind() != reflect.Slice {
+		gotV, wantV = reflect.ValueOf([]any{got.Value()}), reflect.ValueOf([]any{want})
+	}
+	if gotV.Len() != wantV.Len() {
+		t.Fatalf("got %s, wanted %v", got, want)
+	}
+	for i := range gotV.Len() {
+		if !closeEnough(gotV.Index(i).Interface(), wantV.Index(i).Interface()) {
+			t.Fatalf("got %s, wanted %v (element #%d differs)", got, want, i)
+		}
+	}
+}
+
+func closeEnough(got, want any) bool {
+	const tolerance = 1e-5
+	switch want := want.(type) {
+	case float32:
+		return closeEnough(float64(got.(float32)), float64(want))
+	case float64:
+		got := got.(float64)
+		if math.IsNaN(want) {
+			return math.IsNaN(got)
+		}
+		return got == want || math.Abs(got-want) <= tolerance*max(1, math.Abs(want))
+	case complex64:
+		return closeEnough(complex128(got.(complex64)), complex128(want))
+	case complex128:
+		return cmplx.Abs(got.(complex128)-want) <= tolerance*max(1, cmplx.Abs(want))
+	default:
+		return got == want
+	}
+}
+
+func TestRun(t *testing.T) {
+	b := buildMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		y := must1(fn.NamedInput("y", shapes.Make(dtypes.Float32)))
+		broadcastY := must1(stablehlo.BroadcastInDim(y, x.Shape(), nil))
+		return []*stablehlo.Value{must1(stablehlo.Multiply(x, broadcastY)), y}
+	})
+	it := New(b)
+	x := must1(NewTensor([]float32{1, 2, 3}, 3))
+	outputs, err := it.Run(x, NewScalar(float32(2)))
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	checkValues(t, outputs[0], []float32{2, 4, 6})
+	checkValues(t, outputs[1], float32(2))
+
+	// Invalid inputs.
+	if _, err := it.Run(x); err == nil || !strings.Contains(err.Error(), "takes 2 inputs") {
+		t.Errorf("expected error for the wrong number of inputs, got %v", err)
+	}
+	if _, err := it.Run(x, NewScalar(2.0)); err == nil || !strings.Contains(err.Error(), "input #1") {
+		t.Errorf("expected error for the wrong input shape, got %v", err)
+	}
+	if _, err := it.RunFunction("foo"); err == nil {
+		t.Errorf("expected error for missing function")
+	}
+}
+
+func TestNewTensor(t *testing.T) {
+	x, err := NewTensor([]int{1, 2, 3, 4}, 2, 2)
+	if err != nil {
+		t.Fatalf("NewTensor failed: %+v", err)
+	}
+	if !x.Shape.Equal(shapes.Make(dtypes.Int64, 2, 2)) {
+		t.Errorf("got shape %s", x.Shape)
+	}
+	if got := x.String(); got != "(Int64)[2 2]: [1 2 3 4]" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := NewTensor([]float32{1, 2, 3}, 2, 2); err == nil {
+		t.Errorf("expected error for mismatched dimensions")
+	}
+	if _, err := NewTensor([]string{"a"}, 1); err == nil {
+		t.Errorf("expected error for unsupported dtype")
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	t.Run("While", func(t *testing.T) {
+		// Sum the numbers from 0 to 9.
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			counter := must1(fn.ConstantFromScalar(int32(0)))
+			sum := must1(fn.ConstantFromScalar(int32(0)))
+			condFn := fn.Closure()
+			condCounter := must1(condFn.Input(counter.Shape()))
+			_ = must1(condFn.Input(sum.Shape()))
+			limit := must1(condFn.ConstantFromScalar(int32(10)))
+			must(condFn.Return(must1(stablehlo.Compare(condCounter, limit, types.CompareLT, types.CompareSigned))))
+
+			bodyFn := fn.Closure()
+			bodyCounter := must1(bodyFn.Input(counter.Shape()))
+			bodySum := must1(bodyFn.Input(sum.Shape()))
+			one := must1(bodyFn.ConstantFromScalar(int32(1)))
+			must(bodyFn.Return(must1(stablehlo.Add(bodyCounter, one)), must1(stablehlo.Add(bodySum, bodyCounter))))
+			return must1(stablehlo.While(condFn, bodyFn, counter, sum))
+		})
+		checkValues(t, outputs[0], int32(10))
+		checkValues(t, outputs[1], int32(45))
+	})
+
+	t.Run("If", func(t *testing.T) {
+		b := buildMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			pred := must1(fn.Input(shapes.Make(dtypes.Bool)))
+			x := must1(fn.Input(shapes.Make(dtypes.Float64)))
+			// The branches use the value of x from the parent function.
+			trueBranch := fn.Closure()
+			must(trueBranch.Return(must1(stablehlo.Negate(must1(trueBranch.UseParentValue(x))))))
+			falseBranch := fn.Closure()
+			must(falseBranch.Return(must1(falseBranch.UseParentValue(x))))
+			return must1(stablehlo.If(pred, trueBranch, falseBranch))
+		})
+		for _, pred := range []bool{true, false} {
+			outputs, err := New(b).Run(NewScalar(pred), NewScalar(3.0))
+			if err != nil {
+				t.Fatalf("Run failed: %+v", err)
+			}
+			want := 3.0
+			if pred {
+				want = -3.0
+			}
+			checkValues(t, outputs[0], want)
+		}
+	})
+
+	t.Run("Call", func(t *testing.T) {
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			callee := fn.Builder.NewFunction("add_one")
+			calleeX := must1(callee.Input(shapes.Make(dtypes.Int64, 2)))
+			one := must1(callee.ConstantFromFlatAndDimensions([]int64{1, 1}, 2))
+			must(callee.Return(must1(stablehlo.Add(calleeX, one))))
+			x := must1(fn.ConstantFromFlatAndDimensions([]int64{1, 2}, 2))
+			return must1(stablehlo.Call(callee, x))
+		})
+		checkValues(t, outputs[0], []int64{2, 3})
+	})
+}
+
+func TestWithTrace(t *testing.T) {
+	b := buildMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		x := must1(fn.Input(shapes.Make(dtypes.Float32)))
+		return []*stablehlo.Value{must1(stablehlo.Log(must1(stablehlo.Negate(x))))}
+	})
+	var trace []string
+	outputs, err := New(b).WithTrace(func(stmt *stablehlo.Statement, inputs, outputs []*Tensor) {
+		trace = append(trace, fmt.Sprintf("%s(%v) -> %v", stmt.OpType, inputs[0].Value(), outputs[0].Value()))
+	}).Run(NewScalar(float32(1)))
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	checkValues(t, outputs[0], float32(math.NaN()))
+	want := []string{"Negate(1) -> -1", "Log(-1) -> NaN"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got trace %q, wanted %q", trace, want)
+	}
+}
+
+func TestParsedProgram(t *testing.T) {
+	program := `module @parsed {
+  func.func @main(%x: tensor<2x3xf32>) -> tensor<2xf32> {
+    %0 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %1 = "stablehlo.reduce"(%x, %0) ({
+      ^bb0(%a: tensor<f32>, %b: tensor<f32>):
+        %2 = "stablehlo.add"(%a, %b) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+        "stablehlo.return"(%2) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 1> } : (tensor<2x3xf32>, tensor<f32>) -> tensor<2xf32>
+    "stablehlo.return"(%1) : (tensor<2xf32>) -> ()
+  }
+}
+`
+	b, err := stablehlo.Parse([]byte(program))
+	if err != nil {
+		t.Fatalf("Parse failed: %+v", err)
+	}
+	x := must1(NewTensor([]float32{1, 2, 3, 4, 5, 6}, 2, 3))
+	outputs, err := New(b).Run(x)
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	checkValues(t, outputs[0], []float32{6, 15})
+}
+
+func TestParsedJAXExport(t *testing.T) {
+	text, err := os.ReadFile("../test_jax_export.mlir")
+	if err != nil {
+		t.Fatalf("failed to read the exported module: %v", err)
+	}
+	b, err := stablehlo.Parse(text)
+	if err != nil {
+		t.Fatalf("Parse failed: %+v", err)
+	}
+	x := must1(NewTensor([]float32{1, 2, 3, 4, 5, 6}, 2, 3))
+	y := must1(NewTensor([]float32{1, 0, 0, 1, 1, 1}, 3, 2))
+	outputs, err := New(b).Run(x, y)
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	checkValues(t, outputs[0], []float32{3.7837768, -3.9902845})
+	checkValues(t, outputs[1], []float32{4, 10, 5, 11})
+	checkValues(t, outputs[2], int32(10))
+	checkValues(t, outputs[3], []int32{2, 2})
+	checkValues(t, outputs[4], []float32{3.7837768, 1})
+}
+
+func TestUnsupportedOp(t *testing.T) {
+	b := buildMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		x := must1(fn.Input(shapes.Make(dtypes.Float32, 2)))
+		return must1(stablehlo.CustomCall(fn, "my_target", []*stablehlo.Value{x}, []shapes.Shape{x.Shape()}, nil))
+	})
+	_, err := New(b).Run(must1(NewTensor([]float32{1, 2}, 2)))
+	if err == nil || !strings.Contains(err.Error(), "not supported by the interpreter") {
+		t.Errorf("expected unsupported op error, got %v", err)
+	}
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,600 @@
+package interpreter
+
+import (
+	"math"
+	"math/cmplx"
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// accumulator is the set of types used to accumulate products in DotGeneral and Convolution.
+type accumulator interface {
+	int64 | uint64 | float64 | complex128
+}
+
+// multiplyAccumulate converts the operands to the accumulator type of their kind, calls fn to compute the
+// results, and converts them to the dtype of the output shape.
+func multiplyAccumulate(lhs, rhs *Tensor, shape shapes.Shape,
+	fn func(lhs, rhs any) any) any {
+	switch kindOf(lhs.Shape.DType) {
+	case complexKind:
+		return fromComplexes(shape.DType, fn(asComplexes(lhs.Flat), asComplexes(rhs.Flat)).([]complex128))
+	case floatKind:
+		return fromNumbers(shape.DType, fn(asNumbers[float64](lhs.Flat), asNumbers[float64](rhs.Flat)).([]float64))
+	case signedKind:
+		return fromNumbers(shape.DType, fn(asNumbers[int64](lhs.Flat), asNumbers[int64](rhs.Flat)).([]int64))
+	default:
+		return fromNumbers(shape.DType, fn(asNumbers[uint64](lhs.Flat), asNumbers[uint64](rhs.Flat)).([]uint64))
+	}
+}
+
+// dotGeneralOp evaluates DotGeneral. The output axes are the batch axes, followed by the lhs and the rhs free axes.
+func dotGeneralOp(stmt *stablehlo.Statement, lhs, rhs *Tensor, shape shapes.Shape) (any, error) {
+	fields, err := attributes.Struct(stmt, "dot_dimension_numbers")
+	if err != nil {
+		return nil, err
+	}
+	var axes [4][]int
+	for i, name := range []string{"lhs_batching_dimensions", "rhs_batching_dimensions",
+		"lhs_contracting_dimensions", "rhs_contracting_dimensions"} {
+		if axes[i], err = attributes.StructInts(fields, name); err != nil {
+			return nil, err
+		}
+	}
+	lhsBatchAxes, rhsBatchAxes, lhsContractingAxes, rhsContractingAxes := axes[0], axes[1], axes[2], axes[3]
+	freeAxes := func(rank int, batchAxes, contractingAxes []int) []int {
+		var free []int
+		for axis := range rank {
+			if !slices.Contains(batchAxes, axis) && !slices.Contains(contractingAxes, axis) {
+				free = append(free, axis)
+			}
+		}
+		return free
+	}
+	// Output axes mapped to the lhs and rhs axes (-1 if not present).
+	lhsFreeAxes := freeAxes(lhs.Shape.Rank(), lhsBatchAxes, lhsContractingAxes)
+	rhsFreeAxes := freeAxes(rhs.Shape.Rank(), rhsBatchAxes, rhsContractingAxes)
+	lhsOutputAxes := slices.Concat(lhsBatchAxes, lhsFreeAxes, slices.Repeat([]int{-1}, len(rhsFreeAxes)))
+	rhsOutputAxes := slices.Concat(rhsBatchAxes, slices.Repeat([]int{-1}, len(lhsFreeAxes)), rhsFreeAxes)
+	contractingDims := make([]int, len(lhsContractingAxes))
+	for i, axis := range lhsContractingAxes {
+		contractingDims[i] = lhs.Shape.Dimensions[axis]
+	}
+	lhsStrides, rhsStrides := stridesFor(lhs.Shape.Dimensions), stridesFor(rhs.Shape.Dimensions)
+
+	// Flat offsets of the contracting elements, for lhs and rhs.
+	var lhsContractingOffsets, rhsContractingOffsets []int
+	forEachIndex(contractingDims, func(index []int, _ int) {
+		var lhsOffset, rhsOffset int
+		for i, idx := range index {
+			lhsOffset += idx * lhsStrides[lhsContractingAxes[i]]
+			rhsOffset += idx * rhsStrides[rhsContractingAxes[i]]
+		}
+		lhsContractingOffsets = append(lhsContractingOffsets, lhsOffset)
+		rhsContractingOffsets = append(rhsContractingOffsets, rhsOffset)
+	})
+	baseOffsets := func(outputIndex []int) (lhsBase, rhsBase int) {
+		for outputAxis, idx := range outputIndex {
+			if axis := lhsOutputAxes[outputAxis]; axis >= 0 {
+				lhsBase += idx * lhsStrides[axis]
+			}
+			if axis := rhsOutputAxes[outputAxis]; axis >= 0 {
+				rhsBase += idx * rhsStrides[axis]
+			}
+		}
+		return
+	}
+	return multiplyAccumulate(lhs, rhs, shape, func(lhsValues, rhsValues any) any {
+		switch lhsValues := lhsValues.(type) {
+		case []float64:
+			return dotGeneral(lhsValues, rhsValues.([]float64), shape, baseOffsets, lhsContractingOffsets, rhsContractingOffsets)
+		case []int64:
+			return dotGeneral(lhsValues, rhsValues.([]int64), shape, baseOffsets, lhsContractingOffsets, rhsContractingOffsets)
+		case []uint64:
+			return dotGeneral(lhsValues, rhsValues.([]uint64), shape, baseOffsets, lhsContractingOffsets, rhsContractingOffsets)
+		default:
+			return dotGeneral(lhsValues.([]complex128), rhsValues.([]complex128), shape, baseOffsets,
+				lhsContractingOffsets, rhsContractingOffsets)
+		}
+	}), nil
+}
+
+func dotGeneral[A accumulator](lhs, rhs []A, shape shapes.Shape, baseOffsets func(outputIndex []int) (int, int),
+	lhsContractingOffsets, rhsContractingOffsets []int) []A {
+	output := make([]A, shape.Size())
+	forEachIndex(shape.Dimensions, func(outputIndex []int, outputFlatIdx int) {
+		lhsBase, rhsBase := baseOffsets(outputIndex)
+		var sum A
+		for i, lhsOffset := range lhsContractingOffsets {
+			sum += lhs[lhsBase+lhsOffset] * rhs[rhsBase+rhsContractingOffsets[i]]
+		}
+		output[outputFlatIdx] = sum
+	})
+	return output
+}
+
+// convolutionOp follows the semantics in https://openxla.org/stablehlo/spec#convolution.
+func convolutionOp(stmt *stablehlo.Statement, input, kernel *Tensor, shape shapes.Shape) (any, error) {
+	inputAxes, kernelAxes, outputAxes, err := attributes.ConvDimensionNumbers(stmt, "dimension_numbers")
+	if err != nil {
+		return nil, err
+	}
+	numSpatial := len(inputAxes.Spatial)
+	ones := slices.Repeat([]int{1}, numSpatial)
+	strides, err := attributes.OptionalInts(stmt, "window_strides", ones)
+	if err != nil {
+		return nil, err
+	}
+	paddings, err := attributes.OptionalInts(stmt, "padding", make([]int, 2*numSpatial))
+	if err != nil {
+		return nil, err
+	}
+	inputDilations, err := attributes.OptionalInts(stmt, "lhs_dilation", ones)
+	if err != nil {
+		return nil, err
+	}
+	kernelDilations, err := attributes.OptionalInts(stmt, "rhs_dilation", ones)
+	if err != nil {
+		return nil, err
+	}
+	reversal, err := attributes.OptionalInts(stmt, "window_reversal", make([]int, numSpatial))
+	if err != nil {
+		return nil, err
+	}
+	featureGroupCount, err := attributes.Int(stmt, "feature_group_count")
+	if err != nil {
+		return nil, err
+	}
+	batchGroupCount, err := attributes.Int(stmt, "batch_group_count")
+	if err != nil {
+		return nil, err
+	}
+
+	inputDims, kernelDims := input.Shape.Dimensions, kernel.Shape.Dimensions
+	inputStrides, kernelStrides := stridesFor(inputDims), stridesFor(kernelDims)
+	kernelSpatialDims := make([]int, numSpatial)
+	for i, axis := range kernelAxes.Spatial {
+		kernelSpatialDims[i] = kernelDims[axis]
+	}
+	outputFeatures := shape.Dimensions[outputAxes.FeatureOrOutput]
+	outputBatch := shape.Dimensions[outputAxes.BatchOrInput]
+	kernelInputFeatures := kernelDims[kernelAxes.BatchOrInput]
+
+	// convolve returns the list of (input, kernel) flat indices pairs to multiply and sum for an output element.
+	convolve := func(outputIndex []int) (inputIndices, kernelIndices []int) {
+		outputFeature := outputIndex[outputAxes.FeatureOrOutput]
+		batch := outputIndex[outputAxes.BatchOrInput]
+		if batchGroupCount > 1 {
+			batchGroup := outputFeature / (outputFeatures / batchGroupCount)
+			batch += batchGroup * outputBatch
+		}
+		featureGroup := outputFeature / (outputFeatures / featureGroupCount)
+		inputIndex := make([]int, len(inputDims))
+		kernelIndex := make([]int, len(kernelDims))
+		inputIndex[inputAxes.BatchOrInput] = batch
+		kernelIndex[kernelAxes.FeatureOrOutput] = outputFeature
+		forEachIndex(kernelSpatialDims, func(windowIndex []int, _ int) {
+			for i, k := range windowIndex {
+				position := outputIndex[outputAxes.Spatial[i]]*strides[i] - paddings[2*i] + k*kernelDilations[i]
+				if position < 0 || position%inputDilations[i] != 0 {
+					return
+				}
+				position /= inputDilations[i]
+				if position >= inputDims[inputAxes.Spatial[i]] {
+					return
+				}
+				inputIndex[inputAxes.Spatial[i]] = position
+				if reversal[i] != 0 {
+					k = kernelSpatialDims[i] - 1 - k
+				}
+				kernelIndex[kernelAxes.Spatial[i]] = k
+			}
+			for kernelFeature := range kernelInputFeatures {
+				inputIndex[inputAxes.FeatureOrOutput] = featureGroup*kernelInputFeatures + kernelFeature
+				kernelIndex[kernelAxes.BatchOrInput] = kernelFeature
+				inputIndices = append(inputIndices, flatIndex(inputIndex, inputStrides))
+				kernelIndices = append(kernelIndices, flatIndex(kernelIndex, kernelStrides))
+			}
+		})
+		return
+	}
+	return multiplyAccumulate(input, kernel, shape, func(inputValues, kernelValues any) any {
+		switch inputValues := inputValues.(type) {
+		case []float64:
+			return convolution(inputValues, kernelValues.([]float64), shape, convolve)
+		case []int64:
+			return convolution(inputValues, kernelValues.([]int64), shape, convolve)
+		case []uint64:
+			return convolution(inputValues, kernelValues.([]uint64), shape, convolve)
+		default:
+			return convolution(inputValues.([]complex128), kernelValues.([]complex128), shape, convolve)
+		}
+	}), nil
+}
+
+func convolution[A accumulator](input, kernel []A, shape shapes.Shape,
+	convolve func(outputIndex []int) (inputIndices, kernelIndices []int)) []A {
+	output := make([]A, shape.Size())
+	forEachIndex(shape.Dimensions, func(outputIndex []int, outputFlatIdx int) {
+		inputIndices, kernelIndices := convolve(outputIndex)
+		var sum A
+		for i, inputIdx := range inputIndices {
+			sum += input[inputIdx] * kernel[kernelIndices[i]]
+		}
+		output[outputFlatIdx] = sum
+	})
+	return output
+}
+
+// forEachMatrix calls fn with the flat offset of each matrix in the batch of matrices of the given
+// dimensions (the last two axes are the matrix axes).
+func forEachMatrix(dimensions []int, fn func(offset int)) {
+	rank := len(dimensions)
+	matrixSize := dimensions[rank-2] * dimensions[rank-1]
+	for i := range sizeOf(dimensions[:rank-2]) {
+		fn(i * matrixSize)
+	}
+}
+
+// choleskyOp evaluates the Cholesky decomposition of a batch of (real) matrices. Only the lower (or upper)
+// triangle of the operand is used, and the other triangle of the output is set to 0.
+func choleskyOp(stmt *stablehlo.Statement, a *Tensor) (any, error) {
+	if kindOf(a.Shape.DType) != floatKind {
+		return nil, errors.Errorf("Cholesky not supported for dtype %s", a.Shape.DType)
+	}
+	lower, err := attributes.OptionalBool(stmt, "lower", false)
+	if err != nil {
+		return nil, err
+	}
+	values := asNumbers[float64](a.Flat)
+	output := make([]float64, len(values))
+	n := a.Shape.Dimensions[a.Shape.Rank()-1]
+	forEachMatrix(a.Shape.Dimensions, func(offset int) {
+		// at returns the element (i, j) of the lower triangle of the matrix, and set sets it in the output.
+		at := func(i, j int) float64 {
+			if !lower {
+				i, j = j, i
+			}
+			return values[offset+i*n+j]
+		}
+		l := make([]float64, n*n)
+		for j := range n {
+			sum := at(j, j)
+			for k := range j {
+				sum -= l[j*n+k] * l[j*n+k]
+			}
+			l[j*n+j] = math.Sqrt(sum)
+			for i := j + 1; i < n; i++ {
+				sum := at(i, j)
+				for k := range j {
+					sum -= l[i*n+k] * l[j*n+k]
+				}
+				l[i*n+j] = sum / l[j*n+j]
+			}
+		}
+		for i := range n {
+			for j := range i + 1 {
+				if lower {
+					output[offset+i*n+j] = l[i*n+j]
+				} else {
+					output[offset+j*n+i] = l[i*n+j]
+				}
+			}
+		}
+	})
+	return fromNumbers(a.Shape.DType, output), nil
+}
+
+// triangularSolveOp solves op(a) * x = b (leftSide) or x * op(a) = b for x, for batches of (real) matrices.
+func triangularSolveOp(stmt *stablehlo.Statement, a, b *Tensor) (any, error) {
+	if kindOf(a.Shape.DType) != floatKind {
+		return nil, errors.Errorf("TriangularSolve not supported for dtype %s", a.Shape.DType)
+	}
+	leftSide, err := attributes.OptionalBool(stmt, "left_side", false)
+	if err != nil {
+		return nil, err
+	}
+	lower, err := attributes.OptionalBool(stmt, "lower", false)
+	if err != nil {
+		return nil, err
+	}
+	unitDiagonal, err := attributes.OptionalBool(stmt, "unit_diagonal", false)
+	if err != nil {
+		return nil, err
+	}
+	transposeA := "NO_TRANSPOSE"
+	if _, found := stmt.Attributes["transpose_a"]; found {
+		if transposeA, err = attributes.Enum(stmt, "transpose_a"); err != nil {
+			return nil, err
+		}
+	}
+	transposed := transposeA != "NO_TRANSPOSE" // ADJOINT is the same as TRANSPOSE for real numbers.
+
+	aValues, bValues := asNumbers[float64](a.Flat), asNumbers[float64](b.Flat)
+	output := make([]float64, len(bValues))
+	m := a.Shape.Dimensions[a.Shape.Rank()-1]
+	rows, cols := b.Shape.Dimensions[b.Shape.Rank()-2], b.Shape.Dimensions[b.Shape.Rank()-1]
+	batchIdx := 0
+	forEachMatrix(a.Shape.Dimensions, func(aOffset int) {
+		bOffset := batchIdx * rows * cols
+		batchIdx++
+		// t is the effective triangular matrix op(a).
+		t := make([]float64, m*m)
+		for i := range m {
+			for j := range m {
+				if (lower && j > i) || (!lower && j < i) {
+					continue
+				}
+				value := aValues[aOffset+i*m+j]
+				if i == j && unitDiagonal {
+					value = 1
+				}
+				if transposed {
+					t[j*m+i] = value
+				} else {
+					t[i*m+j] = value
+				}
+			}
+		}
+		isLower := lower != transposed
+		if leftSide {
+			// Solve t * x = b, column by column.
+			for col := range cols {
+				x := solveTriangular(t, m, isLower, func(i int) float64 { return bValues[bOffset+i*cols+col] })
+				for i, v := range x {
+					output[bOffset+i*cols+col] = v
+				}
+			}
+		} else {
+			// x * t = b  <=>  t^T * x^T = b^T, row by row.
+			tt := make([]float64, m*m)
+			for i := range m {
+				for j := range m {
+					tt[j*m+i] = t[i*m+j]
+				}
+			}
+			for row := range rows {
+				x := solveTriangular(tt, m, !isLower, func(i int) float64 { return bValues[bOffset+row*cols+i] })
+				copy(output[bOffset+row*cols:], x)
+			}
+		}
+	})
+	return fromNumbers(b.Shape.DType, output), nil
+}
+
+// solveTriangular solves t * x = b, where t is a triangular m x m matrix, by forward or backward substitution.
+func solveTriangular(t []float64, m int, isLower bool, b func(i int) float64) []float64 {
+	x := make([]float64, m)
+	for step := range m {
+		i := step
+		if !isLower {
+			i = m - 1 - step
+		}
+		sum := b(i)
+		for j := range m {
+			if j != i {
+				sum -= t[i*m+j] * x[j]
+			}
+		}
+		x[i] = sum / t[i*m+i]
+	}
+	return x
+}
+
+// fftOp evaluates a Fast Fourier Transform (naively implemented as a DFT) over the last len(fft_length) axes.
+func fftOp(stmt *stablehlo.Statement, x *Tensor, shape shapes.Shape) (any, error) {
+	fftType, err := attributes.Enum(stmt, "fft_type")
+	if err != nil {
+		return nil, err
+	}
+	fftLength, err := attributes.Ints(stmt, "fft_length")
+	if err != nil {
+		return nil, err
+	}
+	rank := x.Shape.Rank()
+	values := asComplexes(x.Flat)
+	dims := slices.Clone(x.Shape.Dimensions)
+	switch fftType {
+	case "FFT", "IFFT":
+		inverse := fftType == "IFFT"
+		for axis := rank - len(fftLength); axis < rank; axis++ {
+			values = dftAxis(values, dims, axis, inverse)
+		}
+	case "RFFT":
+		for axis := rank - len(fftLength); axis < rank; axis++ {
+			values = dftAxis(values, dims, axis, false)
+		}
+		// Keep only the non-redundant half of the last axis.
+		values = resizeLastAxis(values, dims, shape.Dimensions[rank-1])
+	case "IRFFT":
+		for axis := rank - len(fftLength); axis < rank-1; axis++ {
+			values = dftAxis(values, dims, axis, true)
+		}
+		// Restore the last axis from the Hermitian symmetry, before the last inverse transform.
+		half := dims[rank-1]
+		length := fftLength[len(fftLength)-1]
+		values = resizeLastAxis(values, dims, length)
+		dims[rank-1] = length
+		for offset := 0; offset < len(values); offset += length {
+			for k := half; k < length; k++ {
+				values[offset+k] = cmplx.Conj(values[offset+length-k])
+			}
+		}
+		values = dftAxis(values, dims, rank-1, true)
+	default:
+		return nil, errors.Errorf("unknown FFT type %q", fftType)
+	}
+	return fromComplexes(shape.DType, values), nil
+}
+
+// dftAxis computes the discrete Fourier transform of the values along the given axis.
+// The inverse transform is normalized by 1/n.
+func dftAxis(values []complex128, dims []int, axis int, inverse bool) []complex128 {
+	n := dims[axis]
+	stride := stridesFor(dims)[axis]
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	output := make([]complex128, len(values))
+	forEachIndex(dims, func(index []int, flatIdx int) {
+		k := index[axis]
+		base := flatIdx - k*stride
+		var sum complex128
+		for j := range n {
+			angle := sign * 2 * math.Pi * float64(j*k%n) / float64(n)
+			sum += values[base+j*stride] * cmplx.Exp(complex(0, angle))
+		}
+		if inverse {
+			sum /= complex(float64(n), 0)
+		}
+		output[flatIdx] = sum
+	})
+	return output
+}
+
+// resizeLastAxis truncates or zero-pads the last axis of the values to the given length.
+func resizeLastAxis(values []complex128, dims []int, length int) []complex128 {
+	lastDim := dims[len(dims)-1]
+	numRows := len(values) / max(lastDim, 1)
+	output := make([]complex128, numRows*length)
+	for row := range numRows {
+		copy(output[row*length:(row+1)*length], values[row*lastDim:row*lastDim+min(lastDim, length)])
+	}
+	return output
+}
+
+// batchNormConfig holds the attributes and the per-feature layout of the batch normalization ops.
+type batchNormConfig struct {
+	epsilon float64
+	// numFeatures is the dimension of the feature axis, and featureStride its stride.
+	numFeatures, featureStride int
+	// count is the number of elements per feature.
+	count int
+}
+
+func readBatchNormConfig(stmt *stablehlo.Statement, operand *Tensor) (*batchNormConfig, error) {
+	epsilon, err := attributes.Float(stmt, "epsilon")
+	if err != nil {
+		return nil, err
+	}
+	featureAxis, err := attributes.Int(stmt, "feature_index")
+	if err != nil {
+		return nil, err
+	}
+	numFeatures := operand.Shape.Dimensions[featureAxis]
+	return &batchNormConfig{
+		epsilon:       epsilon,
+		numFeatures:   numFeatures,
+		featureStride: stridesFor(operand.Shape.Dimensions)[featureAxis],
+		count:         operand.Shape.Size() / max(numFeatures, 1),
+	}, nil
+}
+
+// feature returns the feature of the element at the flat index.
+func (cfg *batchNormConfig) feature(flatIdx int) int {
+	return (flatIdx / cfg.featureStride) % cfg.numFeatures
+}
+
+// meanAndVariance returns the mean and (biased) variance of the values for each feature.
+func (cfg *batchNormConfig) meanAndVariance(values []float64) (mean, variance []float64) {
+	mean, variance = make([]float64, cfg.numFeatures), make([]float64, cfg.numFeatures)
+	for i, v := range values {
+		mean[cfg.feature(i)] += v
+	}
+	for f := range mean {
+		mean[f] /= float64(cfg.count)
+	}
+	for i, v := range values {
+		centered := v - mean[cfg.feature(i)]
+		variance[cfg.feature(i)] += centered * centered
+	}
+	for f := range variance {
+		variance[f] /= float64(cfg.count)
+	}
+	return
+}
+
+// normalize returns scale * (x - mean) / sqrt(variance + epsilon) + offset, for each element.
+func (cfg *batchNormConfig) normalize(x, scale, offset, mean, variance []float64) []float64 {
+	output := make([]float64, len(x))
+	for i, v := range x {
+		f := cfg.feature(i)
+		output[i] = scale[f]*(v-mean[f])/math.Sqrt(variance[f]+cfg.epsilon) + offset[f]
+	}
+	return output
+}
+
+// batchNormInferenceOp: the inputs are operand, scale, offset, mean and variance.
+func batchNormInferenceOp(stmt *stablehlo.Statement, inputs []*Tensor) (any, error) {
+	cfg, err := readBatchNormConfig(stmt, inputs[0])
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		values[i] = asNumbers[float64](input.Flat)
+	}
+	return fromNumbers(inputs[0].Shape.DType, cfg.normalize(values[0], values[1], values[2], values[3], values[4])), nil
+}
+
+// batchNormTrainingOp: the inputs are operand, scale and offset, and the outputs are the normalized operand,
+// the batch mean and the batch variance.
+func batchNormTrainingOp(stmt *stablehlo.Statement, inputs []*Tensor) ([]*Tensor, error) {
+	cfg, err := readBatchNormConfig(stmt, inputs[0])
+	if err != nil {
+		return nil, err
+	}
+	x := asNumbers[float64](inputs[0].Flat)
+	mean, variance := cfg.meanAndVariance(x)
+	normalized := cfg.normalize(x, asNumbers[float64](inputs[1].Flat), asNumbers[float64](inputs[2].Flat), mean, variance)
+	return floatOutputs(stmt, normalized, mean, variance), nil
+}
+
+// batchNormGradOp: the inputs are operand, scale, mean, variance and the gradient of the output, and the outputs
+// are the gradients of the operand, scale and offset.
+func batchNormGradOp(stmt *stablehlo.Statement, inputs []*Tensor) ([]*Tensor, error) {
+	cfg, err := readBatchNormConfig(stmt, inputs[0])
+	if err != nil {
+		return nil, err
+	}
+	x := asNumbers[float64](inputs[0].Flat)
+	scale := asNumbers[float64](inputs[1].Flat)
+	mean := asNumbers[float64](inputs[2].Flat)
+	variance := asNumbers[float64](inputs[3].Flat)
+	gradOutput := asNumbers[float64](inputs[4].Flat)
+
+	stddev := make([]float64, cfg.numFeatures)
+	for f := range stddev {
+		stddev[f] = math.Sqrt(variance[f] + cfg.epsilon)
+	}
+	normalized := make([]float64, len(x))
+	gradScale, gradOffset := make([]float64, cfg.numFeatures), make([]float64, cfg.numFeatures)
+	for i, v := range x {
+		f := cfg.feature(i)
+		normalized[i] = (v - mean[f]) / stddev[f]
+		gradOffset[f] += gradOutput[i]
+		gradScale[f] += gradOutput[i] * normalized[i]
+	}
+	gradOperand := make([]float64, len(x))
+	n := float64(cfg.count)
+	for i := range x {
+		f := cfg.feature(i)
+		gradOperand[i] = scale[f] / stddev[f] * (gradOutput[i] - gradOffset[f]/n - normalized[i]*gradScale[f]/n)
+	}
+	return floatOutputs(stmt, gradOperand, gradScale, gradOffset), nil
+}
+
+// floatOutputs converts the values to the outputs of the statement.
+func floatOutputs(stmt *stablehlo.Statement, values ...[]float64) []*Tensor {
+	outputs := make([]*Tensor, len(values))
+	for i, v := range values {
+		shape := stmt.Outputs[i].Shape()
+		outputs[i] = &Tensor{Shape: shape, Flat: fromNumbers(shape.DType, v)}
+	}
+	return outputs
+}
@@ -0,0 +1,246 @@
+package interpreter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+// scalarClosure returns a closure of fn that applies the binary op to two scalars of the given dtype.
+func scalarClosure(fn *stablehlo.Function, dtype dtypes.DType,
+	op func(lhs, rhs *stablehlo.Value) (*stablehlo.Value, error)) *stablehlo.Function {
+	closure := fn.Closure()
+	lhs := must1(closure.Input(shapes.Make(dtype)))
+	rhs := must1(closure.Input(shapes.Make(dtype)))
+	must(closure.Return(must1(op(lhs, rhs))))
+	return closure
+}
+
+func TestElementWiseOps(t *testing.T) {
+	t.Run("Float", func(t *testing.T) {
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, -2, 3.5, -4}, 4))
+			y := must1(fn.ConstantFromFlatAndDimensions([]float32{2, 2, float32(math.NaN()), 1}, 4))
+			return []*stablehlo.Value{
+				must1(stablehlo.Add(x, y)),
+				must1(stablehlo.Maximum(x, y)),
+				must1(stablehlo.Abs(x)),
+				must1(stablehlo.Floor(x)),
+				must1(stablehlo.IsFinite(y)),
+			}
+		})
+		checkValues(t, outputs[0], []float32{3, 0, float32(math.NaN()), -3})
+		checkValues(t, outputs[1], []float32{2, 2, float32(math.NaN()), 1})
+		checkValues(t, outputs[2], []float32{1, 2, 3.5, 4})
+		checkValues(t, outputs[3], []float32{1, -2, 3, -4})
+		checkValues(t, outputs[4], []bool{true, true, false, true})
+	})
+
+	t.Run("Integer", func(t *testing.T) {
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.ConstantFromFlatAndDimensions([]int32{7, -7, 5, math.MaxInt32}, 4))
+			y := must1(fn.ConstantFromFlatAndDimensions([]int32{2, 2, 0, 1}, 4))
+			u := must1(fn.ConstantFromFlatAndDimensions([]uint8{250, 3}, 2))
+			return []*stablehlo.Value{
+				must1(stablehlo.Divide(x, y)),
+				must1(stablehlo.Remainder(x, y)),
+				must1(stablehlo.Add(x, y)),
+				must1(stablehlo.Add(u, u)),
+			}
+		})
+		checkValues(t, outputs[0], []int32{3, -3, -1, math.MaxInt32})
+		checkValues(t, outputs[1], []int32{1, -1, 5, 0})
+		checkValues(t, outputs[2], []int32{9, -5, 5, math.MinInt32})
+		checkValues(t, outputs[3], []uint8{244, 6})
+	})
+
+	t.Run("Complex", func(t *testing.T) {
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.ConstantFromFlatAndDimensions([]complex64{1 + 2i, 3 - 4i}, 2))
+			return []*stablehlo.Value{
+				must1(stablehlo.Multiply(x, x)),
+				must1(stablehlo.Abs(x)),
+				must1(stablehlo.Imag(x)),
+			}
+		})
+		checkValues(t, outputs[0], []complex64{-3 + 4i, -7 - 24i})
+		checkValues(t, outputs[1], []float32{float32(math.Sqrt(5)), 5})
+		checkValues(t, outputs[2], []float32{2, -4})
+	})
+
+	t.Run("CompareSelectClampConvert", func(t *testing.T) {
+		outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.ConstantFromFlatAndDimensions([]float64{-1.7, 0.5, 2.5}, 3))
+			zeros := must1(fn.ConstantFromFlatAndDimensions([]float64{0, 0, 0}, 3))
+			ones := must1(fn.ConstantFromFlatAndDimensions([]float64{1, 1, 1}, 3))
+			isNegative := must1(stablehlo.Compare(x, zeros, types.CompareLT, types.CompareFloat))
+			return []*stablehlo.Value{
+				isNegative,
+				must1(stablehlo.Select(isNegative, zeros, x)),
+				must1(stablehlo.Clamp(zeros, x, ones)),
+				must1(stablehlo.Convert(x, dtypes.Int8)),
+				must1(stablehlo.ReducePrecision(x, 5, 1)),
+			}
+		})
+		checkValues(t, outputs[0], []bool{true, false, false})
+		checkValues(t, outputs[1], []float64{0, 0.5, 2.5})
+		checkValues(t, outputs[2], []float64{0, 0.5, 1})
+		checkValues(t, outputs[3], []int8{-1, 0, 2})
+		checkValues(t, outputs[4], []float64{-1.5, 0.5, 2})
+	})
+}
+
+func TestShapeOps(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		iota := must1(fn.Iota(shapes.Make(dtypes.Int32, 2, 3), 1))
+		x := must1(fn.ConstantFromFlatAndDimensions([]int32{1, 2, 3, 4, 5, 6}, 2, 3))
+		zero := must1(fn.ConstantFromScalar(int32(0)))
+		one := must1(fn.ConstantFromScalar(int32(1)))
+		return []*stablehlo.Value{
+			iota,
+			must1(stablehlo.Transpose(x, 1, 0)),
+			must1(stablehlo.Reshape(x, shapes.Make(dtypes.Int32, 3, 2))),
+			must1(stablehlo.BroadcastInDim(must1(stablehlo.Slice(x, []int{0, 1}, []int{2, 2}, nil)),
+				shapes.Make(dtypes.Int32, 2, 2), []int{0, 1})),
+			must1(stablehlo.Pad(must1(stablehlo.Slice(x, []int{0, 0}, []int{1, 3}, []int{1, 2})), zero,
+				[]int{0, 1}, []int{1, 0}, []int{0, 1})),
+			must1(stablehlo.Concatenate(0, x, iota)),
+			must1(stablehlo.Reverse(x, 0, 1)),
+			must1(stablehlo.DynamicSlice(x, []*stablehlo.Value{one, one}, []int{2, 2})),
+			must1(stablehlo.DynamicUpdateSlice(x, must1(stablehlo.Slice(x, []int{0, 0}, []int{1, 2}, nil)),
+				[]*stablehlo.Value{one, one})),
+		}
+	})
+	checkValues(t, outputs[0], []int32{0, 1, 2, 0, 1, 2})
+	checkValues(t, outputs[1], []int32{1, 4, 2, 5, 3, 6})
+	checkValues(t, outputs[2], []int32{1, 2, 3, 4, 5, 6})
+	checkValues(t, outputs[3], []int32{2, 2, 5, 5})
+	checkValues(t, outputs[4], []int32{0, 1, 0, 3, 0, 0, 0, 0})
+	checkValues(t, outputs[5], []int32{1, 2, 3, 4, 5, 6, 0, 1, 2, 0, 1, 2})
+	checkValues(t, outputs[6], []int32{6, 5, 4, 3, 2, 1})
+	// The start indices are clamped, so the slice fits in the operand.
+	checkValues(t, outputs[7], []int32{2, 3, 5, 6})
+	checkValues(t, outputs[8], []int32{1, 2, 3, 4, 1, 2})
+}
+
+func TestGatherAndScatter(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		operand := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3, 4, 5, 6}, 3, 2))
+		indices := must1(fn.ConstantFromFlatAndDimensions([]int32{2, 0}, 2, 1))
+		gathered := must1(stablehlo.Gather(operand, indices, 1,
+			[]int{1}, []int{0}, nil, nil, []int{0}, []int{1, 2}, false))
+
+		zeros := must1(fn.ConstantFromFlatAndDimensions([]float32{0, 0, 0}, 3))
+		scatterIndices := must1(fn.ConstantFromFlatAndDimensions([]int32{1, 1, 5}, 3, 1))
+		updates := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		scattered := must1(stablehlo.Scatter(zeros, scatterIndices, updates,
+			nil, []int{0}, nil, nil, []int{0}, 1, false, false,
+			scalarClosure(fn, dtypes.Float32, stablehlo.Add)))
+		return []*stablehlo.Value{gathered, scattered}
+	})
+	checkValues(t, outputs[0], []float32{5, 6, 1, 2})
+	// The out-of-bounds update is skipped.
+	checkValues(t, outputs[1], []float32{0, 3, 0})
+}
+
+func TestReductions(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 5, 3, 4, 2, 6}, 2, 3))
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		minusInf := must1(fn.ConstantFromScalar(float32(math.Inf(-1))))
+		keys := must1(fn.ConstantFromFlatAndDimensions([]int32{3, 1, 2, 1}, 4))
+		values := must1(fn.Iota(shapes.Make(dtypes.Int32, 4), 0))
+		comparator := fn.Closure()
+		lhsKey := must1(comparator.Input(shapes.Make(dtypes.Int32)))
+		rhsKey := must1(comparator.Input(shapes.Make(dtypes.Int32)))
+		_ = must1(comparator.Input(shapes.Make(dtypes.Int32)))
+		_ = must1(comparator.Input(shapes.Make(dtypes.Int32)))
+		must(comparator.Return(must1(stablehlo.Compare(lhsKey, rhsKey, types.CompareLT, types.CompareSigned))))
+		sorted := must1(stablehlo.Sort(comparator, 0, true, keys, values))
+		return []*stablehlo.Value{
+			must1(stablehlo.Reduce(x, zero, scalarClosure(fn, dtypes.Float32, stablehlo.Add), 1)),
+			must1(stablehlo.ReduceWindow(x, minusInf, scalarClosure(fn, dtypes.Float32, stablehlo.Maximum),
+				[]int{1, 2}, []int{1, 1}, nil, nil, [][2]int{{0, 0}, {0, 1}})),
+			sorted[0], sorted[1],
+		}
+	})
+	checkValues(t, outputs[0], []float32{9, 12})
+	checkValues(t, outputs[1], []float32{5, 5, 3, 4, 6, 6})
+	checkValues(t, outputs[2], []int32{1, 1, 2, 3})
+	checkValues(t, outputs[3], []int32{1, 3, 2, 0})
+}
+
+func TestLinearAlgebraOps(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		lhs := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3, 4, 5, 6}, 2, 3))
+		rhs := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 0, 0, 1, 1, 1}, 3, 2))
+		input := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3, 4}, 1, 1, 4))
+		kernel := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 10}, 1, 1, 2))
+		spd := must1(fn.ConstantFromFlatAndDimensions([]float64{4, 2, 2, 3}, 2, 2))
+		lower := must1(fn.ConstantFromFlatAndDimensions([]float64{2, 99, 1, 1}, 2, 2))
+		b := must1(fn.ConstantFromFlatAndDimensions([]float64{2, 3}, 2, 1))
+		return []*stablehlo.Value{
+			must1(stablehlo.DotGeneral(lhs, []int{1}, nil, rhs, []int{0}, nil).Done()),
+			must1(stablehlo.Convolution(input, kernel, nil, [][2]int{{0, 1}}, nil, nil,
+				0, 1, []int{2}, 1, 0, []int{2}, 0, 1, []int{2}, 1, 1,
+				types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault)),
+			must1(stablehlo.Cholesky(spd, true)),
+			must1(stablehlo.TriangularSolve(lower, b, true, true, false, false)),
+		}
+	})
+	checkValues(t, outputs[0], []float32{4, 5, 10, 11})
+	checkValues(t, outputs[1], []float32{21, 32, 43, 4})
+	checkValues(t, outputs[2], []float64{2, 0, 1, math.Sqrt2})
+	checkValues(t, outputs[3], []float64{1, 2})
+}
+
+func TestFFT(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3, 4}, 4))
+		rfft := must1(stablehlo.FFT(x, types.FFTForwardReal, 4))
+		impulse := must1(fn.ConstantFromFlatAndDimensions([]complex64{1, 0, 0, 0}, 4))
+		return []*stablehlo.Value{
+			rfft,
+			must1(stablehlo.FFT(rfft, types.FFTInverseReal, 4)),
+			must1(stablehlo.FFT(impulse, types.FFTForward, 4)),
+			must1(stablehlo.FFT(impulse, types.FFTInverse, 4)),
+		}
+	})
+	checkValues(t, outputs[0], []complex64{10, -2 + 2i, -2})
+	checkValues(t, outputs[1], []float32{1, 2, 3, 4})
+	checkValues(t, outputs[2], []complex64{1, 1, 1, 1})
+	checkValues(t, outputs[3], []complex64{0.25, 0.25, 0.25, 0.25})
+}
+
+func TestBatchNorm(t *testing.T) {
+	outputs := runMain(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+		// 2 examples with 2 features each.
+		x := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 10, 3, 30}, 2, 2))
+		scale := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2}, 2))
+		offset := must1(fn.ConstantFromFlatAndDimensions([]float32{0, 1}, 2))
+		normalized, mean, variance := must3(stablehlo.BatchNormTraining(x, scale, offset, 0, 1))
+		inference := must1(stablehlo.BatchNormInference(x, scale, offset, mean, variance, 0, 1))
+		gradOutput := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 1, 0, 0}, 2, 2))
+		gradOperand, gradScale, gradOffset := must3(stablehlo.BatchNormGradient(x, scale, mean, variance,
+			gradOutput, 0, 1))
+		return []*stablehlo.Value{normalized, mean, variance, inference, gradOperand, gradScale, gradOffset}
+	})
+	checkValues(t, outputs[0], []float32{-1, -1, 1, 3})
+	checkValues(t, outputs[1], []float32{2, 20})
+	checkValues(t, outputs[2], []float32{1, 100})
+	checkValues(t, outputs[3], []float32{-1, -1, 1, 3})
+	checkValues(t, outputs[4], []float32{0, 0, 0, 0})
+	checkValues(t, outputs[5], []float32{-1, -1})
+	checkValues(t, outputs[6], []float32{1, 1})
+}
+
+func must3[T1, T2, T3 any](value1 T1, value2 T2, value3 T3, err error) (T1, T2, T3) {
+	if err != nil {
+		panic(err)
+	}
+	return value1, value2, value3
+}
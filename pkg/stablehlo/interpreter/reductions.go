@@ -0,0 +1,296 @@
+package interpreter
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// reduceOp evaluates a Reduce: the inputs are the N operands followed by the N initial values.
+// The reduction function takes the N accumulators followed by the N values, and returns the N new accumulators.
+func reduceOp(stmt *stablehlo.Statement, inputs []*Tensor, reductionFn closureFn) ([]*Tensor, error) {
+	axes, err := attributes.Ints(stmt, "dimensions")
+	if err != nil {
+		return nil, err
+	}
+	numOperands := len(inputs) / 2
+	operands, initialValues := inputs[:numOperands], inputs[numOperands:]
+	dims := operands[0].Shape.Dimensions
+	var outputDims []int
+	for axis, dim := range dims {
+		if !slices.Contains(axes, axis) {
+			outputDims = append(outputDims, dim)
+		}
+	}
+	outputStrides := stridesFor(outputDims)
+
+	// accumulators[outputFlatIdx] holds the N accumulated values for each output element.
+	accumulators := make([][]*Tensor, sizeOf(outputDims))
+	for i := range accumulators {
+		accumulators[i] = initialValues
+	}
+	var loopErr error
+	forEachIndex(dims, func(index []int, flatIdx int) {
+		if loopErr != nil {
+			return
+		}
+		var outputFlatIdx, outputAxis int
+		for axis, idx := range index {
+			if !slices.Contains(axes, axis) {
+				outputFlatIdx += idx * outputStrides[outputAxis]
+				outputAxis++
+			}
+		}
+		fnInputs := slices.Clone(accumulators[outputFlatIdx])
+		for _, operand := range operands {
+			fnInputs = append(fnInputs, operand.scalarAt(flatIdx))
+		}
+		accumulators[outputFlatIdx], loopErr = reductionFn(fnInputs...)
+	})
+	if loopErr != nil {
+		return nil, loopErr
+	}
+	return gatherScalars(stmt, accumulators), nil
+}
+
+// gatherScalars assembles the outputs of a statement from the scalar values of each output element:
+// scalars[outputFlatIdx][outputIdx].
+func gatherScalars(stmt *stablehlo.Statement, scalars [][]*Tensor) []*Tensor {
+	outputs := make([]*Tensor, len(stmt.Outputs))
+	for i, output := range stmt.Outputs {
+		shape := output.Shape()
+		flat := newFlat(shape.DType, shape.Size())
+		for flatIdx, values := range scalars {
+			setFlatElement(flat, flatIdx, values[i])
+		}
+		outputs[i] = &Tensor{Shape: shape, Flat: flat}
+	}
+	return outputs
+}
+
+// windowConfig holds the common attributes of windowed ops.
+type windowConfig struct {
+	dimensions, strides, baseDilations, windowDilations, paddingLow []int
+}
+
+// readWindowConfig reads the window attributes of ReduceWindow or SelectAndScatter, setting the defaults of
+// the missing attributes.
+func readWindowConfig(stmt *stablehlo.Statement, rank int) (*windowConfig, error) {
+	ones := slices.Repeat([]int{1}, rank)
+	cfg := &windowConfig{}
+	var err error
+	if cfg.dimensions, err = attributes.Ints(stmt, "window_dimensions"); err != nil {
+		return nil, err
+	}
+	if cfg.strides, err = attributes.OptionalInts(stmt, "window_strides", ones); err != nil {
+		return nil, err
+	}
+	if cfg.baseDilations, err = attributes.OptionalInts(stmt, "base_dilations", ones); err != nil {
+		return nil, err
+	}
+	if cfg.windowDilations, err = attributes.OptionalInts(stmt, "window_dilations", ones); err != nil {
+		return nil, err
+	}
+	paddings, err := attributes.OptionalInts(stmt, "padding", make([]int, 2*rank))
+	if err != nil {
+		return nil, err
+	}
+	cfg.paddingLow = make([]int, rank)
+	for axis := range rank {
+		cfg.paddingLow[axis] = paddings[2*axis]
+	}
+	return cfg, nil
+}
+
+// forEachWindowElement calls fn for each element of the window at the given output (window) index, with the
+// flat index of the corresponding operand element, or -1 if it falls in the padding or in a base dilation hole.
+func (cfg *windowConfig) forEachWindowElement(operandDims, strides, windowIndex []int, fn func(flatIdx int)) {
+	operandIndex := make([]int, len(operandDims))
+	forEachIndex(cfg.dimensions, func(elementIndex []int, _ int) {
+		for axis := range operandIndex {
+			position := windowIndex[axis]*cfg.strides[axis] + elementIndex[axis]*cfg.windowDilations[axis] -
+				cfg.paddingLow[axis]
+			if position < 0 || position%cfg.baseDilations[axis] != 0 {
+				fn(-1)
+				return
+			}
+			operandIndex[axis] = position / cfg.baseDilations[axis]
+		}
+		if !isValidIndex(operandIndex, operandDims) {
+			fn(-1)
+			return
+		}
+		fn(flatIndex(operandIndex, strides))
+	})
+}
+
+// reduceWindowOp evaluates a ReduceWindow: the inputs are the N operands followed by the N initial values.
+// The padding and the base dilation holes take the initial values.
+func reduceWindowOp(stmt *stablehlo.Statement, inputs []*Tensor, reductionFn closureFn) ([]*Tensor, error) {
+	numOperands := len(inputs) / 2
+	operands, initialValues := inputs[:numOperands], inputs[numOperands:]
+	operandDims := operands[0].Shape.Dimensions
+	cfg, err := readWindowConfig(stmt, len(operandDims))
+	if err != nil {
+		return nil, err
+	}
+	operandStrides := stridesFor(operandDims)
+	outputDims := stmt.Outputs[0].Shape().Dimensions
+	accumulators := make([][]*Tensor, sizeOf(outputDims))
+	var loopErr error
+	forEachIndex(outputDims, func(windowIndex []int, outputFlatIdx int) {
+		accumulator := initialValues
+		cfg.forEachWindowElement(operandDims, operandStrides, windowIndex, func(flatIdx int) {
+			if loopErr != nil {
+				return
+			}
+			fnInputs := slices.Clone(accumulator)
+			for i, operand := range operands {
+				if flatIdx < 0 {
+					fnInputs = append(fnInputs, initialValues[i])
+				} else {
+					fnInputs = append(fnInputs, operand.scalarAt(flatIdx))
+				}
+			}
+			accumulator, loopErr = reductionFn(fnInputs...)
+		})
+		accumulators[outputFlatIdx] = accumulator
+	})
+	if loopErr != nil {
+		return nil, loopErr
+	}
+	return gatherScalars(stmt, accumulators), nil
+}
+
+// selectAndScatterOp evaluates a SelectAndScatter: the inputs are the operand, the source and the initial value.
+//
+// For each window, the selectFn (returning whether to keep the current selected value over the new one) picks
+// an element of the operand, and the source value for the window is combined into the selected position of the
+// output with scatterFn. Padding elements are never selected.
+func selectAndScatterOp(stmt *stablehlo.Statement, inputs []*Tensor, selectFn, scatterFn closureFn) (any, error) {
+	operand, source, initialValue := inputs[0], inputs[1], inputs[2]
+	operandDims := operand.Shape.Dimensions
+	cfg, err := readWindowConfig(stmt, len(operandDims))
+	if err != nil {
+		return nil, err
+	}
+	operandStrides := stridesFor(operandDims)
+	output := takeElements(initialValue.Flat, make([]int, operand.Shape.Size()), nil)
+	var loopErr error
+	forEachIndex(source.Shape.Dimensions, func(windowIndex []int, sourceFlatIdx int) {
+		if loopErr != nil {
+			return
+		}
+		selectedIdx := -1
+		cfg.forEachWindowElement(operandDims, operandStrides, windowIndex, func(flatIdx int) {
+			if loopErr != nil || flatIdx < 0 {
+				return
+			}
+			if selectedIdx < 0 {
+				selectedIdx = flatIdx
+				return
+			}
+			var keep []*Tensor
+			keep, loopErr = selectFn(operand.scalarAt(selectedIdx), operand.scalarAt(flatIdx))
+			if loopErr == nil && !keep[0].Flat.([]bool)[0] {
+				selectedIdx = flatIdx
+			}
+		})
+		if loopErr != nil || selectedIdx < 0 {
+			return
+		}
+		current := &Tensor{Shape: initialValue.Shape, Flat: output}
+		var scattered []*Tensor
+		scattered, loopErr = scatterFn(current.scalarAt(selectedIdx), source.scalarAt(sourceFlatIdx))
+		if loopErr == nil {
+			setFlatElement(output, selectedIdx, scattered[0])
+		}
+	})
+	if loopErr != nil {
+		return nil, loopErr
+	}
+	return output, nil
+}
+
+// sortOp sorts the inputs along the given dimension, using the comparator function that takes pairs of
+// (lhs, rhs) scalars for each input, and returns whether lhs < rhs.
+// The sort is always stable.
+func sortOp(stmt *stablehlo.Statement, inputs []*Tensor, comparatorFn closureFn) ([]*Tensor, error) {
+	axis, err := attributes.Int(stmt, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	dims := inputs[0].Shape.Dimensions
+	strides := stridesFor(dims)
+	axisDim, axisStride := dims[axis], strides[axis]
+
+	// Iterate over the index of each 1D slice along the axis.
+	sliceDims := slices.Clone(dims)
+	sliceDims[axis] = 1
+	indices := make([]int, 0, inputs[0].Shape.Size())
+	var sortErr error
+	forEachIndex(sliceDims, func(sliceIndex []int, _ int) {
+		base := flatIndex(sliceIndex, strides)
+		permutation := make([]int, axisDim)
+		for i := range permutation {
+			permutation[i] = base + i*axisStride
+		}
+		sort.SliceStable(permutation, func(i, j int) bool {
+			if sortErr != nil {
+				return false
+			}
+			fnInputs := make([]*Tensor, 0, 2*len(inputs))
+			for _, input := range inputs {
+				fnInputs = append(fnInputs, input.scalarAt(permutation[i]), input.scalarAt(permutation[j]))
+			}
+			var less []*Tensor
+			less, sortErr = comparatorFn(fnInputs...)
+			return sortErr == nil && less[0].Flat.([]bool)[0]
+		})
+		indices = append(indices, permutation...)
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	// indices is ordered by slice and then by the position along the axis: map it back to the output positions.
+	sourceIndices := make([]int, len(indices))
+	var position int
+	forEachIndex(sliceDims, func(sliceIndex []int, _ int) {
+		base := flatIndex(sliceIndex, strides)
+		for i := range axisDim {
+			sourceIndices[base+i*axisStride] = indices[position]
+			position++
+		}
+	})
+	outputs := make([]*Tensor, len(inputs))
+	for i, input := range inputs {
+		outputs[i] = &Tensor{Shape: input.Shape, Flat: takeElements(input.Flat, sourceIndices, nil)}
+	}
+	return outputs, nil
+}
+
+// mapOp applies the mapper function to the scalars of the inputs, element-wise.
+func mapOp(inputs []*Tensor, shape shapes.Shape, mapperFn closureFn) (any, error) {
+	size := shape.Size()
+	output := newFlat(shape.DType, size)
+	for flatIdx := range size {
+		fnInputs := make([]*Tensor, len(inputs))
+		for i, input := range inputs {
+			fnInputs[i] = input.scalarAt(flatIdx)
+		}
+		mapped, err := mapperFn(fnInputs...)
+		if err != nil {
+			return nil, err
+		}
+		if len(mapped) != 1 {
+			return nil, errors.Errorf("mapper function returned %d values, expected 1", len(mapped))
+		}
+		setFlatElement(output, flatIdx, mapped[0])
+	}
+	return output, nil
+}
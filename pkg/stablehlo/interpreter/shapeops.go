@@ -0,0 +1,402 @@
+package interpreter
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// iotaOp returns the flat values of an Iota: the index along the given axis.
+func iotaOp(shape shapes.Shape, axis int) any {
+	values := make([]int64, shape.Size())
+	forEachIndex(shape.Dimensions, func(index []int, flatIdx int) {
+		values[flatIdx] = int64(index[axis])
+	})
+	return fromNumbers(shape.DType, values)
+}
+
+func broadcastInDimOp(stmt *stablehlo.Statement, x *Tensor, shape shapes.Shape) (any, error) {
+	axesMapping, err := attributes.Ints(stmt, "broadcast_dimensions")
+	if err != nil {
+		return nil, err
+	}
+	if len(axesMapping) != x.Shape.Rank() {
+		return nil, errors.Errorf("broadcast_dimensions %v doesn't match operand rank %d", axesMapping, x.Shape.Rank())
+	}
+	strides := stridesFor(x.Shape.Dimensions)
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		var flatIdx int
+		for axis, outputAxis := range axesMapping {
+			if x.Shape.Dimensions[axis] != 1 {
+				flatIdx += outputIndex[outputAxis] * strides[axis]
+			}
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, nil), nil
+}
+
+func transposeOp(stmt *stablehlo.Statement, x *Tensor, shape shapes.Shape) (any, error) {
+	permutation, err := attributes.Ints(stmt, "permutation")
+	if err != nil {
+		return nil, err
+	}
+	strides := stridesFor(x.Shape.Dimensions)
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		var flatIdx int
+		for outputAxis, axis := range permutation {
+			flatIdx += outputIndex[outputAxis] * strides[axis]
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, nil), nil
+}
+
+func sliceOp(stmt *stablehlo.Statement, x *Tensor, shape shapes.Shape) (any, error) {
+	starts, err := attributes.Ints(stmt, "start_indices")
+	if err != nil {
+		return nil, err
+	}
+	strides, err := attributes.Ints(stmt, "strides")
+	if err != nil {
+		return nil, err
+	}
+	xStrides := stridesFor(x.Shape.Dimensions)
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		var flatIdx int
+		for axis, idx := range outputIndex {
+			flatIdx += (starts[axis] + idx*strides[axis]) * xStrides[axis]
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, nil), nil
+}
+
+// clampedStartIndices returns the start indices given as scalar tensors, clamped such that the slice with the
+// given sizes fits in the dimensions.
+func clampedStartIndices(startIndices []*Tensor, dimensions, sizes []int) []int {
+	starts := make([]int, len(startIndices))
+	for axis, t := range startIndices {
+		start := int(asNumbers[int64](t.Flat)[0])
+		starts[axis] = min(max(start, 0), dimensions[axis]-sizes[axis])
+	}
+	return starts
+}
+
+func dynamicSliceOp(x *Tensor, startIndices []*Tensor, shape shapes.Shape) any {
+	starts := clampedStartIndices(startIndices, x.Shape.Dimensions, shape.Dimensions)
+	xStrides := stridesFor(x.Shape.Dimensions)
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		var flatIdx int
+		for axis, idx := range outputIndex {
+			flatIdx += (starts[axis] + idx) * xStrides[axis]
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, nil)
+}
+
+func dynamicUpdateSliceOp(x, update *Tensor, startIndices []*Tensor) any {
+	starts := clampedStartIndices(startIndices, x.Shape.Dimensions, update.Shape.Dimensions)
+	strides, updateStrides := stridesFor(x.Shape.Dimensions), stridesFor(update.Shape.Dimensions)
+	size := x.Shape.Size()
+	updateIndex := make([]int, x.Shape.Rank())
+	// Indices into the concatenation of x and update.
+	indices := mapIndices(x.Shape.Dimensions, func(index []int) int {
+		for axis, idx := range index {
+			updateIndex[axis] = idx - starts[axis]
+		}
+		if !isValidIndex(updateIndex, update.Shape.Dimensions) {
+			return flatIndex(index, strides)
+		}
+		return size + flatIndex(updateIndex, updateStrides)
+	})
+	return takeElements(concatFlats(x.Flat, update.Flat), indices, nil)
+}
+
+func concatenateOp(stmt *stablehlo.Statement, operands []*Tensor, shape shapes.Shape) (any, error) {
+	axis, err := attributes.Int(stmt, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	flats := make([]any, len(operands))
+	// offsets[i] is the flat offset of the operand i in the concatenation of the flats, and starts[i] is
+	// its start index along the axis in the output.
+	offsets := make([]int, len(operands))
+	starts := make([]int, len(operands))
+	strides := make([][]int, len(operands))
+	var offset, start int
+	for i, operand := range operands {
+		flats[i] = operand.Flat
+		offsets[i], starts[i] = offset, start
+		strides[i] = stridesFor(operand.Shape.Dimensions)
+		offset += operand.Shape.Size()
+		start += operand.Shape.Dimensions[axis]
+	}
+	operandIndex := make([]int, shape.Rank())
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		i := len(starts) - 1
+		for outputIndex[axis] < starts[i] {
+			i--
+		}
+		copy(operandIndex, outputIndex)
+		operandIndex[axis] -= starts[i]
+		return offsets[i] + flatIndex(operandIndex, strides[i])
+	})
+	return takeElements(concatFlats(flats...), indices, nil), nil
+}
+
+func padOp(stmt *stablehlo.Statement, x, fill *Tensor, shape shapes.Shape) (any, error) {
+	low, err := attributes.Ints(stmt, "edge_padding_low")
+	if err != nil {
+		return nil, err
+	}
+	interior, err := attributes.Ints(stmt, "interior_padding")
+	if err != nil {
+		return nil, err
+	}
+	strides := stridesFor(x.Shape.Dimensions)
+	indices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		var flatIdx int
+		for axis, idx := range outputIndex {
+			position := idx - low[axis]
+			if position < 0 || position%(interior[axis]+1) != 0 {
+				return -1
+			}
+			position /= interior[axis] + 1
+			if position >= x.Shape.Dimensions[axis] {
+				return -1
+			}
+			flatIdx += position * strides[axis]
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, fill), nil
+}
+
+func reverseOp(stmt *stablehlo.Statement, x *Tensor) (any, error) {
+	axes, err := attributes.Ints(stmt, "dimensions")
+	if err != nil {
+		return nil, err
+	}
+	dims := x.Shape.Dimensions
+	strides := stridesFor(dims)
+	indices := mapIndices(dims, func(outputIndex []int) int {
+		var flatIdx int
+		for axis, idx := range outputIndex {
+			if slices.Contains(axes, axis) {
+				idx = dims[axis] - 1 - idx
+			}
+			flatIdx += idx * strides[axis]
+		}
+		return flatIdx
+	})
+	return takeElements(x.Flat, indices, nil), nil
+}
+
+// startIndexAt returns the start index vector in startIndices for the given batch index: the batch index has
+// all axes of startIndices except the indexVectorAxis (which may be equal to the rank, meaning the indices are
+// scalars).
+func startIndexAt(startIndices []int64, dimensions []int, indexVectorAxis int, batchIndex []int) []int {
+	strides := stridesFor(dimensions)
+	var baseIdx int
+	for i, idx := range batchIndex {
+		axis := i
+		if i >= indexVectorAxis {
+			axis++
+		}
+		baseIdx += idx * strides[axis]
+	}
+	if indexVectorAxis == len(dimensions) {
+		return []int{int(startIndices[baseIdx])}
+	}
+	vector := make([]int, dimensions[indexVectorAxis])
+	for i := range vector {
+		vector[i] = int(startIndices[baseIdx+i*strides[indexVectorAxis]])
+	}
+	return vector
+}
+
+// batchingIndex returns the index of the batch axes of startIndices for a batching axis of the indices,
+// given the batch index (that excludes the indexVectorAxis).
+func batchingIndex(batchIndex []int, indicesBatchingAxis, indexVectorAxis int) int {
+	if indicesBatchingAxis < indexVectorAxis {
+		return batchIndex[indicesBatchingAxis]
+	}
+	return batchIndex[indicesBatchingAxis-1]
+}
+
+// gatherOp follows the semantics in https://openxla.org/stablehlo/spec#gather.
+func gatherOp(stmt *stablehlo.Statement, operand, startIndices *Tensor, shape shapes.Shape) (any, error) {
+	fields, err := attributes.Struct(stmt, "dimension_numbers")
+	if err != nil {
+		return nil, err
+	}
+	offsetAxes, err := attributes.StructInts(fields, "offset_dims")
+	if err != nil {
+		return nil, err
+	}
+	collapsedAxes, err := attributes.StructInts(fields, "collapsed_slice_dims")
+	if err != nil {
+		return nil, err
+	}
+	operandBatchingAxes, err := attributes.StructInts(fields, "operand_batching_dims")
+	if err != nil {
+		return nil, err
+	}
+	indicesBatchingAxes, err := attributes.StructInts(fields, "start_indices_batching_dims")
+	if err != nil {
+		return nil, err
+	}
+	startIndexMap, err := attributes.StructInts(fields, "start_index_map")
+	if err != nil {
+		return nil, err
+	}
+	indexVectorAxis, err := attributes.StructInt(fields, "index_vector_dim")
+	if err != nil {
+		return nil, err
+	}
+	sliceSizes, err := attributes.Ints(stmt, "slice_sizes")
+	if err != nil {
+		return nil, err
+	}
+
+	// Operand axes that are indexed by the offset axes of the output.
+	var offsetOperandAxes []int
+	for axis := range operand.Shape.Rank() {
+		if !slices.Contains(collapsedAxes, axis) && !slices.Contains(operandBatchingAxes, axis) {
+			offsetOperandAxes = append(offsetOperandAxes, axis)
+		}
+	}
+	indices := asNumbers[int64](startIndices.Flat)
+	operandDims := operand.Shape.Dimensions
+	operandStrides := stridesFor(operandDims)
+	operandIndex := make([]int, operand.Shape.Rank())
+	var batchIndex, offsetIndex []int
+	flatIndices := mapIndices(shape.Dimensions, func(outputIndex []int) int {
+		batchIndex, offsetIndex = batchIndex[:0], offsetIndex[:0]
+		for axis, idx := range outputIndex {
+			if slices.Contains(offsetAxes, axis) {
+				offsetIndex = append(offsetIndex, idx)
+			} else {
+				batchIndex = append(batchIndex, idx)
+			}
+		}
+		clear(operandIndex)
+		startIndex := startIndexAt(indices, startIndices.Shape.Dimensions, indexVectorAxis, batchIndex)
+		for i, axis := range startIndexMap {
+			operandIndex[axis] = min(max(startIndex[i], 0), operandDims[axis]-sliceSizes[axis])
+		}
+		for i, axis := range operandBatchingAxes {
+			operandIndex[axis] += batchingIndex(batchIndex, indicesBatchingAxes[i], indexVectorAxis)
+		}
+		for i, axis := range offsetOperandAxes {
+			operandIndex[axis] += offsetIndex[i]
+		}
+		return flatIndex(operandIndex, operandStrides)
+	})
+	return takeElements(operand.Flat, flatIndices, nil), nil
+}
+
+// scatterOp follows the semantics in https://openxla.org/stablehlo/spec#scatter.
+// The inputs are the N operands, the scatter indices and the N updates.
+func scatterOp(stmt *stablehlo.Statement, inputs []*Tensor, updateFn closureFn) ([]*Tensor, error) {
+	fields, err := attributes.Struct(stmt, "scatter_dimension_numbers")
+	if err != nil {
+		return nil, err
+	}
+	updateWindowAxes, err := attributes.StructInts(fields, "update_window_dims")
+	if err != nil {
+		return nil, err
+	}
+	insertedWindowAxes, err := attributes.StructInts(fields, "inserted_window_dims")
+	if err != nil {
+		return nil, err
+	}
+	inputBatchingAxes, err := attributes.StructInts(fields, "input_batching_dims")
+	if err != nil {
+		return nil, err
+	}
+	indicesBatchingAxes, err := attributes.StructInts(fields, "scatter_indices_batching_dims")
+	if err != nil {
+		return nil, err
+	}
+	indexedInputAxes, err := attributes.StructInts(fields, "scatter_dims_to_operand_dims")
+	if err != nil {
+		return nil, err
+	}
+	indexVectorAxis, err := attributes.StructInt(fields, "index_vector_dim")
+	if err != nil {
+		return nil, err
+	}
+
+	numOperands := (len(inputs) - 1) / 2
+	operands, scatterIndices, updates := inputs[:numOperands], inputs[numOperands], inputs[numOperands+1:]
+	results := make([]*Tensor, numOperands)
+	for i, operand := range operands {
+		results[i] = &Tensor{Shape: operand.Shape, Flat: cloneFlat(operand.Flat)}
+	}
+	var windowInputAxes []int
+	for axis := range operands[0].Shape.Rank() {
+		if !slices.Contains(insertedWindowAxes, axis) && !slices.Contains(inputBatchingAxes, axis) {
+			windowInputAxes = append(windowInputAxes, axis)
+		}
+	}
+	indices := asNumbers[int64](scatterIndices.Flat)
+	inputDims := operands[0].Shape.Dimensions
+	inputStrides := stridesFor(inputDims)
+	resultIndex := make([]int, len(inputDims))
+	var scatterIndex, windowIndex []int
+	var loopErr error
+	forEachIndex(updates[0].Shape.Dimensions, func(updateIndex []int, updateFlatIdx int) {
+		if loopErr != nil {
+			return
+		}
+		scatterIndex, windowIndex = scatterIndex[:0], windowIndex[:0]
+		for axis, idx := range updateIndex {
+			if slices.Contains(updateWindowAxes, axis) {
+				windowIndex = append(windowIndex, idx)
+			} else {
+				scatterIndex = append(scatterIndex, idx)
+			}
+		}
+		clear(resultIndex)
+		startIndex := startIndexAt(indices, scatterIndices.Shape.Dimensions, indexVectorAxis, scatterIndex)
+		for i, axis := range indexedInputAxes {
+			resultIndex[axis] = startIndex[i]
+		}
+		for i, axis := range inputBatchingAxes {
+			resultIndex[axis] += batchingIndex(scatterIndex, indicesBatchingAxes[i], indexVectorAxis)
+		}
+		for i, axis := range windowInputAxes {
+			resultIndex[axis] += windowIndex[i]
+		}
+		if !isValidIndex(resultIndex, inputDims) {
+			// Out-of-bounds updates are skipped.
+			return
+		}
+		resultFlatIdx := flatIndex(resultIndex, inputStrides)
+		fnInputs := make([]*Tensor, 0, 2*numOperands)
+		for _, result := range results {
+			fnInputs = append(fnInputs, result.scalarAt(resultFlatIdx))
+		}
+		for _, update := range updates {
+			fnInputs = append(fnInputs, update.scalarAt(updateFlatIdx))
+		}
+		var updated []*Tensor
+		updated, loopErr = updateFn(fnInputs...)
+		if loopErr != nil {
+			return
+		}
+		for i, result := range results {
+			setFlatElement(result.Flat, resultFlatIdx, updated[i])
+		}
+	})
+	if loopErr != nil {
+		return nil, loopErr
+	}
+	return results, nil
+}
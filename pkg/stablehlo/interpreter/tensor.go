@@ -0,0 +1,285 @@
+package interpreter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes/bfloat16"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+	"github.com/x448/float16"
+)
+
+// Tensor is a value computed by the interpreter: a shape and its values stored in a flat Go slice.
+type Tensor struct {
+	// Shape of the tensor.
+	Shape shapes.Shape
+
+	// Flat holds the values in row-major order, in a slice of the Go type of the shape's DType (e.g.: []float32
+	// for dtypes.Float32). It is nil for tuples and tokens.
+	Flat any
+
+	// Elements of a tuple. It is nil if the tensor is not a tuple.
+	Elements []*Tensor
+}
+
+// NewTensor creates a tensor from a flat slice of values (in row-major order) and its dimensions.
+// The dtype is inferred from the slice element type, and the slice is used directly (not copied).
+//
+// A []int slice is converted to []int64 (dtypes.Int64).
+func NewTensor(flat any, dimensions ...int) (*Tensor, error) {
+	if ints, ok := flat.([]int); ok {
+		flat = convertSlice(ints, func(v int) int64 { return int64(v) })
+	}
+	flatV := reflect.ValueOf(flat)
+	if flatV.Kind() != reflect.Slice {
+		return nil, errors.Errorf("NewTensor requires a flat slice of values, got %T", flat)
+	}
+	dtype := dtypes.FromGoType(flatV.Type().Elem())
+	if !isSupportedDType(dtype) {
+		return nil, errors.Errorf("NewTensor: unsupported flat values type %T", flat)
+	}
+	shape := shapes.Make(dtype, dimensions...)
+	if shape.Size() != flatV.Len() {
+		return nil, errors.Errorf("NewTensor: flat values size %d doesn't match shape %s (size %d)",
+			flatV.Len(), shape, shape.Size())
+	}
+	return &Tensor{Shape: shape, Flat: flat}, nil
+}
+
+// NewScalar creates a scalar tensor with the given value. An int value is converted to an int64.
+//
+// It panics if the value is not of a supported type.
+func NewScalar(value any) *Tensor {
+	if v, ok := value.(int); ok {
+		value = int64(v)
+	}
+	valueV := reflect.ValueOf(value)
+	flatV := reflect.MakeSlice(reflect.SliceOf(valueV.Type()), 1, 1)
+	flatV.Index(0).Set(valueV)
+	t, err := NewTensor(flatV.Interface())
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// NewTuple creates a tuple with the given elements.
+func NewTuple(elements ...*Tensor) *Tensor {
+	elementsShapes := make([]shapes.Shape, len(elements))
+	for i, e := range elements {
+		elementsShapes[i] = e.Shape
+	}
+	return &Tensor{Shape: shapes.MakeTuple(elementsShapes), Elements: elements}
+}
+
+// newToken returns a token value: it carries no data.
+func newToken() *Tensor {
+	return &Tensor{Shape: shapes.Make(dtypes.TOKEN)}
+}
+
+// Value returns the value of a scalar tensor, or the flat slice of values otherwise.
+func (t *Tensor) Value() any {
+	if t.Flat == nil || !t.Shape.IsScalar() {
+		return t.Flat
+	}
+	return reflect.ValueOf(t.Flat).Index(0).Interface()
+}
+
+// String implements fmt.Stringer.
+func (t *Tensor) String() string {
+	if t.Shape.IsTuple() {
+		parts := make([]string, len(t.Elements))
+		for i, e := range t.Elements {
+			parts[i] = e.String()
+		}
+		return fmt.Sprintf("(%s)", strings.Join(parts, ", "))
+	}
+	if t.Flat == nil {
+		return t.Shape.String()
+	}
+	return fmt.Sprintf("%s: %v", t.Shape, t.Value())
+}
+
+// isSupportedDType returns whether the interpreter can hold values of the dtype.
+func isSupportedDType(dtype dtypes.DType) bool {
+	switch dtype {
+	case dtypes.Bool, dtypes.Int8, dtypes.Int16, dtypes.Int32, dtypes.Int64,
+		dtypes.Uint8, dtypes.Uint16, dtypes.Uint32, dtypes.Uint64,
+		dtypes.Float16, dtypes.BFloat16, dtypes.Float32, dtypes.Float64,
+		dtypes.Complex64, dtypes.Complex128:
+		return true
+	default:
+		return false
+	}
+}
+
+// newFlat creates a flat slice of the Go type of the dtype, with the given size.
+func newFlat(dtype dtypes.DType, size int) any {
+	return reflect.MakeSlice(reflect.SliceOf(dtype.GoType()), size, size).Interface()
+}
+
+// convertSlice returns a new slice with fn applied to each element.
+func convertSlice[S, T any](values []S, fn func(S) T) []T {
+	result := make([]T, len(values))
+	for i, v := range values {
+		result[i] = fn(v)
+	}
+	return result
+}
+
+// takeElements returns a new flat slice with the elements of the given flat slice at the given indices.
+// Negative indices take the fill value instead, which must be a scalar tensor of the same dtype (or nil if there
+// are no negative indices).
+func takeElements(flat any, indices []int, fill *Tensor) any {
+	var fillFlat any
+	if fill != nil {
+		fillFlat = fill.Flat
+	}
+	switch values := flat.(type) {
+	case []bool:
+		return take(values, fillFlat, indices)
+	case []int8:
+		return take(values, fillFlat, indices)
+	case []int16:
+		return take(values, fillFlat, indices)
+	case []int32:
+		return take(values, fillFlat, indices)
+	case []int64:
+		return take(values, fillFlat, indices)
+	case []uint8:
+		return take(values, fillFlat, indices)
+	case []uint16:
+		return take(values, fillFlat, indices)
+	case []uint32:
+		return take(values, fillFlat, indices)
+	case []uint64:
+		return take(values, fillFlat, indices)
+	case []float16.Float16:
+		return take(values, fillFlat, indices)
+	case []bfloat16.BFloat16:
+		return take(values, fillFlat, indices)
+	case []float32:
+		return take(values, fillFlat, indices)
+	case []float64:
+		return take(values, fillFlat, indices)
+	case []complex64:
+		return take(values, fillFlat, indices)
+	case []complex128:
+		return take(values, fillFlat, indices)
+	default:
+		panic(errors.Errorf("interpreter: unsupported flat values type %T", flat))
+	}
+}
+
+func take[T any](values []T, fillFlat any, indices []int) []T {
+	var fill T
+	if fillFlat != nil {
+		fill = fillFlat.([]T)[0]
+	}
+	result := make([]T, len(indices))
+	for i, idx := range indices {
+		if idx < 0 {
+			result[i] = fill
+		} else {
+			result[i] = values[idx]
+		}
+	}
+	return result
+}
+
+// concatFlats concatenates flat slices of the same type.
+func concatFlats(flats ...any) any {
+	resultV := reflect.ValueOf(flats[0])
+	resultV = reflect.AppendSlice(reflect.MakeSlice(resultV.Type(), 0, resultV.Len()), resultV)
+	for _, flat := range flats[1:] {
+		resultV = reflect.AppendSlice(resultV, reflect.ValueOf(flat))
+	}
+	return resultV.Interface()
+}
+
+// scalarAt returns a scalar tensor with the element at the flat index of the tensor.
+func (t *Tensor) scalarAt(flatIdx int) *Tensor {
+	return &Tensor{Shape: shapes.Make(t.Shape.DType), Flat: takeElements(t.Flat, []int{flatIdx}, nil)}
+}
+
+// setFlatElement sets the element at the flat index of the flat slice to the value of the scalar tensor.
+func setFlatElement(flat any, flatIdx int, scalar *Tensor) {
+	reflect.ValueOf(flat).Index(flatIdx).Set(reflect.ValueOf(scalar.Flat).Index(0))
+}
+
+// cloneFlat returns a copy of the flat slice.
+func cloneFlat(flat any) any {
+	return concatFlats(flat)
+}
+
+// stridesFor returns the strides of each axis for a row-major layout of the given dimensions.
+func stridesFor(dimensions []int) []int {
+	strides := make([]int, len(dimensions))
+	stride := 1
+	for axis := len(dimensions) - 1; axis >= 0; axis-- {
+		strides[axis] = stride
+		stride *= dimensions[axis]
+	}
+	return strides
+}
+
+// sizeOf returns the number of elements for the given dimensions.
+func sizeOf(dimensions []int) int {
+	size := 1
+	for _, dim := range dimensions {
+		size *= dim
+	}
+	return size
+}
+
+// forEachIndex calls fn for each index of a tensor with the given dimensions, in row-major order.
+// The index slice is reused across calls, and must not be modified or kept by fn.
+func forEachIndex(dimensions []int, fn func(index []int, flatIdx int)) {
+	size := sizeOf(dimensions)
+	if size == 0 {
+		return
+	}
+	index := make([]int, len(dimensions))
+	for flatIdx := range size {
+		fn(index, flatIdx)
+		for axis := len(dimensions) - 1; axis >= 0; axis-- {
+			index[axis]++
+			if index[axis] < dimensions[axis] {
+				break
+			}
+			index[axis] = 0
+		}
+	}
+}
+
+// flatIndex returns the flat index of the index, given the strides.
+func flatIndex(index, strides []int) int {
+	var flatIdx int
+	for axis, idx := range index {
+		flatIdx += idx * strides[axis]
+	}
+	return flatIdx
+}
+
+// isValidIndex returns whether the index is within the given dimensions.
+func isValidIndex(index, dimensions []int) bool {
+	for axis, idx := range index {
+		if idx < 0 || idx >= dimensions[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// mapIndices returns, for each element of the output with the given dimensions, the flat index of the source
+// element returned by sourceIndex (or -1 to use a fill value).
+func mapIndices(outputDims []int, sourceIndex func(outputIndex []int) int) []int {
+	indices := make([]int, sizeOf(outputDims))
+	forEachIndex(outputDims, func(index []int, flatIdx int) {
+		indices[flatIdx] = sourceIndex(index)
+	})
+	return indices
+}
@@ -672,6 +672,63 @@ func Sort(comparatorFn *Function, dimension int, isStable bool, inputs ...*Value
 	return stmt.Outputs, nil
 }
 
+// Map applies the scalar mapper function element-wise to the inputs, and returns a value with the same
+// dimensions as the inputs, and the dtype of the mapper output.
+//
+// The mapper must be created with Function.Closure, and take one scalar input per input (with the same dtype),
+// and return one scalar. The inputs must all have the same dimensions.
+//
+// The dimensions are the axes over which to map: they must be all the axes of the inputs, in order.
+// If left nil, they default to all axes.
+//
+// Example:
+//
+//	// z = x*y + x, element-wise (error handling omitted).
+//	mapper := fn.Closure()
+//	a, _ := mapper.Input(shapes.Make(x.Shape().DType))
+//	b, _ := mapper.Input(shapes.Make(y.Shape().DType))
+//	ab, _ := Multiply(a, b)
+//	result, _ := Add(ab, a)
+//	mapper.Return(result)
+//	z, err := Map(mapper, nil, x, y)
+//
+// See https://openxla.org/stablehlo/spec#map
+func Map(mapper *Function, dimensions []int, inputs ...*Value) (*Value, error) {
+	op := optypes.Map
+	if len(inputs) == 0 {
+		return nil, errors.New("Map requires at least one input")
+	}
+	fn, err := innerMostFunction(inputs...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if mapper.Parent != fn {
+		return nil, errors.Errorf("cannot add operation %s because mapper is not a StableHLO closure of %s",
+			op, fn.Name)
+	}
+	if dimensions == nil {
+		dimensions = make([]int, inputs[0].shape.Rank())
+		for axis := range dimensions {
+			dimensions[axis] = axis
+		}
+	}
+	outputShape, err := shapeinference.Map(valuesToShapes(inputs), valuesToShapes(mapper.Inputs),
+		valuesToShapes(mapper.Outputs), dimensions)
+	if err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, outputShape, inputs...)
+	stmt.Attributes = map[string]any{
+		"dimensions": intSliceToArrayI64StableHLO(dimensions),
+	}
+	stmt.AddFunctionParameter("computation", mapper)
+	return stmt.Outputs[0], nil
+}
+
 // Concatenate operands on the given axis.
 //
 // All axes that are not being concatenated must match dimensions, except on the axes being concatenated.
@@ -1285,6 +1342,102 @@ func FFT(x *Value, fftType types.FFTType, fftLength ...int) (*Value, error) {
 	return stmt.Outputs[0], nil
 }
 
+// Cholesky computes the Cholesky decomposition of a batch of symmetric (Hermitian for complex dtypes)
+// positive definite matrices: the last two axes of a form the matrices, and the leading axes are batch axes.
+//
+// If lower is true, it returns the lower-triangular matrices l such that a = l * l^T (l^H for complex).
+// Otherwise, it returns the upper-triangular matrices u such that a = u^T * u.
+// Only the lower (or upper) triangle of a is read, and the values in the other triangle of the result are
+// implementation-defined.
+//
+// If a is not positive definite, the results are implementation-defined (typically NaN).
+func Cholesky(a *Value, lower bool) (*Value, error) {
+	op := optypes.Cholesky
+	fn := a.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	outputShape, err := shapeinference.Cholesky(a.shape)
+	if err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, outputShape, a)
+	stmt.Attributes = map[string]any{
+		"lower": lower,
+	}
+	return stmt.Outputs[0], nil
+}
+
+// TriangularSolve solves a batch of systems of linear equations with lower or upper triangular coefficient
+// matrices a: the last two axes of a form the [M, M] matrices, and the leading axes are batch axes.
+//
+// If leftSide is true, it solves for x in op(a) * x = b, where b (and x) are shaped [..., M, N].
+// Otherwise, it solves for x in x * op(a) = b, where b (and x) are shaped [..., N, M].
+//
+// Parameters:
+//   - lower: whether to use the lower or upper triangle of a. The values in the other triangle are ignored.
+//   - unitDiagonal: if true, the diagonal elements of a are assumed to be 1 and are not read.
+//   - transposeA: if true, op(a) is the transpose of a, otherwise op(a) = a.
+//
+// It returns x, with the same shape as b.
+func TriangularSolve(a, b *Value, leftSide, lower, unitDiagonal, transposeA bool) (*Value, error) {
+	op := optypes.TriangularSolve
+	fn, err := innerMostFunction(a, b)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	outputShape, err := shapeinference.TriangularSolve(a.shape, b.shape, leftSide)
+	if err != nil {
+		return nil, err
+	}
+	transpose := "NO_TRANSPOSE"
+	if transposeA {
+		transpose = "TRANSPOSE"
+	}
+	stmt := fn.addOp(op, outputShape, a, b)
+	stmt.Attributes = map[string]any{
+		"left_side":     leftSide,
+		"lower":         lower,
+		"unit_diagonal": unitDiagonal,
+		"transpose_a":   literalStrF("#stablehlo<transpose %s>", transpose),
+	}
+	return stmt.Outputs[0], nil
+}
+
+// ReducePrecision rounds x to the precision of a float with the given number of exponent and mantissa bits,
+// while keeping x's dtype. Values out of the range of the reduced exponent become infinities (or zeros).
+//
+// It can be used to emulate lower precision floats, see dtypes.DType.ExponentAndMantissaBits. E.g.: to emulate
+// F8E4M3FN in Float32:
+//
+//	exponentBits, mantissaBits, _ := dtypes.F8E4M3FN.ExponentAndMantissaBits()
+//	y, err := ReducePrecision(x, exponentBits, mantissaBits)
+//
+// See https://openxla.org/stablehlo/spec#reduce_precision
+func ReducePrecision(x *Value, exponentBits, mantissaBits int) (*Value, error) {
+	op := optypes.ReducePrecision
+	fn := x.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	outputShape, err := shapeinference.ReducePrecision(x.shape, exponentBits, mantissaBits)
+	if err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, outputShape, x)
+	stmt.Attributes = map[string]any{
+		"exponent_bits": int32(exponentBits),
+		"mantissa_bits": int32(mantissaBits),
+	}
+	return stmt.Outputs[0], nil
+}
+
 // ReduceWindow reduces the inputs using arbitrary windows around each element.
 //
 // Each resulting element for input is initialized with initValue (e.g.: for a sum, it's 0, for a product it is 1),
@@ -2336,6 +2489,66 @@ func If(pred *Value, trueBranch, falseBranch *Function) ([]*Value, error) {
 	return stmt.Outputs, nil
 }
 
+// Case executes exactly one of the branches, selected by index, and returns its outputs.
+//
+// Parameters:
+//   - index: A scalar int32 value with the index of the branch to execute. If it is out of range
+//     (index < 0 or index >= len(branches)), the last branch is executed.
+//   - branches: Functions created with Function.Closure(). They must have no inputs, and return the same number
+//     of values with matching shapes. Like with If, they can use values from the parent function, with
+//     Function.UseParentValue.
+//
+// Example (piecewise function):
+//
+//	x := must(fn.NamedInput("x", shapes.Make(dtypes.Float32)))
+//	index := must(fn.NamedInput("index", shapes.Make(dtypes.Int32)))
+//
+//	negate := fn.Closure()
+//	negate.Return(must(Negate(must(negate.UseParentValue(x)))))
+//	identity := fn.Closure()
+//	identity.Return(must(identity.UseParentValue(x)))
+//	double := fn.Closure()
+//	xb := must(double.UseParentValue(x))
+//	double.Return(must(Add(xb, xb)))
+//
+//	results, err := Case(index, negate, identity, double)
+func Case(index *Value, branches ...*Function) ([]*Value, error) {
+	op := optypes.Case
+	fn := index.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if len(branches) == 0 {
+		return nil, errors.Errorf("cannot add operation %s without branches", op)
+	}
+
+	// Validate branch functions are closures of the current function
+	branchesInputs := make([][]shapes.Shape, len(branches))
+	branchesOutputs := make([][]shapes.Shape, len(branches))
+	for i, branch := range branches {
+		if branch.Parent != fn {
+			return nil, errors.Errorf("cannot add operation %s because branch #%d is not a StableHLO closure of %s",
+				op, i, fn.Name)
+		}
+		branchesInputs[i] = valuesToShapes(branch.Inputs)
+		branchesOutputs[i] = valuesToShapes(branch.Outputs)
+	}
+
+	// Perform shape inference
+	outputsShapes, err := shapeinference.Case(index.shape, branchesInputs, branchesOutputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the statement
+	stmt := fn.addMultiOp(op, outputsShapes, []*Value{index})
+	for i, branch := range branches {
+		stmt.AddFunctionParameter(fmt.Sprintf("branch%d", i), branch)
+	}
+	return stmt.Outputs, nil
+}
+
 // Call invokes a function with the given arguments.
 // The callee must be a top-level function (not a closure).
 // Returns the output values from the callee.
@@ -2373,6 +2586,67 @@ func Call(callee *Function, operands ...*Value) ([]*Value, error) {
 	return stmt.Outputs, nil
 }
 
+// Composite is a call to decomposition, tagged with a name (e.g.: "my_namespace.gelu") and attributes, so
+// backends that recognize the name can replace it with their own implementation (e.g.: a fused kernel).
+// Backends that don't recognize it simply inline the decomposition, so the program remains portable.
+//
+// The decomposition must be a top-level function (created with Builder.NewFunction, not a closure),
+// that has already returned.
+//
+// The attributes can be nil. See CustomCallOptions.TypedBackendConfig for the supported values.
+//
+// See https://openxla.org/stablehlo/spec#composite
+func Composite(name string, attributes map[string]any, decomposition *Function, operands ...*Value) ([]*Value, error) {
+	op := optypes.Composite
+	if len(operands) == 0 {
+		return nil, errors.New("Composite requires at least one operand to determine the calling function context")
+	}
+	fn, err := innerMostFunction(operands...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if name == "" {
+		return nil, errors.Errorf("%s requires a name", op)
+	}
+	if decomposition.Parent != nil {
+		return nil, errors.Errorf("%s decomposition must be a top-level function, but %q is a closure",
+			op, decomposition.Name)
+	}
+	if !decomposition.Returned {
+		return nil, errors.Errorf("%s decomposition %q must have returned (see Function.Return) before being used",
+			op, decomposition.Name)
+	}
+	if decomposition.Builder != fn.Builder {
+		return nil, errors.Errorf("%s decomposition %q is from a different builder", op, decomposition.Name)
+	}
+
+	outputsShapes, err := shapeinference.Call(
+		valuesToShapes(operands),
+		valuesToShapes(decomposition.Inputs),
+		valuesToShapes(decomposition.Outputs))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "in %s %q", op, name)
+	}
+
+	stmtAttributes := map[string]any{
+		"name":          name,
+		"decomposition": symbolRef{name: decomposition.Name},
+	}
+	if attributes != nil {
+		stmtAttributes["composite_attributes"], err = dictionaryToStableHLO(attributes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "in %s %q attributes", op, name)
+		}
+	}
+	stmt := fn.addMultiOp(op, outputsShapes, operands)
+	stmt.Attributes = stmtAttributes
+	return stmt.Outputs, nil
+}
+
 // OptimizationBarrier creates an optimization barrier for the given operands.
 //
 // It takes a variable length number of operands, and returns the same operands (a slice of *Value).
@@ -2396,3 +2670,44 @@ func OptimizationBarrier(operands ...*Value) ([]*Value, error) {
 	stmt := fn.addMultiOp(op, outputShapes, operands)
 	return stmt.Outputs, nil
 }
+
+// Tuple groups the given values into one value with a tuple shape (see shapes.MakeTuple).
+//
+// Tuples are mostly used to interoperate with HLO programs (e.g.: exported from JAX) that use them.
+// Use GetTupleElement to extract the values back.
+func Tuple(values ...*Value) (*Value, error) {
+	op := optypes.Tuple
+	if len(values) == 0 {
+		return nil, errors.New("Tuple requires at least one value")
+	}
+	fn, err := innerMostFunction(values...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	outputShape := shapes.MakeTuple(valuesToShapes(values))
+	stmt := fn.addOp(op, outputShape, values...)
+	return stmt.Outputs[0], nil
+}
+
+// GetTupleElement returns the element at the given index of the tuple value.
+func GetTupleElement(tuple *Value, index int) (*Value, error) {
+	op := optypes.GetTupleElement
+	fn := tuple.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	outputShape, err := shapeinference.GetTupleElement(tuple.shape, index)
+	if err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, outputShape, tuple)
+	stmt.Attributes = map[string]any{
+		"index": int32(index),
+	}
+	return stmt.Outputs[0], nil
+}
@@ -0,0 +1,589 @@
+package stablehlo
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/internal/utils"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/pkg/errors"
+)
+
+// OptimizationPass is one of the simple graph optimizations applied by Builder.Optimize.
+type OptimizationPass int
+
+//go:generate go tool enumer -type=OptimizationPass -trimprefix=Pass -output=gen_optimizationpass_enumer.go optimize.go
+
+const (
+	// PassClosureDeduplication makes the statements of a function that take identical closures share the
+	// same closure. Combined with PassCommonSubexpressionElimination, it allows the elimination of statements that
+	// only differ by their (identical) closures, e.g.: two reductions of the same value.
+	//
+	// The statements share the same *Function afterward: changing the closure of one of them (e.g.: by a later
+	// pass) changes the closure of all of them. This is safe for the passes of Optimize, since a shared closure
+	// is always used by statements of the same function, so it sees the same enclosing values, but the closures
+	// must be copied (see Function.CopyStatement) before changing them for only one of the statements.
+	PassClosureDeduplication OptimizationPass = iota
+
+	// PassConstantFolding replaces the operations on constants by a constant with the result.
+	//
+	// Only operations whose results computed in Go are exactly the ones computed by XLA are folded: Reshape, and
+	// the element-wise Negate, Abs, Add, Subtract, Multiply, Divide (only for floats), Maximum and Minimum.
+	PassConstantFolding
+
+	// PassCommonSubexpressionElimination replaces the statements of pure operations that are identical to an earlier
+	// statement (same operation, operands, attributes and closures) by the outputs of the earlier statement.
+	// This includes duplicate constants.
+	PassCommonSubexpressionElimination
+
+	// PassDeadCodeElimination removes the statements of pure operations whose outputs are not used.
+	PassDeadCodeElimination
+)
+
+// DefaultOptimizationPasses are the passes used by Builder.Optimize if none is given.
+var DefaultOptimizationPasses = []OptimizationPass{
+	PassClosureDeduplication,
+	PassConstantFolding,
+	PassCommonSubexpressionElimination,
+	PassDeadCodeElimination,
+}
+
+// sideEffectOps are the operations that are never removed or merged by the optimizations: their effect is not
+// only in their outputs (or, for CustomCall, it is unknown).
+var sideEffectOps = utils.SetWith(
+	optypes.AllGather,
+	optypes.AllReduce,
+	optypes.AllToAll,
+	optypes.CollectiveBroadcast,
+	optypes.CollectivePermute,
+	optypes.ReduceScatter,
+	optypes.CustomCall,
+	optypes.AfterAll,
+	optypes.Infeed,
+	optypes.Outfeed,
+	optypes.Send,
+	optypes.Recv,
+	optypes.OptimizationBarrier,
+)
+
+// Optimize applies simple graph optimizations to the functions (and their closures) of the program, in place.
+//
+// XLA applies these (and many more) optimizations during compilation, but programs generated by higher-level
+// libraries often contain lots of duplicate constants and unused values, which inflate the StableHLO text
+// and slow down compilation.
+//
+// The passes are applied in the given order, repeatedly until the program no longer changes.
+// If no passes are given, DefaultOptimizationPasses are used.
+//
+// It must be called after all functions have returned (see Function.Return), and before Builder.Build.
+// The values of the statements removed by the optimizations must no longer be used.
+func (b *Builder) Optimize(passes ...OptimizationPass) error {
+	if len(passes) == 0 {
+		passes = DefaultOptimizationPasses
+	}
+	for _, pass := range passes {
+		if !pass.IsAOptimizationPass() {
+			return errors.Errorf("Builder.Optimize: unknown optimization pass %d", pass)
+		}
+	}
+	o := &optimizer{
+		builder:   b,
+		functions: make(map[string]*Function),
+		pure:      make(map[*Function]bool),
+	}
+	for _, fn := range b.functions {
+		if fn.Parent != nil {
+			continue
+		}
+		if !fn.Returned {
+			return errors.Errorf("Builder.Optimize requires all functions to have returned, but %q has not", fn.Name)
+		}
+		o.topLevel = append(o.topLevel, fn)
+		o.functions[NormalizeIdentifier(fn.Name)] = fn
+	}
+
+	for {
+		o.changed = false
+		for _, pass := range passes {
+			o.visited = utils.MakeSet[*Function]()
+			for _, fn := range o.topLevel {
+				switch pass {
+				case PassClosureDeduplication:
+					o.deduplicateClosures(fn)
+				case PassConstantFolding:
+					o.foldConstants(fn, make(map[string]*Statement))
+				case PassCommonSubexpressionElimination:
+					o.eliminateCommonSubexpressions(fn, make(map[string][]*Value), make(map[string]*Value))
+				case PassDeadCodeElimination:
+					o.eliminateDeadCode(fn)
+				}
+			}
+		}
+		if !o.changed {
+			break
+		}
+	}
+
+	// Closures no longer used by any statement are dropped.
+	used := utils.MakeSet[*Function]()
+	for _, fn := range o.topLevel {
+		collectClosures(fn, used)
+	}
+	b.functions = slices.DeleteFunc(b.functions, func(fn *Function) bool {
+		return fn.Parent != nil && !used.Has(fn)
+	})
+	return nil
+}
+
+// collectClosures inserts in the set the closures used by the statements of fn, recursively.
+func collectClosures(fn *Function, closures utils.Set[*Function]) {
+	for _, stmt := range fn.Statements {
+		for _, closure := range stmt.FunctionParameters {
+			closures.Insert(closure)
+			collectClosures(closure, closures)
+		}
+	}
+}
+
+// optimizer holds the state of Builder.Optimize.
+type optimizer struct {
+	builder  *Builder
+	topLevel []*Function
+
+	// functions are the top-level functions, indexed by their normalized name.
+	functions map[string]*Function
+
+	// pure caches whether functions (and closures) are free of side effects.
+	pure map[*Function]bool
+
+	// changed is set by the passes whenever they change the program.
+	changed bool
+
+	// visited holds the closures already visited by the current pass: deduplicated closures are shared by
+	// more than one statement, but they are only optimized in the scope of the first of them.
+	visited utils.Set[*Function]
+}
+
+// callee returns the function referenced by a Call or Composite statement, or nil if it is not found.
+func (o *optimizer) callee(stmt *Statement) *Function {
+	key := "callee"
+	if stmt.OpType == optypes.Composite {
+		key = "decomposition"
+	}
+	ref, ok := stmt.Attributes[key].(symbolRef)
+	if !ok {
+		return nil
+	}
+	return o.functions[NormalizeIdentifier(ref.name)]
+}
+
+// isPure returns whether the statement has no side effects, including those of its closures and, for Call and
+// Composite, of the called function: its only effect is the value of its outputs.
+func (o *optimizer) isPure(stmt *Statement) bool {
+	if stmt.OpType == optypes.FuncReturn || sideEffectOps.Has(stmt.OpType) {
+		return false
+	}
+	for _, closure := range stmt.FunctionParameters {
+		if !o.isPureFunction(closure) {
+			return false
+		}
+	}
+	if stmt.OpType == optypes.Call || stmt.OpType == optypes.Composite {
+		callee := o.callee(stmt)
+		if callee == nil || !o.isPureFunction(callee) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPureFunction returns whether all the statements of fn are pure.
+func (o *optimizer) isPureFunction(fn *Function) bool {
+	if pure, found := o.pure[fn]; found {
+		return pure
+	}
+	// Recursive calls are assumed not to be pure.
+	o.pure[fn] = false
+	pure := true
+	for _, stmt := range fn.Statements {
+		if stmt.OpType != optypes.FuncReturn && !o.isPure(stmt) {
+			pure = false
+			break
+		}
+	}
+	o.pure[fn] = pure
+	return pure
+}
+
+// fingerprinter writes a text representation of statements and closures that is the same for identical ones:
+// the values defined within are renamed by order of definition, while the other values keep their names.
+type fingerprinter struct {
+	sb          strings.Builder
+	numDefined  int
+	definitions map[string]string
+}
+
+// fingerprint returns the text representation of the closure fn.
+func fingerprint(fn *Function) string {
+	f := &fingerprinter{definitions: make(map[string]string)}
+	f.writeFunction(fn)
+	return f.sb.String()
+}
+
+// statementKey returns the text representation of the statement, without its outputs names.
+func statementKey(stmt *Statement) string {
+	f := &fingerprinter{definitions: make(map[string]string)}
+	f.writeStatement(stmt)
+	return f.sb.String()
+}
+
+func (f *fingerprinter) name(v *Value) string {
+	if name, found := f.definitions[v.name]; found {
+		return name
+	}
+	return v.String()
+}
+
+func (f *fingerprinter) define(v *Value) {
+	f.definitions[v.name] = fmt.Sprintf("$%d", f.numDefined)
+	f.numDefined++
+}
+
+func (f *fingerprinter) writeAttributes(attributes map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		fmt.Fprintf(&f.sb, " %s=%s", key, literalToStableHLO(attributes[key]))
+	}
+}
+
+func (f *fingerprinter) writeFunction(fn *Function) {
+	// The values defined in the function are not visible after it.
+	definitions := f.definitions
+	f.definitions = maps.Clone(definitions)
+	defer func() { f.definitions = definitions }()
+
+	f.sb.WriteString("(")
+	for _, input := range fn.Inputs {
+		f.define(input)
+		fmt.Fprintf(&f.sb, "%s: %s", f.name(input), input.shape.ToStableHLO())
+		f.writeAttributes(input.Attributes)
+		f.sb.WriteString(", ")
+	}
+	f.sb.WriteString(") {\n")
+	for _, stmt := range fn.Statements {
+		f.writeStatement(stmt)
+		for _, output := range stmt.Outputs {
+			f.define(output)
+		}
+		f.sb.WriteString("\n")
+	}
+	f.sb.WriteString("}")
+}
+
+func (f *fingerprinter) writeStatement(stmt *Statement) {
+	f.sb.WriteString(stmt.OpType.ToStableHLO())
+	f.sb.WriteString("(")
+	for _, input := range stmt.Inputs {
+		f.sb.WriteString(f.name(input))
+		f.sb.WriteString(", ")
+	}
+	f.sb.WriteString(")")
+	for i, closure := range stmt.FunctionParameters {
+		fmt.Fprintf(&f.sb, " ^%s", stmt.FunctionParametersNames[i])
+		f.writeFunction(closure)
+	}
+	f.writeAttributes(stmt.Attributes)
+	f.sb.WriteString(" ->")
+	for _, output := range stmt.Outputs {
+		f.sb.WriteString(" ")
+		f.sb.WriteString(output.shape.ToStableHLO())
+	}
+}
+
+// deduplicateClosures makes the statements of fn that take identical closures share the first of them.
+//
+// Only closures of fn are shared, so the shared closure only refers to values of fn (or of its enclosing
+// functions) defined before the first statement using it.
+func (o *optimizer) deduplicateClosures(fn *Function) {
+	seen := make(map[string]*Function)
+	for _, stmt := range fn.Statements {
+		for i, closure := range stmt.FunctionParameters {
+			o.deduplicateClosures(closure)
+			if closure.Parent != fn {
+				continue
+			}
+			key := fingerprint(closure)
+			first, found := seen[key]
+			if !found {
+				seen[key] = closure
+				continue
+			}
+			if first != closure {
+				stmt.FunctionParameters[i] = first
+				o.changed = true
+			}
+		}
+	}
+}
+
+// eliminateCommonSubexpressions removes the pure statements of fn that are identical to an earlier one.
+//
+// The available map holds the outputs of the statements visible in fn, indexed by their statementKey, and
+// replaced maps the names of the outputs of the removed statements to the values that replace them.
+func (o *optimizer) eliminateCommonSubexpressions(fn *Function, available map[string][]*Value,
+	replaced map[string]*Value) {
+	removed := utils.MakeSet[*Statement]()
+	for _, stmt := range fn.Statements {
+		for i, input := range stmt.Inputs {
+			if replacement, found := replaced[input.name]; found {
+				stmt.Inputs[i] = replacement
+			}
+		}
+		for _, closure := range stmt.FunctionParameters {
+			if !o.visited.Has(closure) {
+				o.visited.Insert(closure)
+				o.eliminateCommonSubexpressions(closure, maps.Clone(available), maps.Clone(replaced))
+			}
+		}
+		if len(stmt.Outputs) == 0 || !o.isPure(stmt) {
+			continue
+		}
+		key := statementKey(stmt)
+		earlier, found := available[key]
+		if !found {
+			available[key] = stmt.Outputs
+			continue
+		}
+		for i, output := range stmt.Outputs {
+			replaced[output.name] = earlier[i]
+		}
+		removed.Insert(stmt)
+	}
+	for i, output := range fn.Outputs {
+		if replacement, found := replaced[output.name]; found {
+			fn.Outputs[i] = replacement
+		}
+	}
+	if len(removed) > 0 {
+		fn.Statements = slices.DeleteFunc(fn.Statements, removed.Has)
+		o.changed = true
+	}
+}
+
+// eliminateDeadCode removes the pure statements of fn whose outputs are not used, and returns the names of the
+// values used by fn (including its closures), so the enclosing function keeps the statements that define them.
+func (o *optimizer) eliminateDeadCode(fn *Function) utils.Set[string] {
+	used := utils.MakeSet[string]()
+	removed := utils.MakeSet[*Statement]()
+	for _, stmt := range slices.Backward(fn.Statements) {
+		live := !o.isPure(stmt)
+		for _, output := range stmt.Outputs {
+			if used.Has(output.name) {
+				live = true
+			}
+		}
+		if !live {
+			removed.Insert(stmt)
+			continue
+		}
+		for _, input := range stmt.Inputs {
+			used.Insert(input.name)
+		}
+		for _, closure := range stmt.FunctionParameters {
+			for name := range o.eliminateDeadCode(closure) {
+				used.Insert(name)
+			}
+		}
+	}
+	if len(removed) > 0 {
+		fn.Statements = slices.DeleteFunc(fn.Statements, removed.Has)
+		o.changed = true
+	}
+	return used
+}
+
+// foldConstants replaces the statements of fn whose operands are all constants by a constant with the result.
+//
+// The constants map holds the Constant statements visible in fn, indexed by the name of their output.
+func (o *optimizer) foldConstants(fn *Function, constants map[string]*Statement) {
+	for _, stmt := range fn.Statements {
+		for _, closure := range stmt.FunctionParameters {
+			o.foldConstants(closure, maps.Clone(constants))
+		}
+		if stmt.OpType != optypes.Constant {
+			operands := make([]*Statement, len(stmt.Inputs))
+			for i, input := range stmt.Inputs {
+				operands[i] = constants[input.name]
+				if operands[i] == nil {
+					operands = nil
+					break
+				}
+			}
+			if len(operands) == 0 {
+				continue
+			}
+			literal, ok := foldStatement(stmt, operands)
+			if !ok {
+				continue
+			}
+			stmt.OpType = optypes.Constant
+			stmt.Inputs = nil
+			stmt.Attributes = map[string]any{"value": literal}
+			o.changed = true
+		}
+		if len(stmt.Outputs) == 1 {
+			constants[stmt.Outputs[0].name] = stmt
+		}
+	}
+}
+
+// foldStatement returns the constant result of the statement, given the Constant statements of its operands.
+func foldStatement(stmt *Statement, operands []*Statement) (literal tensorLiteral, ok bool) {
+	if len(stmt.Outputs) != 1 {
+		return
+	}
+	shape := stmt.Outputs[0].shape
+	if shape.Quantization != nil || shape.IsTuple() {
+		return
+	}
+	flats := make([]any, len(operands))
+	for i, operand := range operands {
+		var value any
+		if value, _, ok = operand.ConstantValue(); !ok {
+			return
+		}
+		if operand.Outputs[0].shape.Quantization != nil || operand.Outputs[0].shape.Size() != shape.Size() {
+			return tensorLiteral{}, false
+		}
+		valueV := reflect.ValueOf(value)
+		if valueV.Kind() != reflect.Slice {
+			// Scalar: converted to a slice with one element.
+			flatV := reflect.MakeSlice(reflect.SliceOf(valueV.Type()), 1, 1)
+			flatV.Index(0).Set(valueV)
+			valueV = flatV
+		}
+		if dtypes.FromGoType(valueV.Type().Elem()) != operand.Outputs[0].shape.DType {
+			return tensorLiteral{}, false
+		}
+		flats[i] = valueV.Interface()
+	}
+
+	var flat any
+	switch stmt.OpType {
+	case optypes.Reshape:
+		flat = flats[0]
+	case optypes.Negate, optypes.Abs, optypes.Add, optypes.Subtract, optypes.Multiply, optypes.Divide,
+		optypes.Maximum, optypes.Minimum:
+		flat, ok = foldElementWise(stmt.OpType, flats)
+	default:
+		return tensorLiteral{}, false
+	}
+	if !ok || dtypes.FromGoType(reflect.TypeOf(flat).Elem()) != shape.DType {
+		return tensorLiteral{}, false
+	}
+	var err error
+	if shape.IsScalar() {
+		literal, err = newTensorLiteralFromFlatAndDimensions(reflect.ValueOf(flat).Index(0).Interface())
+	} else {
+		literal, err = newTensorLiteralFromFlatAndDimensions(flat, shape.Dimensions...)
+	}
+	return literal, err == nil
+}
+
+// foldableNumber are the Go types of the values of constants that can be folded.
+type foldableNumber interface {
+	float32 | float64 | int | int8 | int16 | int32 | int64 | uint | uint8 | uint16 | uint32 | uint64
+}
+
+// foldElementWise applies the element-wise operation to the flat values of the operands, all of the same type.
+func foldElementWise(op optypes.OpType, flats []any) (any, bool) {
+	switch flats[0].(type) {
+	case []float32:
+		return foldElementWiseTyped[float32](op, flats, true)
+	case []float64:
+		return foldElementWiseTyped[float64](op, flats, true)
+	case []int:
+		return foldElementWiseTyped[int](op, flats, false)
+	case []int8:
+		return foldElementWiseTyped[int8](op, flats, false)
+	case []int16:
+		return foldElementWiseTyped[int16](op, flats, false)
+	case []int32:
+		return foldElementWiseTyped[int32](op, flats, false)
+	case []int64:
+		return foldElementWiseTyped[int64](op, flats, false)
+	case []uint:
+		return foldElementWiseTyped[uint](op, flats, false)
+	case []uint8:
+		return foldElementWiseTyped[uint8](op, flats, false)
+	case []uint16:
+		return foldElementWiseTyped[uint16](op, flats, false)
+	case []uint32:
+		return foldElementWiseTyped[uint32](op, flats, false)
+	case []uint64:
+		return foldElementWiseTyped[uint64](op, flats, false)
+	}
+	return nil, false
+}
+
+func foldElementWiseTyped[T foldableNumber](op optypes.OpType, flats []any, isFloat bool) (any, bool) {
+	operands := make([][]T, len(flats))
+	for i, flat := range flats {
+		var ok bool
+		if operands[i], ok = flat.([]T); !ok || len(operands[i]) != len(operands[0]) {
+			return nil, false
+		}
+	}
+	result := make([]T, len(operands[0]))
+	switch op {
+	case optypes.Negate:
+		for i, x := range operands[0] {
+			result[i] = -x
+		}
+	case optypes.Abs:
+		for i, x := range operands[0] {
+			if isFloat {
+				result[i] = T(math.Abs(float64(x)))
+			} else if x < 0 {
+				result[i] = -x
+			} else {
+				result[i] = x
+			}
+		}
+	case optypes.Add:
+		for i, x := range operands[0] {
+			result[i] = x + operands[1][i]
+		}
+	case optypes.Subtract:
+		for i, x := range operands[0] {
+			result[i] = x - operands[1][i]
+		}
+	case optypes.Multiply:
+		for i, x := range operands[0] {
+			result[i] = x * operands[1][i]
+		}
+	case optypes.Divide:
+		// Integer division by zero (and overflow) are implementation defined.
+		if !isFloat {
+			return nil, false
+		}
+		for i, x := range operands[0] {
+			result[i] = x / operands[1][i]
+		}
+	case optypes.Maximum:
+		for i, x := range operands[0] {
+			result[i] = max(x, operands[1][i])
+		}
+	case optypes.Minimum:
+		for i, x := range operands[0] {
+			result[i] = min(x, operands[1][i])
+		}
+	default:
+		return nil, false
+	}
+	return result, true
+}
@@ -0,0 +1,155 @@
+package stablehlo_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/stablehlo"
+	"github.com/gomlx/go-xla/pkg/stablehlo/interpreter"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func must1[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// checkOptimizedResults runs the program built by buildFn with the interpreter before and after Builder.Optimize,
+// and checks that the results are exactly the same. The optimized program is also built and parsed back, and run
+// again.
+func checkOptimizedResults(t *testing.T, buildFn func(fn *stablehlo.Function) []*stablehlo.Value,
+	inputs ...*interpreter.Tensor) {
+	t.Helper()
+	b := stablehlo.New(t.Name())
+	fn := b.Main()
+	must(fn.Return(buildFn(fn)...))
+	run := func(b *stablehlo.Builder, stage string) []*interpreter.Tensor {
+		t.Helper()
+		outputs, err := interpreter.New(b).Run(inputs...)
+		if err != nil {
+			t.Fatalf("failed to run the program %s: %+v", stage, err)
+		}
+		return outputs
+	}
+	want := run(b, "before Optimize")
+	if err := b.Optimize(); err != nil {
+		t.Fatalf("Optimize failed: %+v", err)
+	}
+	if err := b.Verify(); err != nil {
+		t.Fatalf("Verify failed after Optimize: %v", err)
+	}
+	program := must1(b.Build())
+	t.Logf("Optimized program:\n%s", program)
+	for _, stage := range []string{"after Optimize", "after Optimize, Build and Parse"} {
+		if stage != "after Optimize" {
+			b = must1(stablehlo.Parse(program))
+		}
+		got := run(b, stage)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d outputs, wanted %d", stage, len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].Shape.Equal(want[i].Shape) || !reflect.DeepEqual(got[i].Flat, want[i].Flat) {
+				t.Errorf("%s: output #%d is %s, wanted %s", stage, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestOptimizeResults(t *testing.T) {
+	t.Run("integer wraparound", func(t *testing.T) {
+		checkOptimizedResults(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			n := must1(fn.NamedInput("n", shapes.Make(dtypes.Int8, 3)))
+			lhs := must1(fn.ConstantFromFlatAndDimensions([]int8{100, 127, -128}, 3))
+			rhs := must1(fn.ConstantFromFlatAndDimensions([]int8{100, 1, -1}, 3))
+			sum := must1(stablehlo.Add(lhs, rhs))
+			minInt8 := must1(fn.ConstantFromScalar(int8(-128)))
+			large := must1(fn.ConstantFromScalar(int32(1 << 30)))
+			zero := must1(fn.ConstantFromScalar(uint8(0)))
+			return []*stablehlo.Value{
+				must1(stablehlo.Add(sum, n)),
+				must1(stablehlo.Subtract(lhs, rhs)),
+				must1(stablehlo.Multiply(large, must1(fn.ConstantFromScalar(int32(6))))),
+				must1(stablehlo.Subtract(zero, must1(fn.ConstantFromScalar(uint8(1))))),
+				must1(stablehlo.Negate(minInt8)),
+				must1(stablehlo.Abs(minInt8)),
+			}
+		}, must1(interpreter.NewTensor([]int8{1, -1, 127}, 3)))
+	})
+
+	t.Run("float folding", func(t *testing.T) {
+		checkOptimizedResults(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+			third := must1(stablehlo.Divide(must1(fn.ConstantFromScalar(float32(1))),
+				must1(fn.ConstantFromScalar(float32(3)))))
+			scale := must1(stablehlo.Reshape(must1(stablehlo.Maximum(
+				must1(fn.ConstantFromFlatAndDimensions([]float32{0.1, -2, 3}, 3)),
+				must1(fn.ConstantFromFlatAndDimensions([]float32{0.2, -3, 1}, 3)))), shapes.Make(dtypes.F32, 3)))
+			thirds := must1(stablehlo.BroadcastInDim(third, shapes.Make(dtypes.F32, 3), nil))
+			return []*stablehlo.Value{must1(stablehlo.Multiply(must1(stablehlo.Multiply(x, scale)), thirds))}
+		}, must1(interpreter.NewTensor([]float32{1.5, -2, 7}, 3)))
+	})
+
+	t.Run("CSE inside If", func(t *testing.T) {
+		build := func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+			pred := must1(fn.NamedInput("pred", shapes.Make(dtypes.Bool)))
+			square := must1(stablehlo.Multiply(x, x))
+			branch := func(sign float32) *stablehlo.Function {
+				branchFn := fn.Closure()
+				branchX := must1(branchFn.UseParentValue(x))
+				// Same as square in the enclosing function, and computed twice.
+				squares := []*stablehlo.Value{must1(stablehlo.Multiply(branchX, branchX)),
+					must1(stablehlo.Multiply(branchX, branchX))}
+				signs := must1(stablehlo.BroadcastInDim(must1(branchFn.ConstantFromScalar(sign)),
+					shapes.Make(dtypes.F32, 3), nil))
+				must(branchFn.Return(must1(stablehlo.Multiply(must1(stablehlo.Add(squares[0], squares[1])), signs))))
+				return branchFn
+			}
+			result := must1(stablehlo.If(pred, branch(1), branch(-1)))[0]
+			return []*stablehlo.Value{must1(stablehlo.Add(result, square))}
+		}
+		x := must1(interpreter.NewTensor([]float32{1.5, -2, 7}, 3))
+		checkOptimizedResults(t, build, x, interpreter.NewScalar(true))
+		checkOptimizedResults(t, build, x, interpreter.NewScalar(false))
+	})
+
+	t.Run("CSE inside While", func(t *testing.T) {
+		checkOptimizedResults(t, func(fn *stablehlo.Function) []*stablehlo.Value {
+			x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+			counter := must1(fn.ConstantFromScalar(int32(0)))
+			acc := must1(fn.ConstantFromFlatAndDimensions([]float32{0, 0, 0}, 3))
+
+			condFn := fn.Closure()
+			condCounter := must1(condFn.Input(counter.Shape()))
+			_ = must1(condFn.Input(acc.Shape()))
+			must(condFn.Return(must1(stablehlo.Compare(condCounter, must1(condFn.ConstantFromScalar(int32(4))),
+				types.CompareLT, types.CompareSigned))))
+
+			// The body adds x*x twice, and increments the counter with duplicate constants.
+			bodyFn := fn.Closure()
+			bodyCounter := must1(bodyFn.Input(counter.Shape()))
+			bodyAcc := must1(bodyFn.Input(acc.Shape()))
+			bodyX := must1(bodyFn.UseParentValue(x))
+			square := must1(stablehlo.Multiply(bodyX, bodyX))
+			otherSquare := must1(stablehlo.Multiply(bodyX, bodyX))
+			_ = must1(stablehlo.Negate(bodyAcc)) // Unused.
+			one := must1(bodyFn.ConstantFromScalar(int32(1)))
+			otherOne := must1(bodyFn.ConstantFromScalar(int32(1)))
+			increment := must1(stablehlo.Multiply(one, otherOne))
+			must(bodyFn.Return(must1(stablehlo.Add(bodyCounter, increment)),
+				must1(stablehlo.Add(must1(stablehlo.Add(bodyAcc, square)), otherSquare))))
+			return must1(stablehlo.While(condFn, bodyFn, counter, acc))
+		}, must1(interpreter.NewTensor([]float32{1.5, -2, 7}, 3)))
+	})
+}
@@ -0,0 +1,309 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestOptimize(t *testing.T) {
+	// newProgram builds a program with duplicate constants and computations, identical closures and unused values.
+	newProgram := func(name string) *Builder {
+		b := New(name)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		ones := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		otherOnes := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3}, 3))
+		scale := must1(Add(ones, otherOnes))
+		_ = must1(Negate(x)) // Unused.
+		y := must1(Multiply(x, scale))
+		otherY := must1(Multiply(x, scale))
+
+		sum := func(v *Value) *Value {
+			reductionFn := fn.Closure()
+			lhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+			rhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+			must(reductionFn.Return(must1(Add(lhs, rhs))))
+			return must1(Reduce(v, must1(fn.ConstantFromScalar(float32(0))), reductionFn, 0))
+		}
+		must(fn.Return(must1(Add(sum(y), sum(otherY)))))
+		return b
+	}
+
+	t.Run("default passes", func(t *testing.T) {
+		b := newProgram(t.Name())
+		if err := b.Optimize(); err != nil {
+			t.Fatalf("Optimize failed: %v", err)
+		}
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed after Optimize: %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_default_passes {
+  func.func @main(%x: tensor<3xf32>) -> tensor<f32> {
+    %2 = "stablehlo.constant"() { value = dense<[2.0, 4.0, 6.0]> : tensor<3xf32> } : () -> tensor<3xf32>
+    %4 = "stablehlo.multiply"(%x, %2) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %7 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %8 = "stablehlo.reduce"(%4, %7) ({
+      ^reductionFn(%arg1: tensor<f32>, %arg2: tensor<f32>) :
+          %6 = "stablehlo.add"(%arg1, %arg2) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%6) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %12 = "stablehlo.add"(%8, %8) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+    "stablehlo.return"(%12) : (tensor<f32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+		if len(b.functions) != 2 {
+			t.Errorf("expected the unused closure to be dropped, got %d functions", len(b.functions))
+		}
+	})
+
+	t.Run("closures", func(t *testing.T) {
+		// A branch computing the same as the enclosing function uses its value instead.
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32)))
+		pred := must1(fn.NamedInput("pred", shapes.Make(dtypes.Bool)))
+		negX := must1(Negate(x))
+		trueBranch := fn.Closure()
+		branchNegX := must1(Negate(must1(trueBranch.UseParentValue(x))))
+		branchNegXName := branchNegX.name
+		must(trueBranch.Return(branchNegX))
+		falseBranch := fn.Closure()
+		must(falseBranch.Return(must1(falseBranch.UseParentValue(x))))
+		result := must1(If(pred, trueBranch, falseBranch))[0]
+		must(fn.Return(must1(Add(result, negX))))
+		must(b.Optimize())
+		if trueBranch.Outputs[0] != negX {
+			t.Errorf("expected the output of the true branch to be replaced by %s, got %s", negX, trueBranch.Outputs[0])
+		}
+		if branchNegX.name != branchNegXName {
+			t.Errorf("the replaced value was renamed from %q to %q", branchNegXName, branchNegX.name)
+		}
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed after Optimize: %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_closures {
+  func.func @main(%x: tensor<f32>, %pred: tensor<i1>) -> tensor<f32> {
+    %0 = "stablehlo.negate"(%x) : (tensor<f32>) -> tensor<f32>
+    %2 = "stablehlo.if"(%pred) ({
+      ^true_branch() :
+          "stablehlo.return"(%0) : (tensor<f32>) -> ()
+    }, {
+      ^false_branch() :
+          "stablehlo.return"(%x) : (tensor<f32>) -> ()
+    }) : (tensor<i1>) -> tensor<f32>
+    %3 = "stablehlo.add"(%2, %0) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+    "stablehlo.return"(%3) : (tensor<f32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("shared closure changed", func(t *testing.T) {
+		// The reductions of x and y share their closure after deduplication, and then constant folding changes it.
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		y := must1(fn.NamedInput("y", shapes.Make(dtypes.F32, 3)))
+		scaledSum := func(v *Value) *Value {
+			reductionFn := fn.Closure()
+			lhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+			rhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+			two := must1(reductionFn.ConstantFromScalar(float32(2)))
+			one := must1(Add(two, must1(reductionFn.ConstantFromScalar(float32(-1)))))
+			must(reductionFn.Return(must1(Multiply(must1(Add(lhs, rhs)), one))))
+			return must1(Reduce(v, must1(fn.ConstantFromScalar(float32(0))), reductionFn, 0))
+		}
+		sumX, sumY := scaledSum(x), scaledSum(y)
+		must(fn.Return(sumX, sumY))
+		reduceX, reduceY := sumX.stmt, sumY.stmt
+
+		must(b.Optimize(PassClosureDeduplication))
+		if reduceX.FunctionParameters[0] != reduceY.FunctionParameters[0] {
+			t.Fatal("expected both reductions to share the same closure")
+		}
+		must(b.Optimize(PassConstantFolding, PassDeadCodeElimination))
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed after Optimize: %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_shared_closure_changed {
+  func.func @main(%x: tensor<3xf32>, %y: tensor<3xf32>) -> (tensor<f32>, tensor<f32>) {
+    %5 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %6 = "stablehlo.reduce"(%x, %5) ({
+      ^reductionFn(%arg2: tensor<f32>, %arg3: tensor<f32>) :
+          %2 = "stablehlo.constant"() { value = dense<1.0> : tensor<f32> } : () -> tensor<f32>
+          %3 = "stablehlo.add"(%arg2, %arg3) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          %4 = "stablehlo.multiply"(%3, %2) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%4) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %12 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %13 = "stablehlo.reduce"(%y, %12) ({
+      ^reductionFn(%arg2: tensor<f32>, %arg3: tensor<f32>) :
+          %2 = "stablehlo.constant"() { value = dense<1.0> : tensor<f32> } : () -> tensor<f32>
+          %3 = "stablehlo.add"(%arg2, %arg3) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          %4 = "stablehlo.multiply"(%3, %2) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%4) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    "stablehlo.return"(%6, %13) : (tensor<f32>, tensor<f32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("dead code elimination only", func(t *testing.T) {
+		b := newProgram(t.Name())
+		must(b.Optimize(PassDeadCodeElimination))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_dead_code_elimination_only {
+  func.func @main(%x: tensor<3xf32>) -> tensor<f32> {
+    %0 = "stablehlo.constant"() { value = dense<[1.0, 2.0, 3.0]> : tensor<3xf32> } : () -> tensor<3xf32>
+    %1 = "stablehlo.constant"() { value = dense<[1.0, 2.0, 3.0]> : tensor<3xf32> } : () -> tensor<3xf32>
+    %2 = "stablehlo.add"(%0, %1) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %4 = "stablehlo.multiply"(%x, %2) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %5 = "stablehlo.multiply"(%x, %2) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %7 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %8 = "stablehlo.reduce"(%4, %7) ({
+      ^reductionFn(%arg1: tensor<f32>, %arg2: tensor<f32>) :
+          %6 = "stablehlo.add"(%arg1, %arg2) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%6) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %10 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %11 = "stablehlo.reduce"(%5, %10) ({
+      ^reductionFn(%arg3: tensor<f32>, %arg4: tensor<f32>) :
+          %9 = "stablehlo.add"(%arg3, %arg4) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%9) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %12 = "stablehlo.add"(%8, %11) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+    "stablehlo.return"(%12) : (tensor<f32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("side effects", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		_ = must1(OptimizationBarrier(x))
+		_ = must1(OptimizationBarrier(x))
+		must(fn.Return(x))
+		must(b.Optimize())
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_side_effects {
+  func.func @main(%x: tensor<3xf32>) -> tensor<3xf32> {
+    %0 = "stablehlo.optimization_barrier"(%x) : (tensor<3xf32>) -> tensor<3xf32>
+    %1 = "stablehlo.optimization_barrier"(%x) : (tensor<3xf32>) -> tensor<3xf32>
+    "stablehlo.return"(%x) : (tensor<3xf32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("side effects with unused outputs", func(t *testing.T) {
+		// Each side-effecting statement is duplicated, and none of their outputs is used.
+		b := New(t.Name())
+		logFn := b.NewFunction("log")
+		logX := must1(logFn.Input(shapes.Make(dtypes.F32, 3)))
+		must(logFn.Return(must1(Outfeed(must1(logFn.CreateToken()), []*Value{logX}, "")), logX))
+
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		channelID := 1
+		for range 2 {
+			token := must1(fn.CreateToken())
+			_ = must1(Send(token, []*Value{x}, true, &types.CollectiveConfig{ChannelID: &channelID}))
+			_ = must1(Outfeed(token, []*Value{x}, ""))
+			_, _ = must2(Infeed(token, []shapes.Shape{x.Shape()}, ""))
+			_ = must1(CustomCall(fn, "my_target", []*Value{x}, []shapes.Shape{x.Shape()}, nil))
+			_ = must1(Call(logFn, x))
+		}
+		must(fn.Return(x))
+		must(b.Optimize())
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed after Optimize: %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		want := `module @TestOptimize_side_effects_with_unused_outputs {
+  func.func @log(%arg0: tensor<3xf32>) -> (!stablehlo.token, tensor<3xf32>) {
+    %0 = "stablehlo.after_all"() : () -> !stablehlo.token
+    %1 = "stablehlo.outfeed"(%arg0, %0) { outfeed_config = "" } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    "stablehlo.return"(%1, %arg0) : (!stablehlo.token, tensor<3xf32>) -> ()
+  }
+
+  func.func @main(%x: tensor<3xf32>) -> tensor<3xf32> {
+    %0 = "stablehlo.after_all"() : () -> !stablehlo.token
+    %1 = "stablehlo.send"(%x, %0) {
+      channel_handle = #stablehlo.channel_handle<handle = 1, type = 2>,
+      is_host_transfer = true
+    } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %2 = "stablehlo.outfeed"(%x, %0) { outfeed_config = "" } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %3, %4 = "stablehlo.infeed"(%0) { infeed_config = "" } : (!stablehlo.token) -> (tensor<3xf32>, !stablehlo.token)
+    %5 = "stablehlo.custom_call"(%x) {
+      api_version = 4 : i32,
+      call_target_name = "my_target"
+    } : (tensor<3xf32>) -> tensor<3xf32>
+    %6, %7 = "func.call"(%x) { callee = @log } : (tensor<3xf32>) -> (!stablehlo.token, tensor<3xf32>)
+    %8 = "stablehlo.after_all"() : () -> !stablehlo.token
+    %9 = "stablehlo.send"(%x, %8) {
+      channel_handle = #stablehlo.channel_handle<handle = 1, type = 2>,
+      is_host_transfer = true
+    } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %10 = "stablehlo.outfeed"(%x, %8) { outfeed_config = "" } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %11, %12 = "stablehlo.infeed"(%8) { infeed_config = "" } : (!stablehlo.token) -> (tensor<3xf32>, !stablehlo.token)
+    %13 = "stablehlo.custom_call"(%x) {
+      api_version = 4 : i32,
+      call_target_name = "my_target"
+    } : (tensor<3xf32>) -> tensor<3xf32>
+    %14, %15 = "func.call"(%x) { callee = @log } : (tensor<3xf32>) -> (!stablehlo.token, tensor<3xf32>)
+    "stablehlo.return"(%x) : (tensor<3xf32>) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("not returned", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		_ = must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		if err := b.Optimize(); err == nil {
+			t.Fatal("expected Optimize to fail for a function that has not returned")
+		}
+	})
+}
@@ -0,0 +1,1630 @@
+package stablehlo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes/bfloat16"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/gomlx/go-xla/pkg/types/shardy"
+	"github.com/pkg/errors"
+	"github.com/x448/float16"
+)
+
+// Parse parses a StableHLO module in text format, like the one generated by Builder.Build, and returns a
+// Builder with its functions, statements and values rebuilt.
+//
+// The returned Builder can be inspected (see Builder.Functions), changed (more ops can be added to functions
+// not yet returned, or new functions created) and built again.
+//
+// Operations can be in MLIR's generic format (`%0 = "stablehlo.add"(%x, %y) : (...) -> ...`), which is the format
+// used by Builder.Build, or in the custom (pretty) format used by the modules exported by JAX
+// (`%0 = stablehlo.add %x, %y : tensor<f32>`), including the custom formats of constants, returns, calls and of
+// the regions of reduce and while. The custom format is supported for the operations printed that way by JAX; other
+// operations with a specific custom syntax are rejected with an error, and must first be converted with
+// `stablehlo-opt --mlir-print-op-generic`. The module and functions themselves can be either in the generic format
+// or in the usual custom format (`module @name {`, `func.func @name(...) -> ... {`). Locations (`loc(...)`) are
+// ignored.
+//
+// Attributes are parsed to the Go types used by the ops of this package when possible (string, bool, typed
+// integers and floats, constant tensors and function references), and otherwise kept as their StableHLO text.
+//
+// Module attributes other than the number of replicas and partitions, and function attributes, are ignored.
+// The shapes of the values are taken from the types in the module, they are not checked with shape inference.
+func Parse(text []byte) (*Builder, error) {
+	p := &parser{
+		text:    string(text),
+		builder: New(""),
+		scopes:  make(map[*Function]map[string]*Value),
+	}
+	if err := p.parseModule(); err != nil {
+		return nil, err
+	}
+	p.finalize()
+	return p.builder, nil
+}
+
+// Functions returns the functions of the program, including closures, in the order they were created.
+func (b *Builder) Functions() []*Function {
+	return b.functions
+}
+
+// parser implements Parse. It is a simple recursive-descent parser working directly on the text.
+type parser struct {
+	text    string
+	pos     int
+	builder *Builder
+
+	// scopes maps each function to the values defined in it, by their name in the text.
+	scopes map[*Function]map[string]*Value
+
+	// numUnnamed is a counter used to name results not named in the text.
+	numUnnamed int
+}
+
+// errorf returns an error annotated with the current line and column.
+func (p *parser) errorf(format string, args ...any) error {
+	line := strings.Count(p.text[:p.pos], "\n") + 1
+	column := p.pos - strings.LastIndex(p.text[:p.pos], "\n")
+	return errors.Errorf("stablehlo.Parse: line %d, column %d: %s", line, column, fmt.Sprintf(format, args...))
+}
+
+// skipSpaces skips white spaces, comments and locations (`loc(...)`), which are ignored.
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case strings.HasPrefix(p.text[p.pos:], "//"):
+			end := strings.IndexByte(p.text[p.pos:], '\n')
+			if end == -1 {
+				p.pos = len(p.text)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.text[p.pos:], "loc("):
+			p.pos += len("loc")
+			p.readBalanced(stopAlways)
+		default:
+			return
+		}
+	}
+}
+
+// atEnd returns whether the whole text was consumed.
+func (p *parser) atEnd() bool {
+	p.skipSpaces()
+	return p.pos >= len(p.text)
+}
+
+// peek returns whether the text (after spaces) starts with the given prefix, without consuming it.
+func (p *parser) peek(prefix string) bool {
+	p.skipSpaces()
+	return strings.HasPrefix(p.text[p.pos:], prefix)
+}
+
+// consume skips spaces and the given prefix, if present. It returns whether the prefix was present.
+func (p *parser) consume(prefix string) bool {
+	if p.peek(prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// consumeKeyword is like consume, but the keyword must not be followed by an identifier character.
+func (p *parser) consumeKeyword(keyword string) bool {
+	if !p.peek(keyword) {
+		return false
+	}
+	end := p.pos + len(keyword)
+	if end < len(p.text) && isSuffixIDChar(p.text[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// expect is like consume, but it returns an error if the prefix is not present.
+func (p *parser) expect(prefix string) error {
+	if !p.consume(prefix) {
+		return p.errorf("expected %q, got %q", prefix, p.excerpt())
+	}
+	return nil
+}
+
+// excerpt returns the start of the text not parsed yet, for error messages.
+func (p *parser) excerpt() string {
+	const maxLen = 40
+	rest := p.text[p.pos:]
+	if end := strings.IndexByte(rest, '\n'); end != -1 {
+		rest = rest[:end]
+	}
+	if len(rest) > maxLen {
+		rest = rest[:maxLen] + "..."
+	}
+	return rest
+}
+
+// isSuffixIDChar returns whether the character can be part of an MLIR identifier, after the first character.
+func isSuffixIDChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '_' || c == '$' || c == '.' || c == '-'
+}
+
+// readIdentifier reads an MLIR bare identifier, like "func.func" or "stablehlo.add".
+func (p *parser) readIdentifier() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.text) && isSuffixIDChar(p.text[p.pos]) && p.text[p.pos] != '-' {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// readString reads a quoted string, and returns it unquoted.
+func (p *parser) readString() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	if !p.consume(`"`) {
+		return "", p.errorf("expected a quoted string, got %q", p.excerpt())
+	}
+	for p.pos < len(p.text) && p.text[p.pos] != '"' {
+		if p.text[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.text) {
+		p.pos = start
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	str, err := unquoteString(p.text[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return "", p.errorf("invalid string: %v", err)
+	}
+	return str, nil
+}
+
+// unquoteString unquotes a string, accepting both Go escapes (used by Builder) and MLIR's hexadecimal
+// escapes (e.g.: "\0A").
+func unquoteString(quoted string) (string, error) {
+	if str, err := unquoteMLIRString(quoted); err == nil {
+		return str, nil
+	}
+	return strconv.Unquote(quoted)
+}
+
+// unquoteMLIRString unquotes a string with MLIR's escapes.
+func unquoteMLIRString(quoted string) (string, error) {
+	var sb strings.Builder
+	body := quoted[1 : len(quoted)-1]
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(body) {
+			return "", errors.New("invalid escape at the end of the string")
+		}
+		switch next := body[i+1]; next {
+		case '\\', '"':
+			sb.WriteByte(next)
+			i++
+		case 'n':
+			sb.WriteByte('\n')
+			i++
+		case 't':
+			sb.WriteByte('\t')
+			i++
+		default:
+			if i+2 >= len(body) {
+				return "", errors.Errorf("invalid escape %q", body[i:])
+			}
+			b, err := hex.DecodeString(body[i+1 : i+3])
+			if err != nil {
+				return "", errors.Errorf("invalid escape %q", body[i:i+3])
+			}
+			sb.WriteByte(b[0])
+			i += 2
+		}
+	}
+	return sb.String(), nil
+}
+
+// readSymbol reads a symbol reference (e.g.: @main or @"my.function"), and returns its name.
+func (p *parser) readSymbol() (string, error) {
+	if !p.consume("@") {
+		return "", p.errorf("expected a symbol (\"@name\"), got %q", p.excerpt())
+	}
+	if p.peek(`"`) {
+		return p.readString()
+	}
+	name := p.readIdentifier()
+	if name == "" {
+		return "", p.errorf("expected a symbol name, got %q", p.excerpt())
+	}
+	return name, nil
+}
+
+// readValueName reads the name of a value (e.g.: "%0" or "%arg0"), without the "%".
+func (p *parser) readValueName() (string, error) {
+	if !p.consume("%") {
+		return "", p.errorf("expected a value (\"%%name\"), got %q", p.excerpt())
+	}
+	start := p.pos
+	for p.pos < len(p.text) && isSuffixIDChar(p.text[p.pos]) && !strings.HasPrefix(p.text[p.pos:], "->") {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a value name, got %q", p.excerpt())
+	}
+	return p.text[start:p.pos], nil
+}
+
+// readInt reads a decimal integer.
+func (p *parser) readInt() (int, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos < len(p.text) && p.text[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+	value, err := strconv.Atoi(p.text[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("expected an integer, got %q", p.excerpt())
+	}
+	return value, nil
+}
+
+// readBalanced reads text until stop returns true for a character outside any brackets or strings, or until
+// an unmatched closing bracket. It returns the text read, with surrounding spaces trimmed.
+//
+// The first character is never checked with stop, so if it is an opening bracket, readBalanced(stopAlways)
+// reads until the matching closing bracket.
+//
+// The "->" arrow of function types is not taken as a closing bracket.
+func (p *parser) readBalanced(stop func(c byte) bool) string {
+	p.skipSpaces()
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		if depth == 0 && p.pos > start && stop(c) {
+			break
+		}
+		switch c {
+		case '"':
+			p.pos++
+			for p.pos < len(p.text) && p.text[p.pos] != '"' {
+				if p.text[p.pos] == '\\' {
+					p.pos++
+				}
+				p.pos++
+			}
+		case '-':
+			if strings.HasPrefix(p.text[p.pos:], "->") {
+				p.pos++
+			}
+		case '(', '[', '{', '<':
+			depth++
+		case ')', ']', '}', '>':
+			if depth == 0 {
+				return strings.TrimSpace(p.text[start:p.pos])
+			}
+			depth--
+		}
+		p.pos++
+	}
+	return strings.TrimSpace(p.text[start:p.pos])
+}
+
+// stopAlways and stopNever are stop functions for readBalanced.
+func stopAlways(byte) bool { return true }
+func stopNever(byte) bool  { return false }
+
+// readType reads a type (e.g.: "tensor<2xf32>") and returns its shape.
+func (p *parser) readType() (shapes.Shape, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.text) && (isSuffixIDChar(p.text[p.pos]) || p.text[p.pos] == '!') && p.text[p.pos] != '-' {
+		p.pos++
+	}
+	if p.peek("<") {
+		p.readBalanced(stopAlways)
+	}
+	typeText := p.text[start:p.pos]
+	shape, err := shapes.FromStableHLO(typeText)
+	if err != nil {
+		p.pos = start
+		return shapes.Invalid(), p.errorf("%v", err)
+	}
+	return shape, nil
+}
+
+// readTypeList reads a parenthesized list of types, or a single type if allowSingle is true.
+func (p *parser) readTypeList(allowSingle bool) ([]shapes.Shape, error) {
+	if !p.consume("(") {
+		if !allowSingle {
+			return nil, p.errorf("expected \"(\" for a list of types, got %q", p.excerpt())
+		}
+		shape, err := p.readType()
+		if err != nil {
+			return nil, err
+		}
+		return []shapes.Shape{shape}, nil
+	}
+	var types []shapes.Shape
+	for !p.consume(")") {
+		if len(types) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		shape, err := p.readType()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, shape)
+	}
+	return types, nil
+}
+
+// readFunctionType reads a function type, like "(tensor<f32>, tensor<f32>) -> tensor<f32>".
+func (p *parser) readFunctionType() (inputs, outputs []shapes.Shape, err error) {
+	inputs, err = p.readTypeList(false)
+	if err != nil {
+		return
+	}
+	if err = p.expect("->"); err != nil {
+		return
+	}
+	outputs, err = p.readTypeList(true)
+	return
+}
+
+// readDictionary reads a dictionary of attributes (e.g.: "{a = 1 : i64, b}"), after the opening "{".
+// Attributes without a value (unit attributes) are set to the literal "unit".
+func (p *parser) readDictionary() (map[string]any, error) {
+	attributes := make(map[string]any)
+	for !p.consume("}") {
+		if len(attributes) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		var key string
+		if p.peek(`"`) {
+			var err error
+			if key, err = p.readString(); err != nil {
+				return nil, err
+			}
+		} else if key = p.readIdentifier(); key == "" {
+			return nil, p.errorf("expected an attribute name, got %q", p.excerpt())
+		}
+		if !p.consume("=") {
+			attributes[key] = literalStr("unit")
+			continue
+		}
+		raw := p.readBalanced(func(c byte) bool { return c == ',' })
+		if raw == "" {
+			return nil, p.errorf("expected a value for attribute %q, got %q", key, p.excerpt())
+		}
+		value, err := parseAttributeValue(raw)
+		if err != nil {
+			return nil, p.errorf("invalid value for attribute %q: %v", key, err)
+		}
+		attributes[key] = value
+	}
+	return attributes, nil
+}
+
+var (
+	typedIntAttributeRegexp   = regexp.MustCompile(`^(-?\d+)\s*:\s*(u?i\d+)$`)
+	typedFloatAttributeRegexp = regexp.MustCompile(`^(\S+)\s*:\s*(f32|f64)$`)
+)
+
+// parseAttributeValue converts the text of an attribute value to the Go types used by the builder ops when
+// possible, or otherwise keeps its text as a literalStr.
+func parseAttributeValue(raw string) (any, error) {
+	switch {
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, `"`) && strings.HasSuffix(raw, `"`) && len(raw) > 1:
+		sub := &parser{text: raw}
+		if str, err := sub.readString(); err == nil && sub.atEnd() {
+			return str, nil
+		}
+	case strings.HasPrefix(raw, "@"):
+		sub := &parser{text: raw}
+		if name, err := sub.readSymbol(); err == nil && sub.atEnd() {
+			return symbolRef{name: name}, nil
+		}
+	case strings.HasPrefix(raw, "dense<"):
+		literal, ok, err := parseDenseLiteral(raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return literal, nil
+		}
+	}
+	if match := typedIntAttributeRegexp.FindStringSubmatch(raw); match != nil {
+		if value, ok := parseTypedInt(match[1], dtypes.FromStableHLO(match[2])); ok {
+			return value, nil
+		}
+	}
+	if match := typedFloatAttributeRegexp.FindStringSubmatch(raw); match != nil {
+		dtype := dtypes.FromStableHLO(match[2])
+		if value, err := parseScalar(match[1], dtype); err == nil {
+			return value, nil
+		}
+	}
+	return literalStr(raw), nil
+}
+
+// parseTypedInt parses an integer of the given dtype, and returns it as the corresponding Go type.
+// Only dtypes with a matching Go type are accepted.
+func parseTypedInt(text string, dtype dtypes.DType) (any, bool) {
+	if !dtype.IsInt() || dtype == dtypes.Int4 || dtype == dtypes.Int2 || dtype == dtypes.Uint4 ||
+		dtype == dtypes.Uint2 {
+		return nil, false
+	}
+	value, err := parseScalar(text, dtype)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// lineIndentation returns the indentation of the line at the given position.
+func (p *parser) lineIndentation(pos int) string {
+	lineStart := strings.LastIndex(p.text[:pos], "\n") + 1
+	return p.text[lineStart:pos]
+}
+
+// removeAttributeIndentation removes the indentation added by writeAttributes to a single multi-line
+// attribute, so its original text is restored. The indentation is the one of the statement (or function) line.
+func removeAttributeIndentation(attributes map[string]any, indentation string) {
+	if len(attributes) != 1 || strings.TrimSpace(indentation) != "" {
+		return
+	}
+	prefix := "\n" + indentation + IndentationStep
+	for key, value := range attributes {
+		if literal, ok := value.(literalStr); ok {
+			attributes[key] = literalStr(strings.ReplaceAll(string(literal), prefix, "\n"))
+		}
+	}
+}
+
+// parseDenseLiteral parses a constant tensor literal (e.g.: "dense<[1, 2]> : tensor<2xi32>") to a tensorLiteral.
+//
+// It returns ok=false for literals of dtypes without a Go type (e.g.: sub-byte ints, quantized values), which
+// are kept as text.
+func parseDenseLiteral(raw string) (literal tensorLiteral, ok bool, err error) {
+	sub := &parser{text: raw}
+	if err = sub.expect("dense<"); err != nil {
+		return
+	}
+	content := sub.readBalanced(stopNever)
+	if err = sub.expect(">"); err != nil {
+		return
+	}
+	if err = sub.expect(":"); err != nil {
+		return
+	}
+	shape, err := sub.readType()
+	if err != nil {
+		return
+	}
+	if !sub.atEnd() {
+		err = sub.errorf("unexpected %q after dense literal", sub.excerpt())
+		return
+	}
+	dtype := shape.DType
+	if shape.IsTuple() || shape.IsDynamic() || shape.Quantization != nil || dtype == dtypes.TOKEN ||
+		dtypes.FromGoType(dtype.GoType()) != dtype {
+		return tensorLiteral{}, false, nil
+	}
+
+	size := shape.Size()
+	flat := reflect.MakeSlice(reflect.SliceOf(dtype.GoType()), size, size)
+	if strings.HasPrefix(content, `"0x`) {
+		// Raw little-endian data, in hexadecimal.
+		if dtype == dtypes.Bool {
+			return tensorLiteral{}, false, nil
+		}
+		var data []byte
+		data, err = hex.DecodeString(strings.Trim(content, `"`)[2:])
+		if err != nil {
+			return
+		}
+		elementSize := dtype.Size()
+		if len(data) == elementSize && size > 1 {
+			// Splat value.
+			data = bytes.Repeat(data, size)
+		}
+		if len(data) != elementSize*size {
+			err = errors.Errorf("dense literal has %d bytes, expected %d for %s", len(data), elementSize*size, shape)
+			return
+		}
+		if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, flat.Interface()); err != nil {
+			return
+		}
+	} else {
+		elements := splitDenseElements(content)
+		if len(elements) == 1 && size != 1 {
+			// Splat value.
+			elements = slices.Repeat(elements[:1], size)
+		}
+		if len(elements) != size {
+			err = errors.Errorf("dense literal has %d elements, expected %d for %s", len(elements), size, shape)
+			return
+		}
+		for i, element := range elements {
+			var value any
+			if value, err = parseScalar(element, dtype); err != nil {
+				return
+			}
+			flat.Index(i).Set(reflect.ValueOf(value))
+		}
+	}
+	if shape.IsScalar() {
+		return tensorLiteral{value: flat.Index(0).Interface()}, true, nil
+	}
+	return tensorLiteral{value: flat.Interface(), dims: shape.Dimensions}, true, nil
+}
+
+// splitDenseElements returns the flat list of elements of the contents of a dense literal, with the
+// nested lists ("[...]") flattened. Complex numbers ("(re, im)") are kept as one element.
+func splitDenseElements(content string) []string {
+	var elements []string
+	var current strings.Builder
+	parenDepth := 0
+	flush := func() {
+		if element := strings.TrimSpace(current.String()); element != "" {
+			elements = append(elements, element)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '(':
+			parenDepth++
+			current.WriteByte(c)
+		case c == ')':
+			parenDepth--
+			current.WriteByte(c)
+		case parenDepth == 0 && (c == '[' || c == ']' || c == ','):
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return elements
+}
+
+// parseScalar parses a scalar element of a dense literal, or of a typed attribute, and returns it as the Go
+// type of the dtype. Floats can also be given in hexadecimal, with their bits.
+func parseScalar(text string, dtype dtypes.DType) (any, error) {
+	text = strings.TrimSpace(text)
+	isHex := strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X")
+	switch {
+	case dtype == dtypes.Bool:
+		switch text {
+		case "true", "1":
+			return true, nil
+		case "false", "0":
+			return false, nil
+		}
+		return nil, errors.Errorf("invalid bool value %q", text)
+
+	case dtype.IsInt():
+		base := 10
+		if isHex {
+			base, text = 16, text[2:]
+		}
+		goType := dtype.GoType()
+		if dtype.IsUnsigned() {
+			value, err := strconv.ParseUint(text, base, goType.Bits())
+			if err != nil {
+				return nil, errors.Errorf("invalid %s value %q", dtype, text)
+			}
+			return reflect.ValueOf(value).Convert(goType).Interface(), nil
+		}
+		value, err := strconv.ParseInt(text, base, goType.Bits())
+		if err != nil {
+			return nil, errors.Errorf("invalid %s value %q", dtype, text)
+		}
+		return reflect.ValueOf(value).Convert(goType).Interface(), nil
+
+	case dtype.IsComplex():
+		if !strings.HasPrefix(text, "(") || !strings.HasSuffix(text, ")") {
+			return nil, errors.Errorf("invalid complex value %q", text)
+		}
+		parts := strings.Split(text[1:len(text)-1], ",")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid complex value %q", text)
+		}
+		realDType := dtype.RealDType()
+		re, err := parseScalar(parts[0], realDType)
+		if err != nil {
+			return nil, err
+		}
+		im, err := parseScalar(parts[1], realDType)
+		if err != nil {
+			return nil, err
+		}
+		if dtype == dtypes.Complex64 {
+			return complex(re.(float32), im.(float32)), nil
+		}
+		return complex(re.(float64), im.(float64)), nil
+
+	case dtype.IsFloat():
+		if isHex {
+			bits, err := strconv.ParseUint(text[2:], 16, dtype.Bits())
+			if err != nil {
+				return nil, errors.Errorf("invalid %s value %q", dtype, text)
+			}
+			switch dtype {
+			case dtypes.Float16:
+				return float16.Frombits(uint16(bits)), nil
+			case dtypes.BFloat16:
+				return bfloat16.FromBits(uint16(bits)), nil
+			case dtypes.Float32:
+				return math.Float32frombits(uint32(bits)), nil
+			default:
+				return math.Float64frombits(bits), nil
+			}
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s value %q", dtype, text)
+		}
+		switch dtype {
+		case dtypes.Float16:
+			return float16.Fromfloat32(float32(value)), nil
+		case dtypes.BFloat16:
+			return bfloat16.FromFloat32(float32(value)), nil
+		case dtypes.Float32:
+			return float32(value), nil
+		default:
+			return value, nil
+		}
+	}
+	return nil, errors.Errorf("dtype %s not supported for literal values", dtype)
+}
+
+// parseModule parses the whole text: a module, in the custom or generic format, or only its contents.
+func (p *parser) parseModule() error {
+	p.skipAliases()
+	switch {
+	case p.consumeKeyword("module"):
+		if p.peek("@") {
+			name, err := p.readSymbol()
+			if err != nil {
+				return err
+			}
+			p.builder.name = name
+		}
+		if p.consumeKeyword("attributes") {
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseModuleAttributes(); err != nil {
+				return err
+			}
+		}
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		if err := p.parseModuleBody("}"); err != nil {
+			return err
+		}
+
+	case p.consume(`"builtin.module"`):
+		if err := p.expect("()"); err != nil {
+			return err
+		}
+		for !p.peek(":") {
+			switch {
+			case p.consume("<{"), p.consume("{"):
+				if err := p.parseModuleAttributes(); err != nil {
+					return err
+				}
+				p.consume(">")
+			case p.consume("({"):
+				if p.consume("^") {
+					p.readIdentifier()
+					if err := p.expect(":"); err != nil {
+						return err
+					}
+				}
+				if err := p.parseModuleBody("}"); err != nil {
+					return err
+				}
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+			default:
+				return p.errorf("unexpected %q in module", p.excerpt())
+			}
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if _, _, err := p.readFunctionType(); err != nil {
+			return err
+		}
+
+	default:
+		// Implicit module, with only the functions.
+		if err := p.parseModuleBody(""); err != nil {
+			return err
+		}
+	}
+	p.skipAliases()
+	if !p.atEnd() {
+		return p.errorf("unexpected %q after the module", p.excerpt())
+	}
+	return nil
+}
+
+// skipAliases skips the definitions of attribute and type aliases (e.g.: `#loc1 = loc("x")`), which are
+// only used for locations, that are ignored.
+func (p *parser) skipAliases() {
+	for p.peek("#") || p.peek("!") {
+		p.pos++
+		p.readIdentifier()
+		if !p.consume("=") {
+			return
+		}
+		// Skip the rest of the definition, until the end of the line outside brackets.
+		depth := 0
+		for p.pos < len(p.text) && (depth > 0 || p.text[p.pos] != '\n') {
+			switch p.text[p.pos] {
+			case '"':
+				for p.pos++; p.pos < len(p.text) && p.text[p.pos] != '"'; p.pos++ {
+					if p.text[p.pos] == '\\' {
+						p.pos++
+					}
+				}
+			case '(', '[', '{', '<':
+				depth++
+			case ')', ']', '}', '>':
+				depth--
+			}
+			p.pos++
+		}
+	}
+}
+
+// parseModuleAttributes parses the module attributes, after the opening "{", and sets the number of replicas and
+// partitions. Other module attributes are ignored.
+func (p *parser) parseModuleAttributes() error {
+	attributes, err := p.readDictionary()
+	if err != nil {
+		return err
+	}
+	for key, value := range attributes {
+		var target *int
+		switch key {
+		case "sym_name":
+			if name, ok := value.(string); ok {
+				p.builder.name = name
+			}
+			continue
+		case "stablehlo.num_replicas", "mhlo.num_replicas":
+			target = &p.builder.numReplicas
+		case "stablehlo.num_partitions", "mhlo.num_partitions":
+			target = &p.builder.numPartitions
+		default:
+			continue
+		}
+		n, ok := attributeToInt(value)
+		if !ok {
+			return p.errorf("invalid value for module attribute %q: %v", key, value)
+		}
+		*target = n
+	}
+	return nil
+}
+
+// attributeToInt converts an integer attribute value (typed or not) to an int.
+func attributeToInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeFor[int64]()).Int()), true
+	case literalStr:
+		n, err := strconv.Atoi(string(v))
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// parseModuleBody parses the meshes and functions of the module, until the given closing text.
+// If closing is empty, it parses until the end of the text.
+func (p *parser) parseModuleBody(closing string) error {
+	for {
+		p.skipAliases()
+		if closing == "" && p.atEnd() {
+			return nil
+		}
+		if closing != "" && p.consume(closing) {
+			return nil
+		}
+		var err error
+		switch {
+		case p.consumeKeyword("sdy.mesh"):
+			err = p.parseMesh()
+		case p.consumeKeyword("func.func"):
+			err = p.parseFunction()
+		case p.consume(`"func.func"`):
+			err = p.parseGenericFunction()
+		case p.atEnd():
+			return p.errorf("unexpected end of the text, expected %q", closing)
+		default:
+			return p.errorf("expected a function or a mesh in the module, got %q", p.excerpt())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseMesh parses a Shardy mesh definition, after the "sdy.mesh" keyword:
+// `@mesh = <["x"=2, "y"=4], device_ids=[...]>`.
+func (p *parser) parseMesh() error {
+	name, err := p.readSymbol()
+	if err != nil {
+		return err
+	}
+	if err = p.expect("="); err != nil {
+		return err
+	}
+	if err = p.expect("<"); err != nil {
+		return err
+	}
+	if err = p.expect("["); err != nil {
+		return err
+	}
+	var axesNames []string
+	var axesSizes []int
+	for !p.consume("]") {
+		if len(axesNames) > 0 {
+			if err = p.expect(","); err != nil {
+				return err
+			}
+		}
+		axisName, err := p.readString()
+		if err != nil {
+			return err
+		}
+		if err = p.expect("="); err != nil {
+			return err
+		}
+		axisSize, err := p.readInt()
+		if err != nil {
+			return err
+		}
+		axesNames = append(axesNames, axisName)
+		axesSizes = append(axesSizes, axisSize)
+	}
+	var deviceIDs []int
+	if p.consume(",") {
+		if err = p.expect("device_ids"); err != nil {
+			return err
+		}
+		if err = p.expect("="); err != nil {
+			return err
+		}
+		if err = p.expect("["); err != nil {
+			return err
+		}
+		for !p.consume("]") {
+			if len(deviceIDs) > 0 {
+				if err = p.expect(","); err != nil {
+					return err
+				}
+			}
+			id, err := p.readInt()
+			if err != nil {
+				return err
+			}
+			deviceIDs = append(deviceIDs, id)
+		}
+	}
+	if err = p.expect(">"); err != nil {
+		return err
+	}
+	mesh, err := shardy.NewDeviceMesh(name, axesSizes, axesNames)
+	if err != nil {
+		return p.errorf("invalid mesh %q: %v", name, err)
+	}
+	if len(deviceIDs) > 0 {
+		if err = mesh.SetLogicalDeviceAssignment(deviceIDs...); err != nil {
+			return p.errorf("invalid device_ids for mesh %q: %v", name, err)
+		}
+	}
+	p.builder.meshes = append(p.builder.meshes, mesh)
+	return nil
+}
+
+// newFunction creates a top-level function in the builder.
+func (p *parser) newFunction(name string) (*Function, error) {
+	for _, fn := range p.builder.functions {
+		if fn.Parent == nil && fn.Name == name {
+			return nil, p.errorf("function %q defined more than once", name)
+		}
+	}
+	fn := p.builder.NewFunction(name)
+	p.scopes[fn] = make(map[string]*Value)
+	return fn, nil
+}
+
+// addInput adds an input value to the function, with the name used in the text.
+func (p *parser) addInput(fn *Function, name string, shape shapes.Shape, attributes map[string]any) error {
+	if _, found := p.scopes[fn][name]; found {
+		return p.errorf("value %%%s defined more than once", name)
+	}
+	value := &Value{
+		fn:         fn,
+		name:       name,
+		shape:      shape,
+		Attributes: attributes,
+	}
+	fn.Inputs = append(fn.Inputs, value)
+	p.scopes[fn][name] = value
+	return nil
+}
+
+// parseFunction parses a function in the custom format, after the "func.func" keyword.
+func (p *parser) parseFunction() error {
+	indentation := p.lineIndentation(strings.LastIndex(p.text[:p.pos], "func.func"))
+	for _, visibility := range []string{"public", "private", "nested"} {
+		if p.consumeKeyword(visibility) {
+			break
+		}
+	}
+	name, err := p.readSymbol()
+	if err != nil {
+		return err
+	}
+	fn, err := p.newFunction(name)
+	if err != nil {
+		return err
+	}
+
+	// Inputs.
+	if err = p.expect("("); err != nil {
+		return err
+	}
+	for !p.consume(")") {
+		if len(fn.Inputs) > 0 {
+			if err = p.expect(","); err != nil {
+				return err
+			}
+		}
+		inputName, err := p.readValueName()
+		if err != nil {
+			return err
+		}
+		if err = p.expect(":"); err != nil {
+			return err
+		}
+		shape, err := p.readType()
+		if err != nil {
+			return err
+		}
+		var attributes map[string]any
+		if p.consume("{") {
+			if attributes, err = p.readDictionary(); err != nil {
+				return err
+			}
+			removeAttributeIndentation(attributes, indentation)
+		}
+		if err = p.addInput(fn, inputName, shape, attributes); err != nil {
+			return err
+		}
+	}
+
+	// Outputs.
+	var outputShapes []shapes.Shape
+	var outputsAttributes []map[string]any
+	if p.consume("->") {
+		if p.consume("(") {
+			for !p.consume(")") {
+				if len(outputShapes) > 0 {
+					if err = p.expect(","); err != nil {
+						return err
+					}
+				}
+				shape, err := p.readType()
+				if err != nil {
+					return err
+				}
+				var attributes map[string]any
+				if p.consume("{") {
+					if attributes, err = p.readDictionary(); err != nil {
+						return err
+					}
+					removeAttributeIndentation(attributes, indentation)
+				}
+				outputShapes = append(outputShapes, shape)
+				outputsAttributes = append(outputsAttributes, attributes)
+			}
+		} else {
+			shape, err := p.readType()
+			if err != nil {
+				return err
+			}
+			outputShapes = []shapes.Shape{shape}
+			outputsAttributes = []map[string]any{nil}
+		}
+	}
+
+	// Function attributes are ignored.
+	if p.consumeKeyword("attributes") {
+		if err = p.expect("{"); err != nil {
+			return err
+		}
+		if _, err = p.readDictionary(); err != nil {
+			return err
+		}
+	}
+
+	// Body.
+	if err = p.expect("{"); err != nil {
+		return err
+	}
+	return p.parseFunctionBody(fn, "}", outputShapes, outputsAttributes)
+}
+
+// parseGenericFunction parses a function in the generic format, after the `"func.func"` op name:
+// `() <{function_type = ..., sym_name = "main"}> ({ ^bb0(%arg0: ...): ... }) : () -> ()`.
+func (p *parser) parseGenericFunction() error {
+	if err := p.expect("()"); err != nil {
+		return err
+	}
+	attributes := make(map[string]any)
+	for p.consume("<{") || p.consume("{") {
+		more, err := p.readDictionary()
+		if err != nil {
+			return err
+		}
+		p.consume(">")
+		for key, value := range more {
+			attributes[key] = value
+		}
+	}
+	name, ok := attributes["sym_name"].(string)
+	if !ok {
+		return p.errorf("func.func without a sym_name attribute")
+	}
+	functionType, ok := attributes["function_type"].(literalStr)
+	if !ok {
+		return p.errorf("func.func %q without a function_type attribute", name)
+	}
+	sub := &parser{text: string(functionType)}
+	inputShapes, outputShapes, err := sub.readFunctionType()
+	if err != nil {
+		return p.errorf("invalid function_type for func.func %q: %v", name, err)
+	}
+	inputsAttributes, err := parseArrayOfDictionaries(attributes["arg_attrs"], len(inputShapes))
+	if err != nil {
+		return p.errorf("invalid arg_attrs for func.func %q: %v", name, err)
+	}
+	outputsAttributes, err := parseArrayOfDictionaries(attributes["res_attrs"], len(outputShapes))
+	if err != nil {
+		return p.errorf("invalid res_attrs for func.func %q: %v", name, err)
+	}
+	fn, err := p.newFunction(name)
+	if err != nil {
+		return err
+	}
+
+	if err = p.expect("({"); err != nil {
+		return err
+	}
+	var inputNames []string
+	if p.consume("^") {
+		p.readIdentifier()
+		if p.consume("(") {
+			for !p.consume(")") {
+				if len(inputNames) > 0 {
+					if err = p.expect(","); err != nil {
+						return err
+					}
+				}
+				inputName, err := p.readValueName()
+				if err != nil {
+					return err
+				}
+				if err = p.expect(":"); err != nil {
+					return err
+				}
+				if _, err = p.readType(); err != nil {
+					return err
+				}
+				inputNames = append(inputNames, inputName)
+			}
+		}
+		if err = p.expect(":"); err != nil {
+			return err
+		}
+	}
+	if len(inputNames) != len(inputShapes) {
+		return p.errorf("func.func %q has %d arguments, but its function_type has %d inputs",
+			name, len(inputNames), len(inputShapes))
+	}
+	for i, inputName := range inputNames {
+		if err = p.addInput(fn, inputName, inputShapes[i], inputsAttributes[i]); err != nil {
+			return err
+		}
+	}
+	if err = p.parseFunctionBody(fn, "}", outputShapes, outputsAttributes); err != nil {
+		return err
+	}
+	if err = p.expect(")"); err != nil {
+		return err
+	}
+	if err = p.expect(":"); err != nil {
+		return err
+	}
+	_, _, err = p.readFunctionType()
+	return err
+}
+
+// parseArrayOfDictionaries parses an array of dictionaries attribute, like the "arg_attrs" of a generic func.func.
+// It returns a slice of nil maps if the attribute is not set.
+func parseArrayOfDictionaries(attribute any, length int) ([]map[string]any, error) {
+	dictionaries := make([]map[string]any, length)
+	if attribute == nil {
+		return dictionaries, nil
+	}
+	raw, ok := attribute.(literalStr)
+	if !ok {
+		return nil, errors.Errorf("expected an array of dictionaries, got %v", attribute)
+	}
+	sub := &parser{text: string(raw)}
+	if err := sub.expect("["); err != nil {
+		return nil, err
+	}
+	for i := 0; !sub.consume("]"); i++ {
+		if i > 0 {
+			if err := sub.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if err := sub.expect("{"); err != nil {
+			return nil, err
+		}
+		dictionary, err := sub.readDictionary()
+		if err != nil {
+			return nil, err
+		}
+		if i >= length {
+			return nil, errors.Errorf("got more than %d dictionaries", length)
+		}
+		if len(dictionary) > 0 {
+			dictionaries[i] = dictionary
+		}
+	}
+	return dictionaries, nil
+}
+
+// parseFunctionBody parses the statements of a function (or closure), until the closing text.
+//
+// For top-level functions the outputShapes and outputsAttributes are the ones declared in the function
+// signature, and they are checked against the returned values.
+func (p *parser) parseFunctionBody(fn *Function, closing string, outputShapes []shapes.Shape,
+	outputsAttributes []map[string]any) error {
+	for !p.consume(closing) {
+		if p.atEnd() {
+			return p.errorf("unexpected end of the text in function %q, expected %q", fn.Name, closing)
+		}
+		if fn.Returned {
+			return p.errorf("statements after the return in function %q", fn.Name)
+		}
+		if err := p.parseStatement(fn, outputsAttributes); err != nil {
+			return err
+		}
+	}
+	if !fn.Returned {
+		return p.errorf("function %q has no return statement", fn.Name)
+	}
+	if fn.Parent == nil {
+		if len(fn.Outputs) != len(outputShapes) {
+			return p.errorf("function %q returns %d values, but its signature has %d outputs",
+				fn.Name, len(fn.Outputs), len(outputShapes))
+		}
+		for i, output := range fn.Outputs {
+			if !output.shape.Equal(outputShapes[i]) {
+				return p.errorf("function %q returns %s for output #%d, but its signature has %s",
+					fn.Name, output.shape, i, outputShapes[i])
+			}
+		}
+	}
+	return nil
+}
+
+// resultDef is the definition of the results of a statement, as in `%0 = ...` or `%0:2 = ...`.
+type resultDef struct {
+	name  string
+	count int
+}
+
+// lookupValue returns the value referred by name (e.g.: "0" or "0#1" for the second result of "%0:2") in the
+// scope of the function. Values of parent functions are referred with Function.UseParentValue.
+func (p *parser) lookupValue(fn *Function, name string) (*Value, error) {
+	for scopeFn := fn; scopeFn != nil; scopeFn = scopeFn.Parent {
+		value, found := p.scopes[scopeFn][name]
+		if !found && strings.HasSuffix(name, "#0") {
+			value, found = p.scopes[scopeFn][strings.TrimSuffix(name, "#0")]
+		}
+		if !found {
+			continue
+		}
+		if scopeFn == fn {
+			return value, nil
+		}
+		return fn.UseParentValue(value)
+	}
+	return nil, p.errorf("value %%%s not defined in the scope of function %q", name, fn.Name)
+}
+
+// parseStatement parses one statement, in the generic or in the custom format, and adds it to the function.
+func (p *parser) parseStatement(fn *Function, outputsAttributes []map[string]any) error {
+	p.skipSpaces()
+	indentation := p.lineIndentation(p.pos)
+
+	// Results.
+	var results []resultDef
+	numResults := 0
+	if p.peek("%") {
+		for {
+			name, err := p.readValueName()
+			if err != nil {
+				return err
+			}
+			count := 1
+			if p.consume(":") {
+				if count, err = p.readInt(); err != nil {
+					return err
+				}
+			}
+			results = append(results, resultDef{name: name, count: count})
+			numResults += count
+			if !p.consume(",") {
+				break
+			}
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+	}
+
+	// Operation.
+	var op *parsedOp
+	var err error
+	if p.peek(`"`) {
+		op, err = p.parseGenericOp(fn, indentation)
+	} else {
+		op, err = p.parseCustomOp(fn, numResults)
+	}
+	if err != nil {
+		return err
+	}
+
+	if op.opType == optypes.FuncReturn {
+		if numResults > 0 || len(op.outputShapes) > 0 {
+			return p.errorf("return statement can't have results")
+		}
+		if fn.Parent != nil {
+			outputsAttributes = nil
+		} else if len(outputsAttributes) != len(op.inputs) {
+			return p.errorf("function %q returns %d values, but its signature has %d outputs",
+				fn.Name, len(op.inputs), len(outputsAttributes))
+		}
+		hasAttributes := false
+		for _, outputAttributes := range outputsAttributes {
+			hasAttributes = hasAttributes || len(outputAttributes) > 0
+		}
+		if !hasAttributes {
+			outputsAttributes = nil
+		}
+		if err = fn.ReturnWithAttributes(op.inputs, outputsAttributes); err != nil {
+			return p.errorf("%v", err)
+		}
+		return nil
+	}
+	return p.addStatement(fn, op, results, numResults)
+}
+
+// parsedOp is an operation parsed in the generic or in the custom format, before it is added to its function.
+type parsedOp struct {
+	name          string
+	opType        optypes.OpType
+	inputs        []*Value
+	attributes    map[string]any
+	closures      []*Function
+	closuresNames []string
+	outputShapes  []shapes.Shape
+}
+
+// addStatement creates the statement of the parsed operation, with its outputs named after the results, and adds
+// it to the function.
+func (p *parser) addStatement(fn *Function, op *parsedOp, results []resultDef, numResults int) error {
+	if numResults > 0 && numResults != len(op.outputShapes) {
+		return p.errorf("operation %q defines %d results, but its signature has %d outputs",
+			op.name, numResults, len(op.outputShapes))
+	}
+	stmt := &Statement{
+		Builder:                 fn.Builder,
+		Function:                fn,
+		OpType:                  op.opType,
+		Inputs:                  op.inputs,
+		Attributes:              op.attributes,
+		FunctionParameters:      op.closures,
+		FunctionParametersNames: op.closuresNames,
+		Outputs:                 make([]*Value, 0, len(op.outputShapes)),
+	}
+	scope := p.scopes[fn]
+	addOutput := func(name, key string) error {
+		if _, found := scope[key]; found {
+			return p.errorf("value %%%s defined more than once in function %q", key, fn.Name)
+		}
+		value := &Value{
+			fn:          fn,
+			name:        name,
+			shape:       op.outputShapes[len(stmt.Outputs)],
+			stmt:        stmt,
+			outputIndex: len(stmt.Outputs),
+		}
+		stmt.Outputs = append(stmt.Outputs, value)
+		fn.values = append(fn.values, value)
+		scope[key] = value
+		return nil
+	}
+	if numResults == 0 {
+		// Results not used: they still need a unique name.
+		for range op.outputShapes {
+			name := p.unnamed()
+			if err := addOutput(name, name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, result := range results {
+		if result.count == 1 {
+			if err := addOutput(result.name, result.name); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := range result.count {
+			if err := addOutput(fmt.Sprintf("%s_%d", result.name, i), fmt.Sprintf("%s#%d", result.name, i)); err != nil {
+				return err
+			}
+		}
+	}
+	fn.Statements = append(fn.Statements, stmt)
+	return nil
+}
+
+// unnamed returns a new unique name, for values without a name in the text.
+func (p *parser) unnamed() string {
+	name := fmt.Sprintf("unnamed%d", p.numUnnamed)
+	p.numUnnamed++
+	return name
+}
+
+// readOperand reads the name of an operand (e.g.: "%0" or "%0#1" for the second result of "%0:2") and returns
+// its value.
+func (p *parser) readOperand(fn *Function) (*Value, error) {
+	name, err := p.readValueName()
+	if err != nil {
+		return nil, err
+	}
+	if p.consume("#") {
+		index, err := p.readInt()
+		if err != nil {
+			return nil, err
+		}
+		name = fmt.Sprintf("%s#%d", name, index)
+	}
+	return p.lookupValue(fn, name)
+}
+
+// parseGenericOp parses an operation in MLIR's generic format (`"op.name"(operands) ... : type`), after its
+// results.
+func (p *parser) parseGenericOp(fn *Function, indentation string) (*parsedOp, error) {
+	opName, err := p.readString()
+	if err != nil {
+		return nil, err
+	}
+	op := &parsedOp{name: opName}
+	var found bool
+	op.opType, found = optypes.FromStableHLO(opName)
+	if !found {
+		return nil, p.errorf("operation %q not supported", opName)
+	}
+
+	// Operands.
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.consume(")") {
+		if len(op.inputs) > 0 {
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		value, err := p.readOperand(fn)
+		if err != nil {
+			return nil, err
+		}
+		op.inputs = append(op.inputs, value)
+	}
+
+	// Properties, regions and attributes, in any order.
+	for !p.consume(":") {
+		switch {
+		case p.consume("<{"), p.consume("{"):
+			if err = p.readAttributes(op); err != nil {
+				return nil, err
+			}
+			p.consume(">")
+		case p.consume("("):
+			for !p.consume(")") {
+				if len(op.closures) > 0 {
+					if err = p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				closure, closureName, err := p.parseRegion(fn)
+				if err != nil {
+					return nil, err
+				}
+				op.closures = append(op.closures, closure)
+				op.closuresNames = append(op.closuresNames, closureName)
+			}
+		default:
+			return nil, p.errorf("unexpected %q in operation %q", p.excerpt(), opName)
+		}
+	}
+
+	removeAttributeIndentation(op.attributes, indentation)
+
+	// Signature.
+	var inputShapes []shapes.Shape
+	inputShapes, op.outputShapes, err = p.readFunctionType()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.checkInputShapes(op, inputShapes); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// readAttributes reads a dictionary of attributes, after the opening "{", and adds them to the operation.
+func (p *parser) readAttributes(op *parsedOp) error {
+	more, err := p.readDictionary()
+	if err != nil {
+		return err
+	}
+	if op.attributes == nil {
+		op.attributes = make(map[string]any, len(more))
+	}
+	for key, value := range more {
+		op.attributes[key] = value
+	}
+	return nil
+}
+
+// checkInputShapes checks that the shapes of the operands match the ones of the operation signature.
+func (p *parser) checkInputShapes(op *parsedOp, inputShapes []shapes.Shape) error {
+	if len(inputShapes) != len(op.inputs) {
+		return p.errorf("operation %q has %d operands, but its signature has %d", op.name, len(op.inputs), len(inputShapes))
+	}
+	for i, input := range op.inputs {
+		if !input.shape.Equal(inputShapes[i]) {
+			return p.errorf("operand #%d (%s) of %q has shape %s, but its signature has %s",
+				i, input, op.name, input.shape, inputShapes[i])
+		}
+	}
+	return nil
+}
+
+// parseRegion parses a region of an operation (e.g.: the reduction function of a Reduce), after the opening "{",
+// and returns it as a closure of fn, along with the name of its block.
+func (p *parser) parseRegion(fn *Function) (closure *Function, name string, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+	closure = fn.Closure()
+	p.scopes[closure] = make(map[string]*Value)
+	name = "bb0"
+	if p.consume("^") {
+		name = p.readIdentifier()
+		if p.consume("(") {
+			for !p.consume(")") {
+				if len(closure.Inputs) > 0 {
+					if err = p.expect(","); err != nil {
+						return
+					}
+				}
+				var inputName string
+				if inputName, err = p.readValueName(); err != nil {
+					return
+				}
+				if err = p.expect(":"); err != nil {
+					return
+				}
+				var shape shapes.Shape
+				if shape, err = p.readType(); err != nil {
+					return
+				}
+				if err = p.addInput(closure, inputName, shape, nil); err != nil {
+					return
+				}
+			}
+		}
+		if err = p.expect(":"); err != nil {
+			return
+		}
+	}
+	err = p.parseFunctionBody(closure, "}", nil, nil)
+	return
+}
+
+var channelHandleRegexp = regexp.MustCompile(`handle = (\d+)`)
+
+// finalize sets the counters of the builder and functions, so new values, inputs, closures and channels
+// created after parsing don't clash with the ones parsed.
+func (p *parser) finalize() {
+	for _, fn := range p.builder.functions {
+		rootFn := fn.findRootFn()
+		rootFn.nextArgID += len(fn.Inputs)
+		for _, input := range fn.Inputs {
+			if id, err := strconv.Atoi(strings.TrimPrefix(input.name, "arg")); err == nil && strings.HasPrefix(input.name, "arg") {
+				rootFn.nextArgID = max(rootFn.nextArgID, id+1)
+			}
+		}
+		for _, value := range fn.values {
+			if id, err := strconv.Atoi(value.name); err == nil {
+				rootFn.nextTmpID = max(rootFn.nextTmpID, id+1)
+			}
+		}
+		for _, stmt := range fn.Statements {
+			if handle, ok := stmt.Attributes["channel_handle"].(literalStr); ok {
+				if match := channelHandleRegexp.FindStringSubmatch(string(handle)); match != nil {
+					id, _ := strconv.Atoi(match[1])
+					p.builder.nextChannelID = max(p.builder.nextChannelID, id+1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,700 @@
+package stablehlo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+// customOpAliases maps the names of the operations of MLIR's func dialect, that are printed without the dialect
+// prefix in the custom format, to their full names.
+var customOpAliases = map[string]string{
+	"return": "func.return",
+	"call":   "func.call",
+}
+
+// parseCustomOp parses an operation in MLIR's custom (pretty) format, like `stablehlo.add %x, %y : tensor<f32>`,
+// after its results. numResults is the number of results defined by the statement, or 0 if they are not named.
+//
+// Besides the common form (operands, keyword attributes like `dims = [0, 1]`, an optional dictionary of
+// attributes and the types), it handles the specific syntax of the operations exported in the custom format
+// by JAX: constants, comparisons, slices, calls, and the regions of reduce and while.
+// Operations without a custom format (e.g.: "stablehlo.if") are printed in the generic format, with the
+// operations of their regions in the custom format.
+func (p *parser) parseCustomOp(fn *Function, numResults int) (*parsedOp, error) {
+	opName := p.readIdentifier()
+	if opName == "" {
+		return nil, p.errorf("expected an operation, got %q", p.excerpt())
+	}
+	if alias, found := customOpAliases[opName]; found {
+		opName = alias
+	}
+	op := &parsedOp{name: opName}
+	var found bool
+	op.opType, found = optypes.FromStableHLO(opName)
+	if !found {
+		return nil, p.errorf("operation %q not supported", opName)
+	}
+	var err error
+	switch op.opType {
+	case optypes.Constant:
+		err = p.parseCustomConstant(op)
+	case optypes.Reduce:
+		err = p.parseCustomReduce(fn, op)
+	case optypes.While:
+		err = p.parseCustomWhile(fn, op)
+	default:
+		err = p.parseCustomCommonOp(fn, op, numResults)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// setAttribute sets an attribute of the operation.
+func (op *parsedOp) setAttribute(key string, value any) {
+	if op.attributes == nil {
+		op.attributes = make(map[string]any)
+	}
+	op.attributes[key] = value
+}
+
+// parseCustomConstant parses the value and type of a constant, as in `stablehlo.constant dense<1.0> : tensor<f32>`.
+func (p *parser) parseCustomConstant(op *parsedOp) error {
+	if p.consume("{") {
+		if err := p.readAttributes(op); err != nil {
+			return err
+		}
+	}
+	p.skipSpaces()
+	start := p.pos
+	if p.readIdentifier() == "" {
+		return p.errorf("expected the value of the constant, got %q", p.excerpt())
+	}
+	if p.peek("<") {
+		p.readBalanced(stopAlways)
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	shape, err := p.readType()
+	if err != nil {
+		return err
+	}
+	value, err := parseAttributeValue(p.text[start:p.pos])
+	if err != nil {
+		return p.errorf("invalid value for constant: %v", err)
+	}
+	op.setAttribute("value", value)
+	op.outputShapes = []shapes.Shape{shape}
+	return nil
+}
+
+// parseCustomCommonOp parses the operations with the common custom format: operands, keyword attributes
+// (`name = value`), an optional dictionary of attributes and the types.
+//
+// The types are either the signature (`(operands types) -> results types`), or a list of types, in which case
+// the results types are the types listed if there is one for each result, or otherwise the last one -- e.g.:
+// `stablehlo.add %x, %y : tensor<f32>` or `stablehlo.select %pred, %x, %y : tensor<i1>, tensor<f32>`.
+func (p *parser) parseCustomCommonOp(fn *Function, op *parsedOp, numResults int) error {
+	var err error
+	switch op.opType {
+	case optypes.Compare:
+		direction := p.readIdentifier()
+		if direction == "" {
+			return p.errorf("expected the comparison direction, got %q", p.excerpt())
+		}
+		op.setAttribute("comparison_direction", literalStrF("#stablehlo<comparison_direction %s>", direction))
+		if err = p.expect(","); err != nil {
+			return err
+		}
+	case optypes.Composite:
+		name, err := p.readString()
+		if err != nil {
+			return err
+		}
+		op.setAttribute("name", name)
+	case optypes.CustomCall, optypes.Call:
+		symbol, err := p.readSymbol()
+		if err != nil {
+			return err
+		}
+		if op.opType == optypes.CustomCall {
+			op.setAttribute("call_target_name", symbol)
+		} else {
+			op.setAttribute("callee", symbolRef{name: symbol})
+		}
+	}
+
+	// Operands and keyword attributes.
+	keywords := make(map[string]string)
+	var keywordsOrder []string
+	if p.consume("(") {
+		// Parenthesized operands, used by calls and convolutions.
+		if op.inputs, err = p.readOperands(fn, ")"); err != nil {
+			return err
+		}
+	}
+	for p.peek("%") || p.peekKeyword() {
+		if p.peek("%") {
+			value, err := p.readOperand(fn)
+			if err != nil {
+				return err
+			}
+			op.inputs = append(op.inputs, value)
+			if err = p.parseOperandSuffix(op); err != nil {
+				return err
+			}
+		} else {
+			keyword := p.readIdentifier()
+			if !p.consume("=") {
+				if op.opType != optypes.Compare {
+					return p.errorf("expected \"=\" after %q in operation %q, got %q", keyword, op.name, p.excerpt())
+				}
+				op.setAttribute("compare_type", literalStrF("#stablehlo<comparison_type %s>", keyword))
+			} else {
+				raw := p.readBalanced(func(c byte) bool { return c == ',' || c == ':' || c == '{' || c == '\n' })
+				if raw == "" {
+					return p.errorf("expected a value for %q in operation %q, got %q", keyword, op.name, p.excerpt())
+				}
+				keywords[keyword] = raw
+				keywordsOrder = append(keywordsOrder, keyword)
+			}
+		}
+		if !p.consume(",") {
+			break
+		}
+	}
+	if err = p.setKeywordAttributes(op, keywords, keywordsOrder); err != nil {
+		return err
+	}
+	if p.consume("{") {
+		if err = p.readAttributes(op); err != nil {
+			return err
+		}
+	}
+
+	// Types.
+	if !p.consume(":") {
+		if op.opType == optypes.FuncReturn && len(op.inputs) == 0 {
+			return nil
+		}
+		return p.errorf("expected \":\" and the types of operation %q, got %q", op.name, p.excerpt())
+	}
+	if p.peek("(") {
+		var inputShapes []shapes.Shape
+		if inputShapes, op.outputShapes, err = p.readFunctionType(); err != nil {
+			return err
+		}
+		return p.checkInputShapes(op, inputShapes)
+	}
+	types, err := p.readTypes()
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.consume("->"):
+		// Signature without parenthesis, used by the CHLO operations.
+		if op.outputShapes, err = p.readTypeList(true); err != nil {
+			return err
+		}
+		return p.checkInputShapes(op, types)
+	case op.opType == optypes.FuncReturn:
+		return p.checkInputShapes(op, types)
+	case len(types) == numResults:
+		op.outputShapes = types
+	default:
+		op.outputShapes = types[len(types)-1:]
+	}
+	return nil
+}
+
+// peekKeyword returns whether the text (after spaces) starts with an identifier, without consuming it.
+func (p *parser) peekKeyword() bool {
+	p.skipSpaces()
+	return p.pos < len(p.text) && (p.text[p.pos] == '_' ||
+		(p.text[p.pos] >= 'a' && p.text[p.pos] <= 'z') || (p.text[p.pos] >= 'A' && p.text[p.pos] <= 'Z'))
+}
+
+// readOperands reads a comma-separated list of operands, until the closing text.
+func (p *parser) readOperands(fn *Function, closing string) ([]*Value, error) {
+	var values []*Value
+	for !p.consume(closing) {
+		if len(values) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		value, err := p.readOperand(fn)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// readTypes reads a comma-separated list of types, without parenthesis.
+func (p *parser) readTypes() ([]shapes.Shape, error) {
+	var types []shapes.Shape
+	for {
+		shape, err := p.readType()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, shape)
+		if !p.consume(",") {
+			return types, nil
+		}
+	}
+}
+
+// parseOperandSuffix parses the attributes written right after the operand of some operations: the index of
+// `stablehlo.get_tuple_element %t[1]` and the ranges of `stablehlo.slice %x [0:2, 1:4:2]`.
+func (p *parser) parseOperandSuffix(op *parsedOp) error {
+	switch {
+	case op.opType == optypes.GetTupleElement && p.consume("["):
+		index, err := p.readInt()
+		if err != nil {
+			return err
+		}
+		op.setAttribute("index", int32(index))
+		return p.expect("]")
+
+	case op.opType == optypes.Slice && p.consume("["):
+		var starts, limits, strides []int
+		for !p.consume("]") {
+			if len(starts) > 0 {
+				if err := p.expect(","); err != nil {
+					return err
+				}
+			}
+			values := []int{0, 0, 1}
+			for i := range values {
+				if i > 0 && !p.consume(":") {
+					if i == 1 {
+						return p.errorf("expected \":\" in the range of a slice, got %q", p.excerpt())
+					}
+					break
+				}
+				value, err := p.readInt()
+				if err != nil {
+					return err
+				}
+				values[i] = value
+			}
+			starts = append(starts, values[0])
+			limits = append(limits, values[1])
+			strides = append(strides, values[2])
+		}
+		op.setAttribute("start_indices", intSliceToArrayI64StableHLO(starts))
+		op.setAttribute("limit_indices", intSliceToArrayI64StableHLO(limits))
+		op.setAttribute("strides", intSliceToArrayI64StableHLO(strides))
+	}
+	return nil
+}
+
+// setKeywordAttributes converts the keyword attributes of the custom format (e.g.: `dims = [1, 0]` of a
+// transpose) to the attributes of the generic format (e.g.: `permutation = array<i64: 1, 0>`).
+func (p *parser) setKeywordAttributes(op *parsedOp, keywords map[string]string, order []string) error {
+	var err error
+	ints := func(keyword string) []int {
+		var values []int
+		if err == nil {
+			values, err = parseIntList(keywords[keyword])
+		}
+		return values
+	}
+	for _, keyword := range order {
+		raw := keywords[keyword]
+		switch {
+		case keyword == "dims" && op.opType == optypes.BroadcastInDim:
+			op.setAttribute("broadcast_dimensions", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "dims" && op.opType == optypes.Transpose:
+			op.setAttribute("permutation", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "dims" && op.opType == optypes.Reverse:
+			op.setAttribute("dimensions", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "dim" && (op.opType == optypes.Concatenate || op.opType == optypes.GetDimensionSize ||
+			op.opType == optypes.Iota):
+			var dim int
+			dim, err = strconv.Atoi(raw)
+			key := "dimension"
+			if op.opType == optypes.Iota {
+				key = "iota_dimension"
+			}
+			op.setAttribute(key, int64(dim))
+		case keyword == "sizes" && op.opType == optypes.DynamicSlice:
+			op.setAttribute("slice_sizes", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "low" && op.opType == optypes.Pad:
+			op.setAttribute("edge_padding_low", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "high" && op.opType == optypes.Pad:
+			op.setAttribute("edge_padding_high", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "interior" && op.opType == optypes.Pad:
+			op.setAttribute("interior_padding", intSliceToArrayI64StableHLO(ints(keyword)))
+		case (keyword == "batching_dims" || keyword == "contracting_dims") && op.opType == optypes.DotGeneral:
+			// Both are set together, below.
+		case keyword == "precision" && (op.opType == optypes.DotGeneral || op.opType == optypes.Convolution):
+			precisions := strings.Split(strings.Trim(raw, "[]"), ",")
+			for i, precision := range precisions {
+				precisions[i] = fmt.Sprintf("#stablehlo<precision %s>", strings.TrimSpace(precision))
+			}
+			op.setAttribute("precision_config", literalStr("["+strings.Join(precisions, ", ")+"]"))
+		case keyword == "algorithm" && op.opType == optypes.DotGeneral:
+			fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(raw, "<"), ">"), ",")
+			for i, field := range fields {
+				fields[i] = strings.TrimSpace(field)
+			}
+			op.setAttribute("algorithm", literalStrF("#stablehlo.dot_algorithm<\n\t%s>", strings.Join(fields, ",\n\t")))
+		case keyword == "algorithm" && op.opType == optypes.RNGBitGenerator:
+			op.setAttribute("rng_algorithm", literalStrF("#stablehlo<rng_algorithm %s>", raw))
+		case keyword == "format" && op.opType == optypes.ReducePrecision:
+			var exponentBits, mantissaBits int
+			if _, scanErr := fmt.Sscanf(raw, "e%dm%d", &exponentBits, &mantissaBits); scanErr != nil {
+				return p.errorf("invalid format %q for operation %q", raw, op.name)
+			}
+			op.setAttribute("exponent_bits", int32(exponentBits))
+			op.setAttribute("mantissa_bits", int32(mantissaBits))
+		case keyword == "type" && op.opType == optypes.Fft:
+			op.setAttribute("fft_type", literalStrF("#stablehlo<fft_type %s>", raw))
+		case keyword == "length" && op.opType == optypes.Fft:
+			op.setAttribute("fft_length", intSliceToArrayI64StableHLO(ints(keyword)))
+		case keyword == "lower" && op.opType == optypes.Cholesky:
+			op.setAttribute("lower", raw == "true")
+		case keyword == "dim_numbers" && op.opType == optypes.Convolution:
+			op.setAttribute("dimension_numbers", literalStrF("#stablehlo.conv<%s>", raw))
+		case keyword == "window" && op.opType == optypes.Convolution:
+			err = setConvolutionWindowAttributes(op, raw)
+		default:
+			return p.errorf("attribute %q of operation %q in the custom format not supported", keyword, op.name)
+		}
+		if err != nil {
+			return p.errorf("invalid value %q for attribute %q of operation %q: %v", raw, keyword, op.name, err)
+		}
+	}
+
+	if op.opType == optypes.DotGeneral {
+		var dims [4][]int
+		for i, keyword := range []string{"batching_dims", "contracting_dims"} {
+			raw, found := keywords[keyword]
+			if !found {
+				dims[2*i], dims[2*i+1] = []int{}, []int{}
+				continue
+			}
+			lhs, rhs, found := strings.Cut(raw, "x")
+			if !found {
+				return p.errorf("invalid value %q for attribute %q of operation %q", raw, keyword, op.name)
+			}
+			if dims[2*i], err = parseIntList(lhs); err == nil {
+				dims[2*i+1], err = parseIntList(rhs)
+			}
+			if err != nil {
+				return p.errorf("invalid value %q for attribute %q of operation %q: %v", raw, keyword, op.name, err)
+			}
+		}
+		op.setAttribute("dot_dimension_numbers", literalStrF(
+			"#stablehlo.dot<\n"+
+				"\tlhs_batching_dimensions = %s,\n"+
+				"\trhs_batching_dimensions = %s,\n"+
+				"\tlhs_contracting_dimensions = %s,\n"+
+				"\trhs_contracting_dimensions = %s\n>",
+			intSliceToStableHLO(dims[0]), intSliceToStableHLO(dims[1]),
+			intSliceToStableHLO(dims[2]), intSliceToStableHLO(dims[3])))
+	}
+	return nil
+}
+
+// parseIntList parses a list of integers, like "[1, 2]".
+func parseIntList(raw string) ([]int, error) {
+	sub := &parser{text: raw}
+	if err := sub.expect("["); err != nil {
+		return nil, err
+	}
+	values := []int{}
+	for !sub.consume("]") {
+		if len(values) > 0 {
+			if err := sub.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		value, err := sub.readInt()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if !sub.atEnd() {
+		return nil, sub.errorf("unexpected %q after the list", sub.excerpt())
+	}
+	return values, nil
+}
+
+// setConvolutionWindowAttributes sets the attributes of a convolution from its window in the custom format,
+// like `{stride = [2, 2], pad = [[0, 0], [1, 1]], lhs_dilate = [1, 1], rhs_dilate = [1, 1], reverse = [false, false]}`.
+func setConvolutionWindowAttributes(op *parsedOp, raw string) error {
+	sub := &parser{text: raw}
+	if err := sub.expect("{"); err != nil {
+		return err
+	}
+	window, err := sub.readDictionary()
+	if err != nil {
+		return err
+	}
+	for key, value := range window {
+		text := literalToStableHLO(value)
+		switch key {
+		case "stride", "lhs_dilate", "rhs_dilate":
+			values, err := parseIntList(text)
+			if err != nil {
+				return err
+			}
+			attribute := map[string]string{
+				"stride":     "window_strides",
+				"lhs_dilate": "lhs_dilation",
+				"rhs_dilate": "rhs_dilation",
+			}[key]
+			op.setAttribute(attribute, intSliceToArrayI64StableHLO(values))
+		case "pad":
+			flat, err := parseIntList(strings.NewReplacer("[[", "[", "]]", "]", "], [", ", ").Replace(text))
+			if err != nil {
+				return err
+			}
+			padding, err := newTensorLiteralFromFlatAndDimensions(flat, len(flat)/2, 2)
+			if err != nil {
+				return err
+			}
+			op.setAttribute("padding", padding)
+		case "reverse":
+			var reversal []bool
+			for _, element := range strings.Split(strings.Trim(text, "[]"), ",") {
+				element = strings.TrimSpace(element)
+				reversal = append(reversal, element == "true" || element == "1")
+			}
+			op.setAttribute("window_reversal", boolSliceToArrayI1StableHLO(reversal))
+		default:
+			return fmt.Errorf("unknown window attribute %q", key)
+		}
+	}
+	return nil
+}
+
+// parseCustomReduce parses a reduce in the custom format, either with the reduction operation
+// (`stablehlo.reduce(%x init: %zero) applies stablehlo.add across dimensions = [0] : ...`), or with the
+// reduction region after the types (`... : ... reducer(%a: tensor<f32>, %b: tensor<f32>) { ... }`).
+func (p *parser) parseCustomReduce(fn *Function, op *parsedOp) error {
+	var inputs, initialValues []*Value
+	for {
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		input, err := p.readOperand(fn)
+		if err != nil {
+			return err
+		}
+		if !p.consumeKeyword("init") {
+			return p.errorf("expected \"init:\" in operation %q, got %q", op.name, p.excerpt())
+		}
+		if err = p.expect(":"); err != nil {
+			return err
+		}
+		initialValue, err := p.readOperand(fn)
+		if err != nil {
+			return err
+		}
+		if err = p.expect(")"); err != nil {
+			return err
+		}
+		inputs = append(inputs, input)
+		initialValues = append(initialValues, initialValue)
+		if !p.consume(",") {
+			break
+		}
+	}
+	op.inputs = append(inputs, initialValues...)
+
+	var reductionOpName string
+	if p.consumeKeyword("applies") {
+		if reductionOpName = p.readIdentifier(); reductionOpName == "" {
+			return p.errorf("expected the reduction operation of %q, got %q", op.name, p.excerpt())
+		}
+	}
+	if !p.consumeKeyword("across") || !p.consumeKeyword("dimensions") {
+		return p.errorf("expected \"across dimensions\" in operation %q, got %q", op.name, p.excerpt())
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	dimensions, err := parseIntList(p.readBalanced(stopAlways))
+	if err != nil {
+		return p.errorf("invalid dimensions of operation %q: %v", op.name, err)
+	}
+	op.setAttribute("dimensions", intSliceToArrayI64StableHLO(dimensions))
+	if p.consume("{") {
+		if err = p.readAttributes(op); err != nil {
+			return err
+		}
+	}
+	if err = p.expect(":"); err != nil {
+		return err
+	}
+	var inputShapes []shapes.Shape
+	if inputShapes, op.outputShapes, err = p.readFunctionType(); err != nil {
+		return err
+	}
+	if err = p.checkInputShapes(op, inputShapes); err != nil {
+		return err
+	}
+
+	// Reduction function: its inputs are the accumulated values (one per input) followed by the values reduced.
+	var closure *Function
+	if reductionOpName != "" {
+		if len(inputs) != 1 {
+			return p.errorf("operation %q with %q requires one input, got %d", op.name, reductionOpName, len(inputs))
+		}
+		closure = fn.Closure()
+		p.scopes[closure] = make(map[string]*Value)
+		for range 2 {
+			if err = p.addInput(closure, p.unnamed(), initialValues[0].shape, nil); err != nil {
+				return err
+			}
+		}
+		reductionOp := &parsedOp{
+			name:         reductionOpName,
+			inputs:       closure.Inputs,
+			outputShapes: []shapes.Shape{initialValues[0].shape},
+		}
+		var found bool
+		if reductionOp.opType, found = optypes.FromStableHLO(reductionOpName); !found {
+			return p.errorf("operation %q not supported", reductionOpName)
+		}
+		if err = p.addStatement(closure, reductionOp, nil, 0); err != nil {
+			return err
+		}
+		if err = closure.Return(closure.Statements[0].Outputs...); err != nil {
+			return p.errorf("%v", err)
+		}
+	} else {
+		if !p.consumeKeyword("reducer") {
+			return p.errorf("expected \"applies\" or \"reducer\" in operation %q, got %q", op.name, p.excerpt())
+		}
+		names := make([]string, 2*len(inputs))
+		argsShapes := make([]shapes.Shape, 2*len(inputs))
+		for i := range inputs {
+			// Each pair holds the accumulated value and the value reduced of one input.
+			if err = p.expect("("); err != nil {
+				return err
+			}
+			for j := range 2 {
+				if j > 0 {
+					if err = p.expect(","); err != nil {
+						return err
+					}
+				}
+				argIdx := i + j*len(inputs)
+				if names[argIdx], err = p.readValueName(); err != nil {
+					return err
+				}
+				if err = p.expect(":"); err != nil {
+					return err
+				}
+				if argsShapes[argIdx], err = p.readType(); err != nil {
+					return err
+				}
+			}
+			if err = p.expect(")"); err != nil {
+				return err
+			}
+		}
+		if closure, err = p.parseCustomRegion(fn, names, argsShapes); err != nil {
+			return err
+		}
+	}
+	op.closures = []*Function{closure}
+	op.closuresNames = []string{"reductionFn"}
+	return nil
+}
+
+// parseCustomWhile parses a while in the custom format:
+//
+//	stablehlo.while(%iterArg = %x, %iterArg_0 = %y) : tensor<i32>, tensor<f32>
+//	cond {
+//	  ...
+//	} do {
+//	  ...
+//	}
+func (p *parser) parseCustomWhile(fn *Function, op *parsedOp) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	var names []string
+	for !p.consume(")") {
+		if len(names) > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		name, err := p.readValueName()
+		if err != nil {
+			return err
+		}
+		if err = p.expect("="); err != nil {
+			return err
+		}
+		value, err := p.readOperand(fn)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		op.inputs = append(op.inputs, value)
+	}
+	if p.consume("{") {
+		if err := p.readAttributes(op); err != nil {
+			return err
+		}
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	types, err := p.readTypes()
+	if err != nil {
+		return err
+	}
+	if err = p.checkInputShapes(op, types); err != nil {
+		return err
+	}
+	op.outputShapes = types
+	for _, regionName := range []string{"cond", "do"} {
+		if !p.consumeKeyword(regionName) {
+			return p.errorf("expected the %q region of operation %q, got %q", regionName, op.name, p.excerpt())
+		}
+		closure, err := p.parseCustomRegion(fn, names, types)
+		if err != nil {
+			return err
+		}
+		op.closures = append(op.closures, closure)
+	}
+	op.closuresNames = []string{"cond", "body"}
+	return nil
+}
+
+// parseCustomRegion parses a region whose arguments are declared before it in the custom format, like the
+// regions of a while, and returns it as a closure of fn.
+func (p *parser) parseCustomRegion(fn *Function, names []string, argsShapes []shapes.Shape) (*Function, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	closure := fn.Closure()
+	p.scopes[closure] = make(map[string]*Value)
+	for i, name := range names {
+		if err := p.addInput(closure, name, argsShapes[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parseFunctionBody(closure, "}", nil, nil); err != nil {
+		return nil, err
+	}
+	return closure, nil
+}
@@ -0,0 +1,638 @@
+package stablehlo
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/dtypes/bfloat16"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/gomlx/go-xla/pkg/types/shardy"
+	"github.com/x448/float16"
+)
+
+// scalarAddClosure returns a closure of fn that adds two scalars of the given dtype.
+func scalarAddClosure(fn *Function, dtype dtypes.DType) *Function {
+	closure := fn.Closure()
+	lhs := must1(closure.Input(shapes.Make(dtype)))
+	rhs := must1(closure.Input(shapes.Make(dtype)))
+	if err := closure.Return(must1(Add(lhs, rhs))); err != nil {
+		panic(err)
+	}
+	return closure
+}
+
+func TestParse(t *testing.T) {
+	// Each program is built, parsed and built again: the programs must match.
+	for _, tc := range []struct {
+		name  string
+		build func(b *Builder) error
+	}{
+		{"constants", func(b *Builder) error {
+			fn := b.Main()
+			return fn.Return(
+				must1(fn.ConstantFromScalar(float32(1.1))),
+				must1(fn.ConstantFromScalar(math.Inf(-1))),
+				must1(fn.ConstantFromFlatAndDimensions([]float32{float32(math.NaN()), 0, -2.5e-10, 3}, 2, 2)),
+				must1(fn.ConstantFromFlatAndDimensions([]int8{-128, 127}, 2)),
+				must1(fn.ConstantFromFlatAndDimensions([]uint64{math.MaxUint64}, 1)),
+				must1(fn.ConstantFromFlatAndDimensions([]bool{true, false, true}, 3, 1)),
+				must1(fn.ConstantFromFlatAndDimensions([]complex64{1 + 2i, -3}, 2)),
+				must1(fn.ConstantFromFlatAndDimensions([]float16.Float16{float16.Fromfloat32(0.5)}, 1)),
+				must1(fn.ConstantFromScalar(bfloat16.FromFloat32(-7))),
+				must1(fn.ConstantFromFlatAndDimensions([]float64{}, 0, 3)),
+			)
+		}},
+		{"element-wise", func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2, 3)))
+			y := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			z := must1(Add(must1(Tanh(x)), must1(Multiply(x, y))))
+			cmp := must1(Compare(z, y, types.CompareGT, types.CompareFloat))
+			z = must1(Select(cmp, z, must1(Negate(y))))
+			i := must1(Convert(z, dtypes.Int32))
+			iota := must1(fn.Iota(shapes.Make(dtypes.Int32, 2, 3), 1))
+			return fn.Return(must1(Clamp(iota, i, iota)), must1(ReducePrecision(z, 5, 2)))
+		}},
+		{"shapes", func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			x = must1(Transpose(x, 1, 0))
+			x = must1(Reshape(x, shapes.Make(dtypes.F32, 6)))
+			x = must1(BroadcastInDim(x, shapes.Make(dtypes.F32, 4, 6), []int{1}))
+			x = must1(Slice(x, []int{0, 1}, []int{4, 5}, []int{2, 1}))
+			x = must1(Pad(x, must1(fn.ConstantFromScalar(float32(0))), []int{1, 0}, []int{0, 2}, []int{0, 1}))
+			x = must1(Concatenate(0, x, x))
+			return fn.Return(x, must1(Reverse(x, 1)))
+		}},
+		{"dot and convolution", func(b *Builder) error {
+			fn := b.Main()
+			lhs := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3, 4)))
+			rhs := must1(fn.Input(shapes.Make(dtypes.F32, 2, 4, 5)))
+			dot := must1(DotGeneral(lhs, []int{2}, []int{0}, rhs, []int{1}, []int{0}).
+				Precision(types.DotGeneralPrecisionHighest, types.DotGeneralPrecisionDefault).
+				Algorithm(&types.DotGeneralAlgorithm{
+					LhsPrecisionType:       types.FloatPrecisionType{DType: dtypes.F32},
+					RhsPrecisionType:       types.FloatPrecisionType{DType: dtypes.F32},
+					AccumulationType:       types.FloatPrecisionType{DType: dtypes.F32},
+					LhsComponentCount:      1,
+					RhsComponentCount:      1,
+					NumPrimitiveOperations: 1,
+				}).Done())
+			input := must1(fn.Input(shapes.Make(dtypes.F32, 1, 3, 8, 8)))
+			kernel := must1(fn.Input(shapes.Make(dtypes.F32, 4, 3, 3, 3)))
+			conv := must1(Convolution(input, kernel, []int{2, 2}, [][2]int{{1, 1}, {1, 1}}, nil, nil,
+				0, 1, []int{2, 3}, 1, 0, []int{2, 3}, 0, 1, []int{2, 3}, 1, 1,
+				types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))
+			return fn.Return(dot, conv)
+		}},
+		{"closures", func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 4, 3)))
+			zero := must1(fn.ConstantFromScalar(float32(0)))
+			sum := must1(Reduce(x, zero, scalarAddClosure(fn, dtypes.F32), 1))
+			window := must1(ReduceWindow(x, zero, scalarAddClosure(fn, dtypes.F32),
+				[]int{2, 1}, nil, nil, nil, nil))
+			mapper := fn.Closure()
+			a := must1(mapper.Input(shapes.Make(dtypes.F32)))
+			// The closure uses a value from its parent function.
+			if err := mapper.Return(must1(Multiply(a, must1(mapper.UseParentValue(zero))))); err != nil {
+				return err
+			}
+			mapped := must1(Map(mapper, nil, x))
+
+			// While loop, with a nested If.
+			counter := must1(fn.ConstantFromScalar(int32(0)))
+			condFn := fn.Closure()
+			condCounter := must1(condFn.Input(counter.Shape()))
+			if err := condFn.Return(must1(Compare(condCounter, must1(condFn.ConstantFromScalar(int32(10))),
+				types.CompareLT, types.CompareSigned))); err != nil {
+				return err
+			}
+			bodyFn := fn.Closure()
+			bodyCounter := must1(bodyFn.Input(counter.Shape()))
+			isEven := must1(Compare(must1(Remainder(bodyCounter, must1(bodyFn.ConstantFromScalar(int32(2))))),
+				must1(bodyFn.ConstantFromScalar(int32(0))), types.CompareEQ, types.CompareSigned))
+			trueBranch := bodyFn.Closure()
+			if err := trueBranch.Return(must1(trueBranch.ConstantFromScalar(int32(1)))); err != nil {
+				return err
+			}
+			falseBranch := bodyFn.Closure()
+			if err := falseBranch.Return(must1(falseBranch.ConstantFromScalar(int32(3)))); err != nil {
+				return err
+			}
+			step := must1(If(isEven, trueBranch, falseBranch))[0]
+			if err := bodyFn.Return(must1(Add(bodyCounter, step))); err != nil {
+				return err
+			}
+			loop := must1(While(condFn, bodyFn, counter))
+			return fn.Return(sum, window, mapped, loop[0])
+		}},
+		{"multiple outputs and calls", func(b *Builder) error {
+			callee := b.NewFunction("my.callee")
+			x := must1(callee.Input(shapes.Make(dtypes.F32, 3)))
+			if err := callee.Return(x, must1(Abs(x))); err != nil {
+				return err
+			}
+			fn := b.Main()
+			x = must1(fn.Input(shapes.Make(dtypes.F32, 3)))
+			results := must1(Call(callee, x))
+			composite := must1(Composite("my.composite", map[string]any{"scale": float32(2)}, callee, x))
+			comparator := fn.Closure()
+			lhs := must1(comparator.Input(shapes.Make(dtypes.F32)))
+			rhs := must1(comparator.Input(shapes.Make(dtypes.F32)))
+			if err := comparator.Return(must1(Compare(lhs, rhs, types.CompareLT, types.CompareFloat))); err != nil {
+				return err
+			}
+			sorted := must1(Sort(comparator, 0, true, results[0], results[1]))
+			tuple := must1(Tuple(sorted[0], sorted[1]))
+			return fn.Return(must1(GetTupleElement(tuple, 1)), composite[1])
+		}},
+		{"gather and scatter", func(b *Builder) error {
+			fn := b.Main()
+			operand := must1(fn.Input(shapes.Make(dtypes.F32, 5, 3)))
+			indices := must1(fn.Input(shapes.Make(dtypes.Int32, 2, 1)))
+			gathered := must1(Gather(operand, indices, 1, []int{1}, []int{0}, nil, nil, []int{0}, []int{1, 3}, false))
+			scattered := must1(Scatter(operand, indices, gathered, []int{1}, []int{0}, nil, nil, []int{0}, 1,
+				false, false, scalarAddClosure(fn, dtypes.F32)))
+			return fn.Return(scattered)
+		}},
+		{"collectives and tokens", func(b *Builder) error {
+			b.WithNumReplicas(2)
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 4)))
+			sum := must1(AllReduce([]*Value{x}, [][]int{{0, 1}}, scalarAddClosure(fn, dtypes.F32)))[0]
+			gathered := must1(AllGather(sum, [][]int{{0, 1}}, 0))
+			token := must1(fn.CreateToken())
+			channelID := 7
+			token = must1(Send(token, []*Value{gathered}, true, &types.CollectiveConfig{ChannelID: &channelID}))
+			return fn.Return(gathered, must1(fn.ReplicaId()), token)
+		}},
+		{"custom call", func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 3)))
+			results := must1(CustomCall(fn, "my_target", []*Value{x}, []shapes.Shape{x.Shape(), shapes.Make(dtypes.Int64)},
+				&CustomCallOptions{
+					TypedBackendConfig: map[string]any{"alpha": float32(0.5), "name": "x", "axes": []int{0, 1}},
+					HasSideEffect:      true,
+				}))
+			return fn.Return(results...)
+		}},
+		{"quantized and dynamic shapes", func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			quantizedShape := shapes.Make(dtypes.F32, 2, 3).WithUniformQuantization(dtypes.Int8, dtypes.F32, 0.5, -1)
+			quantized := must1(UniformQuantize(x, quantizedShape))
+			dequantized := must1(UniformDequantize(quantized))
+			y := must1(fn.Input(shapes.Make(dtypes.F32, shapes.DimUnknown, 3)))
+			size := must1(GetDimensionSize(y, 0))
+			return fn.Return(dequantized, size)
+		}},
+		{"sharding", func(b *Builder) error {
+			mesh := must1(shardy.NewDeviceMesh("mesh", []int{4, 2}, []string{"data", "model"}))
+			if err := mesh.SetLogicalDeviceAssignment(7, 6, 5, 4, 3, 2, 1, 0); err != nil {
+				return err
+			}
+			b.WithShardy(mesh)
+			fn := b.Main()
+			x := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 16, 128),
+				b.NewShardingSpec().AddShardedAxis("data")))
+			return fn.ReturnWithShardingAndAttributes([]*Value{must1(Tanh(x))},
+				[]*shardy.ShardingSpec{b.NewShardingSpec().AddShardedAxis("data")},
+				[]map[string]any{{"jax.result_info": "result"}})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New(t.Name())
+			if err := tc.build(b); err != nil {
+				t.Fatalf("failed to build program: %v", err)
+			}
+			program := string(must1(b.Build()))
+			parsed, err := Parse([]byte(program))
+			if err != nil {
+				t.Fatalf("failed to parse program:\n%s\nError: %+v", program, err)
+			}
+			reprogram := string(must1(parsed.Build()))
+			if reprogram != program {
+				t.Fatalf("program doesn't match after parsing.\nOriginal:\n%s\nParsed:\n%s", program, reprogram)
+			}
+		})
+	}
+}
+
+func TestParseCustomFormat(t *testing.T) {
+	// Each program in the custom (pretty) format is parsed and built: it must match the program built with the
+	// corresponding ops.
+	for _, tc := range []struct {
+		name, program string
+		build         func(b *Builder) error
+	}{
+		{"element-wise", `
+  func.func @main(%arg0: tensor<2x3xf32>, %arg1: tensor<2x3xf32>) -> (tensor<2x3xi32>, tensor<2x3xf32>) {
+    %0 = stablehlo.tanh %arg0 : tensor<2x3xf32>
+    %1 = stablehlo.multiply %arg0, %arg1 : tensor<2x3xf32>
+    %2 = stablehlo.add %0, %1 : tensor<2x3xf32>
+    %3 = stablehlo.compare  GT, %2, %arg1,  FLOAT : (tensor<2x3xf32>, tensor<2x3xf32>) -> tensor<2x3xi1>
+    %4 = stablehlo.negate %arg1 : tensor<2x3xf32>
+    %5 = stablehlo.select %3, %2, %4 : tensor<2x3xi1>, tensor<2x3xf32>
+    %6 = stablehlo.convert %5 : (tensor<2x3xf32>) -> tensor<2x3xi32>
+    %7 = stablehlo.iota dim = 1 : tensor<2x3xi32>
+    %8 = stablehlo.clamp %7, %6, %7 : tensor<2x3xi32>
+    %9 = stablehlo.reduce_precision %5, format = e5m2 : tensor<2x3xf32>
+    return %8, %9 : tensor<2x3xi32>, tensor<2x3xf32>
+  }`, func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			y := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			tanh := must1(Tanh(x))
+			z := must1(Add(tanh, must1(Multiply(x, y))))
+			cmp := must1(Compare(z, y, types.CompareGT, types.CompareFloat))
+			z = must1(Select(cmp, z, must1(Negate(y))))
+			i := must1(Convert(z, dtypes.Int32))
+			iota := must1(fn.Iota(shapes.Make(dtypes.Int32, 2, 3), 1))
+			return fn.Return(must1(Clamp(iota, i, iota)), must1(ReducePrecision(z, 5, 2)))
+		}},
+		{"shapes", `
+  func.func @main(%arg0: tensor<2x3xf32>, %arg1: tensor<i32>) -> tensor<6x9xf32> {
+    %0 = stablehlo.transpose %arg0, dims = [1, 0] : (tensor<2x3xf32>) -> tensor<3x2xf32>
+    %1 = stablehlo.reshape %0 : (tensor<3x2xf32>) -> tensor<6xf32>
+    %2 = stablehlo.broadcast_in_dim %1, dims = [1] : (tensor<6xf32>) -> tensor<4x6xf32>
+    %3 = stablehlo.slice %2 [0:4:2, 1:5] : (tensor<4x6xf32>) -> tensor<2x4xf32>
+    %4 = stablehlo.constant dense<0.000000e+00> : tensor<f32>
+    %5 = stablehlo.pad %3, %4, low = [1, 0], high = [0, 2], interior = [0, 1] : (tensor<2x4xf32>, tensor<f32>) -> tensor<3x9xf32>
+    %6 = stablehlo.concatenate %5, %5, dim = 0 : (tensor<3x9xf32>, tensor<3x9xf32>) -> tensor<6x9xf32>
+    %7 = stablehlo.reverse %6, dims = [1] : tensor<6x9xf32>
+    %8 = stablehlo.dynamic_slice %7, %arg1, %arg1, sizes = [2, 2] : (tensor<6x9xf32>, tensor<i32>, tensor<i32>) -> tensor<2x2xf32>
+    %9 = stablehlo.dynamic_update_slice %7, %8, %arg1, %arg1 : (tensor<6x9xf32>, tensor<2x2xf32>, tensor<i32>, tensor<i32>) -> tensor<6x9xf32>
+    return %9 : tensor<6x9xf32>
+  }`, func(b *Builder) error {
+			fn := b.Main()
+			x := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3)))
+			start := must1(fn.Input(shapes.Make(dtypes.Int32)))
+			x = must1(Transpose(x, 1, 0))
+			x = must1(Reshape(x, shapes.Make(dtypes.F32, 6)))
+			x = must1(BroadcastInDim(x, shapes.Make(dtypes.F32, 4, 6), []int{1}))
+			x = must1(Slice(x, []int{0, 1}, []int{4, 5}, []int{2, 1}))
+			x = must1(Pad(x, must1(fn.ConstantFromScalar(float32(0))), []int{1, 0}, []int{0, 2}, []int{0, 1}))
+			x = must1(Concatenate(0, x, x))
+			x = must1(Reverse(x, 1))
+			slice := must1(DynamicSlice(x, []*Value{start, start}, []int{2, 2}))
+			return fn.Return(must1(DynamicUpdateSlice(x, slice, []*Value{start, start})))
+		}},
+		{"dot and convolution", `
+  func.func @main(%arg0: tensor<2x3x4xf32>, %arg1: tensor<2x4x5xf32>, %arg2: tensor<1x3x8x8xf32>, %arg3: tensor<4x3x3x3xf32>) -> (tensor<2x3x5xf32>, tensor<1x4x4x4xf32>) {
+    %0 = stablehlo.dot_general %arg0, %arg1, batching_dims = [0] x [0], contracting_dims = [2] x [1], precision = [HIGHEST, DEFAULT], algorithm = <lhs_precision_type = f32, rhs_precision_type = f32, accumulation_type = f32, lhs_component_count = 1, rhs_component_count = 1, num_primitive_operations = 1, allow_imprecise_accumulation = false> : (tensor<2x3x4xf32>, tensor<2x4x5xf32>) -> tensor<2x3x5xf32>
+    %1 = stablehlo.convolution(%arg2, %arg3) dim_numbers = [b, f, 0, 1]x[o, i, 0, 1]->[b, f, 0, 1], window = {stride = [2, 2], pad = [[1, 1], [1, 1]], lhs_dilate = [1, 1], rhs_dilate = [1, 1], reverse = [false, false]} {batch_group_count = 1 : i64, feature_group_count = 1 : i64, precision_config = [#stablehlo<precision DEFAULT>, #stablehlo<precision DEFAULT>]} : (tensor<1x3x8x8xf32>, tensor<4x3x3x3xf32>) -> tensor<1x4x4x4xf32>
+    return %0, %1 : tensor<2x3x5xf32>, tensor<1x4x4x4xf32>
+  }`, func(b *Builder) error {
+			fn := b.Main()
+			lhs := must1(fn.Input(shapes.Make(dtypes.F32, 2, 3, 4)))
+			rhs := must1(fn.Input(shapes.Make(dtypes.F32, 2, 4, 5)))
+			input := must1(fn.Input(shapes.Make(dtypes.F32, 1, 3, 8, 8)))
+			kernel := must1(fn.Input(shapes.Make(dtypes.F32, 4, 3, 3, 3)))
+			dot := must1(DotGeneral(lhs, []int{2}, []int{0}, rhs, []int{1}, []int{0}).
+				Precision(types.DotGeneralPrecisionHighest, types.DotGeneralPrecisionDefault).
+				Algorithm(&types.DotGeneralAlgorithm{
+					LhsPrecisionType:       types.FloatPrecisionType{DType: dtypes.F32},
+					RhsPrecisionType:       types.FloatPrecisionType{DType: dtypes.F32},
+					AccumulationType:       types.FloatPrecisionType{DType: dtypes.F32},
+					LhsComponentCount:      1,
+					RhsComponentCount:      1,
+					NumPrimitiveOperations: 1,
+				}).Done())
+			conv := must1(Convolution(input, kernel, []int{2, 2}, [][2]int{{1, 1}, {1, 1}}, nil, nil,
+				0, 1, []int{2, 3}, 1, 0, []int{2, 3}, 0, 1, []int{2, 3}, 1, 1,
+				types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))
+			return fn.Return(dot, conv)
+		}},
+		{"calls and tuples", `
+  func.func @callee(%arg0: tensor<3xf32>) -> (tensor<3xf32>, tensor<3xf32>) {
+    %0 = stablehlo.abs %arg0 : tensor<3xf32>
+    return %arg0, %0 : tensor<3xf32>, tensor<3xf32>
+  }
+
+  func.func @main(%arg0: tensor<3xf32>) -> (tensor<3xf32>, tensor<i64>, tensor<3xf32>) {
+    %0, %1 = call @callee(%arg0) : (tensor<3xf32>) -> (tensor<3xf32>, tensor<3xf32>)
+    %2 = stablehlo.tuple %0, %1 : tuple<tensor<3xf32>, tensor<3xf32>>
+    %3 = stablehlo.get_tuple_element %2[1] : (tuple<tensor<3xf32>, tensor<3xf32>>) -> tensor<3xf32>
+    %4, %5 = stablehlo.optimization_barrier %3, %arg0 : tensor<3xf32>, tensor<3xf32>
+    %6, %7 = stablehlo.custom_call @my_target(%4) {api_version = 4 : i32, has_side_effect = true} : (tensor<3xf32>) -> (tensor<3xf32>, tensor<i64>)
+    return %6, %7, %5 : tensor<3xf32>, tensor<i64>, tensor<3xf32>
+  }`, func(b *Builder) error {
+			callee := b.NewFunction("callee")
+			x := must1(callee.Input(shapes.Make(dtypes.F32, 3)))
+			if err := callee.Return(x, must1(Abs(x))); err != nil {
+				return err
+			}
+			fn := b.Main()
+			x = must1(fn.Input(shapes.Make(dtypes.F32, 3)))
+			results := must1(Call(callee, x))
+			tuple := must1(Tuple(results[0], results[1]))
+			barrier := must1(OptimizationBarrier(must1(GetTupleElement(tuple, 1)), x))
+			results = must1(CustomCall(fn, "my_target", barrier[:1], []shapes.Shape{x.Shape(), shapes.Make(dtypes.Int64)},
+				&CustomCallOptions{HasSideEffect: true}))
+			return fn.Return(results[0], results[1], barrier[1])
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New("custom_format")
+			if err := tc.build(b); err != nil {
+				t.Fatalf("failed to build program: %v", err)
+			}
+			want := string(must1(b.Build()))
+			parsed, err := Parse([]byte("module @custom_format {" + tc.program + "\n}\n"))
+			if err != nil {
+				t.Fatalf("failed to parse program:\n%s\nError: %+v", tc.program, err)
+			}
+			program := string(must1(parsed.Build()))
+			if program != want {
+				fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+				fmt.Printf("  Got:\n%s", program)
+				t.Fatal("programs don't match")
+			}
+		})
+	}
+}
+
+func TestParseJAXExport(t *testing.T) {
+	// Module exported by JAX, in the custom format, with the operations JAX prints in the generic format (case and
+	// sort) and locations.
+	text, err := os.ReadFile("test_jax_export.mlir")
+	if err != nil {
+		t.Fatalf("failed to read the exported module: %v", err)
+	}
+	b, err := Parse(text)
+	if err != nil {
+		t.Fatalf("failed to parse the exported module: %+v", err)
+	}
+	if err = b.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	program := string(must1(b.Build()))
+	want := `module @jit_f attributes {stablehlo.num_replicas = 1,  stablehlo.num_partitions = 1} {
+  func.func @main(%arg0: tensor<2x3xf32> { mhlo.layout_mode = "default" }, %arg1: tensor<3x2xf32> { mhlo.layout_mode = "default" }) -> (tensor<2xf32> {
+    jax.result_info = "[0]",
+    mhlo.layout_mode = "default"
+  }, tensor<2x2xf32> {
+    jax.result_info = "[1]",
+    mhlo.layout_mode = "default"
+  }, tensor<i32> {
+    jax.result_info = "[2]",
+    mhlo.layout_mode = "default"
+  }, tensor<2xi32> {
+    jax.result_info = "[3]",
+    mhlo.layout_mode = "default"
+  }, tensor<1x2xf32> {
+    jax.result_info = "[4]",
+    mhlo.layout_mode = "default"
+  }) {
+    %0 = "stablehlo.sine"(%arg0) : (tensor<2x3xf32>) -> tensor<2x3xf32>
+    %cst = "stablehlo.constant"() { value = dense<2.0> : tensor<f32> } : () -> tensor<f32>
+    %1 = "stablehlo.broadcast_in_dim"(%cst) { broadcast_dimensions = array<i64> } : (tensor<f32>) -> tensor<2x3xf32>
+    %2 = "stablehlo.multiply"(%0, %1) : (tensor<2x3xf32>, tensor<2x3xf32>) -> tensor<2x3xf32>
+    %cst_0 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %3 = "stablehlo.reduce"(%2, %cst_0) ({
+      ^reductionFn(%unnamed0: tensor<f32>, %unnamed1: tensor<f32>) :
+          %unnamed2 = "stablehlo.add"(%unnamed0, %unnamed1) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%unnamed2) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 1> } : (tensor<2x3xf32>, tensor<f32>) -> tensor<2xf32>
+    %4 = "stablehlo.dot_general"(%arg0, %arg1) {
+      dot_dimension_numbers = #stablehlo.dot<
+  lhs_batching_dimensions = [],
+  rhs_batching_dimensions = [],
+  lhs_contracting_dimensions = [1],
+  rhs_contracting_dimensions = [0]
+>,
+      precision_config = [#stablehlo<precision DEFAULT>, #stablehlo<precision DEFAULT>]
+    } : (tensor<2x3xf32>, tensor<3x2xf32>) -> tensor<2x2xf32>
+    %5 = "stablehlo.transpose"(%4) { permutation = array<i64: 1, 0> } : (tensor<2x2xf32>) -> tensor<2x2xf32>
+    %6 = "func.call"(%5) { callee = @relu } : (tensor<2x2xf32>) -> tensor<2x2xf32>
+    %c = "stablehlo.constant"() { value = dense<0> : tensor<i32> } : () -> tensor<i32>
+    %7_0, %7_1 = "stablehlo.while"(%c, %c) ({
+      ^cond(%iterArg: tensor<i32>, %iterArg_1: tensor<i32>) :
+          %c_6 = "stablehlo.constant"() { value = dense<5> : tensor<i32> } : () -> tensor<i32>
+          %20 = "stablehlo.compare"(%iterArg, %c_6) {
+            compare_type = #stablehlo<comparison_type SIGNED>,
+            comparison_direction = #stablehlo<comparison_direction LT>
+          } : (tensor<i32>, tensor<i32>) -> tensor<i1>
+          "stablehlo.return"(%20) : (tensor<i1>) -> ()
+    }, {
+      ^body(%iterArg: tensor<i32>, %iterArg_1: tensor<i32>) :
+          %c_6 = "stablehlo.constant"() { value = dense<1> : tensor<i32> } : () -> tensor<i32>
+          %20 = "stablehlo.add"(%iterArg, %c_6) : (tensor<i32>, tensor<i32>) -> tensor<i32>
+          %21 = "stablehlo.add"(%iterArg_1, %iterArg) : (tensor<i32>, tensor<i32>) -> tensor<i32>
+          "stablehlo.return"(%20, %21) : (tensor<i32>, tensor<i32>) -> ()
+    }) : (tensor<i32>, tensor<i32>) -> (tensor<i32>, tensor<i32>)
+    %cst_2 = "stablehlo.constant"() { value = dense<0xff800000> : tensor<f32> } : () -> tensor<f32>
+    %c_3 = "stablehlo.constant"() { value = dense<0> : tensor<i32> } : () -> tensor<i32>
+    %8 = "stablehlo.iota"() { iota_dimension = 1 : i64 } : () -> tensor<2x3xi32>
+    %9_0, %9_1 = "stablehlo.reduce"(%arg0, %8, %cst_2, %c_3) ({
+      ^reductionFn(%arg2: tensor<f32>, %arg3: tensor<i32>, %arg4: tensor<f32>, %arg5: tensor<i32>) :
+          %20 = "stablehlo.compare"(%arg2, %arg4) {
+            compare_type = #stablehlo<comparison_type FLOAT>,
+            comparison_direction = #stablehlo<comparison_direction GE>
+          } : (tensor<f32>, tensor<f32>) -> tensor<i1>
+          %21 = "stablehlo.select"(%20, %arg2, %arg4) : (tensor<i1>, tensor<f32>, tensor<f32>) -> tensor<f32>
+          %22 = "stablehlo.select"(%20, %arg3, %arg5) : (tensor<i1>, tensor<i32>, tensor<i32>) -> tensor<i32>
+          "stablehlo.return"(%21, %22) : (tensor<f32>, tensor<i32>) -> ()
+    }) { dimensions = array<i64: 1> } : (tensor<2x3xf32>, tensor<2x3xi32>, tensor<f32>, tensor<i32>) -> (tensor<2xf32>, tensor<2xi32>)
+    %c_4 = "stablehlo.constant"() { value = dense<1> : tensor<i32> } : () -> tensor<i32>
+    %10 = "stablehlo.case"(%c_4) ({
+      ^bb0() :
+          %20 = "stablehlo.negate"(%3) : (tensor<2xf32>) -> tensor<2xf32>
+          "stablehlo.return"(%20) : (tensor<2xf32>) -> ()
+    }, {
+      ^bb0() :
+          "stablehlo.return"(%3) : (tensor<2xf32>) -> ()
+    }) : (tensor<i32>) -> tensor<2xf32>
+    %11 = "stablehlo.sort"(%10) ({
+      ^bb0(%arg2: tensor<f32>, %arg3: tensor<f32>) :
+          %20 = "stablehlo.compare"(%arg2, %arg3) {
+            compare_type = #stablehlo<comparison_type TOTALORDER>,
+            comparison_direction = #stablehlo<comparison_direction LT>
+          } : (tensor<f32>, tensor<f32>) -> tensor<i1>
+          "stablehlo.return"(%20) : (tensor<i1>) -> ()
+    }) {
+      dimension = 0 : i64,
+      is_stable = true
+    } : (tensor<2xf32>) -> tensor<2xf32>
+    %12 = "stablehlo.iota"() { iota_dimension = 0 : i64 } : () -> tensor<2xi32>
+    %13 = "stablehlo.convert"(%12) : (tensor<2xi32>) -> tensor<2xf32>
+    %14 = "stablehlo.concatenate"(%11, %13) { dimension = 0 : i64 } : (tensor<2xf32>, tensor<2xf32>) -> tensor<4xf32>
+    %15 = "stablehlo.slice"(%14) {
+      limit_indices = array<i64: 4>,
+      start_indices = array<i64: 1>,
+      strides = array<i64: 2>
+    } : (tensor<4xf32>) -> tensor<2xf32>
+    %16 = "stablehlo.reshape"(%15) : (tensor<2xf32>) -> tensor<1x2xf32>
+    "stablehlo.return"(%3, %6, %7_1, %9_1, %16) : (tensor<2xf32>, tensor<2x2xf32>, tensor<i32>, tensor<2xi32>, tensor<1x2xf32>) -> ()
+  }
+
+  func.func @relu(%arg0: tensor<2x2xf32>) -> tensor<2x2xf32> {
+    %cst = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    %0 = "stablehlo.broadcast_in_dim"(%cst) { broadcast_dimensions = array<i64> } : (tensor<f32>) -> tensor<2x2xf32>
+    %1 = "stablehlo.maximum"(%arg0, %0) : (tensor<2x2xf32>, tensor<2x2xf32>) -> tensor<2x2xf32>
+    "stablehlo.return"(%1) : (tensor<2x2xf32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+
+	// The generic format built is parsed back to the same program.
+	reprogram := string(must1(must1(Parse([]byte(program))).Build()))
+	if reprogram != program {
+		t.Fatalf("program doesn't match after parsing.\nOriginal:\n%s\nParsed:\n%s", program, reprogram)
+	}
+}
+
+func TestParseAndModify(t *testing.T) {
+	program := `
+// Comments and locations are ignored.
+#loc1 = loc("x")
+module @jit_f attributes {mhlo.num_partitions = 1 : i32, mhlo.num_replicas = 1 : i32} {
+  func.func public @main(%arg0: tensor<3xf32> loc("x"), %arg1: tensor<f32>) -> (tensor<3xf32> {jax.result_info = ""}) {
+    %cst = "stablehlo.constant"() <{value = dense<2.0> : tensor<3xf32>}> : () -> tensor<3xf32> loc(#loc1)
+    %0 = "stablehlo.multiply"(%arg0, %cst) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %1 = "stablehlo.reduce"(%0, %arg1) ({
+    ^bb0(%a: tensor<f32>, %b: tensor<f32>):
+      %2 = "stablehlo.add"(%a, %b) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+      "stablehlo.return"(%2) : (tensor<f32>) -> ()
+    }) {dimensions = array<i64: 0>} : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %3:2 = "stablehlo.optimization_barrier"(%0, %1) : (tensor<3xf32>, tensor<f32>) -> (tensor<3xf32>, tensor<f32>)
+    "func.return"(%3#0) : (tensor<3xf32>) -> ()
+  }
+}
+`
+	b, err := Parse([]byte(program))
+	if err != nil {
+		t.Fatalf("failed to parse program: %+v", err)
+	}
+	if b.numReplicas != 1 || b.numPartitions != 1 {
+		t.Errorf("expected 1 replica and 1 partition, got %d and %d", b.numReplicas, b.numPartitions)
+	}
+	functions := b.Functions()
+	if len(functions) != 2 || functions[0].Name != "main" || functions[1].Parent != functions[0] {
+		t.Fatalf("expected main function and a closure, got %d functions", len(functions))
+	}
+	main := functions[0]
+	if len(main.Inputs) != 2 || len(main.Statements) != 5 || len(main.Outputs) != 1 {
+		t.Fatalf("expected 2 inputs, 5 statements and 1 output, got %d, %d and %d",
+			len(main.Inputs), len(main.Statements), len(main.Outputs))
+	}
+	constant := main.Statements[0]
+	if constant.OpType != optypes.Constant || constant.Outputs[0].Shape().Size() != 3 {
+		t.Errorf("expected a constant of 3 elements, got %s with shape %s", constant.OpType, constant.Outputs[0].Shape())
+	}
+	if got := fmt.Sprint(constant.Attributes["value"].(tensorLiteral).value); got != "[2 2 2]" {
+		t.Errorf("expected the splat constant to be [2 2 2], got %s", got)
+	}
+	if main.Outputs[0].Attributes["jax.result_info"] != "" {
+		t.Errorf("expected the output attribute jax.result_info, got %v", main.Outputs[0].Attributes)
+	}
+	barrier := main.Statements[3]
+	if barrier.OpType != optypes.OptimizationBarrier || len(barrier.Outputs) != 2 ||
+		!barrier.Outputs[1].Shape().Equal(shapes.Make(dtypes.F32)) {
+		t.Errorf("expected an optimization barrier with 2 outputs, got %s with %d outputs",
+			barrier.OpType, len(barrier.Outputs))
+	}
+
+	// Add a new function calling the parsed main function.
+	caller := b.NewFunction("caller")
+	x := must1(caller.Input(shapes.Make(dtypes.F32, 3)))
+	y := must1(caller.Input(shapes.Make(dtypes.F32)))
+	results := must1(Call(main, x, y))
+	if err := caller.Return(must1(Negate(results[0]))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	newProgram := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s", t.Name(), newProgram)
+	want := `module @jit_f attributes {stablehlo.num_replicas = 1,  stablehlo.num_partitions = 1} {
+  func.func @main(%arg0: tensor<3xf32>, %arg1: tensor<f32>) -> (tensor<3xf32> { jax.result_info = "" }) {
+    %cst = "stablehlo.constant"() { value = dense<[2.0, 2.0, 2.0]> : tensor<3xf32> } : () -> tensor<3xf32>
+    %0 = "stablehlo.multiply"(%arg0, %cst) : (tensor<3xf32>, tensor<3xf32>) -> tensor<3xf32>
+    %1 = "stablehlo.reduce"(%0, %arg1) ({
+      ^bb0(%a: tensor<f32>, %b: tensor<f32>) :
+          %2 = "stablehlo.add"(%a, %b) : (tensor<f32>, tensor<f32>) -> tensor<f32>
+          "stablehlo.return"(%2) : (tensor<f32>) -> ()
+    }) { dimensions = array<i64: 0> } : (tensor<3xf32>, tensor<f32>) -> tensor<f32>
+    %3_0, %3_1 = "stablehlo.optimization_barrier"(%0, %1) : (tensor<3xf32>, tensor<f32>) -> (tensor<3xf32>, tensor<f32>)
+    "stablehlo.return"(%3_0) : (tensor<3xf32>) -> ()
+  }
+
+  func.func @caller(%arg0: tensor<3xf32>, %arg1: tensor<f32>) -> tensor<3xf32> {
+    %0 = "func.call"(%arg0, %arg1) { callee = @main } : (tensor<3xf32>, tensor<f32>) -> tensor<3xf32>
+    %1 = "stablehlo.negate"(%0) : (tensor<3xf32>) -> tensor<3xf32>
+    "stablehlo.return"(%1) : (tensor<3xf32>) -> ()
+  }
+}
+`
+	if newProgram != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+	if _, err := Parse([]byte(newProgram)); err != nil {
+		t.Errorf("failed to parse the modified program: %+v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, program, wantErr string
+	}{
+		{"custom format unknown attribute", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = stablehlo.negate %x, foo = [1] : tensor<f32>
+  return %0 : tensor<f32>
+}`, `attribute "foo" of operation "stablehlo.negate" in the custom format not supported`},
+		{"custom format without types", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = stablehlo.negate %x
+  return %0 : tensor<f32>
+}`, `expected ":" and the types of operation "stablehlo.negate"`},
+		{"custom format wrong operand type", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = stablehlo.convert %x : (tensor<i32>) -> tensor<f32>
+  return %0 : tensor<f32>
+}`, "has shape (Float32), but its signature has (Int32)"},
+		{"unknown op", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = "stablehlo.foo"(%x) : (tensor<f32>) -> tensor<f32>
+  "stablehlo.return"(%0) : (tensor<f32>) -> ()
+}`, `"stablehlo.foo" not supported`},
+		{"undefined value", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  "stablehlo.return"(%y) : (tensor<f32>) -> ()
+}`, "%y not defined"},
+		{"value out of scope", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = "stablehlo.reduce"(%x, %x) ({
+  ^bb0(%a: tensor<f32>, %b: tensor<f32>):
+    "stablehlo.return"(%a) : (tensor<f32>) -> ()
+  }) {dimensions = array<i64>} : (tensor<f32>, tensor<f32>) -> tensor<f32>
+  "stablehlo.return"(%a) : (tensor<f32>) -> ()
+}`, "%a not defined"},
+		{"wrong operand type", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+  %0 = "stablehlo.negate"(%x) : (tensor<i32>) -> tensor<f32>
+  "stablehlo.return"(%0) : (tensor<f32>) -> ()
+}`, "has shape (Float32), but its signature has (Int32)"},
+		{"missing return", `func.func @main(%x: tensor<f32>) -> tensor<f32> {
+}`, "has no return"},
+		{"wrong output type", `func.func @main(%x: tensor<f32>) -> tensor<i32> {
+  "stablehlo.return"(%x) : (tensor<f32>) -> ()
+}`, "but its signature has (Int32)"},
+		{"invalid type", `func.func @main(%x: tensor<2xfoo>) -> tensor<f32> {
+}`, "unknown element type"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.program))
+			if err == nil {
+				t.Fatalf("expected error parsing program, got nil")
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -5,11 +5,18 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gomlx/go-xla/pkg/types"
 	"github.com/gomlx/go-xla/pkg/types/dtypes"
 	"github.com/gomlx/go-xla/pkg/types/shapes"
 	"github.com/gomlx/go-xla/pkg/types/shardy"
 )
 
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
 func must1[T any](value T, err error) T {
 	if err != nil {
 		panic(err)
@@ -17,6 +24,13 @@ func must1[T any](value T, err error) T {
 	return value
 }
 
+func must2[T1, T2 any](value1 T1, value2 T2, err error) (T1, T2) {
+	if err != nil {
+		panic(err)
+	}
+	return value1, value2
+}
+
 func TestBuilder(t *testing.T) {
 	t.Run("no inputs", func(t *testing.T) {
 		b := New(t.Name())
@@ -424,3 +438,199 @@ func TestDynamicOperations(t *testing.T) {
 	})
 
 }
+
+func TestLinearAlgebra(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	a := must1(fn.NamedInput("a", shapes.Make(dtypes.Float32, 2, 3, 3)))
+	rhs := must1(fn.NamedInput("b", shapes.Make(dtypes.Float32, 2, 3, 4)))
+	l := must1(Cholesky(a, true))
+	x := must1(TriangularSolve(l, rhs, true, true, false, true))
+	if err := fn.Return(l, x); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestLinearAlgebra {
+  func.func @main(%a: tensor<2x3x3xf32>, %b: tensor<2x3x4xf32>) -> (tensor<2x3x3xf32>, tensor<2x3x4xf32>) {
+    %0 = "stablehlo.cholesky"(%a) { lower = true } : (tensor<2x3x3xf32>) -> tensor<2x3x3xf32>
+    %1 = "stablehlo.triangular_solve"(%0, %b) {
+      left_side = true,
+      lower = true,
+      transpose_a = #stablehlo<transpose TRANSPOSE>,
+      unit_diagonal = false
+    } : (tensor<2x3x3xf32>, tensor<2x3x4xf32>) -> tensor<2x3x4xf32>
+    "stablehlo.return"(%0, %1) : (tensor<2x3x3xf32>, tensor<2x3x4xf32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+}
+
+func TestReplicaAndPartitionId(t *testing.T) {
+	b := New(t.Name()).WithNumReplicas(2).WithNumPartitions(2)
+	fn := b.Main()
+	replicaId := must1(fn.ReplicaId())
+	partitionId := must1(fn.PartitionId())
+	if replicaId.Shape().DType != dtypes.Uint32 || replicaId.Shape().Rank() != 0 {
+		t.Errorf("expected ReplicaId to return a ui32 scalar, got %s", replicaId.Shape())
+	}
+	if err := fn.Return(replicaId, partitionId); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestReplicaAndPartitionId attributes {stablehlo.num_replicas = 2,  stablehlo.num_partitions = 2} {
+  func.func @main() -> (tensor<ui32>, tensor<ui32>) {
+    %0 = "stablehlo.replica_id"() : () -> tensor<ui32>
+    %1 = "stablehlo.partition_id"() : () -> tensor<ui32>
+    "stablehlo.return"(%0, %1) : (tensor<ui32>, tensor<ui32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+	if _, err := fn.ReplicaId(); err == nil {
+		t.Error("expected error adding ReplicaId after returning, got nil")
+	}
+}
+
+func TestTuple(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2)))
+	pair := must1(fn.NamedInput("pair", shapes.MakeTuple([]shapes.Shape{
+		shapes.Make(dtypes.Int32), shapes.Make(dtypes.Float32, 2)})))
+	tuple := must1(Tuple(x, must1(GetTupleElement(pair, 0))))
+	if !tuple.Shape().IsTuple() || tuple.Shape().TupleSize() != 2 {
+		t.Fatalf("expected a tuple with 2 elements, got %s", tuple.Shape())
+	}
+	y := must1(Add(must1(GetTupleElement(pair, 1)), must1(GetTupleElement(tuple, 0))))
+	if _, err := GetTupleElement(pair, 2); err == nil {
+		t.Error("expected error for GetTupleElement index out of range, got nil")
+	}
+	if _, err := GetTupleElement(x, 0); err == nil {
+		t.Error("expected error for GetTupleElement on a non-tuple, got nil")
+	}
+	if err := fn.Return(y, tuple); err == nil {
+		t.Error("expected error for returning a tuple from main, got nil")
+	}
+	if err := fn.Return(y, must1(GetTupleElement(tuple, 1))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestTuple {
+  func.func @main(%x: tensor<2xf32>, %pair: tuple<tensor<i32>, tensor<2xf32>>) -> (tensor<2xf32>, tensor<i32>) {
+    %0 = "stablehlo.get_tuple_element"(%pair) { index = 0 : i32 } : (tuple<tensor<i32>, tensor<2xf32>>) -> tensor<i32>
+    %1 = "stablehlo.tuple"(%x, %0) : (tensor<2xf32>, tensor<i32>) -> tuple<tensor<2xf32>, tensor<i32>>
+    %2 = "stablehlo.get_tuple_element"(%pair) { index = 1 : i32 } : (tuple<tensor<i32>, tensor<2xf32>>) -> tensor<2xf32>
+    %3 = "stablehlo.get_tuple_element"(%1) { index = 0 : i32 } : (tuple<tensor<2xf32>, tensor<i32>>) -> tensor<2xf32>
+    %4 = "stablehlo.add"(%2, %3) : (tensor<2xf32>, tensor<2xf32>) -> tensor<2xf32>
+    %5 = "stablehlo.get_tuple_element"(%1) { index = 1 : i32 } : (tuple<tensor<2xf32>, tensor<i32>>) -> tensor<i32>
+    "stablehlo.return"(%4, %5) : (tensor<2xf32>, tensor<i32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+}
+
+func TestReducePrecision(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 5)))
+	exponentBits, mantissaBits, _ := dtypes.F8E4M3FN.ExponentAndMantissaBits()
+	y := must1(ReducePrecision(x, exponentBits, mantissaBits))
+	if _, err := ReducePrecision(x, 0, 3); err == nil {
+		t.Error("expected error for ReducePrecision with 0 exponent bits, got nil")
+	}
+	xInt := must1(fn.NamedInput("x_int", shapes.Make(dtypes.Int32, 5)))
+	if _, err := ReducePrecision(xInt, 4, 3); err == nil {
+		t.Error("expected error for ReducePrecision of an integer, got nil")
+	}
+	if err := fn.Return(y); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestReducePrecision {
+  func.func @main(%x: tensor<5xf32>, %x_int: tensor<5xi32>) -> tensor<5xf32> {
+    %0 = "stablehlo.reduce_precision"(%x) {
+      exponent_bits = 4 : i32,
+      mantissa_bits = 3 : i32
+    } : (tensor<5xf32>) -> tensor<5xf32>
+    "stablehlo.return"(%0) : (tensor<5xf32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+}
+
+func TestMap(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+	y := must1(fn.NamedInput("y", shapes.Make(dtypes.Float32, 2, 3)))
+	mapper := fn.Closure()
+	lhs := must1(mapper.Input(shapes.Make(dtypes.Float32)))
+	rhs := must1(mapper.Input(shapes.Make(dtypes.Float32)))
+	if err := mapper.Return(must1(Compare(lhs, rhs, types.CompareGT, types.CompareFloat))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	z := must1(Map(mapper, nil, x, y))
+	if !z.Shape().Equal(shapes.Make(dtypes.Bool, 2, 3)) {
+		t.Errorf("expected Map output shape (Bool)[2 3], got %s", z.Shape())
+	}
+	if _, err := Map(mapper, nil, x); err == nil {
+		t.Error("expected error for Map with the wrong number of inputs, got nil")
+	}
+	if _, err := Map(mapper, []int{1, 0}, x, y); err == nil {
+		t.Error("expected error for Map with permuted dimensions, got nil")
+	}
+	otherFn := b.NewFunction("other")
+	if _, err := Map(otherFn, nil, x, y); err == nil {
+		t.Error("expected error for Map with a mapper that is not a closure, got nil")
+	}
+	if err := otherFn.Return(must1(otherFn.ConstantFromScalar(float32(0)))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := fn.Return(z); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	program := string(must1(b.Build()))
+	fmt.Printf("%s program:\n%s\n", t.Name(), program)
+	want := `module @TestMap {
+  func.func @main(%x: tensor<2x3xf32>, %y: tensor<2x3xf32>) -> tensor<2x3xi1> {
+    %1 = "stablehlo.map"(%x, %y) ({
+      ^computation(%arg2: tensor<f32>, %arg3: tensor<f32>) :
+          %0 = "stablehlo.compare"(%arg2, %arg3) {
+            compare_type = #stablehlo<comparison_type FLOAT>,
+            comparison_direction = #stablehlo<comparison_direction GT>
+          } : (tensor<f32>, tensor<f32>) -> tensor<i1>
+          "stablehlo.return"(%0) : (tensor<i1>) -> ()
+    }) { dimensions = array<i64: 0, 1> } : (tensor<2x3xf32>, tensor<2x3xf32>) -> tensor<2x3xi1>
+    "stablehlo.return"(%1) : (tensor<2x3xi1>) -> ()
+  }
+
+  func.func @other() -> tensor<f32> {
+    %0 = "stablehlo.constant"() { value = dense<0.0> : tensor<f32> } : () -> tensor<f32>
+    "stablehlo.return"(%0) : (tensor<f32>) -> ()
+  }
+}
+`
+	if program != want {
+		fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+		t.Fatal("programs don't match")
+	}
+}
@@ -45,6 +45,36 @@ func (s *Statement) AddFunctionParameter(name string, inlineFn *Function) {
 	s.FunctionParametersNames = append(s.FunctionParametersNames, name)
 }
 
+// AttributeToStableHLO returns the attribute with the given key rendered in StableHLO format, as it is written
+// in the program (e.g.: "array<i64: 1, 0>" or "3 : i64"), and whether the statement has the attribute.
+//
+// It is meant for tools that inspect the program, like the interpreter.
+func (s *Statement) AttributeToStableHLO(key string) (string, bool) {
+	value, found := s.Attributes[key]
+	if !found {
+		return "", false
+	}
+	return literalToStableHLO(value), true
+}
+
+// ConstantValue returns the value of a Constant statement: either a Go scalar (for scalar constants) or a flat
+// slice with the values in row-major order, and the dimensions of the constant.
+//
+// It returns ok=false if the statement is not a Constant, or if its value is not available as Go values
+// (e.g.: a constant with a dtype that has no Go type, parsed from StableHLO text).
+//
+// The returned value is shared with the statement, and it should not be modified.
+func (s *Statement) ConstantValue() (value any, dimensions []int, ok bool) {
+	if s.OpType != optypes.Constant {
+		return nil, nil, false
+	}
+	literal, ok := s.Attributes["value"].(tensorLiteral)
+	if !ok {
+		return nil, nil, false
+	}
+	return literal.value, slices.Clone(literal.dims), true
+}
+
 // Write writes a string representation of the statement to the given writer.
 func (s *Statement) Write(writer io.Writer, indentation string) error {
 	// Create the formatting w() and we() internal functions to facilitate handling error while generating the statement code.
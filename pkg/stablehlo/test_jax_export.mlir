@@ -0,0 +1,62 @@
+#loc1 = loc("x")
+#loc2 = loc("y")
+module @jit_f attributes {mhlo.num_partitions = 1 : i32, mhlo.num_replicas = 1 : i32} {
+  func.func public @main(%arg0: tensor<2x3xf32> {mhlo.layout_mode = "default"} loc("x"), %arg1: tensor<3x2xf32> {mhlo.layout_mode = "default"} loc("y")) -> (tensor<2xf32> {jax.result_info = "[0]", mhlo.layout_mode = "default"}, tensor<2x2xf32> {jax.result_info = "[1]", mhlo.layout_mode = "default"}, tensor<i32> {jax.result_info = "[2]", mhlo.layout_mode = "default"}, tensor<2xi32> {jax.result_info = "[3]", mhlo.layout_mode = "default"}, tensor<1x2xf32> {jax.result_info = "[4]", mhlo.layout_mode = "default"}) {
+    %0 = stablehlo.sine %arg0 : tensor<2x3xf32> loc(#loc3)
+    %cst = stablehlo.constant dense<2.000000e+00> : tensor<f32>
+    %1 = stablehlo.broadcast_in_dim %cst, dims = [] : (tensor<f32>) -> tensor<2x3xf32>
+    %2 = stablehlo.multiply %0, %1 : tensor<2x3xf32>
+    %cst_0 = stablehlo.constant dense<0.000000e+00> : tensor<f32>
+    %3 = stablehlo.reduce(%2 init: %cst_0) applies stablehlo.add across dimensions = [1] : (tensor<2x3xf32>, tensor<f32>) -> tensor<2xf32>
+    %4 = stablehlo.dot_general %arg0, %arg1, contracting_dims = [1] x [0], precision = [DEFAULT, DEFAULT] : (tensor<2x3xf32>, tensor<3x2xf32>) -> tensor<2x2xf32>
+    %5 = stablehlo.transpose %4, dims = [1, 0] : (tensor<2x2xf32>) -> tensor<2x2xf32>
+    %6 = call @relu(%5) : (tensor<2x2xf32>) -> tensor<2x2xf32>
+    %c = stablehlo.constant dense<0> : tensor<i32>
+    %7:2 = stablehlo.while(%iterArg = %c, %iterArg_1 = %c) : tensor<i32>, tensor<i32>
+     cond {
+      %c_6 = stablehlo.constant dense<5> : tensor<i32>
+      %20 = stablehlo.compare  LT, %iterArg, %c_6,  SIGNED : (tensor<i32>, tensor<i32>) -> tensor<i1>
+      stablehlo.return %20 : tensor<i1>
+    } do {
+      %c_6 = stablehlo.constant dense<1> : tensor<i32>
+      %20 = stablehlo.add %iterArg, %c_6 : tensor<i32>
+      %21 = stablehlo.add %iterArg_1, %iterArg : tensor<i32>
+      stablehlo.return %20, %21 : tensor<i32>, tensor<i32>
+    }
+    %cst_2 = stablehlo.constant dense<0xFF800000> : tensor<f32>
+    %c_3 = stablehlo.constant dense<0> : tensor<i32>
+    %8 = stablehlo.iota dim = 1 : tensor<2x3xi32>
+    %9:2 = stablehlo.reduce(%arg0 init: %cst_2), (%8 init: %c_3) across dimensions = [1] : (tensor<2x3xf32>, tensor<2x3xi32>, tensor<f32>, tensor<i32>) -> (tensor<2xf32>, tensor<2xi32>)
+     reducer(%arg2: tensor<f32>, %arg4: tensor<f32>) (%arg3: tensor<i32>, %arg5: tensor<i32>)  {
+      %20 = stablehlo.compare  GE, %arg2, %arg4,  FLOAT : (tensor<f32>, tensor<f32>) -> tensor<i1>
+      %21 = stablehlo.select %20, %arg2, %arg4 : tensor<i1>, tensor<f32>
+      %22 = stablehlo.select %20, %arg3, %arg5 : tensor<i1>, tensor<i32>
+      stablehlo.return %21, %22 : tensor<f32>, tensor<i32>
+    }
+    %c_4 = stablehlo.constant dense<1> : tensor<i32>
+    %10 = "stablehlo.case"(%c_4) ({
+      %20 = stablehlo.negate %3 : tensor<2xf32>
+      stablehlo.return %20 : tensor<2xf32>
+    }, {
+      stablehlo.return %3 : tensor<2xf32>
+    }) : (tensor<i32>) -> tensor<2xf32>
+    %11 = "stablehlo.sort"(%10) <{dimension = 0 : i64, is_stable = true}> ({
+    ^bb0(%arg2: tensor<f32>, %arg3: tensor<f32>):
+      %20 = stablehlo.compare  LT, %arg2, %arg3,  TOTALORDER : (tensor<f32>, tensor<f32>) -> tensor<i1>
+      stablehlo.return %20 : tensor<i1>
+    }) : (tensor<2xf32>) -> tensor<2xf32>
+    %12 = stablehlo.iota dim = 0 : tensor<2xi32>
+    %13 = stablehlo.convert %12 : (tensor<2xi32>) -> tensor<2xf32>
+    %14 = stablehlo.concatenate %11, %13, dim = 0 : (tensor<2xf32>, tensor<2xf32>) -> tensor<4xf32>
+    %15 = stablehlo.slice %14 [1:4:2] : (tensor<4xf32>) -> tensor<2xf32>
+    %16 = stablehlo.reshape %15 : (tensor<2xf32>) -> tensor<1x2xf32>
+    return %3, %6, %7#1, %9#1, %16 : tensor<2xf32>, tensor<2x2xf32>, tensor<i32>, tensor<2xi32>, tensor<1x2xf32>
+  }
+  func.func private @relu(%arg0: tensor<2x2xf32>) -> tensor<2x2xf32> {
+    %cst = stablehlo.constant dense<0.000000e+00> : tensor<f32>
+    %0 = stablehlo.broadcast_in_dim %cst, dims = [] : (tensor<f32>) -> tensor<2x2xf32>
+    %1 = stablehlo.maximum %arg0, %0 : tensor<2x2xf32>
+    return %1 : tensor<2x2xf32>
+  }
+}
+#loc3 = loc("jit(f)/sin"(#loc1))
@@ -0,0 +1,232 @@
+package stablehlo
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Channel types used by Send and Recv, as defined by XLA's ChannelHandle.ChannelType.
+const (
+	channelTypeDeviceToDevice = 1
+	channelTypeDeviceToHost   = 2
+	channelTypeHostToDevice   = 3
+)
+
+// TokenShape is the shape of tokens: values of dtype dtypes.TOKEN that carry no data, used to order
+// side-effecting operations (Infeed, Outfeed, Send and Recv). Each of these takes a token as input and returns
+// a new token, ready once the operation is executed.
+//
+// Use Function.CreateToken to create the first token, and AfterAll to join tokens.
+var TokenShape = shapes.Make(dtypes.TOKEN)
+
+// isToken returns whether the value is a token.
+func isToken(value *Value) bool {
+	return value.shape.DType == dtypes.TOKEN && !value.shape.IsTuple()
+}
+
+// CreateToken returns a new token, used to order side-effecting operations like Infeed, Outfeed, Send and Recv.
+//
+// It is the same as AfterAll without any tokens.
+func (fn *Function) CreateToken() (*Value, error) {
+	op := optypes.AfterAll
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	stmt := fn.addOp(op, TokenShape)
+	return stmt.Outputs[0], nil
+}
+
+// AfterAll returns a token that is ready after all the given tokens are ready.
+//
+// It is used to join side-effecting operations. See Function.CreateToken to create a token without any dependencies.
+func AfterAll(tokens ...*Value) (*Value, error) {
+	op := optypes.AfterAll
+	if len(tokens) == 0 {
+		return nil, errors.New("AfterAll requires at least one token, use Function.CreateToken to create a new token")
+	}
+	fn, err := innerMostFunction(tokens...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	for i, token := range tokens {
+		if !isToken(token) {
+			return nil, errors.Errorf("%s requires tokens as inputs, but input #%d has shape %s", op, i, token.shape)
+		}
+	}
+	stmt := fn.addOp(op, TokenShape, tokens...)
+	return stmt.Outputs[0], nil
+}
+
+// Infeed reads values of the given shapes from the device's infeed queue, which is filled by the host.
+//
+// The config is an implementation-defined string passed to the backend, usually left empty.
+//
+// It returns the values read and a new token, ready once the values are read.
+//
+// See https://openxla.org/stablehlo/spec#infeed
+func Infeed(token *Value, outputShapes []shapes.Shape, config string) (values []*Value, newToken *Value, err error) {
+	op := optypes.Infeed
+	fn := token.fn
+	if fn.Returned {
+		return nil, nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if !isToken(token) {
+		return nil, nil, errors.Errorf("%s requires a token, got shape %s", op, token.shape)
+	}
+	if err = checkTransferShapes(op, outputShapes); err != nil {
+		return nil, nil, err
+	}
+	stmt := fn.addMultiOp(op, append(slices.Clone(outputShapes), TokenShape), []*Value{token})
+	stmt.Attributes = map[string]any{
+		"infeed_config": config,
+	}
+	numValues := len(outputShapes)
+	return stmt.Outputs[:numValues], stmt.Outputs[numValues], nil
+}
+
+// Outfeed writes the values to the device's outfeed queue, to be read by the host.
+//
+// The config is an implementation-defined string passed to the backend, usually left empty.
+//
+// It returns a new token, ready once the values are written.
+//
+// See https://openxla.org/stablehlo/spec#outfeed
+func Outfeed(token *Value, values []*Value, config string) (*Value, error) {
+	op := optypes.Outfeed
+	fn, err := innerMostFunction(append(slices.Clone(values), token)...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if !isToken(token) {
+		return nil, errors.Errorf("%s requires a token, got shape %s", op, token.shape)
+	}
+	if err = checkTransferShapes(op, valuesToShapes(values)); err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, TokenShape, append(slices.Clone(values), token)...)
+	stmt.Attributes = map[string]any{
+		"outfeed_config": config,
+	}
+	return stmt.Outputs[0], nil
+}
+
+// Send sends the values through a channel, to be received by a matching Recv in another program (or device),
+// or by the host if isHostTransfer is true.
+//
+// The config is used to set the channel ID (its ChannelType is not used): matching Send and Recv ops must use
+// the same ChannelID. For host transfers, the ChannelID is required, and it's the one used to register the
+// host callback with pjrt.ExecutionConfig.WithHostSendCallback.
+//
+// It returns a new token, ready once the values are sent.
+//
+// See https://openxla.org/stablehlo/spec#send
+func Send(token *Value, values []*Value, isHostTransfer bool, config ...*types.CollectiveConfig) (*Value, error) {
+	op := optypes.Send
+	fn, err := innerMostFunction(append(slices.Clone(values), token)...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if !isToken(token) {
+		return nil, errors.Errorf("%s requires a token, got shape %s", op, token.shape)
+	}
+	if err = checkTransferShapes(op, valuesToShapes(values)); err != nil {
+		return nil, err
+	}
+	channelHandle, err := fn.Builder.getSendRecvChannelHandle(op, isHostTransfer, channelTypeDeviceToHost, config)
+	if err != nil {
+		return nil, err
+	}
+	stmt := fn.addOp(op, TokenShape, append(slices.Clone(values), token)...)
+	stmt.Attributes = map[string]any{
+		"channel_handle":   channelHandle,
+		"is_host_transfer": isHostTransfer,
+	}
+	return stmt.Outputs[0], nil
+}
+
+// Recv receives values of the given shapes through a channel, sent by a matching Send in another program
+// (or device), or by the host if isHostTransfer is true.
+//
+// The config is used to set the channel ID (its ChannelType is not used): matching Send and Recv ops must use
+// the same ChannelID. For host transfers, the ChannelID is required, and it's the one used to register the
+// host callback with pjrt.ExecutionConfig.WithHostRecvCallback.
+//
+// It returns the values received and a new token, ready once the values are received.
+//
+// See https://openxla.org/stablehlo/spec#recv
+func Recv(token *Value, outputShapes []shapes.Shape, isHostTransfer bool, config ...*types.CollectiveConfig) (
+	values []*Value, newToken *Value, err error) {
+	op := optypes.Recv
+	fn := token.fn
+	if fn.Returned {
+		return nil, nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if !isToken(token) {
+		return nil, nil, errors.Errorf("%s requires a token, got shape %s", op, token.shape)
+	}
+	if err = checkTransferShapes(op, outputShapes); err != nil {
+		return nil, nil, err
+	}
+	channelHandle, err := fn.Builder.getSendRecvChannelHandle(op, isHostTransfer, channelTypeHostToDevice, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt := fn.addMultiOp(op, append(slices.Clone(outputShapes), TokenShape), []*Value{token})
+	stmt.Attributes = map[string]any{
+		"channel_handle":   channelHandle,
+		"is_host_transfer": isHostTransfer,
+	}
+	numValues := len(outputShapes)
+	return stmt.Outputs[:numValues], stmt.Outputs[numValues], nil
+}
+
+// checkTransferShapes checks that the shapes of values transferred by op are valid tensors.
+func checkTransferShapes(op optypes.OpType, transferShapes []shapes.Shape) error {
+	for i, shape := range transferShapes {
+		if !shape.Ok() || shape.IsTuple() || shape.DType == dtypes.TOKEN {
+			return errors.Errorf("%s can only transfer tensors, but value #%d has shape %s", op, i, shape)
+		}
+	}
+	return nil
+}
+
+// getSendRecvChannelHandle returns the channel_handle attribute for Send or Recv ops.
+// The hostChannelType is the type used if isHostTransfer is true, in which case the config ChannelID is required.
+func (b *Builder) getSendRecvChannelHandle(op optypes.OpType, isHostTransfer bool, hostChannelType int,
+	config []*types.CollectiveConfig) (literalStr, error) {
+	var cfg *types.CollectiveConfig
+	if len(config) > 1 {
+		return "", errors.Errorf("only one config can be provided, got %d", len(config))
+	} else if len(config) == 1 {
+		cfg = config[0]
+	}
+	typ := channelTypeDeviceToDevice
+	if isHostTransfer {
+		if cfg == nil || cfg.ChannelID == nil {
+			return "", errors.Errorf("%s with host transfer requires a config with the ChannelID set, "+
+				"to match the host callback", op)
+		}
+		typ = hostChannelType
+	}
+	return literalStrF("#stablehlo.channel_handle<handle = %d, type = %d>", b.getChannelID(cfg), typ), nil
+}
@@ -0,0 +1,105 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+)
+
+func TestTokens(t *testing.T) {
+	t.Run("host transfers", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		token := must1(fn.CreateToken())
+		values, inToken := must2(Infeed(token, []shapes.Shape{shapes.Make(dtypes.Float32, 3)}, ""))
+		outToken := must1(Outfeed(inToken, values, ""))
+		sendChannel, recvChannel := 1, 2
+		sendToken := must1(Send(outToken, values, true, &types.CollectiveConfig{ChannelID: &sendChannel}))
+		received, recvToken := must2(Recv(token, []shapes.Shape{shapes.Make(dtypes.Int32)}, true,
+			&types.CollectiveConfig{ChannelID: &recvChannel}))
+		joined := must1(AfterAll(sendToken, recvToken))
+		if err := fn.Return(received[0], joined); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s\n", t.Name(), program)
+		want := `module @TestTokens_host_transfers {
+  func.func @main() -> (tensor<i32>, !stablehlo.token) {
+    %0 = "stablehlo.after_all"() : () -> !stablehlo.token
+    %1, %2 = "stablehlo.infeed"(%0) { infeed_config = "" } : (!stablehlo.token) -> (tensor<3xf32>, !stablehlo.token)
+    %3 = "stablehlo.outfeed"(%1, %2) { outfeed_config = "" } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %4 = "stablehlo.send"(%1, %3) {
+      channel_handle = #stablehlo.channel_handle<handle = 1, type = 2>,
+      is_host_transfer = true
+    } : (tensor<3xf32>, !stablehlo.token) -> !stablehlo.token
+    %5, %6 = "stablehlo.recv"(%0) {
+      channel_handle = #stablehlo.channel_handle<handle = 2, type = 3>,
+      is_host_transfer = true
+    } : (!stablehlo.token) -> (tensor<i32>, !stablehlo.token)
+    %7 = "stablehlo.after_all"(%4, %6) : (!stablehlo.token, !stablehlo.token) -> !stablehlo.token
+    "stablehlo.return"(%5, %7) : (tensor<i32>, !stablehlo.token) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("device transfers", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2)))
+		token := must1(fn.CreateToken())
+		token = must1(Send(token, []*Value{x}, false))
+		if err := fn.Return(token); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s\n", t.Name(), program)
+		want := `module @TestTokens_device_transfers {
+  func.func @main(%x: tensor<2xf32>) -> !stablehlo.token {
+    %0 = "stablehlo.after_all"() : () -> !stablehlo.token
+    %1 = "stablehlo.send"(%x, %0) {
+      channel_handle = #stablehlo.channel_handle<handle = 0, type = 1>,
+      is_host_transfer = false
+    } : (tensor<2xf32>, !stablehlo.token) -> !stablehlo.token
+    "stablehlo.return"(%1) : (!stablehlo.token) -> ()
+  }
+}
+`
+		if program != want {
+			fmt.Printf("  Failed. Wanted the following program:\n%s", want)
+			t.Fatal("programs don't match")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2)))
+		token := must1(fn.CreateToken())
+		if _, err := AfterAll(); err == nil {
+			t.Error("expected error for AfterAll without tokens, got nil")
+		}
+		if _, err := AfterAll(token, x); err == nil {
+			t.Error("expected error for AfterAll with a non-token, got nil")
+		}
+		if _, _, err := Infeed(x, []shapes.Shape{shapes.Make(dtypes.Float32)}, ""); err == nil {
+			t.Error("expected error for Infeed without a token, got nil")
+		}
+		if _, err := Outfeed(token, []*Value{token}, ""); err == nil {
+			t.Error("expected error for Outfeed of a token, got nil")
+		}
+		if _, err := Send(token, []*Value{x}, true); err == nil {
+			t.Error("expected error for Send with host transfer without a ChannelID, got nil")
+		}
+		if _, _, err := Recv(token, []shapes.Shape{TokenShape}, false); err == nil {
+			t.Error("expected error for Recv of a token, got nil")
+		}
+	})
+}
@@ -0,0 +1,804 @@
+package stablehlo
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/attributes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/internal/shapeinference"
+	"github.com/gomlx/go-xla/internal/utils"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Verify checks the program for mistakes that would otherwise only be reported by the compiler (PJRT), as
+// opaque MLIR errors. It walks every function, closure and statement of the program, and checks:
+//
+//   - That every function returns, and that the return is its last statement.
+//   - The scoping of the operands: values must be defined (before being used) in the function or in one of the
+//     enclosing functions of a closure, and their shapes must match their definitions.
+//   - The output shapes recorded when the statements were created, re-running the shape inference of the operations
+//     that only depend on the shapes of their operands and closures: element-wise operations, Select, Clamp, Complex,
+//     Real, Imag, IsFinite, Cholesky, Call, Composite, If, Case and While; and of the operations that also depend on
+//     their attributes: DotGeneral, Reduce, Gather, Scatter, Slice, Transpose, Reshape, BroadcastInDim, Concatenate,
+//     Pad, Convolution and Iota. The output shapes of the other operations are not checked.
+//   - That func.call callees and composite decompositions reference functions of the program.
+//   - That Shardy shardings reference meshes registered with WithShardy, and that the mesh names are unique.
+//   - That attributes (of statements, inputs and outputs) are of a supported type and well-formed.
+//
+// It returns nil if the program is valid, or otherwise an error listing every problem found, each with the
+// function and the statement where it was found. The error also lists the operations whose output shapes were
+// not checked.
+//
+// Since not every operation is fully checked, a program that passes Verify may still be rejected by the compiler.
+func (b *Builder) Verify() error {
+	v := &verifier{
+		builder:   b,
+		functions: make(map[string]*Function),
+		meshes:    utils.MakeSet[string](len(b.meshes)),
+		unchecked: utils.MakeSet[string](),
+	}
+	v.verifyProgram()
+	if len(v.problems) == 0 {
+		return nil
+	}
+	report := fmt.Sprintf("program verification found %d problem(s):\n\t%s",
+		len(v.problems), strings.Join(v.problems, "\n\t"))
+	if len(v.unchecked) > 0 {
+		report += fmt.Sprintf("\n(output shapes not checked for: %s)",
+			strings.Join(slices.Sorted(maps.Keys(v.unchecked)), ", "))
+	}
+	return errors.New(report)
+}
+
+// verifier holds the state of Builder.Verify.
+type verifier struct {
+	builder *Builder
+
+	// functions are the top-level functions, indexed by their normalized name.
+	functions map[string]*Function
+
+	// meshes holds the names of the registered meshes.
+	meshes utils.Set[string]
+
+	// unchecked holds the operations whose output shapes were not checked, because verifyOutputShapes doesn't
+	// support them.
+	unchecked utils.Set[string]
+
+	problems []string
+}
+
+// addProblem records a problem found at the given location.
+func (v *verifier) addProblem(location, format string, args ...any) {
+	v.problems = append(v.problems, location+": "+fmt.Sprintf(format, args...))
+}
+
+// functionLocation describes the location of a function (or closure) for problem reports.
+func functionLocation(path string) string {
+	return fmt.Sprintf("function %q", path)
+}
+
+// statementLocation describes the location of a statement for problem reports: e.g.
+// `function "main", statement #3 (stablehlo.add -> %5)`.
+func statementLocation(path string, idx int, stmt *Statement) string {
+	var sb strings.Builder
+	sb.WriteString(stmt.OpType.ToStableHLO())
+	for i, output := range stmt.Outputs {
+		if i == 0 {
+			sb.WriteString(" -> ")
+		} else {
+			sb.WriteString(", ")
+		}
+		if output == nil {
+			sb.WriteString("<nil>")
+		} else {
+			sb.WriteString(output.String())
+		}
+	}
+	return fmt.Sprintf("function %q, statement #%d (%s)", path, idx, sb.String())
+}
+
+// verifyProgram checks the meshes and the top-level functions of the program.
+func (v *verifier) verifyProgram() {
+	b := v.builder
+	for _, mesh := range b.meshes {
+		if v.meshes.Has(mesh.Name()) {
+			v.addProblem("program", "duplicate mesh name %q", mesh.Name())
+		}
+		v.meshes.Insert(mesh.Name())
+	}
+
+	var topLevel []*Function
+	for _, fn := range b.functions {
+		if fn.Parent != nil {
+			// Closures are verified with the statements that use them.
+			continue
+		}
+		name := NormalizeIdentifier(fn.Name)
+		if _, found := v.functions[name]; found {
+			v.addProblem(functionLocation(fn.Name), "duplicate function name %q", name)
+			continue
+		}
+		v.functions[name] = fn
+		topLevel = append(topLevel, fn)
+	}
+	if _, found := v.functions["main"]; !found {
+		v.addProblem("program", "program must have a main function")
+	}
+	for _, fn := range topLevel {
+		v.verifyFunction(fn, fn.Name, make(map[string]shapes.Shape))
+	}
+}
+
+// verifyFunction checks the function fn and, recursively, its closures.
+//
+// The scope maps the names of the values visible in fn (including those of its enclosing functions) to their
+// shapes, and it is updated with the values defined by fn.
+func (v *verifier) verifyFunction(fn *Function, path string, scope map[string]shapes.Shape) {
+	location := functionLocation(path)
+	if fn.Builder != v.builder {
+		v.addProblem(location, "function is from a different builder")
+	}
+	for i, input := range fn.Inputs {
+		if input == nil {
+			v.addProblem(location, "input #%d is nil", i)
+			continue
+		}
+		if input.fn != fn {
+			v.addProblem(location, "input #%d (%s) belongs to another function", i, input)
+		}
+		v.define(location, scope, input)
+		v.verifyValueAttributes(fmt.Sprintf("%s, input #%d (%s)", location, i, input), input.Attributes)
+	}
+
+	numStatements := len(fn.Statements)
+	if !fn.Returned || numStatements == 0 || fn.Statements[numStatements-1] == nil ||
+		fn.Statements[numStatements-1].OpType != optypes.FuncReturn {
+		v.addProblem(location, "function doesn't end with a return statement (Function.Return was not called)")
+	}
+	for idx, stmt := range fn.Statements {
+		if stmt == nil {
+			v.addProblem(location, "statement #%d is nil", idx)
+			continue
+		}
+		v.verifyStatement(fn, path, idx, stmt, scope)
+	}
+
+	// The outputs of the function are the values of the return statement.
+	if numStatements == 0 {
+		return
+	}
+	last := fn.Statements[numStatements-1]
+	if last == nil || last.OpType != optypes.FuncReturn {
+		return
+	}
+	location = statementLocation(path, numStatements-1, last)
+	if len(fn.Outputs) != len(last.Inputs) {
+		v.addProblem(location, "function has %d outputs, but it returns %d values", len(fn.Outputs), len(last.Inputs))
+		return
+	}
+	for i, output := range fn.Outputs {
+		if output == nil || last.Inputs[i] == nil {
+			v.addProblem(location, "output #%d is nil", i)
+			continue
+		}
+		if output.name != last.Inputs[i].name || !output.shape.Equal(last.Inputs[i].shape) {
+			v.addProblem(location, "output #%d is %s with shape %s, but the returned value is %s with shape %s",
+				i, output, output.shape, last.Inputs[i], last.Inputs[i].shape)
+		}
+		v.verifyValueAttributes(fmt.Sprintf("%s, output #%d (%s)", location, i, output), output.Attributes)
+	}
+}
+
+// define adds the value to the scope, reporting a problem if its name is already in use.
+func (v *verifier) define(location string, scope map[string]shapes.Shape, value *Value) {
+	if _, found := scope[value.name]; found {
+		v.addProblem(location, "value %s is defined more than once", value)
+	}
+	if !value.shape.Ok() {
+		v.addProblem(location, "value %s has an invalid shape", value)
+	}
+	scope[value.name] = value.shape
+}
+
+// verifyStatement checks the statement at position idx of fn, and defines its outputs in the scope.
+func (v *verifier) verifyStatement(fn *Function, path string, idx int, stmt *Statement,
+	scope map[string]shapes.Shape) {
+	location := statementLocation(path, idx, stmt)
+	if stmt.Builder != v.builder {
+		v.addProblem(location, "statement is from a different builder")
+	}
+	if stmt.Function != fn {
+		v.addProblem(location, "statement belongs to another function")
+	}
+	if stmt.OpType == optypes.FuncReturn && idx != len(fn.Statements)-1 {
+		v.addProblem(location, "return must be the last statement of the function")
+	}
+
+	// Operands must be in scope.
+	for i, input := range stmt.Inputs {
+		if input == nil {
+			v.addProblem(location, "operand #%d is nil", i)
+			continue
+		}
+		if input.fn == nil {
+			v.addProblem(location, "operand #%d (%s) doesn't belong to any function", i, input)
+		} else if !isAncestor(input.fn, fn) {
+			v.addProblem(location, "operand #%d (%s) belongs to function %q, which is not %q or one of its "+
+				"enclosing functions", i, input, input.fn.Name, fn.Name)
+		}
+		shape, found := scope[input.name]
+		if !found {
+			v.addProblem(location, "operand #%d (%s) is used outside of its scope, or before it is defined", i, input)
+			continue
+		}
+		if !shape.Equal(input.shape) {
+			v.addProblem(location, "operand #%d (%s) has shape %s, but it was defined with shape %s",
+				i, input, input.shape, shape)
+		}
+	}
+
+	// Closures see the values defined so far, but the values they define are not visible outside them.
+	if len(stmt.FunctionParameters) != len(stmt.FunctionParametersNames) {
+		v.addProblem(location, "statement has %d closures, but %d closure names",
+			len(stmt.FunctionParameters), len(stmt.FunctionParametersNames))
+	}
+	for i, closure := range stmt.FunctionParameters {
+		name := fmt.Sprintf("closure%d", i)
+		if i < len(stmt.FunctionParametersNames) {
+			name = stmt.FunctionParametersNames[i]
+		}
+		if closure == nil {
+			v.addProblem(location, "closure %q is nil", name)
+			continue
+		}
+		if closure.Parent != fn {
+			v.addProblem(location, "closure %q was not created with Function.Closure() of function %q", name, fn.Name)
+		}
+		v.verifyFunction(closure, path+"/"+name, maps.Clone(scope))
+	}
+
+	v.verifyAttributes(location, stmt.Attributes)
+	v.verifyOutputShapes(location, stmt)
+
+	for i, output := range stmt.Outputs {
+		if output == nil {
+			v.addProblem(location, "output #%d is nil", i)
+			continue
+		}
+		if output.fn != fn {
+			v.addProblem(location, "output #%d (%s) belongs to another function", i, output)
+		}
+		v.define(location, scope, output)
+	}
+}
+
+// callee returns the function referenced by the symbol attribute of a Call or Composite statement,
+// or nil if it is not valid.
+func (v *verifier) callee(location string, stmt *Statement) *Function {
+	key := "callee"
+	if stmt.OpType == optypes.Composite {
+		key = "decomposition"
+	}
+	attr, found := stmt.Attributes[key]
+	if !found {
+		v.addProblem(location, "missing attribute %q", key)
+		return nil
+	}
+	ref, ok := attr.(symbolRef)
+	if !ok {
+		v.addProblem(location, "attribute %q must be a function symbol reference, got %T", key, attr)
+		return nil
+	}
+	callee, found := v.functions[NormalizeIdentifier(ref.name)]
+	if !found {
+		v.addProblem(location, "attribute %q references function %s, which is not defined in the program",
+			key, ref.ToStableHLO())
+		return nil
+	}
+	return callee
+}
+
+// verifyOutputShapes re-runs the shape inference of the operations that only depend on the shapes of their operands
+// and closures, or also on their attributes (see inferShapesWithAttributes), and compares it with the shapes of the
+// outputs of the statement.
+//
+// The other operations are recorded in v.unchecked.
+func (v *verifier) verifyOutputShapes(location string, stmt *Statement) {
+	if containsNil(stmt.Inputs) || containsNil(stmt.Outputs) {
+		// Already reported.
+		return
+	}
+	for _, closure := range stmt.FunctionParameters {
+		if closure == nil || containsNil(closure.Inputs) || containsNil(closure.Outputs) {
+			// Already reported.
+			return
+		}
+	}
+	inputs := valuesToShapes(stmt.Inputs)
+	var outputs []shapes.Shape
+	var err error
+	op := stmt.OpType
+	switch {
+	case shapeinference.StandardBinaryOperations.Has(op):
+		if len(inputs) != 2 {
+			v.addProblem(location, "%s requires 2 operands, got %d", op, len(inputs))
+			return
+		}
+		var output shapes.Shape
+		output, err = shapeinference.BinaryOp(op, inputs[0], inputs[1])
+		outputs = []shapes.Shape{output}
+	case shapeinference.StandardUnaryOperations.Has(op):
+		if len(inputs) != 1 {
+			v.addProblem(location, "%s requires 1 operand, got %d", op, len(inputs))
+			return
+		}
+		var output shapes.Shape
+		output, err = shapeinference.UnaryOp(op, inputs[0])
+		outputs = []shapes.Shape{output}
+	case op == optypes.Select || op == optypes.Clamp:
+		if len(inputs) != 3 {
+			v.addProblem(location, "%s requires 3 operands, got %d", op, len(inputs))
+			return
+		}
+		var output shapes.Shape
+		if op == optypes.Select {
+			output, err = shapeinference.Select(inputs[0], inputs[1], inputs[2])
+		} else {
+			output, err = shapeinference.Clamp(inputs[0], inputs[1], inputs[2])
+		}
+		outputs = []shapes.Shape{output}
+	case op == optypes.Complex:
+		if len(inputs) != 2 {
+			v.addProblem(location, "%s requires 2 operands, got %d", op, len(inputs))
+			return
+		}
+		var output shapes.Shape
+		output, err = shapeinference.Complex(inputs[0], inputs[1])
+		outputs = []shapes.Shape{output}
+	case op == optypes.Real || op == optypes.Imag || op == optypes.IsFinite || op == optypes.Cholesky:
+		if len(inputs) != 1 {
+			v.addProblem(location, "%s requires 1 operand, got %d", op, len(inputs))
+			return
+		}
+		var output shapes.Shape
+		switch op {
+		case optypes.IsFinite:
+			output, err = shapeinference.IsFinite(inputs[0])
+		case optypes.Cholesky:
+			output, err = shapeinference.Cholesky(inputs[0])
+		default:
+			output, err = shapeinference.RealOrImag(inputs[0])
+		}
+		outputs = []shapes.Shape{output}
+	case op == optypes.Call || op == optypes.Composite:
+		callee := v.callee(location, stmt)
+		if callee == nil || containsNil(callee.Inputs) || containsNil(callee.Outputs) {
+			return
+		}
+		outputs, err = shapeinference.Call(inputs, valuesToShapes(callee.Inputs), valuesToShapes(callee.Outputs))
+	case op == optypes.If:
+		if len(inputs) != 1 || len(stmt.FunctionParameters) != 2 {
+			v.addProblem(location, "%s requires 1 operand and 2 branches, got %d and %d",
+				op, len(inputs), len(stmt.FunctionParameters))
+			return
+		}
+		trueBranch, falseBranch := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+		outputs, err = shapeinference.If(inputs[0],
+			valuesToShapes(trueBranch.Inputs), valuesToShapes(trueBranch.Outputs),
+			valuesToShapes(falseBranch.Inputs), valuesToShapes(falseBranch.Outputs))
+	case op == optypes.Case:
+		if len(inputs) != 1 {
+			v.addProblem(location, "%s requires 1 operand, got %d", op, len(inputs))
+			return
+		}
+		branchesInputs := make([][]shapes.Shape, len(stmt.FunctionParameters))
+		branchesOutputs := make([][]shapes.Shape, len(stmt.FunctionParameters))
+		for i, branch := range stmt.FunctionParameters {
+			branchesInputs[i] = valuesToShapes(branch.Inputs)
+			branchesOutputs[i] = valuesToShapes(branch.Outputs)
+		}
+		outputs, err = shapeinference.Case(inputs[0], branchesInputs, branchesOutputs)
+	case op == optypes.While:
+		if len(stmt.FunctionParameters) != 2 {
+			v.addProblem(location, "%s requires 2 closures, got %d", op, len(stmt.FunctionParameters))
+			return
+		}
+		cond, body := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+		outputs, err = shapeinference.While(inputs,
+			valuesToShapes(cond.Inputs), valuesToShapes(cond.Outputs),
+			valuesToShapes(body.Inputs), valuesToShapes(body.Outputs))
+	default:
+		var supported bool
+		outputs, supported, err = inferShapesWithAttributes(stmt, inputs)
+		if !supported {
+			if op != optypes.FuncReturn {
+				v.unchecked.Insert(op.ToStableHLO())
+			}
+			return
+		}
+	}
+	if err != nil {
+		v.addProblem(location, "%v", err)
+		return
+	}
+	if len(outputs) != len(stmt.Outputs) {
+		v.addProblem(location, "statement has %d outputs, but %d are expected", len(stmt.Outputs), len(outputs))
+		return
+	}
+	for i, output := range stmt.Outputs {
+		if !output.shape.Equal(outputs[i]) {
+			v.addProblem(location, "output #%d (%s) has shape %s, but shape inference expects %s",
+				i, output, output.shape, outputs[i])
+		}
+	}
+}
+
+// inferShapesWithAttributes re-runs the shape inference of the operations whose output shapes depend on their
+// attributes. The attributes are decoded from their StableHLO text, so it works the same way for programs built
+// with the API or parsed from text.
+//
+// It returns supported=false for the operations it doesn't handle.
+func inferShapesWithAttributes(stmt *Statement, inputs []shapes.Shape) (
+	outputs []shapes.Shape, supported bool, err error) {
+	op := stmt.OpType
+	numOperands := map[optypes.OpType]int{
+		optypes.DotGeneral: 2, optypes.Gather: 2, optypes.Slice: 1, optypes.Transpose: 1, optypes.Reshape: 1,
+		optypes.BroadcastInDim: 1, optypes.Pad: 2, optypes.Convolution: 2, optypes.Iota: 0,
+	}
+	switch op {
+	case optypes.DotGeneral, optypes.Reduce, optypes.Gather, optypes.Scatter, optypes.Slice, optypes.Transpose,
+		optypes.Reshape, optypes.BroadcastInDim, optypes.Concatenate, optypes.Pad, optypes.Convolution, optypes.Iota:
+	default:
+		return nil, false, nil
+	}
+	if n, found := numOperands[op]; found && len(inputs) != n {
+		return nil, true, errors.Errorf("%s requires %d operands, got %d", op, n, len(inputs))
+	}
+	if op != optypes.Reduce && op != optypes.Scatter && len(stmt.Outputs) != 1 {
+		return nil, true, errors.Errorf("%s requires 1 output, got %d", op, len(stmt.Outputs))
+	}
+	var output shapes.Shape
+	switch op {
+	case optypes.DotGeneral:
+		var fields map[string]string
+		if fields, err = attributes.Struct(stmt, "dot_dimension_numbers"); err != nil {
+			break
+		}
+		var axes [4][]int
+		for i, name := range []string{"lhs_batching_dimensions", "rhs_batching_dimensions",
+			"lhs_contracting_dimensions", "rhs_contracting_dimensions"} {
+			if axes[i], err = attributes.StructInts(fields, name); err != nil {
+				return nil, true, err
+			}
+		}
+		output, err = shapeinference.DotGeneral(inputs[0], axes[2], axes[0], inputs[1], axes[3], axes[1],
+			stmt.Outputs[0].shape.DType)
+
+	case optypes.Reduce:
+		if len(inputs) == 0 || len(inputs)%2 != 0 || len(stmt.FunctionParameters) != 1 {
+			return nil, true, errors.Errorf("%s requires an even number of operands and 1 closure, got %d and %d",
+				op, len(inputs), len(stmt.FunctionParameters))
+		}
+		var axes []int
+		if axes, err = attributes.Ints(stmt, "dimensions"); err != nil {
+			break
+		}
+		reductionFn := stmt.FunctionParameters[0]
+		outputs, err = shapeinference.Reduce(inputs[:len(inputs)/2], inputs[len(inputs)/2:],
+			valuesToShapes(reductionFn.Inputs), valuesToShapes(reductionFn.Outputs), axes)
+		return outputs, true, err
+
+	case optypes.Gather:
+		var fields map[string]string
+		if fields, err = attributes.Struct(stmt, "dimension_numbers"); err != nil {
+			break
+		}
+		var axes [5][]int
+		for i, name := range []string{"offset_dims", "collapsed_slice_dims", "operand_batching_dims",
+			"start_indices_batching_dims", "start_index_map"} {
+			if axes[i], err = attributes.StructInts(fields, name); err != nil {
+				return nil, true, err
+			}
+		}
+		var indexVectorAxis int
+		if indexVectorAxis, err = attributes.StructInt(fields, "index_vector_dim"); err != nil {
+			break
+		}
+		var sliceSizes []int
+		if sliceSizes, err = attributes.Ints(stmt, "slice_sizes"); err != nil {
+			break
+		}
+		var indicesAreSorted bool
+		if indicesAreSorted, err = attributes.OptionalBool(stmt, "indices_are_sorted", false); err != nil {
+			break
+		}
+		output, err = shapeinference.Gather(inputs[0], inputs[1], indexVectorAxis,
+			axes[0], axes[1], axes[2], axes[3], axes[4], sliceSizes, indicesAreSorted)
+
+	case optypes.Scatter:
+		if len(inputs) < 3 || len(inputs)%2 != 1 || len(stmt.FunctionParameters) != 1 {
+			return nil, true, errors.Errorf("%s requires an odd number of operands (at least 3) and 1 closure, "+
+				"got %d and %d", op, len(inputs), len(stmt.FunctionParameters))
+		}
+		var fields map[string]string
+		if fields, err = attributes.Struct(stmt, "scatter_dimension_numbers"); err != nil {
+			break
+		}
+		var axes [5][]int
+		for i, name := range []string{"update_window_dims", "inserted_window_dims", "input_batching_dims",
+			"scatter_indices_batching_dims", "scatter_dims_to_operand_dims"} {
+			if axes[i], err = attributes.StructInts(fields, name); err != nil {
+				return nil, true, err
+			}
+		}
+		var indexVectorAxis int
+		if indexVectorAxis, err = attributes.StructInt(fields, "index_vector_dim"); err != nil {
+			break
+		}
+		numInputs := len(inputs) / 2
+		updateFn := stmt.FunctionParameters[0]
+		outputs, err = shapeinference.Scatter(inputs[:numInputs], inputs[numInputs], inputs[numInputs+1:],
+			axes[0], axes[1], axes[2], axes[3], axes[4], indexVectorAxis,
+			valuesToShapes(updateFn.Inputs), valuesToShapes(updateFn.Outputs))
+		return outputs, true, err
+
+	case optypes.Slice:
+		var starts, limits, strides []int
+		if starts, err = attributes.Ints(stmt, "start_indices"); err != nil {
+			break
+		}
+		if limits, err = attributes.Ints(stmt, "limit_indices"); err != nil {
+			break
+		}
+		if strides, err = attributes.OptionalInts(stmt, "strides", slices.Repeat([]int{1}, len(starts))); err != nil {
+			break
+		}
+		output, err = shapeinference.Slice(inputs[0], starts, limits, strides)
+
+	case optypes.Transpose:
+		var permutation []int
+		if permutation, err = attributes.Ints(stmt, "permutation"); err != nil {
+			break
+		}
+		output, err = shapeinference.Transpose(inputs[0], permutation)
+
+	case optypes.Reshape:
+		// The output shape is only given by the statement: only its dtype and size can be checked.
+		output = stmt.Outputs[0].shape
+		if inputs[0].DType != output.DType ||
+			(!inputs[0].IsDynamic() && !output.IsDynamic() && inputs[0].Size() != output.Size()) {
+			err = errors.Errorf("Reshape requires the same dtype and size for the operand and the output, "+
+				"got operand=%s and output=%s", inputs[0], output)
+		}
+
+	case optypes.BroadcastInDim:
+		// The output shape is only given by the statement: it is checked against the operand and the axes mapping.
+		var axesMapping []int
+		if axesMapping, err = attributes.Ints(stmt, "broadcast_dimensions"); err != nil {
+			break
+		}
+		output = stmt.Outputs[0].shape
+		err = shapeinference.BroadcastInDim(inputs[0], output, axesMapping)
+
+	case optypes.Concatenate:
+		var axis int
+		if axis, err = attributes.Int(stmt, "dimension"); err != nil {
+			break
+		}
+		output, err = shapeinference.Concatenate(inputs, axis)
+
+	case optypes.Pad:
+		var low, high, interior []int
+		if low, err = attributes.Ints(stmt, "edge_padding_low"); err != nil {
+			break
+		}
+		if high, err = attributes.Ints(stmt, "edge_padding_high"); err != nil {
+			break
+		}
+		if interior, err = attributes.Ints(stmt, "interior_padding"); err != nil {
+			break
+		}
+		output, err = shapeinference.Pad(inputs[0], inputs[1], low, high, interior)
+
+	case optypes.Convolution:
+		output, err = inferConvolutionShape(stmt, inputs[0], inputs[1])
+
+	case optypes.Iota:
+		// The output shape is only given by the statement: only the axis can be checked.
+		var axis int
+		if axis, err = attributes.Int(stmt, "iota_dimension"); err != nil {
+			break
+		}
+		output = stmt.Outputs[0].shape
+		if axis < 0 || axis >= output.Rank() {
+			err = errors.Errorf("Iota dimension %d is out of range for the output shape %s", axis, output)
+		}
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return []shapes.Shape{output}, true, nil
+}
+
+// inferConvolutionShape decodes the attributes of a Convolution statement and re-runs its shape inference.
+func inferConvolutionShape(stmt *Statement, input, kernel shapes.Shape) (shapes.Shape, error) {
+	inputAxes, kernelAxes, outputAxes, err := attributes.ConvDimensionNumbers(stmt, "dimension_numbers")
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	numSpatial := len(inputAxes.Spatial)
+	ones := slices.Repeat([]int{1}, numSpatial)
+	var config [4][]int
+	for i, attr := range []struct {
+		key          string
+		defaultValue []int
+	}{
+		{"window_strides", ones}, {"padding", make([]int, 2*numSpatial)}, {"lhs_dilation", ones}, {"rhs_dilation", ones},
+	} {
+		if config[i], err = attributes.OptionalInts(stmt, attr.key, attr.defaultValue); err != nil {
+			return shapes.Invalid(), err
+		}
+	}
+	if len(config[1]) != 2*numSpatial {
+		return shapes.Invalid(), errors.Errorf("Convolution requires %d padding values (low and high for each "+
+			"spatial axis), got %d", 2*numSpatial, len(config[1]))
+	}
+	paddings := make([][2]int, numSpatial)
+	for i := range paddings {
+		paddings[i] = [2]int{config[1][2*i], config[1][2*i+1]}
+	}
+	featureGroupCount, err := attributes.Int(stmt, "feature_group_count")
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	batchGroupCount, err := attributes.Int(stmt, "batch_group_count")
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	return shapeinference.Convolve(input, kernel, config[0], paddings, config[2], config[3],
+		inputAxes.BatchOrInput, inputAxes.FeatureOrOutput, inputAxes.Spatial,
+		kernelAxes.BatchOrInput, kernelAxes.FeatureOrOutput, kernelAxes.Spatial,
+		outputAxes.BatchOrInput, outputAxes.FeatureOrOutput, outputAxes.Spatial,
+		featureGroupCount, batchGroupCount)
+}
+
+// containsNil returns whether any of the values is nil.
+func containsNil(values []*Value) bool {
+	for _, value := range values {
+		if value == nil {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// attributeKeyRegexp matches valid attribute names, e.g.: "comparison_direction" or "sdy.sharding".
+	attributeKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$.]*$`)
+
+	// meshReferenceRegexp matches the references to meshes in Shardy attributes, e.g.: `#sdy.sharding<@mesh, ...>`.
+	meshReferenceRegexp = regexp.MustCompile(`@([A-Za-z0-9_$.\-]+)`)
+)
+
+// verifyAttributes checks that the attributes are of the types supported by literalToStableHLO and are well-formed.
+func (v *verifier) verifyAttributes(location string, attributes map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		if !attributeKeyRegexp.MatchString(key) {
+			v.addProblem(location, "invalid attribute name %q", key)
+		}
+		if err := v.verifyLiteral(attributes[key]); err != nil {
+			v.addProblem(location, "attribute %q: %v", key, err)
+		}
+	}
+}
+
+// verifyValueAttributes checks the attributes of a function input or output: the same as verifyAttributes,
+// plus that Shardy shardings reference registered meshes.
+func (v *verifier) verifyValueAttributes(location string, attributes map[string]any) {
+	v.verifyAttributes(location, attributes)
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		str, ok := attributes[key].(literalStr)
+		if !ok || !strings.HasPrefix(string(str), "#sdy.") {
+			continue
+		}
+		if len(v.builder.meshes) == 0 {
+			v.addProblem(location, "attribute %q uses Shardy, but no mesh was registered with Builder.WithShardy", key)
+			continue
+		}
+		for _, match := range meshReferenceRegexp.FindAllStringSubmatch(string(str), -1) {
+			if !v.meshes.Has(match[1]) {
+				v.addProblem(location, "attribute %q references mesh %q, which was not registered with "+
+					"Builder.WithShardy", key, match[1])
+			}
+		}
+	}
+}
+
+// verifyLiteral checks that the attribute value is of one of the types supported by literalToStableHLO, and
+// that it is well-formed.
+func (v *verifier) verifyLiteral(attr any) error {
+	switch value := attr.(type) {
+	case string, float32, float64, int, int8, int16, int32, int64, uint8, uint16, uint32, uint64, bool:
+		return nil
+	case literalStr:
+		if value == "" {
+			return errors.New("empty value")
+		}
+		return checkDelimiters(string(value))
+	case symbolRef:
+		if value.name == "" {
+			return errors.New("empty symbol reference")
+		}
+		return nil
+	case tensorLiteral:
+		if value.value == nil {
+			return errors.New("tensor literal has no value")
+		}
+		valueV := reflect.ValueOf(value.value)
+		if valueV.Kind() != reflect.Slice && valueV.Kind() != reflect.Array {
+			if len(value.dims) != 0 {
+				return errors.Errorf("tensor literal with dimensions %v has a scalar value", value.dims)
+			}
+			return nil
+		}
+		size := 1
+		for _, dim := range value.dims {
+			size *= dim
+		}
+		if valueV.Len() != size {
+			return errors.Errorf("tensor literal with dimensions %v has %d values", value.dims, valueV.Len())
+		}
+		return nil
+	case nil:
+		return errors.New("nil value")
+	default:
+		if _, ok := attr.(hasToStableHLO); ok {
+			return nil
+		}
+		return errors.Errorf("unsupported attribute type %T", attr)
+	}
+}
+
+// checkDelimiters checks that the parentheses, brackets, braces and angle brackets of a literal are balanced,
+// ignoring the contents of quoted strings and the arrow "->".
+func checkDelimiters(text string) error {
+	closing := map[byte]byte{'(': ')', '[': ']', '{': '}', '<': '>'}
+	var stack []byte
+	for pos := 0; pos < len(text); pos++ {
+		c := text[pos]
+		switch c {
+		case '"':
+			pos++
+			for pos < len(text) && text[pos] != '"' {
+				if text[pos] == '\\' {
+					pos++
+				}
+				pos++
+			}
+			if pos >= len(text) {
+				return errors.Errorf("unterminated string in %q", text)
+			}
+		case '-':
+			if strings.HasPrefix(text[pos:], "->") {
+				pos++
+			}
+		case '(', '[', '{', '<':
+			stack = append(stack, closing[c])
+		case ')', ']', '}', '>':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return errors.Errorf("unbalanced %q in %q", c, text)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) > 0 {
+		return errors.Errorf("missing %q in %q", stack[len(stack)-1], text)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package stablehlo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-xla/pkg/types"
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/gomlx/go-xla/pkg/types/shapes"
+	"github.com/gomlx/go-xla/pkg/types/shardy"
+)
+
+// requireVerifyErrors checks that Verify fails, and that the error includes each of the given texts.
+func requireVerifyErrors(t *testing.T, b *Builder, wants ...string) {
+	t.Helper()
+	err := b.Verify()
+	if err == nil {
+		t.Fatal("expected Verify to fail, got no error")
+	}
+	t.Logf("Verify error: %v", err)
+	for _, want := range wants {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected Verify error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// newProgram builds a program that calls a function, reduces with a closure and uses a conditional.
+	newProgram := func(name string) (*Builder, *Function) {
+		b := New(name)
+		double := b.NewFunction("double")
+		y := must1(double.Input(shapes.Make(dtypes.F32, 3)))
+		must(double.Return(must1(Add(y, y))))
+
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		doubled := must1(Call(double, x))[0]
+
+		reductionFn := fn.Closure()
+		lhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+		rhs := must1(reductionFn.Input(shapes.Make(dtypes.F32)))
+		must(reductionFn.Return(must1(Add(lhs, rhs))))
+		sum := must1(Reduce(doubled, must1(fn.ConstantFromScalar(float32(0))), reductionFn, 0))
+
+		isPositive := must1(Compare(sum, must1(fn.ConstantFromScalar(float32(0))), types.CompareGT, types.CompareFloat))
+		trueBranch := fn.Closure()
+		must(trueBranch.Return(must1(Negate(must1(trueBranch.UseParentValue(sum))))))
+		falseBranch := fn.Closure()
+		must(falseBranch.Return(must1(falseBranch.UseParentValue(sum))))
+		result := must1(If(isPositive, trueBranch, falseBranch))[0]
+		must(fn.Return(result))
+		return b, fn
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		b, _ := newProgram(t.Name())
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		program := must1(b.Build())
+		t.Logf("%s program:\n%s", t.Name(), program)
+		parsed := must1(Parse(program))
+		if err := parsed.Verify(); err != nil {
+			t.Fatalf("Verify of the parsed program failed: %v", err)
+		}
+	})
+
+	t.Run("unreturned closure", func(t *testing.T) {
+		b, fn := newProgram(t.Name())
+		reduce := fn.Statements[2]
+		closure := reduce.FunctionParameters[0]
+		closure.Statements = closure.Statements[:len(closure.Statements)-1]
+		closure.Returned = false
+		requireVerifyErrors(t, b, `function "main/reductionFn": function doesn't end with a return statement`)
+	})
+
+	t.Run("value out of scope", func(t *testing.T) {
+		b, fn := newProgram(t.Name())
+		ifStmt := fn.Statements[len(fn.Statements)-2]
+		negated := ifStmt.FunctionParameters[0].Statements[0].Outputs[0]
+		fn.Statements[len(fn.Statements)-1].Inputs[0] = negated
+		requireVerifyErrors(t, b,
+			`function "main", statement #6 (stablehlo.return): operand #0 (%6) belongs to function "closure1"`,
+			`operand #0 (%6) is used outside of its scope`)
+	})
+
+	t.Run("unknown callee", func(t *testing.T) {
+		b, fn := newProgram(t.Name())
+		call := fn.Statements[0]
+		call.Attributes["callee"] = symbolRef{name: "triple"}
+		requireVerifyErrors(t, b,
+			`function "main", statement #0 (func.call -> %0): attribute "callee" references function @triple`)
+	})
+
+	t.Run("wrong output shape", func(t *testing.T) {
+		b, _ := newProgram(t.Name())
+		add := b.functions[0].Statements[0]
+		add.Outputs[0].shape = shapes.Make(dtypes.F32, 4)
+		requireVerifyErrors(t, b,
+			`function "double", statement #0 (stablehlo.add -> %0): output #0 (%0) has shape (Float32)[4], `+
+				`but shape inference expects (Float32)[3]`,
+			`(output shapes not checked for: stablehlo.compare, stablehlo.constant)`)
+	})
+
+	t.Run("output shapes from attributes", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2, 3)))
+		y := must1(fn.NamedInput("y", shapes.Make(dtypes.F32, 3, 4)))
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		sumFn := func() *Function {
+			closure := fn.Closure()
+			lhs := must1(closure.Input(shapes.Make(dtypes.F32)))
+			rhs := must1(closure.Input(shapes.Make(dtypes.F32)))
+			must(closure.Return(must1(Add(lhs, rhs))))
+			return closure
+		}
+		// One of each operation whose output shape depends on its attributes.
+		dot := must1(DotGeneral(x, []int{1}, nil, y, []int{0}, nil).Done())
+		transposed := must1(Transpose(dot, 1, 0))
+		reshaped := must1(Reshape(transposed, shapes.Make(dtypes.F32, 8)))
+		sliced := must1(Slice(reshaped, []int{1}, []int{7}, []int{2}))
+		broadcast := must1(BroadcastInDim(sliced, shapes.Make(dtypes.F32, 2, 3), []int{1}))
+		padded := must1(Pad(must1(Concatenate(0, broadcast, x)), zero, []int{1, 0}, []int{0, 0}, []int{0, 0}))
+		indices := must1(fn.Iota(shapes.Make(dtypes.Int32, 2, 1), 0))
+		gathered := must1(Gather(padded, indices, 1, []int{1}, []int{0}, nil, nil, []int{0}, []int{1, 3}, false))
+		scattered := must1(Scatter(padded, indices, gathered, []int{1}, []int{0}, nil, nil, []int{0}, 1,
+			false, false, sumFn()))
+		kernel := must1(fn.ConstantFromFlatAndDimensions([]float32{1, 2, 3, 4, 5, 6}, 2, 3, 1))
+		conv := must1(Convolution(must1(Reshape(scattered, shapes.Make(dtypes.F32, 1, 5, 3))), kernel,
+			nil, nil, nil, nil, 0, 2, []int{1}, 1, 2, []int{0}, 0, 2, []int{1}, 1, 1,
+			types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))
+		must(fn.Return(must1(Reduce(scattered, zero, sumFn(), 0)), conv))
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		parsed := must1(Parse(must1(b.Build())))
+		if err := parsed.Verify(); err != nil {
+			t.Fatalf("Verify of the parsed program failed: %v", err)
+		}
+
+		transposed.shape = shapes.Make(dtypes.F32, 2, 4)
+		sliced.stmt.Attributes["limit_indices"] = intSliceToArrayI64StableHLO([]int{9})
+		requireVerifyErrors(t, b,
+			`(stablehlo.transpose -> %2): output #0 (%2) has shape (Float32)[2 4], but shape inference expects (Float32)[4 2]`,
+			`(stablehlo.slice -> %4): Slice: limit index 9 is out of bounds for axis 0`)
+	})
+
+	t.Run("complex output shape", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.C64, 2)))
+		must(fn.Return(must1(Real(x)), must1(IsFinite(must1(Imag(x))))))
+		if err := b.Verify(); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		fn.Statements[0].Outputs[0].shape = shapes.Make(dtypes.C64, 2)
+		requireVerifyErrors(t, b, `output #0 (%0) has shape (Complex64)[2], but shape inference expects (Float32)[2]`)
+	})
+
+	t.Run("unregistered mesh", func(t *testing.T) {
+		b := New(t.Name())
+		mesh := must1(shardy.NewDeviceMesh("mesh", []int{2}, []string{"data"}))
+		otherMesh := must1(shardy.NewDeviceMesh("other_mesh", []int{2}, []string{"data"}))
+		b.WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 4),
+			b.NewShardingSpec().AddShardedAxis("data")))
+		must(fn.ReturnWithShardingAndAttributes([]*Value{must1(Negate(x))},
+			[]*shardy.ShardingSpec{shardy.NewShardingSpec(otherMesh).AddShardedAxis("data")}, nil))
+		requireVerifyErrors(t, b, `references mesh "other_mesh", which was not registered with Builder.WithShardy`)
+	})
+
+	t.Run("malformed attributes", func(t *testing.T) {
+		b, fn := newProgram(t.Name())
+		reduce := fn.Statements[2]
+		reduce.Attributes["dimensions"] = []int{0}
+		reduce.Attributes["custom.attr"] = literalStr("array<i64: 0")
+		requireVerifyErrors(t, b,
+			`attribute "dimensions": unsupported attribute type []int`,
+			`attribute "custom.attr": missing '>'`)
+	})
+}
@@ -476,6 +476,39 @@ func (dtype DType) RealDType() DType {
 	}
 }
 
+// ExponentAndMantissaBits returns the number of bits of the exponent and of the mantissa (not including the
+// implicit leading bit) of floating point dtypes, including the F8 and F4 variants, which are not otherwise supported.
+//
+// They can be used with the ReducePrecision op to emulate the rounding to a lower precision float, e.g.:
+// F8E4M3FN has 4 exponent bits and 3 mantissa bits. Notice the emulation doesn't include the differences in
+// exponent bias or in special values (e.g.: the "FN" variants have no infinities).
+//
+// It returns ok=false for non-float dtypes (including complex).
+func (dtype DType) ExponentAndMantissaBits() (exponentBits, mantissaBits int, ok bool) {
+	switch dtype {
+	case Float64:
+		return 11, 52, true
+	case Float32:
+		return 8, 23, true
+	case Float16:
+		return 5, 10, true
+	case BFloat16:
+		return 8, 7, true
+	case F8E5M2, F8E5M2FNUZ:
+		return 5, 2, true
+	case F8E4M3, F8E4M3FN, F8E4M3FNUZ, F8E4M3B11FNUZ:
+		return 4, 3, true
+	case F8E3M4:
+		return 3, 4, true
+	case F8E8M0FNU:
+		return 8, 0, true
+	case F4E2M1FN:
+		return 2, 1, true
+	default:
+		return 0, 0, false
+	}
+}
+
 // IsInt returns whether dtype is a supported integer type -- float types not yet supported will return false.
 func (dtype DType) IsInt() bool {
 	return IntDTypes[dtype]
@@ -103,6 +103,32 @@ func TestSizeForDimensions(t *testing.T) {
 	}
 }
 
+func TestExponentAndMantissaBits(t *testing.T) {
+	for _, tc := range []struct {
+		dtype                      DType
+		exponentBits, mantissaBits int
+	}{
+		{Float64, 11, 52},
+		{Float32, 8, 23},
+		{Float16, 5, 10},
+		{BFloat16, 8, 7},
+		{F8E4M3FN, 4, 3},
+		{F8E5M2, 5, 2},
+		{F4E2M1FN, 2, 1},
+	} {
+		exponentBits, mantissaBits, ok := tc.dtype.ExponentAndMantissaBits()
+		if !ok || exponentBits != tc.exponentBits || mantissaBits != tc.mantissaBits {
+			t.Errorf("expected %s.ExponentAndMantissaBits() to be (%d, %d, true), got (%d, %d, %v)",
+				tc.dtype, tc.exponentBits, tc.mantissaBits, exponentBits, mantissaBits, ok)
+		}
+	}
+	for _, dtype := range []DType{Int32, Bool, Complex64} {
+		if _, _, ok := dtype.ExponentAndMantissaBits(); ok {
+			t.Errorf("expected %s.ExponentAndMantissaBits() to return ok=false", dtype)
+		}
+	}
+}
+
 func TestIsPromotableTo(t *testing.T) {
 	if !Float32.IsPromotableTo(Float64) {
 		t.Fatal("expected Float32 to be promotable to Float64")
@@ -114,3 +140,14 @@ func TestIsPromotableTo(t *testing.T) {
 		t.Fatal("expected Int8 to not be promotable to Float32")
 	}
 }
+
+func TestFromStableHLO(t *testing.T) {
+	for _, dtype := range []DType{Float32, BFloat16, Int4, Uint64, Bool, Complex128, TOKEN} {
+		if got := FromStableHLO(dtype.ToStableHLO()); got != dtype {
+			t.Errorf("FromStableHLO(%q) = %s, want %s", dtype.ToStableHLO(), got, dtype)
+		}
+	}
+	if got := FromStableHLO("f7"); got != InvalidDType {
+		t.Errorf("FromStableHLO(\"f7\") = %s, want InvalidDType", got)
+	}
+}
@@ -2,6 +2,7 @@ package dtypes
 
 import (
 	"fmt"
+	"strings"
 )
 
 // ToStableHLO returns the StableHLO string representation of the DType.
@@ -45,7 +46,36 @@ func (dtype DType) ToStableHLO() string {
 		return "complex<f32>"
 	case Complex128:
 		return "complex<f64>"
+	case TOKEN:
+		return "!stablehlo.token"
 	default:
 		return fmt.Sprintf("unknown_dtype<%s>", dtype.String())
 	}
 }
+
+// stableHLONames maps the StableHLO names to the DType, see FromStableHLO.
+var stableHLONames = func() map[string]DType {
+	names := make(map[string]DType)
+	for _, dtype := range DTypeValues() {
+		if dtype == InvalidDType {
+			continue
+		}
+		name := dtype.ToStableHLO()
+		if _, found := names[name]; !found && !strings.HasPrefix(name, "unknown_dtype") {
+			names[name] = dtype
+		}
+	}
+	return names
+}()
+
+// FromStableHLO returns the DType for the given StableHLO element type name (e.g.: "f32", "ui8", "complex<f32>"),
+// the inverse of DType.ToStableHLO.
+//
+// It returns InvalidDType if the name is not known.
+func FromStableHLO(name string) DType {
+	dtype, found := stableHLONames[name]
+	if !found {
+		return InvalidDType
+	}
+	return dtype
+}
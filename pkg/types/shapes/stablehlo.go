@@ -3,7 +3,11 @@ package shapes
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+
+	"github.com/gomlx/go-xla/pkg/types/dtypes"
+	"github.com/pkg/errors"
 )
 
 // ToStableHLO returns the ToStableHLO representation of the shape's type.
@@ -41,6 +45,12 @@ func (s Shape) WriteStableHLO(writer io.Writer) error {
 		return err
 	}
 
+	if s.DType == dtypes.TOKEN {
+		// Tokens are not tensors.
+		w("!stablehlo.token")
+		return err
+	}
+
 	w("tensor<")
 	if s.Rank() > 0 {
 		for i, dim := range s.Dimensions {
@@ -87,3 +97,307 @@ func (s Shape) WriteStableHLO(writer io.Writer) error {
 	w(">")
 	return err
 }
+
+// FromStableHLO parses a StableHLO type, like "tensor<2x?xf32>", "tuple<tensor<i32>, tensor<3xi1>>" or
+// "!stablehlo.token", and returns the corresponding shape. It is the inverse of Shape.ToStableHLO.
+//
+// It supports dynamic dimensions ("?"), their bounds (#stablehlo.bounds) and uniform quantized element
+// types (!quant.uniform). Unranked tensors and other tensor encodings are not supported.
+func FromStableHLO(text string) (Shape, error) {
+	p := &stableHLOTypeParser{text: text}
+	shape, err := p.parseType()
+	if err != nil {
+		return Invalid(), errors.WithMessagef(err, "failed to parse StableHLO type %q", text)
+	}
+	p.skipSpaces()
+	if p.pos < len(p.text) {
+		return Invalid(), errors.Errorf("failed to parse StableHLO type %q: unexpected %q after the type",
+			text, p.text[p.pos:])
+	}
+	return shape, nil
+}
+
+// stableHLOTypeParser is a simple recursive-descent parser of StableHLO types, used by FromStableHLO.
+type stableHLOTypeParser struct {
+	text string
+	pos  int
+}
+
+func (p *stableHLOTypeParser) skipSpaces() {
+	for p.pos < len(p.text) && strings.ContainsRune(" \t\r\n", rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips spaces and the given prefix, if present. It returns whether the prefix was present.
+func (p *stableHLOTypeParser) consume(prefix string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.text[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// expect is like consume, but it returns an error if the prefix is not present.
+func (p *stableHLOTypeParser) expect(prefix string) error {
+	if !p.consume(prefix) {
+		return errors.Errorf("expected %q at position %d", prefix, p.pos)
+	}
+	return nil
+}
+
+// readWhile reads the longest sequence of characters for which accept returns true.
+func (p *stableHLOTypeParser) readWhile(accept func(c byte) bool) string {
+	start := p.pos
+	for p.pos < len(p.text) && accept(p.text[p.pos]) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentifierChar(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+// readInt reads a (possibly negative) integer.
+func (p *stableHLOTypeParser) readInt() (int64, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos < len(p.text) && p.text[p.pos] == '-' {
+		p.pos++
+	}
+	p.readWhile(isDigit)
+	value, err := strconv.ParseInt(p.text[start:p.pos], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("expected an integer at position %d", start)
+	}
+	return value, nil
+}
+
+// readFloat reads a float in decimal format.
+func (p *stableHLOTypeParser) readFloat() (float64, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		isSign := (c == '-' || c == '+') && (p.pos == start || p.text[p.pos-1] == 'e' || p.text[p.pos-1] == 'E')
+		if !isSign && !isDigit(c) && c != '.' && c != 'e' && c != 'E' {
+			break
+		}
+		p.pos++
+	}
+	value, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+	if err != nil {
+		return 0, errors.Errorf("expected a float at position %d", start)
+	}
+	return value, nil
+}
+
+// parseType parses any of the types supported.
+func (p *stableHLOTypeParser) parseType() (Shape, error) {
+	switch {
+	case p.consume("tuple<"):
+		var elements []Shape
+		if p.consume(">") {
+			return MakeTuple(elements), nil
+		}
+		for {
+			element, err := p.parseType()
+			if err != nil {
+				return Invalid(), err
+			}
+			elements = append(elements, element)
+			if p.consume(">") {
+				return MakeTuple(elements), nil
+			}
+			if err = p.expect(","); err != nil {
+				return Invalid(), err
+			}
+		}
+	case p.consume("!stablehlo.token"):
+		return Make(dtypes.TOKEN), nil
+	case p.consume("tensor<"):
+		return p.parseTensor()
+	default:
+		return Invalid(), errors.Errorf("unknown type at position %d", p.pos)
+	}
+}
+
+// parseTensor parses the contents of a "tensor<...>" type, after the "tensor<" prefix.
+func (p *stableHLOTypeParser) parseTensor() (Shape, error) {
+	var shape Shape
+	dimensions := []int{}
+	for {
+		p.skipSpaces()
+		if p.consume("?") {
+			dimensions = append(dimensions, DimUnknown)
+		} else if p.pos < len(p.text) && isDigit(p.text[p.pos]) {
+			dim, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			dimensions = append(dimensions, int(dim))
+		} else {
+			break
+		}
+		if err := p.expect("x"); err != nil {
+			return Invalid(), err
+		}
+	}
+	if len(dimensions) > 0 {
+		shape.Dimensions = dimensions
+	}
+
+	// Element type.
+	if p.consume("!quant.uniform<") {
+		quantization, err := p.parseQuantization()
+		if err != nil {
+			return Invalid(), err
+		}
+		shape.Quantization = quantization
+		shape.DType = quantization.ExpressedType
+	} else {
+		dtype, err := p.parseDType()
+		if err != nil {
+			return Invalid(), err
+		}
+		shape.DType = dtype
+	}
+
+	// Optional bounds.
+	if p.consume(",") {
+		if err := p.expect("#stablehlo.bounds<"); err != nil {
+			return Invalid(), errors.WithMessage(err, "only #stablehlo.bounds tensor encoding is supported")
+		}
+		bounds := make([]int, 0, len(dimensions))
+		for !p.consume(">") {
+			if len(bounds) > 0 {
+				if err := p.expect(","); err != nil {
+					return Invalid(), err
+				}
+			}
+			if p.consume("?") {
+				bounds = append(bounds, 0)
+				continue
+			}
+			bound, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			bounds = append(bounds, int(bound))
+		}
+		if len(bounds) != len(dimensions) {
+			return Invalid(), errors.Errorf("got %d bounds for a tensor of rank %d", len(bounds), len(dimensions))
+		}
+		shape.DimensionBounds = bounds
+		shape.EncodeBounds = true
+	}
+	if err := p.expect(">"); err != nil {
+		return Invalid(), err
+	}
+	return shape, nil
+}
+
+// parseDType parses an element type name, like "f32" or "complex<f32>".
+func (p *stableHLOTypeParser) parseDType() (dtypes.DType, error) {
+	p.skipSpaces()
+	start := p.pos
+	name := p.readWhile(isIdentifierChar)
+	if name == "complex" && p.consume("<") {
+		p.readWhile(isIdentifierChar)
+		if err := p.expect(">"); err != nil {
+			return dtypes.InvalidDType, err
+		}
+		name = p.text[start:p.pos]
+	}
+	dtype := dtypes.FromStableHLO(name)
+	if dtype == dtypes.InvalidDType {
+		return dtype, errors.Errorf("unknown element type %q at position %d", name, start)
+	}
+	return dtype, nil
+}
+
+// parseQuantization parses the contents of a "!quant.uniform<...>" type, after the "!quant.uniform<" prefix.
+func (p *stableHLOTypeParser) parseQuantization() (*Quantization, error) {
+	var q Quantization
+	var err error
+	if q.StorageType, err = p.parseDType(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	if q.ExpressedType, err = p.parseDType(); err != nil {
+		return nil, err
+	}
+	if p.consume(":") {
+		if p.consume("{") {
+			// Blockwise: {axis:blockSize, ...}
+			for !p.consume("}") {
+				if len(q.QuantizedAxes) > 0 {
+					if err = p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				axis, err := p.readInt()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expect(":"); err != nil {
+					return nil, err
+				}
+				blockSize, err := p.readInt()
+				if err != nil {
+					return nil, err
+				}
+				q.QuantizedAxes = append(q.QuantizedAxes, int(axis))
+				q.BlockSizes = append(q.BlockSizes, blockSize)
+			}
+		} else {
+			axis, err := p.readInt()
+			if err != nil {
+				return nil, err
+			}
+			q.QuantizedAxes = []int{int(axis)}
+		}
+	}
+	if err = p.expect(","); err != nil {
+		return nil, err
+	}
+	readParameter := func() error {
+		scale, err := p.readFloat()
+		if err != nil {
+			return err
+		}
+		var zeroPoint int64
+		if p.consume(":") {
+			if zeroPoint, err = p.readInt(); err != nil {
+				return err
+			}
+		}
+		q.Scales = append(q.Scales, scale)
+		q.ZeroPoints = append(q.ZeroPoints, zeroPoint)
+		return nil
+	}
+	if p.consume("{") {
+		for !p.consume("}") {
+			if len(q.Scales) > 0 {
+				if err = p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			if err = readParameter(); err != nil {
+				return nil, err
+			}
+		}
+	} else if err = readParameter(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(">"); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
@@ -23,4 +23,58 @@ func TestToStableHLO(t *testing.T) {
 	if got := shape.ToStableHLO(); got != want {
 		t.Errorf("ToStableHLO() = %q, want %q", got, want)
 	}
+
+	// Test tuple, including a nested tuple.
+	shape = MakeTuple([]Shape{Make(dtypes.Float32, 2), MakeTuple([]Shape{Make(dtypes.Int32), Make(dtypes.Bool, 3)})})
+	want = "tuple<tensor<2xf32>, tuple<tensor<i32>, tensor<3xi1>>>"
+	if got := shape.ToStableHLO(); got != want {
+		t.Errorf("ToStableHLO() = %q, want %q", got, want)
+	}
+
+	// Test token.
+	shape = Make(dtypes.TOKEN)
+	if got := shape.ToStableHLO(); got != "!stablehlo.token" {
+		t.Errorf("ToStableHLO() = %q, want %q", got, "!stablehlo.token")
+	}
+}
+
+func TestFromStableHLO(t *testing.T) {
+	dynamic := Make(dtypes.Float32, DimUnknown, 3)
+	dynamic.DimensionBounds = []int{8, 0}
+	dynamic.EncodeBounds = true
+	perAxis := &Quantization{
+		StorageType:   dtypes.Int8,
+		ExpressedType: dtypes.Float32,
+		Scales:        []float64{0.5, 0.25},
+		ZeroPoints:    []int64{1, -2},
+		QuantizedAxes: []int{0},
+	}
+	for _, shape := range []Shape{
+		Make(dtypes.Float32, 1, 10),
+		Make(dtypes.Int32),
+		Make(dtypes.Complex64, 0, 2),
+		Make(dtypes.Bool, DimUnknown),
+		dynamic,
+		Make(dtypes.Float32, 1, 10).WithUniformQuantization(dtypes.Int8, dtypes.Float32, 0.1, -3),
+		Make(dtypes.Float32, 2, 3).WithQuantization(perAxis),
+		MakeTuple([]Shape{Make(dtypes.Float32, 2), MakeTuple([]Shape{Make(dtypes.Int32), Make(dtypes.Bool, 3)})}),
+		MakeTuple(nil),
+		Make(dtypes.TOKEN),
+	} {
+		text := shape.ToStableHLO()
+		got, err := FromStableHLO(text)
+		if err != nil {
+			t.Errorf("FromStableHLO(%q) failed: %v", text, err)
+			continue
+		}
+		if gotText := got.ToStableHLO(); gotText != text || !got.Equal(shape) {
+			t.Errorf("FromStableHLO(%q) = %s (%q), want %s", text, got, gotText, shape)
+		}
+	}
+
+	for _, text := range []string{"tensor<*xf32>", "tensor<2xf7>", "tensor<2x3>", "tuple<tensor<f32>", "tensor<f32> x"} {
+		if _, err := FromStableHLO(text); err == nil {
+			t.Errorf("expected error for FromStableHLO(%q), got nil", text)
+		}
+	}
 }